@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,12 +15,18 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/miekg/dns"
+
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/app"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/proxyproto"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rfc2136"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/tlscert"
 )
 
 func main() {
 	configFile := flag.String("c", "", "Path to config file")
+	checkConfig := flag.Bool("check-config", false, "Check the config and print the effective grants of all principals")
 	flag.Parse()
 
 	var (
@@ -34,45 +43,148 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *checkConfig {
+		if err := config.WriteGrants(os.Stdout, cfg.Auth.EffectiveGrants()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	log.Printf("Enabled endpoints: %s", strings.Join(cfg.Endpoints.Enabled(), ", "))
 	log.Printf("Authorization method set to: %s", cfg.Auth.Method)
+	if cfg.ProxyProtocol.Enabled {
+		log.Printf("PROXY protocol enabled for: %s", strings.Join(cfg.ProxyProtocol.TrustedNetworks, ", "))
+	}
+	if cfg.TLS.Enabled() {
+		log.Printf("TLS enabled with certificate %s", cfg.TLS.CertFile)
+	}
 	log.Printf("Starting hetzner-dnsapi-proxy, listening on %s", cfg.ListenAddr)
-	if err := runServer(cfg.ListenAddr, app.New(cfg)); err != nil {
+	if err := runServer(cfg, app.NewLimits(cfg)); err != nil {
 		log.Fatal("Error running server:", err)
 	}
 }
 
-func runServer(listenAddr string, handler http.Handler) error {
-	const (
-		readHeaderTimeout = 10
-		readTimeout       = 30
-		writeTimeout      = 30
-		idleTimeout       = 120
-		shutdownTimeout   = 5
-	)
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+	shutdownTimeout   = 5 * time.Second
+)
 
-	s := &http.Server{
-		Addr:              listenAddr,
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
 		Handler:           handler,
-		ReadHeaderTimeout: readHeaderTimeout * time.Second,
-		ReadTimeout:       readTimeout * time.Second,
-		WriteTimeout:      writeTimeout * time.Second,
-		IdleTimeout:       idleTimeout * time.Second,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
 	}
+}
 
-	go func() {
-		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal(err)
+func runServer(cfg *config.Config, limits *app.Limits) error {
+	s := newServer(cfg.ListenAddr, app.New(cfg, limits))
+	servers := []*http.Server{s}
+
+	var err error
+	if cfg.TLS.Enabled() {
+		if s.TLSConfig, err = newTLSConfig(&cfg.TLS); err != nil {
+			return err
 		}
-	}()
+	}
+
+	ln, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	if cfg.ProxyProtocol.Enabled {
+		ln = proxyproto.NewListener(ln, cfg.ProxyProtocol.TrustedPrefixes, readHeaderTimeout)
+	}
+
+	if s.TLSConfig != nil {
+		go serve(func() error { return s.ServeTLS(ln, "", "") })
+
+		if cfg.TLS.RedirectAddr != "" {
+			r := newServer(cfg.TLS.RedirectAddr, app.NewRedirect(cfg))
+			servers = append(servers, r)
+			log.Printf("Redirecting HTTP to HTTPS, listening on %s", cfg.TLS.RedirectAddr)
+			go serve(r.ListenAndServe)
+		}
+	} else {
+		go serve(func() error { return s.Serve(ln) })
+	}
+
+	if w := startWebhook(cfg); w != nil {
+		servers = append(servers, w)
+	}
+	dnsServers := startRFC2136(cfg, limits)
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Println("Shutting down hetzner-dnsapi-proxy")
 
-	c, cancel := context.WithTimeout(context.Background(), shutdownTimeout*time.Second)
+	c, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
-	return s.Shutdown(c)
+	var errs []error
+	for _, srv := range servers {
+		errs = append(errs, srv.Shutdown(c))
+	}
+	for _, srv := range dnsServers {
+		errs = append(errs, srv.ShutdownContext(c))
+	}
+	return errors.Join(errs...)
+}
+
+// startRFC2136 starts the UDP and TCP servers of the DNS UPDATE listener if
+// it is enabled.
+func startRFC2136(cfg *config.Config, limits *app.Limits) []*dns.Server {
+	if cfg.RFC2136 == nil {
+		return nil
+	}
+	servers := rfc2136.NewServers(cfg, app.NewRFC2136(cfg, limits))
+	for _, srv := range servers {
+		log.Printf("Accepting DNS UPDATE messages, listening on %s/%s", srv.Addr, srv.Net)
+		go serve(srv.ListenAndServe)
+	}
+	return servers
+}
+
+// startWebhook starts the server of the external-dns webhook listener if it
+// is enabled.
+func startWebhook(cfg *config.Config) *http.Server {
+	if cfg.Webhook == nil {
+		return nil
+	}
+	s := newServer(cfg.Webhook.ListenAddr, app.NewWebhook(cfg))
+	log.Printf("Serving the external-dns webhook provider, listening on %s", cfg.Webhook.ListenAddr)
+	go serve(s.ListenAndServe)
+	return s
+}
+
+func newTLSConfig(cfg *config.TLS) (*tls.Config, error) {
+	certs, err := tlscert.NewReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     cfg.Version,
+		GetCertificate: certs.GetCertificate,
+	}
+	if cfg.ClientCA != "" {
+		clientCAs, err := tlscert.LoadCertPool(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client CA: %w", err)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+func serve(fn func() error) {
+	if err := fn(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
 }
@@ -2,17 +2,34 @@ package app
 
 import (
 	"log"
+	"net"
 	"net/http"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cloudflare"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cloudzones"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cpanel"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/duckdns"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/forwardauth"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetznerdns"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/clean"
+	cleancloud "github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/clean/cloud"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/update"
+	updatecloud "github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware/update/cloud"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/powerdns"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rfc2136"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/route53"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/signature"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/webhook"
 )
 
 type loggingResponseWriter struct {
@@ -25,59 +42,245 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-func New(cfg *config.Config) http.Handler {
-	lockout := ratelimit.NewLockout(
-		cfg.Lockout.MaxAttempts,
-		time.Duration(cfg.Lockout.DurationSeconds)*time.Second,
-		time.Duration(cfg.Lockout.WindowSeconds)*time.Second,
-	)
-	authorizer := middleware.NewAuthorizer(cfg, lockout)
+// Limits are the scoped rate limits and the lockout shared by the HTTP
+// endpoints and the DNS UPDATE listener.
+type Limits struct {
+	Scoped  *middleware.ScopedRateLimits
+	Lockout *ratelimit.Lockout
+}
+
+func NewLimits(cfg *config.Config) *Limits {
+	idle := time.Duration(cfg.RateLimit.IdleSeconds) * time.Second
+	return &Limits{
+		Scoped: &middleware.ScopedRateLimits{
+			User:     newPolicy(&cfg.RateLimit.User, idle),
+			Zone:     newPolicy(&cfg.RateLimit.Zone, idle),
+			Upstream: newPolicy(&cfg.RateLimit.Upstream, idle),
+		},
+		Lockout: ratelimit.NewLockout(
+			cfg.Lockout.MaxAttempts,
+			time.Duration(cfg.Lockout.DurationSeconds)*time.Second,
+			time.Duration(cfg.Lockout.WindowSeconds)*time.Second,
+		),
+	}
+}
+
+func New(cfg *config.Config, limits *Limits) http.Handler {
+	lockout := limits.Lockout
+	authorizer, nicAuth := newAuthorizers(cfg, lockout)
 
 	updater := update.New(cfg)
 	cleaner := clean.New(cfg)
 
-	limiter := ratelimit.NewLimiter(cfg.RateLimit.RPS, cfg.RateLimit.Burst, time.Duration(cfg.RateLimit.IdleSeconds)*time.Second)
+	idle := time.Duration(cfg.RateLimit.IdleSeconds) * time.Second
+	limiter := ratelimit.NewLimiter(cfg.RateLimit.RPS, cfg.RateLimit.Burst, idle)
 	rl := middleware.NewRateLimit(limiter, middleware.RateLimitExceeded)
 
+	scopedLimits := limits.Scoped
+	srl := middleware.NewScopedRateLimit(cfg, scopedLimits, middleware.RateLimitExceeded)
+	ipm := middleware.NewClientIPMatch(cfg, middleware.ClientIPMismatch)
+
+	pre := commonHandlers(cfg)
+	// Clients of the DuckDNS and API token endpoints send their own tokens,
+	// which must not be taken for JWTs.
+	base := baseHandlers(cfg)
+	signed := newSignedRequestAuth(cfg)
+
 	mux := http.NewServeMux()
 	if cfg.Endpoints.Plain {
 		mux.Handle("GET /plain/update",
-			handle(cfg, rl, middleware.BindPlain, authorizer, updater, middleware.StatusOk))
+			handle(pre, rl, middleware.BindPlain(cfg), signed, authorizer, ipm, srl, updater, middleware.StatusOk))
 	}
 	if cfg.Endpoints.Nic {
 		mux.Handle("GET /nic/update", handle(
-			cfg, middleware.NewRateLimit(limiter, middleware.NicRateLimitExceeded), middleware.BindNicUpdate,
-			middleware.NicAuth(cfg, lockout), middleware.NicUpdate(updater), middleware.StatusOkNicUpdate,
+			pre, middleware.NewRateLimit(limiter, middleware.NicRateLimitExceeded), middleware.BindNicUpdate(cfg), signed, nicAuth,
+			middleware.NewClientIPMatch(cfg, middleware.NicClientIPMismatch),
+			middleware.NewScopedRateLimit(cfg, scopedLimits, middleware.NicRateLimitExceeded),
+			middleware.NicUpdate(updater), middleware.StatusOkNicUpdate,
 		))
 	}
 	if cfg.Endpoints.AcmeDNS {
 		mux.Handle("POST /acmedns/update",
-			handle(cfg, rl, middleware.BindAcmeDNS, authorizer, updater, middleware.StatusOkAcmeDNS))
+			handle(pre, rl, middleware.BindAcmeDNS(cfg), authorizer, srl, updater, middleware.StatusOkAcmeDNS))
 	}
 	if cfg.Endpoints.HTTPReq {
 		mux.Handle("POST /httpreq/present",
-			handle(cfg, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq, authorizer, updater, middleware.StatusOk))
+			handle(pre, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq(cfg), authorizer, srl, updater, middleware.StatusOk))
 		mux.Handle("POST /httpreq/cleanup",
-			handle(cfg, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq, authorizer, cleaner, middleware.StatusOk))
+			handle(pre, rl, middleware.ContentTypeJSON, middleware.BindHTTPReq(cfg), authorizer, srl, cleaner, middleware.StatusOk))
 	}
 	if cfg.Endpoints.DirectAdmin {
 		mux.Handle("GET /directadmin/CMD_API_SHOW_DOMAINS",
-			handle(cfg, rl, middleware.NewShowDomainsDirectAdmin(cfg, lockout)))
+			handle(pre, rl, middleware.NewShowDomainsDirectAdmin(cfg, lockout)))
 		mux.Handle("GET /directadmin/CMD_API_DOMAIN_POINTER",
-			handle(cfg, rl, middleware.StatusOk))
+			handle(pre, rl, middleware.StatusOk))
 		mux.Handle("GET /directadmin/CMD_API_DNS_CONTROL",
-			handle(cfg, rl, middleware.BindDirectAdmin, authorizer, updater, middleware.StatusOkDirectAdmin))
+			handle(pre, rl, middleware.BindDirectAdmin(cfg), authorizer, ipm, srl, updater, middleware.StatusOkDirectAdmin))
+	}
+	if cfg.Endpoints.Namecheap {
+		mux.Handle("GET /update", handle(
+			pre, middleware.NamecheapErrors, rl, middleware.BindNamecheap(cfg), authorizer, ipm, srl, updater,
+			middleware.StatusOkNamecheap,
+		))
 	}
+	if cfg.Endpoints.DuckDNS {
+		mux.Handle("GET "+duckdns.Path, handle(
+			base, middleware.NewRateLimit(limiter, duckdns.KO),
+			middleware.NewTokenAuth(lockout, duckdns.Lookup(cfg), duckdns.Token, duckdns.KO),
+			duckdns.New(cfg, scopedLimits, updatecloud.New(cfg), cleancloud.New(cfg), hetzner.NewRecords(cfg)),
+		))
+	}
+	handleAPITokenEndpoints(mux, cfg, base, rl, lockout, scopedLimits)
 
 	return mux
 }
 
-func handle(cfg *config.Config, handlers ...func(http.Handler) http.Handler) http.Handler {
-	handlers = slices.Insert(handlers, 0, middleware.NewSetClientIP(cfg.TrustedProxyPrefixes))
-	handlers = slices.Insert(handlers, 0, middleware.SecurityHeaders)
+// handleAPITokenEndpoints registers the endpoints emulating DNS provider
+// APIs, whose clients authenticate with API tokens. Their changes count
+// towards the zone and upstream limits of limits.
+func handleAPITokenEndpoints(
+	mux *http.ServeMux, cfg *config.Config, pre []func(http.Handler) http.Handler, rl func(http.Handler) http.Handler,
+	lockout *ratelimit.Lockout, limits *middleware.ScopedRateLimits,
+) {
+	records := hetzner.NewRecords(cfg)
+	auth := func(token func(*http.Request) string, onUnauthorized http.HandlerFunc) func(http.Handler) http.Handler {
+		return middleware.NewAPITokenAuth(cfg, lockout, token, onUnauthorized)
+	}
+	if cfg.Endpoints.HetznerDNS {
+		h := handle(pre, rl, auth(hetznerdns.APIToken, hetznerdns.Unauthorized), hetznerdns.New(cfg, limits, records))
+		for _, pattern := range []string{"/api/v1/zones", "/api/v1/zones/", "/api/v1/records", "/api/v1/records/"} {
+			mux.Handle(pattern, h)
+		}
+	}
+	if cfg.Endpoints.CloudZones {
+		h := handle(pre, rl, auth(cloudzones.APIToken, cloudzones.Unauthorized), cloudzones.New(cfg, limits, records))
+		mux.Handle("/v1/zones", h)
+		mux.Handle("/v1/zones/", h)
+	}
+	if cfg.Endpoints.PowerDNS {
+		h := handle(pre, rl, auth(powerdns.APIKey, powerdns.Unauthorized), powerdns.New(cfg, limits, records))
+		mux.Handle("/api/v1/servers", h)
+		mux.Handle("/api/v1/servers/", h)
+	}
+	if cfg.Endpoints.Cloudflare {
+		mux.Handle(cloudflare.PathPrefix+"/",
+			handle(pre, rl, auth(cloudflare.APIToken, cloudflare.Unauthorized), cloudflare.New(cfg, limits, records)))
+	}
+	if cfg.Endpoints.Route53 {
+		mux.Handle(route53.PathPrefix+"/",
+			handle(pre, rl, auth(route53.APIToken(cfg), route53.Unauthorized), route53.New(cfg, limits, records)))
+	}
+	if cfg.Endpoints.CPanel {
+		h := handle(pre, rl, auth(cpanel.APIToken(cfg), cpanel.Unauthorized), cpanel.New(cfg, limits, records))
+		mux.Handle(cpanel.API2Path, h)
+		mux.Handle(cpanel.UAPIPath, h)
+	}
+}
+
+// NewRedirect returns the handler of the plain HTTP listener that redirects
+// to the HTTPS listener on cfg.ListenAddr.
+func NewRedirect(cfg *config.Config) http.Handler {
+	_, port, _ := net.SplitHostPort(cfg.ListenAddr)
+	return middleware.NewSecurityHeaders(false)(middleware.NewHTTPSRedirect(port))
+}
+
+// NewRFC2136 returns the handler of the DNS UPDATE listener, whose updates
+// count towards the limits of the HTTP endpoints.
+func NewRFC2136(cfg *config.Config, limits *Limits) *rfc2136.Handler {
+	return rfc2136.New(cfg, limits.Scoped, limits.Lockout, hetzner.NewRecords(cfg))
+}
+
+// NewWebhook returns the handler of the external-dns webhook listener,
+// which runs without authentication since external-dns does not
+// authenticate.
+func NewWebhook(cfg *config.Config) http.Handler {
+	return handle(baseHandlers(cfg), webhook.New(cfg, hetzner.NewRecords(cfg).WithCNAME()))
+}
+
+// newAuthorizers returns the authorizer of the endpoints and the one of the
+// nic update endpoint.
+func newAuthorizers(
+	cfg *config.Config, lockout *ratelimit.Lockout,
+) (authorizer, nicAuth func(http.Handler) http.Handler) {
+	if cfg.Auth.Method != config.AuthMethodForwardAuth {
+		return middleware.NewAuthorizer(cfg, lockout), middleware.NicAuth(cfg, lockout)
+	}
+	fa := cfg.Auth.ForwardAuth
+	timeout := fa.TimeoutSeconds
+	if timeout == 0 {
+		timeout = config.DefaultForwardAuthTimeoutSeconds
+	}
+	client := forwardauth.New(fa.URL, time.Duration(timeout)*time.Second, time.Duration(fa.CacheSeconds)*time.Second)
+	return middleware.NewForwardAuthorizer(cfg, client, fa.FailOpen, lockout),
+		middleware.NewNicForwardAuth(cfg, client, fa.FailOpen, lockout)
+}
+
+// newSignedRequestAuth returns the handler verifying signed requests, or a
+// handler passing requests on if they are disabled.
+func newSignedRequestAuth(cfg *config.Config) func(http.Handler) http.Handler {
+	if cfg.Auth.SignedRequests == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	window := cfg.Auth.SignedRequests.WindowSeconds
+	if window == 0 {
+		window = config.DefaultSignedRequestsWindowSeconds
+	}
+	return middleware.NewSignedRequestAuth(cfg, signature.NewVerifier(time.Duration(window)*time.Second))
+}
+
+func newPolicy(scope *config.RateLimitScope, idle time.Duration) *ratelimit.Policy {
+	var (
+		limiter *ratelimit.Limiter
+		quota   *ratelimit.Quota
+	)
+	if scope.RPS > 0 {
+		limiter = ratelimit.NewLimiter(scope.RPS, scope.Burst, idle)
+	}
+	if scope.DailyQuota > 0 {
+		quota = ratelimit.NewQuota(scope.DailyQuota)
+	}
+	return ratelimit.NewPolicy(limiter, quota)
+}
+
+// commonHandlers returns the handlers that run in front of every endpoint
+// authenticating users.
+func commonHandlers(cfg *config.Config) []func(http.Handler) http.Handler {
+	handlers := baseHandlers(cfg)
+	if cfg.Auth.JWT != nil {
+		handlers = append(handlers, middleware.NewBearerAuth(newJWTVerifier(cfg.Auth.JWT)))
+	}
+	return handlers
+}
+
+// baseHandlers returns the common handlers without authentication.
+func baseHandlers(cfg *config.Config) []func(http.Handler) http.Handler {
+	var handlers []func(http.Handler) http.Handler
 	if cfg.Debug {
-		handlers = slices.Insert(handlers, 0, middleware.LogDebug)
+		handlers = append(handlers, middleware.LogDebug)
 	}
+	handlers = append(handlers,
+		middleware.NewSecurityHeaders(cfg.TLS.Enabled()),
+		middleware.NewSetClientIP(cfg.TrustedProxyPrefixes, cfg.ClientIPHeaders, cfg.TrustedProxyHeaders),
+		middleware.NewClientIPFilter(
+			netlist.New(cfg.DenyNetworks.Prefixes, cfg.DenyNetworks.Files),
+			netlist.New(cfg.ExemptNetworks.Prefixes, cfg.ExemptNetworks.Files),
+		),
+	)
+	return handlers
+}
+
+func newJWTVerifier(cfg *config.JWT) *jwt.Verifier {
+	var keys jwt.KeySet
+	if cfg.JWKSFile != "" {
+		keys = jwt.NewFileKeySet(cfg.JWKSFile)
+	} else {
+		keys = jwt.NewURLKeySet(cfg.JWKSURL, time.Duration(cfg.JWKSCacheSeconds)*time.Second)
+	}
+	return jwt.NewVerifier(cfg.Issuer, cfg.Audience, keys)
+}
+
+func handle(pre []func(http.Handler) http.Handler, handlers ...func(http.Handler) http.Handler) http.Handler {
+	handlers = slices.Concat(pre, handlers)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
@@ -98,7 +301,7 @@ func logRequest(r *http.Request, start time.Time, statusCode int) {
 	addr := sanitize.LogValue(r.RemoteAddr)
 	method := sanitize.LogValue(r.Method)
 	methodPadding := strings.Repeat(" ", max(0, methodWidth-len(method)))
-	url := sanitize.LogValue(r.URL.String())
+	url := sanitize.LogValue(middleware.RedactURL(r.URL))
 	//nolint:gosec // values are sanitized above
 	log.Printf("| %d | %13v | %15s | %s \"%s\"", statusCode, time.Since(start), addr, method+methodPadding, url)
 }
@@ -0,0 +1,193 @@
+// Package cloudflare implements the subset of the zones and DNS records
+// routes of the Cloudflare API v4 used by DNS clients like ddclient, lego or
+// Caddy on top of the zones and RRSets of the Cloud API. Clients
+// authenticate with API tokens issued by the proxy and only see the zones
+// and records their grants cover.
+package cloudflare
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/zoneapi"
+)
+
+const (
+	// HeaderAuthKey is the header carrying the API token as legacy API key,
+	// next to the bearer token of the Authorization header.
+	HeaderAuthKey = "X-Auth-Key"
+
+	// PathPrefix is the prefix of all routes, the path of the API base URL.
+	PathPrefix = "/client/v4"
+
+	zonePath           = PathPrefix + "/zones/{zone}"
+	recordsPath        = zonePath + "/dns_records"
+	maxRequestBodySize = 64 << 10 // 64 KB
+	defaultPerPage     = 100
+	maxPerPage         = 5000
+)
+
+// Error codes of the Cloudflare API.
+const (
+	codeAuthentication  = 10000
+	codeNoRoute         = 7000
+	codeInvalidZone     = 7003
+	codeInvalidBody     = 9207
+	codeValidation      = 1004
+	codeInvalidContent  = 9005
+	codeForbidden       = 9109
+	codeRecordNotFound  = 81044
+	codeIdenticalRecord = 81058
+	codeRateLimited     = 971
+	codeInternal        = 1000
+)
+
+// Records reads and changes the zones and records of the Cloud API.
+type Records interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+	RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error)
+	AddRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string, ttl int) error
+	RemoveRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string) error
+	ChangeTTL(ctx context.Context, zone *hcloud.Zone, name, recordType string, ttl int) error
+}
+
+type handler struct {
+	cfg     *config.Config
+	limits  *middleware.ScopedRateLimits
+	records Records
+}
+
+// New returns the handler of the routes below PathPrefix. It must run after
+// middleware.NewAPITokenAuth. Changes are limited by the zone and upstream
+// limits of limits.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, records Records) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, limits: limits, records: records}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+PathPrefix+"/user/tokens/verify", verifyToken)
+	mux.HandleFunc("GET "+PathPrefix+"/zones", h.listZones)
+	mux.HandleFunc("GET "+zonePath, h.getZone)
+	mux.HandleFunc("GET "+recordsPath, h.withZone(h.listRecords))
+	mux.HandleFunc("POST "+recordsPath, h.withZone(h.createRecord))
+	mux.HandleFunc("GET "+recordsPath+"/{id}", h.withZone(h.getRecord))
+	mux.HandleFunc("PUT "+recordsPath+"/{id}", h.withZone(h.updateRecord))
+	mux.HandleFunc("PATCH "+recordsPath+"/{id}", h.withZone(h.updateRecord))
+	mux.HandleFunc("DELETE "+recordsPath+"/{id}", h.withZone(h.deleteRecord))
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, codeNoRoute, "No route for that URI")
+	})
+
+	return func(_ http.Handler) http.Handler {
+		return mux
+	}
+}
+
+// APIToken returns the bearer token of r, or its legacy API key.
+func APIToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token
+	}
+	return r.Header.Get(HeaderAuthKey)
+}
+
+// Unauthorized answers requests without a valid API token like Cloudflare.
+func Unauthorized(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusForbidden, codeAuthentication, "Authentication error")
+}
+
+// verifyToken answers token verifications, which only reach it with a valid
+// API token.
+func verifyToken(w http.ResponseWriter, r *http.Request) {
+	t := middleware.APITokenFromContext(r.Context())
+	writeResult(w, http.StatusOK, map[string]string{"id": t.Name, "status": "active"}, nil)
+}
+
+func (h *handler) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+// withZone looks up the zone of the path and answers 404 if the API token
+// does not cover it.
+func (h *handler) withZone(
+	next func(context.Context, http.ResponseWriter, *http.Request, *hcloud.Zone),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := h.context(r)
+		defer cancel()
+		zone, err := zoneapi.VisibleZone(ctx, &h.cfg.Auth, h.records, r, r.PathValue("zone"))
+		if err != nil {
+			failed(w, err)
+			return
+		}
+		if zone == nil {
+			writeError(w, http.StatusNotFound, codeInvalidZone, errInvalidZone)
+			return
+		}
+		next(ctx, w, r, zone)
+	}
+}
+
+type resultInfo struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Count      int `json:"count"`
+	TotalCount int `json:"total_count"`
+	TotalPages int `json:"total_pages"`
+}
+
+// paginate returns the page of items requested by the page and per_page
+// query parameters of r.
+func paginate[T any](r *http.Request, items []T) ([]T, *resultInfo) {
+	items, page := zoneapi.Paginate(r, items, defaultPerPage, maxPerPage)
+	return items, &resultInfo{
+		Page:       page.Number,
+		PerPage:    page.PerPage,
+		Count:      page.Count,
+		TotalCount: page.Total,
+		TotalPages: page.Pages,
+	}
+}
+
+type apiMessage struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// envelope is the body of all responses.
+type envelope struct {
+	Success    bool         `json:"success"`
+	Errors     []apiMessage `json:"errors"`
+	Messages   []apiMessage `json:"messages"`
+	Result     any          `json:"result"`
+	ResultInfo *resultInfo  `json:"result_info,omitempty"`
+}
+
+func writeResult(w http.ResponseWriter, code int, result any, info *resultInfo) {
+	zoneapi.WriteJSON(w, code, &envelope{Success: true, Errors: []apiMessage{}, Messages: []apiMessage{}, Result: result, ResultInfo: info})
+}
+
+// allow reports whether changing records of zone with the API token of r is
+// within the scoped rate limits, and answers 429 Too Many Requests otherwise.
+func (h *handler) allow(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) bool {
+	if !h.limits.Allow(w, middleware.APITokenLimitUser(r), zone.Name) {
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, "Please wait and consider throttling your request speed")
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, code, errCode int, message string) {
+	zoneapi.WriteJSON(w, code, &envelope{Errors: []apiMessage{{Code: errCode, Message: message}}, Messages: []apiMessage{}})
+}
+
+func failed(w http.ResponseWriter, err error) {
+	log.Printf("failed to call the Cloud API: %v", err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+}
@@ -0,0 +1,13 @@
+package cloudflare_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloudflare(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "cloudflare test suite")
+}
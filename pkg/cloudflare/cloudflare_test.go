@@ -0,0 +1,317 @@
+package cloudflare_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cloudflare"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+const (
+	token      = "team-token"
+	recordsURL = "/client/v4/zones/1/dns_records"
+)
+
+// fakeRecords keeps the zones and RRSets of the Cloud API in memory.
+type fakeRecords struct {
+	zones  []*hcloud.Zone
+	rrSets map[int64][]*hcloud.ZoneRRSet
+	calls  []string
+}
+
+func (f *fakeRecords) Zones(_ context.Context) ([]*hcloud.Zone, error) {
+	return f.zones, nil
+}
+
+func (f *fakeRecords) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	for _, zone := range f.zones {
+		if strconv.FormatInt(zone.ID, 10) == idOrName || zone.Name == idOrName {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRecords) RRSets(_ context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	return f.rrSets[zone.ID], nil
+}
+
+func (f *fakeRecords) rrSet(zone *hcloud.Zone, name, recordType string) *hcloud.ZoneRRSet {
+	for _, rrSet := range f.rrSets[zone.ID] {
+		if rrSet.Name == name && string(rrSet.Type) == recordType {
+			return rrSet
+		}
+	}
+	return nil
+}
+
+func (f *fakeRecords) AddRecord(_ context.Context, zone *hcloud.Zone, name, recordType, value string, ttl int) error {
+	f.calls = append(f.calls, "add "+name+" "+recordType+" "+value+" "+strconv.Itoa(ttl))
+	record := hcloud.ZoneRRSetRecord{Value: hetzner.QuoteIfRequired(value, hcloud.ZoneRRSetType(recordType))}
+	if rrSet := f.rrSet(zone, name, recordType); rrSet != nil {
+		rrSet.Records = append(rrSet.Records, record)
+		return nil
+	}
+	f.rrSets[zone.ID] = append(f.rrSets[zone.ID], &hcloud.ZoneRRSet{
+		Name: name, Type: hcloud.ZoneRRSetType(recordType), TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{record},
+	})
+	return nil
+}
+
+func (f *fakeRecords) RemoveRecord(_ context.Context, zone *hcloud.Zone, name, recordType, value string) error {
+	f.calls = append(f.calls, "remove "+name+" "+recordType+" "+value)
+	if rrSet := f.rrSet(zone, name, recordType); rrSet != nil {
+		quoted := hetzner.QuoteIfRequired(value, rrSet.Type)
+		rrSet.Records = slices.DeleteFunc(rrSet.Records, func(r hcloud.ZoneRRSetRecord) bool {
+			return r.Value == quoted
+		})
+	}
+	return nil
+}
+
+func (f *fakeRecords) ChangeTTL(_ context.Context, zone *hcloud.Zone, name, recordType string, ttl int) error {
+	f.calls = append(f.calls, "ttl "+name+" "+recordType+" "+strconv.Itoa(ttl))
+	f.rrSet(zone, name, recordType).TTL = &ttl
+	return nil
+}
+
+type recordJSON struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+type response struct {
+	Success bool            `json:"success"`
+	Errors  []any           `json:"errors"`
+	Result  json.RawMessage `json:"result"`
+}
+
+var _ = Describe("Cloudflare API", func() {
+	var (
+		records *fakeRecords
+		limits  *middleware.ScopedRateLimits
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		ttl := 300
+		records = &fakeRecords{
+			zones: []*hcloud.Zone{
+				{ID: 1, Name: "example.com", TTL: 3600, Status: hcloud.ZoneStatusOk, Created: time.Unix(0, 0)},
+				{ID: 2, Name: "example.org", TTL: 3600, Status: hcloud.ZoneStatusOk},
+			},
+			rrSets: map[int64][]*hcloud.ZoneRRSet{
+				1: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.4"}}},
+					{Name: "_acme-challenge.www", Type: hcloud.ZoneRRSetTypeTXT, Records: []hcloud.ZoneRRSetRecord{{Value: `"token"`}}},
+					{Name: "@", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.5"}}},
+				},
+			},
+		}
+
+		cfg := &config.Config{
+			Timeout:   10,
+			RecordTTL: 60,
+			Auth: config.Auth{
+				APITokens: []config.APIToken{{Name: "team", Token: token, Domains: []string{"*.example.com"}}},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewAPITokenAuth(cfg, lockout, cloudflare.APIToken, cloudflare.Unauthorized)(
+			cloudflare.New(cfg, limits, records)(nil),
+		)
+	})
+
+	do := func(method, target string, body any) (*httptest.ResponseRecorder, *response) {
+		var reader io.Reader = http.NoBody
+		if body != nil {
+			data, err := json.Marshal(body)
+			Expect(err).ToNot(HaveOccurred())
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := &response{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), res)).To(Succeed())
+		return rec, res
+	}
+
+	listRecords := func(query string) []recordJSON {
+		rec, res := do(http.MethodGet, recordsURL+query, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var result []recordJSON
+		Expect(json.Unmarshal(res.Result, &result)).To(Succeed())
+		return result
+	}
+
+	findRecord := func(name, recordType string) recordJSON {
+		for _, r := range listRecords("") {
+			if r.Name == name && r.Type == recordType {
+				return r
+			}
+		}
+		Fail("record not found: " + name)
+		return recordJSON{}
+	}
+
+	It("should reject invalid API tokens", func() {
+		req := httptest.NewRequest(http.MethodGet, "/client/v4/zones", http.NoBody)
+		req.Header.Set(cloudflare.HeaderAuthKey, "invalid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("should verify API tokens", func() {
+		rec, res := do(http.MethodGet, "/client/v4/user/tokens/verify", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(res.Success).To(BeTrue())
+	})
+
+	It("should list only covered zones", func() {
+		rec, res := do(http.MethodGet, "/client/v4/zones?name=example.com", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(res.Success).To(BeTrue())
+		var zones []map[string]any
+		Expect(json.Unmarshal(res.Result, &zones)).To(Succeed())
+		Expect(zones).To(HaveLen(1))
+		Expect(zones[0]).To(HaveKeyWithValue("id", "1"))
+		Expect(zones[0]).To(HaveKeyWithValue("status", "active"))
+
+		rec, _ = do(http.MethodGet, "/client/v4/zones/2", nil)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should answer pages past the end with no records", func() {
+		Expect(listRecords("?page=100000000000000000")).To(BeEmpty())
+	})
+
+	It("should list and filter granted records", func() {
+		Expect(listRecords("")).To(HaveLen(2))
+		result := listRecords("?type=TXT&name=_acme-challenge.www.example.com")
+		Expect(result).To(HaveLen(1))
+		Expect(result[0].Content).To(Equal("token"))
+		Expect(result[0].ID).To(HaveLen(32))
+	})
+
+	It("should keep record IDs stable", func() {
+		id := findRecord("www.example.com", "A").ID
+		Expect(findRecord("www.example.com", "A").ID).To(Equal(id))
+		rec, _ := do(http.MethodGet, recordsURL+"/"+id, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should create records with relative names and an automatic TTL", func() {
+		rec, res := do(http.MethodPost, recordsURL, map[string]any{
+			"type": "TXT", "name": "_acme-challenge.api", "content": `"challenge"`, "ttl": 1, "proxied": false,
+		})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(res.Success).To(BeTrue())
+		Expect(records.calls).To(Equal([]string{"add _acme-challenge.api TXT challenge 60"}))
+
+		var created recordJSON
+		Expect(json.Unmarshal(res.Result, &created)).To(Succeed())
+		Expect(created.Name).To(Equal("_acme-challenge.api.example.com"))
+		Expect(created.ID).To(Equal(findRecord("_acme-challenge.api.example.com", "TXT").ID))
+	})
+
+	DescribeTable("should reject creating", func(body map[string]any, code int) {
+		rec, res := do(http.MethodPost, recordsURL, body)
+		Expect(rec.Code).To(Equal(code))
+		Expect(res.Success).To(BeFalse())
+		Expect(res.Errors).To(HaveLen(1))
+		Expect(records.calls).To(BeEmpty())
+	},
+		Entry("records outside the grants", map[string]any{"type": "A", "name": "@", "content": "1.2.3.4"},
+			http.StatusForbidden),
+		Entry("unsupported types", map[string]any{"type": "MX", "name": "mail", "content": "mx.example.com"},
+			http.StatusBadRequest),
+		Entry("private addresses", map[string]any{"type": "A", "name": "www", "content": "10.0.0.1"},
+			http.StatusBadRequest),
+		Entry("addresses other than the client IP", map[string]any{"type": "A", "name": "home", "content": "1.2.3.8"},
+			http.StatusForbidden),
+		Entry("proxied records", map[string]any{"type": "A", "name": "api", "content": "1.2.3.4", "proxied": true},
+			http.StatusBadRequest),
+		Entry("identical records", map[string]any{"type": "A", "name": "www.example.com", "content": "1.2.3.4"},
+			http.StatusBadRequest),
+	)
+
+	It("should update the content of a record", func() {
+		old := findRecord("www.example.com", "A")
+		rec, res := do(http.MethodPatch, recordsURL+"/"+old.ID, map[string]any{"content": "1.2.3.7"})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(records.calls).To(Equal([]string{"add www A 1.2.3.7 300", "remove www A 1.2.3.4"}))
+
+		var updated recordJSON
+		Expect(json.Unmarshal(res.Result, &updated)).To(Succeed())
+		Expect(updated.ID).ToNot(Equal(old.ID))
+		Expect(updated.TTL).To(Equal(300))
+	})
+
+	It("should update the TTL of a record", func() {
+		old := findRecord("www.example.com", "A")
+		rec, _ := do(http.MethodPut, recordsURL+"/"+old.ID, map[string]any{
+			"type": "A", "name": "www.example.com", "content": "1.2.3.4", "ttl": 120,
+		})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(records.calls).To(Equal([]string{"ttl www A 120"}))
+	})
+
+	It("should delete records", func() {
+		old := findRecord("www.example.com", "A")
+		rec, res := do(http.MethodDelete, recordsURL+"/"+old.ID, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(string(res.Result)).To(ContainSubstring(old.ID))
+		Expect(records.calls).To(Equal([]string{"remove www A 1.2.3.4"}))
+
+		rec, _ = do(http.MethodDelete, recordsURL+"/"+old.ID, nil)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should limit changes against the upstream budget", func() {
+		limits.Upstream = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		rec, _ := do(http.MethodDelete, recordsURL+"/"+findRecord("www.example.com", "A").ID, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		rec, res := do(http.MethodDelete, recordsURL+"/"+findRecord("_acme-challenge.www.example.com", "TXT").ID, nil)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(res.Success).To(BeFalse())
+		Expect(records.calls).To(Equal([]string{"remove www A 1.2.3.4"}))
+	})
+
+	It("should limit changes per API token", func() {
+		limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		rec, _ := do(http.MethodDelete, recordsURL+"/"+findRecord("www.example.com", "A").ID, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		rec, _ = do(http.MethodDelete, recordsURL+"/"+findRecord("_acme-challenge.www.example.com", "TXT").ID, nil)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(records.calls).To(Equal([]string{"remove www A 1.2.3.4"}))
+	})
+})
@@ -0,0 +1,394 @@
+package cloudflare
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	apexName = "@"
+
+	// autoTTL is the TTL of Cloudflare records with an automatic TTL.
+	autoTTL = 1
+
+	// recordIDLength is the length of record IDs, like the hex IDs of
+	// Cloudflare.
+	recordIDLength = 32
+
+	errUnauthorized = "Unauthorized to access requested resource"
+)
+
+// apiError is an error answered to the client with code, the Cloudflare
+// error code errCode and message.
+type apiError struct {
+	code    int
+	errCode int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+var errRecordNotFound = &apiError{code: http.StatusNotFound, errCode: codeRecordNotFound, message: "Record does not exist."}
+
+// recordJSON is a Cloudflare DNS record, a single value of an RRSet.
+type recordJSON struct {
+	ID        string   `json:"id"`
+	ZoneID    string   `json:"zone_id"`
+	ZoneName  string   `json:"zone_name"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Content   string   `json:"content"`
+	Proxiable bool     `json:"proxiable"`
+	Proxied   bool     `json:"proxied"`
+	TTL       int      `json:"ttl"`
+	Locked    bool     `json:"locked"`
+	Tags      []string `json:"tags"`
+
+	// rrSetName is the name of the RRSet of the record.
+	rrSetName string
+}
+
+type recordRequest struct {
+	Name    *string `json:"name"`
+	Type    *string `json:"type"`
+	Content *string `json:"content"`
+	TTL     *int    `json:"ttl"`
+	Proxied *bool   `json:"proxied"`
+}
+
+func newRecordJSON(zone *hcloud.Zone, rrSetName, recordType, value string, ttl int) *recordJSON {
+	return &recordJSON{
+		ID:        recordID(zone.ID, rrSetName, recordType, value),
+		ZoneID:    strconv.FormatInt(zone.ID, 10),
+		ZoneName:  zone.Name,
+		Name:      recordFQDN(rrSetName, zone.Name),
+		Type:      recordType,
+		Content:   value,
+		TTL:       ttl,
+		Tags:      []string{},
+		rrSetName: rrSetName,
+	}
+}
+
+// recordID returns the ID of a record, a hash of its zone, RRSet and value.
+// IDs are stable as long as the value of the record is unchanged.
+func recordID(zoneID int64, rrSetName, recordType, value string) string {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(zoneID, 10) + "/" + rrSetName + "/" + recordType + "/" + value))
+	return hex.EncodeToString(sum[:])[:recordIDLength]
+}
+
+// recordFQDN returns the fully qualified name of the RRSet name in zone.
+func recordFQDN(rrSetName, zone string) string {
+	if rrSetName == apexName {
+		return zone
+	}
+	return rrSetName + "." + zone
+}
+
+// rrSetName returns the FQDN of name in zone and the name of its RRSet.
+// Like Cloudflare, names outside of zone are taken as relative to it.
+func rrSetName(name, zone string) (fqdn, rrSet string) {
+	fqdn = strings.ToLower(strings.TrimSuffix(name, "."))
+	switch {
+	case fqdn == "" || fqdn == apexName || fqdn == zone:
+		return zone, apexName
+	case !strings.HasSuffix(fqdn, "."+zone):
+		fqdn += "." + zone
+	}
+	return fqdn, strings.TrimSuffix(fqdn, "."+zone)
+}
+
+// zoneRecords returns the records of zone the API token of r may change.
+func (h *handler) zoneRecords(ctx context.Context, r *http.Request, zone *hcloud.Zone) ([]*recordJSON, error) {
+	rrSets, err := h.records.RRSets(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	t := middleware.APITokenFromContext(r.Context())
+	records := []*recordJSON{}
+	for _, rrSet := range rrSets {
+		if !middleware.APITokenAllows(&h.cfg.Auth, t, recordFQDN(rrSet.Name, zone.Name), string(rrSet.Type), r.RemoteAddr) {
+			continue
+		}
+		ttl := zone.TTL
+		if rrSet.TTL != nil {
+			ttl = *rrSet.TTL
+		}
+		for _, record := range rrSet.Records {
+			value := hetzner.UnquoteIfRequired(record.Value, rrSet.Type)
+			records = append(records, newRecordJSON(zone, rrSet.Name, string(rrSet.Type), value, ttl))
+		}
+	}
+	return records, nil
+}
+
+// findRecord returns the record of zone with id if the API token of r may
+// change it.
+func (h *handler) findRecord(ctx context.Context, r *http.Request, zone *hcloud.Zone, id string) (*recordJSON, error) {
+	records, err := h.zoneRecords(ctx, r, zone)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.ID == id {
+			return record, nil
+		}
+	}
+	return nil, errRecordNotFound
+}
+
+// listRecords lists the records of the zone, optionally filtered by the
+// exact type, name and content.
+func (h *handler) listRecords(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	records, err := h.zoneRecords(ctx, r, zone)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	recordType, content := strings.ToUpper(q.Get("type")), q.Get("content")
+	var name string
+	if q.Has("name") {
+		name, _ = rrSetName(q.Get("name"), zone.Name)
+	}
+	result := make([]*recordJSON, 0, len(records))
+	for _, record := range records {
+		if (recordType == "" || record.Type == recordType) &&
+			(name == "" || record.Name == name) &&
+			(content == "" || record.Content == content) {
+			result = append(result, record)
+		}
+	}
+	page, info := paginate(r, result)
+	writeResult(w, http.StatusOK, page, info)
+}
+
+func (h *handler) getRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	record, err := h.findRecord(ctx, r, zone, r.PathValue("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	writeResult(w, http.StatusOK, record, nil)
+}
+
+func (h *handler) createRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	req, err := decodeRecord(w, r)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	record, err := h.checkRecord(ctx, r, zone, req, nil)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	if !h.allow(w, r, zone) {
+		return
+	}
+	logChange(r, "add", record)
+	if err := h.records.AddRecord(ctx, zone, record.rrSetName, record.Type, record.Content, record.TTL); err != nil {
+		failed(w, err)
+		return
+	}
+	writeResult(w, http.StatusOK, record, nil)
+}
+
+// updateRecord replaces (PUT) or changes (PATCH) the record with id, both
+// keep the fields missing in the request. If its RRSet or value change, the
+// new record is added before the old one is removed.
+func (h *handler) updateRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	req, err := decodeRecord(w, r)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	old, err := h.findRecord(ctx, r, zone, r.PathValue("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	record, err := h.checkRecord(ctx, r, zone, req, old)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	if !h.allow(w, r, zone) {
+		return
+	}
+	logChange(r, "update", record)
+	if err := h.replace(ctx, zone, old, record); err != nil {
+		failed(w, err)
+		return
+	}
+	writeResult(w, http.StatusOK, record, nil)
+}
+
+func (h *handler) replace(ctx context.Context, zone *hcloud.Zone, old, record *recordJSON) error {
+	sameRRSet := old.Name == record.Name && old.Type == record.Type
+	if !sameRRSet || old.Content != record.Content {
+		if err := h.records.AddRecord(ctx, zone, record.rrSetName, record.Type, record.Content, record.TTL); err != nil {
+			return err
+		}
+		if err := h.records.RemoveRecord(ctx, zone, old.rrSetName, old.Type, old.Content); err != nil {
+			return err
+		}
+	}
+	if sameRRSet && old.TTL != record.TTL {
+		return h.records.ChangeTTL(ctx, zone, record.rrSetName, record.Type, record.TTL)
+	}
+	return nil
+}
+
+func (h *handler) deleteRecord(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	record, err := h.findRecord(ctx, r, zone, r.PathValue("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if !middleware.APITokenRulesAllow(h.cfg, r, config.EndpointCloudflare, record.Name, record.Type, record.Content) {
+		writeError(w, http.StatusForbidden, codeForbidden, errUnauthorized)
+		return
+	}
+	if !h.allow(w, r, zone) {
+		return
+	}
+	logChange(r, "delete", record)
+	if err := h.records.RemoveRecord(ctx, zone, record.rrSetName, record.Type, record.Content); err != nil {
+		failed(w, err)
+		return
+	}
+	writeResult(w, http.StatusOK, map[string]string{"id": record.ID}, nil)
+}
+
+// checkRecord returns the record of req in zone, with the fields of old for
+// the ones missing in req, if the API token of r may set it.
+func (h *handler) checkRecord(
+	ctx context.Context, r *http.Request, zone *hcloud.Zone, req *recordRequest, old *recordJSON,
+) (*recordJSON, error) {
+	name, recordType, content, ttl := mergeRecord(req, old)
+	if ttl == autoTTL {
+		ttl = h.cfg.RecordTTL
+	}
+
+	fqdn, rrSet := rrSetName(name, zone.Name)
+	rrSetType, err := hetzner.RRSetTypeFromString(recordType)
+	switch {
+	case err != nil:
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeValidation, message: "DNS Validation Error: " + err.Error()}
+	case content == "":
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeInvalidContent, message: "Content is missing."}
+	case ttl < autoTTL:
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeValidation, message: "DNS Validation Error: invalid TTL"}
+	case req.Proxied != nil && *req.Proxied:
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeValidation, message: "Proxied records are not supported."}
+	}
+
+	t := middleware.APITokenFromContext(r.Context())
+	if !middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, recordType, r.RemoteAddr) {
+		logDenied(r, t.Name, fqdn, recordType)
+		return nil, &apiError{code: http.StatusForbidden, errCode: codeForbidden, message: errUnauthorized}
+	}
+	content = hetzner.UnquoteIfRequired(content, rrSetType)
+	if err := h.checkContent(r, fqdn, recordType, content); err != nil {
+		return nil, err
+	}
+
+	record := newRecordJSON(zone, rrSet, recordType, content, ttl)
+	if old != nil && old.ID == record.ID {
+		return record, nil
+	}
+	if _, err := h.findRecord(ctx, r, zone, record.ID); err == nil {
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeIdenticalRecord, message: "An identical record already exists."}
+	} else if !errors.Is(err, errRecordNotFound) {
+		return nil, err
+	}
+	return record, nil
+}
+
+// checkContent checks content against the address policy, auth.matchClientIP
+// and auth.rules.
+func (h *handler) checkContent(r *http.Request, fqdn, recordType, content string) error {
+	if err := middleware.ValidateValue(&h.cfg.AddressPolicy, fqdn, content, recordType); err != nil {
+		return &apiError{code: http.StatusBadRequest, errCode: codeInvalidContent, message: err.Error()}
+	}
+	if !middleware.ClientIPAllowed(h.cfg, nil, fqdn, recordType, content, r.RemoteAddr) ||
+		!middleware.APITokenRulesAllow(h.cfg, r, config.EndpointCloudflare, fqdn, recordType, content) {
+		return &apiError{code: http.StatusForbidden, errCode: codeForbidden, message: errUnauthorized}
+	}
+	return nil
+}
+
+// mergeRecord returns the fields of req, with the ones of old for missing
+// fields. Without old, records are created at the apex with an automatic
+// TTL.
+func mergeRecord(req *recordRequest, old *recordJSON) (name, recordType, content string, ttl int) {
+	name, ttl = apexName, autoTTL
+	if old != nil {
+		name, recordType, content, ttl = old.Name, old.Type, old.Content, old.TTL
+	}
+	return deref(req.Name, name), strings.ToUpper(deref(req.Type, recordType)), deref(req.Content, content), deref(req.TTL, ttl)
+}
+
+func deref[T any](p *T, def T) T {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+func decodeRecord(w http.ResponseWriter, r *http.Request) (*recordRequest, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	req := &recordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeInvalidBody, message: "Request body is invalid."}
+	}
+	return req, nil
+}
+
+// writeErr answers err, which is an apiError or an error of the Cloud API.
+func writeErr(w http.ResponseWriter, err error) {
+	var e *apiError
+	if errors.As(err, &e) {
+		writeError(w, e.code, e.errCode, e.message)
+		return
+	}
+	failed(w, err)
+}
+
+func logChange(r *http.Request, action string, record *recordJSON) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	typ := sanitize.LogValue(record.Type)
+	name := sanitize.LogValue(record.Name)
+	val := sanitize.LogValue(record.Content)
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request of API token '%s' to %s '%s' data of '%s' with value '%s'", t, action, typ, name, val)
+}
+
+func logDenied(r *http.Request, token, fqdn, recordType string) {
+	t := sanitize.LogValue(token)
+	addr := sanitize.LogValue(r.RemoteAddr)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("API token '%s' of client '%s' is not allowed to change '%s' data of '%s'", t, addr, typ, name)
+}
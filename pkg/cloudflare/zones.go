@@ -0,0 +1,78 @@
+package cloudflare
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/zoneapi"
+)
+
+const errInvalidZone = "Invalid zone identifier"
+
+type zoneJSON struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Status      string   `json:"status"`
+	Paused      bool     `json:"paused"`
+	Type        string   `json:"type"`
+	NameServers []string `json:"name_servers"`
+	CreatedOn   string   `json:"created_on"`
+}
+
+func newZoneJSON(zone *hcloud.Zone) zoneJSON {
+	status := "pending"
+	if zone.Status == hcloud.ZoneStatusOk {
+		status = "active"
+	}
+	nameServers := make([]string, 0, len(zone.AuthoritativeNameservers.Assigned))
+	nameServers = append(nameServers, zone.AuthoritativeNameservers.Assigned...)
+	return zoneJSON{
+		ID:          strconv.FormatInt(zone.ID, 10),
+		Name:        zone.Name,
+		Status:      status,
+		Type:        "full",
+		NameServers: nameServers,
+		CreatedOn:   zone.Created.UTC().Format(time.RFC3339),
+	}
+}
+
+// listZones lists the zones of the API token, optionally filtered by the
+// exact name.
+func (h *handler) listZones(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.records.Zones(ctx)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(r.URL.Query().Get("name"), "."))
+	result := make([]zoneJSON, 0, len(zones))
+	for _, zone := range zones {
+		if (name == "" || zone.Name == name) && zoneapi.CoversZone(&h.cfg.Auth, r, zone.Name) {
+			result = append(result, newZoneJSON(zone))
+		}
+	}
+	page, info := paginate(r, result)
+	writeResult(w, http.StatusOK, page, info)
+}
+
+func (h *handler) getZone(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zone, err := zoneapi.VisibleZone(ctx, &h.cfg.Auth, h.records, r, r.PathValue("zone"))
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	if zone == nil {
+		writeError(w, http.StatusNotFound, codeInvalidZone, errInvalidZone)
+		return
+	}
+	writeResult(w, http.StatusOK, newZoneJSON(zone), nil)
+}
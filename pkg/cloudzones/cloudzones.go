@@ -0,0 +1,280 @@
+// Package cloudzones passes the zone routes of the Cloud API through to the
+// upstream API for clients authenticated with API tokens issued by the proxy.
+// The proxy injects its own Cloud API token and restricts clients to the
+// zones and RRSets their grants cover.
+package cloudzones
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	pathPrefix         = "/v1"
+	maxRequestBodySize = 64 << 10 // 64 KB
+	maxResponseSize    = 16 << 20 // 16 MB
+
+	errCodeForbidden    = "forbidden"
+	errCodeNotFound     = "not_found"
+	errCodeService      = "service_error"
+	errCodeInvalidInput = "invalid_input"
+	errCodeRateLimited  = "rate_limit_exceeded"
+)
+
+// upstreamHeaders are the headers of the Cloud API about the budget of the
+// token of the proxy, which are replaced by the limits of the proxy.
+var upstreamHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+
+// Zones looks up the zones of the Cloud API.
+type Zones interface {
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+}
+
+type handler struct {
+	cfg    *config.Config
+	limits *middleware.ScopedRateLimits
+	zones  Zones
+	proxy  *httputil.ReverseProxy
+}
+
+// filterKey is the request context key of the filter of list responses.
+type filterKey struct{}
+
+// filter removes the entries a client may not see from the decoded body of
+// a list response.
+type filter func(body map[string]json.RawMessage) error
+
+// New returns the handler of the /v1/zones routes. It must run after
+// middleware.NewAPITokenAuth. Routes that are not known to be restricted
+// to the zones and RRSets of the API token are forbidden. Changes are limited
+// by the zone and upstream limits of limits.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, zones Zones) func(http.Handler) http.Handler {
+	target, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		// The base URL is validated when reading the config.
+		panic(fmt.Sprintf("invalid baseURL: %v", err))
+	}
+
+	h := &handler{cfg: cfg, limits: limits, zones: zones}
+	h.proxy = &httputil.ReverseProxy{
+		Rewrite:        h.rewrite(target),
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   upstreamFailed,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/zones", h.listZones)
+	mux.HandleFunc("GET /v1/zones/{zone}", h.withZone(h.forward))
+	mux.HandleFunc("GET /v1/zones/{zone}/actions", h.withZone(h.forward))
+	mux.HandleFunc("GET /v1/zones/{zone}/actions/{action}", h.withZone(h.forward))
+	mux.HandleFunc("GET /v1/zones/{zone}/rrsets", h.withZone(h.listRRSets))
+	mux.HandleFunc("POST /v1/zones/{zone}/rrsets", h.withZone(h.createRRSet))
+	mux.HandleFunc("GET /v1/zones/{zone}/rrsets/{name}/{type}", h.withZone(h.rrSet))
+	mux.HandleFunc("PUT /v1/zones/{zone}/rrsets/{name}/{type}", h.withZone(h.rrSet))
+	mux.HandleFunc("DELETE /v1/zones/{zone}/rrsets/{name}/{type}", h.withZone(h.rrSet))
+	mux.HandleFunc("POST /v1/zones/{zone}/rrsets/{name}/{type}/actions/{action}", h.withZone(h.rrSetAction))
+	mux.HandleFunc("/", forbidden)
+
+	return func(_ http.Handler) http.Handler {
+		return mux
+	}
+}
+
+// APIToken returns the bearer token of r.
+func APIToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// Unauthorized answers requests without a valid API token like the Cloud
+// API.
+func Unauthorized(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusUnauthorized, "unauthorized", "unable to authenticate")
+}
+
+// rewrite returns the rewrite function of the proxy, which sends requests to
+// target with the Cloud API token of the proxy.
+func (h *handler) rewrite(target *url.URL) func(*httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, pathPrefix)
+		pr.Out.URL.RawPath = strings.TrimPrefix(pr.In.URL.RawPath, pathPrefix)
+		pr.SetURL(target)
+		pr.Out.Header.Set("Authorization", "Bearer "+h.cfg.Token)
+		// Let the transport negotiate and decode compression, list responses
+		// are rewritten.
+		pr.Out.Header.Del("Accept-Encoding")
+	}
+}
+
+// modifyResponse drops the rate limit headers of the Cloud API and applies
+// the filter of the request to successful list responses.
+func modifyResponse(resp *http.Response) error {
+	for _, header := range upstreamHeaders {
+		resp.Header.Del(header)
+	}
+	f, ok := resp.Request.Context().Value(filterKey{}).(filter)
+	if !ok || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if closeErr := resp.Body.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	if err := f(body); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(body); err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+// forward passes r on to the Cloud API.
+func (h *handler) forward(w http.ResponseWriter, r *http.Request, _ *hcloud.Zone) {
+	h.proxy.ServeHTTP(w, r)
+}
+
+// forwardFiltered passes r on to the Cloud API and applies f to the
+// response.
+func (h *handler) forwardFiltered(w http.ResponseWriter, r *http.Request, f filter) {
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), filterKey{}, f)))
+}
+
+// withZone looks up the zone of the request and answers 404 if the API
+// token does not cover it, like the Cloud API does for unknown zones.
+func (h *handler) withZone(next func(http.ResponseWriter, *http.Request, *hcloud.Zone)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+		defer cancel()
+		zone, err := h.zones.Zone(ctx, r.PathValue("zone"))
+		if err != nil {
+			failed(w, err)
+			return
+		}
+		if zone == nil || !h.coversZone(r, zone.Name) {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "zone not found")
+			return
+		}
+		next(w, r, zone)
+	}
+}
+
+func (h *handler) coversZone(r *http.Request, zone string) bool {
+	return middleware.APITokenCoversZone(&h.cfg.Auth, middleware.APITokenFromContext(r.Context()), zone, r.RemoteAddr)
+}
+
+// listZones passes the zone listing on and removes the zones the API token
+// does not cover from the response.
+func (h *handler) listZones(w http.ResponseWriter, r *http.Request) {
+	h.forwardFiltered(w, r, func(body map[string]json.RawMessage) error {
+		return filterList(body, "zones", func(e *entry) bool {
+			return h.coversZone(r, strings.ToLower(e.Name))
+		})
+	})
+}
+
+// entry holds the fields of the entries of list responses that filters
+// check.
+type entry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// filterList keeps the entries of the list key of body that keep accepts.
+// The total number of entries is removed from the pagination metadata as it
+// would reveal the number of hidden entries.
+func filterList(body map[string]json.RawMessage, key string, keep func(e *entry) bool) error {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body[key], &entries); err != nil {
+		return err
+	}
+	kept := make([]json.RawMessage, 0, len(entries))
+	for _, raw := range entries {
+		e := &entry{}
+		if err := json.Unmarshal(raw, e); err != nil {
+			return err
+		}
+		if keep(e) {
+			kept = append(kept, raw)
+		}
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	body[key] = data
+	return hideTotalEntries(body)
+}
+
+func hideTotalEntries(body map[string]json.RawMessage) error {
+	raw, ok := body["meta"]
+	if !ok {
+		return nil
+	}
+	var m map[string]map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return err
+	}
+	if pagination, ok := m["pagination"]; ok {
+		pagination["total_entries"] = nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	body["meta"] = data
+	return nil
+}
+
+// forbidden answers the routes that are not passed on to the Cloud API.
+func forbidden(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusForbidden, errCodeForbidden, "route not available with this API token")
+}
+
+func writeError(w http.ResponseWriter, code int, errCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": errCode, "message": message, "details": nil},
+	})
+	if err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func failed(w http.ResponseWriter, err error) {
+	log.Printf("failed to call the Cloud API: %v", err)
+	writeError(w, http.StatusInternalServerError, errCodeService, "internal server error")
+}
+
+func upstreamFailed(w http.ResponseWriter, _ *http.Request, err error) {
+	log.Printf("failed to forward request to the Cloud API: %v", err)
+	writeError(w, http.StatusBadGateway, errCodeService, "upstream request failed")
+}
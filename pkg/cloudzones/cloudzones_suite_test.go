@@ -0,0 +1,13 @@
+package cloudzones_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloudZones(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "cloudzones test suite")
+}
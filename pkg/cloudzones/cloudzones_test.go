@@ -0,0 +1,246 @@
+package cloudzones_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cloudzones"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+const (
+	token         = "team-token"
+	upstreamToken = "upstream-token"
+	rrSetsURL     = "/v1/zones/1/rrsets"
+)
+
+type fakeZones []*hcloud.Zone
+
+func (f fakeZones) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	for _, zone := range f {
+		if strconv.FormatInt(zone.ID, 10) == idOrName || zone.Name == idOrName {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+// upstreamRequest is a request received by the fake Cloud API.
+type upstreamRequest struct {
+	Method        string
+	Path          string
+	Authorization string
+	Body          string
+}
+
+var _ = Describe("Cloud API zones pass-through", func() {
+	var (
+		upstream *httptest.Server
+		received []upstreamRequest
+		limits   *middleware.ScopedRateLimits
+		handler  http.Handler
+	)
+
+	BeforeEach(func() {
+		received = nil
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received = append(received, upstreamRequest{
+				Method: r.Method, Path: r.URL.Path, Authorization: r.Header.Get("Authorization"), Body: string(body),
+			})
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("RateLimit-Remaining", "3599")
+			switch r.URL.Path {
+			case "/v1/zones":
+				_, _ = io.WriteString(w, `{"zones":[{"id":1,"name":"example.com"},{"id":2,"name":"example.org"}],`+
+					`"meta":{"pagination":{"page":1,"per_page":25,"next_page":null,"total_entries":2}}}`)
+			case rrSetsURL:
+				_, _ = io.WriteString(w, `{"rrsets":[`+
+					`{"id":"www/A","name":"www","type":"A","records":[{"value":"1.2.3.4"}]},`+
+					`{"id":"@/A","name":"@","type":"A","records":[{"value":"1.2.3.5"}]},`+
+					`{"id":"@/MX","name":"@","type":"MX","records":[{"value":"10 mx.example.com."}]}]}`)
+			default:
+				_, _ = io.WriteString(w, `{"action":{"id":1,"status":"running"}}`)
+			}
+		}))
+		DeferCleanup(upstream.Close)
+
+		cfg := &config.Config{
+			BaseURL: upstream.URL + "/v1",
+			Token:   upstreamToken,
+			Timeout: 10,
+			Auth: config.Auth{
+				APITokens: []config.APIToken{{Name: "team", Token: token, Domains: []string{"*.example.com"}}},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewAPITokenAuth(cfg, lockout, cloudzones.APIToken, cloudzones.Unauthorized)(
+			cloudzones.New(cfg, limits, fakeZones{
+				{ID: 1, Name: "example.com"},
+				{ID: 2, Name: "example.org"},
+			})(nil),
+		)
+	})
+
+	do := func(method, target string, body any) (*httptest.ResponseRecorder, map[string]any) {
+		var reader io.Reader = http.NoBody
+		if body != nil {
+			data, err := json.Marshal(body)
+			Expect(err).ToNot(HaveOccurred())
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := map[string]any{}
+		if rec.Body.Len() > 0 {
+			Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+		}
+		return rec, res
+	}
+
+	names := func(res map[string]any, key string) []string {
+		var result []string
+		for _, e := range res[key].([]any) {
+			entry := e.(map[string]any)
+			name := entry["name"].(string)
+			if t, ok := entry["type"]; ok {
+				name += "/" + t.(string)
+			}
+			result = append(result, name)
+		}
+		return result
+	}
+
+	It("should reject invalid API tokens", func() {
+		req := httptest.NewRequest(http.MethodGet, "/v1/zones", http.NoBody)
+		req.Header.Set("Authorization", "Bearer invalid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(received).To(BeEmpty())
+	})
+
+	It("should list only covered zones with the upstream token", func() {
+		rec, res := do(http.MethodGet, "/v1/zones", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(names(res, "zones")).To(ConsistOf("example.com"))
+		Expect(res["meta"]).To(HaveKeyWithValue("pagination", HaveKeyWithValue("total_entries", BeNil())))
+		Expect(received).To(HaveLen(1))
+		Expect(received[0].Authorization).To(Equal("Bearer " + upstreamToken))
+	})
+
+	It("should hide zones that are not covered", func() {
+		rec, _ := do(http.MethodGet, "/v1/zones/example.org", nil)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+		Expect(received).To(BeEmpty())
+	})
+
+	It("should pass covered zones on", func() {
+		rec, _ := do(http.MethodGet, "/v1/zones/example.com", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(received).To(ConsistOf(HaveField("Path", "/v1/zones/example.com")))
+	})
+
+	It("should list only granted RRSets", func() {
+		rec, res := do(http.MethodGet, rrSetsURL, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(names(res, "rrsets")).To(ConsistOf("www/A"))
+	})
+
+	It("should pass granted RRSet changes on", func() {
+		body := map[string]any{"name": "api", "type": "A", "records": []map[string]string{{"value": "1.2.3.4"}}}
+		rec, _ := do(http.MethodPost, rrSetsURL, body)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(received).To(HaveLen(1))
+		Expect(received[0].Method).To(Equal(http.MethodPost))
+		Expect(received[0].Body).To(ContainSubstring(`"api"`))
+	})
+
+	It("should pass on the checked fields of RRSet changes only", func() {
+		rec, _ := do(http.MethodPost, rrSetsURL, json.RawMessage(
+			`{"name":"API","type":"a","ttl":60,"records":[{"value":"1.2.3.4","comment":"x"}],"zone":"example.org"}`,
+		))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(received).To(HaveLen(1))
+		Expect(received[0].Body).To(MatchJSON(`{"name":"api","type":"A","ttl":60,"records":[{"value":"1.2.3.4","comment":"x"}]}`))
+
+		rec, _ = do(http.MethodPost, rrSetsURL+"/www/A/actions/change_ttl", json.RawMessage(`{"ttl":null,"name":"@"}`))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(received).To(HaveLen(2))
+		Expect(received[1].Body).To(MatchJSON(`{"ttl":null}`))
+	})
+
+	It("should limit RRSet changes per zone with the limits of the proxy", func() {
+		limits.Zone = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		rec, _ := do(http.MethodDelete, rrSetsURL+"/www/A", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Values("RateLimit-Remaining")).To(Equal([]string{"0"}))
+
+		rec, res := do(http.MethodDelete, rrSetsURL+"/www/A", nil)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(res).To(HaveKeyWithValue("error", HaveKeyWithValue("code", "rate_limit_exceeded")))
+		Expect(received).To(HaveLen(1))
+	})
+
+	It("should limit RRSet changes per API token", func() {
+		limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		rec, _ := do(http.MethodDelete, rrSetsURL+"/www/A", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		rec, _ = do(http.MethodDelete, rrSetsURL+"/_acme-challenge.www/TXT", nil)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(received).To(HaveLen(1))
+	})
+
+	DescribeTable("should reject", func(method, target string, body any, code int) {
+		rec, res := do(method, target, body)
+		Expect(rec.Code).To(Equal(code))
+		Expect(res).To(HaveKey("error"))
+		Expect(received).To(BeEmpty())
+	},
+		Entry("RRSets outside the grants", http.MethodPost, rrSetsURL,
+			map[string]any{"name": "@", "type": "A", "records": []map[string]string{{"value": "1.2.3.4"}}},
+			http.StatusForbidden),
+		Entry("private addresses", http.MethodPost, rrSetsURL+"/www/A/actions/add_records",
+			map[string]any{"records": []map[string]string{{"value": "10.0.0.1"}}},
+			http.StatusUnprocessableEntity),
+		Entry("addresses other than the client IP", http.MethodPost, rrSetsURL,
+			map[string]any{"name": "home", "type": "A", "records": []map[string]string{{"value": "1.2.3.8"}}},
+			http.StatusForbidden),
+		Entry("deleting RRSets outside the grants", http.MethodDelete, rrSetsURL+"/@/A", nil,
+			http.StatusForbidden),
+		Entry("RRSets of zones that are not covered", http.MethodGet, "/v1/zones/2/rrsets", nil,
+			http.StatusNotFound),
+		Entry("deleting zones", http.MethodDelete, "/v1/zones/1", nil,
+			http.StatusForbidden),
+		Entry("exporting zone files", http.MethodGet, "/v1/zones/1/zonefile", nil,
+			http.StatusForbidden),
+	)
+
+	It("should pass removing private addresses on", func() {
+		rec, _ := do(http.MethodPost, rrSetsURL+"/www/A/actions/remove_records",
+			map[string]any{"records": []map[string]string{{"value": "10.0.0.1"}}})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(received).To(ConsistOf(HaveField("Path", rrSetsURL+"/www/A/actions/remove_records")))
+	})
+})
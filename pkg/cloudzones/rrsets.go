@@ -0,0 +1,251 @@
+package cloudzones
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// apexName is the name of the RRSets at the apex of a zone.
+	apexName = "@"
+
+	// actionRemoveRecords is the RRSet action removing records, whose values
+	// are not checked against the address policy.
+	actionRemoveRecords = "remove_records"
+
+	errRRSetNotAllowed = "RRSet not allowed with this API token"
+)
+
+// rrSetRequest holds the fields of RRSet request bodies the proxy passes on.
+// Requests are re-encoded from it after the checks, so that the Cloud API
+// receives exactly the checked values.
+type rrSetRequest struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	TTL     optionalTTL       `json:"ttl"`
+	Labels  map[string]string `json:"labels"`
+	Records []rrSetRecord     `json:"records"`
+}
+
+type rrSetRecord struct {
+	Value   string `json:"value"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// optionalTTL is a TTL that may be null, which resets it to the TTL of the
+// zone, and keeps whether it was sent at all.
+type optionalTTL struct {
+	set   bool
+	value *int
+}
+
+func (t *optionalTTL) UnmarshalJSON(data []byte) error {
+	t.set = true
+	return json.Unmarshal(data, &t.value)
+}
+
+// listRRSets passes the RRSet listing of zone on and removes the RRSets the
+// API token does not grant from the response.
+func (h *handler) listRRSets(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	t := middleware.APITokenFromContext(r.Context())
+	h.forwardFiltered(w, r, func(body map[string]json.RawMessage) error {
+		return filterList(body, "rrsets", func(e *entry) bool {
+			fqdn := rrSetFQDN(e.Name, zone.Name)
+			return middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, strings.ToUpper(e.Type), r.RemoteAddr)
+		})
+	})
+}
+
+func (h *handler) createRRSet(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	req, ok := decodeRRSet(w, r)
+	if !ok {
+		return
+	}
+	if !h.allowed(w, r, zone, req.Name, req.Type) || !h.validValues(w, r, zone, req) || !h.rulesAllow(w, r, zone, req) ||
+		!h.limitAllows(w, r, zone) {
+		return
+	}
+	logChange(r, "create", rrSetFQDN(req.Name, zone.Name), req.Type)
+	h.forwardRRSet(w, r, zone, req, true)
+}
+
+// rrSet handles reading, changing the labels of and deleting the RRSet of
+// the path.
+func (h *handler) rrSet(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	name, recordType := r.PathValue("name"), strings.ToUpper(r.PathValue("type"))
+	if !h.allowed(w, r, zone, name, recordType) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		if !h.rulesAllow(w, r, zone, &rrSetRequest{Name: name, Type: recordType}) || !h.limitAllows(w, r, zone) {
+			return
+		}
+		logChange(r, strings.ToLower(r.Method), rrSetFQDN(name, zone.Name), recordType)
+	}
+	h.forward(w, r, zone)
+}
+
+// rrSetAction handles the actions of the RRSet of the path. The values of
+// added and set records are checked against the address policy.
+func (h *handler) rrSetAction(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	name, recordType := r.PathValue("name"), strings.ToUpper(r.PathValue("type"))
+	if !h.allowed(w, r, zone, name, recordType) {
+		return
+	}
+	req, ok := decodeRRSet(w, r)
+	if !ok {
+		return
+	}
+	req.Name, req.Type = name, recordType
+	if r.PathValue("action") != actionRemoveRecords && !h.validValues(w, r, zone, req) {
+		return
+	}
+	if !h.rulesAllow(w, r, zone, req) || !h.limitAllows(w, r, zone) {
+		return
+	}
+	logChange(r, r.PathValue("action"), rrSetFQDN(name, zone.Name), recordType)
+	h.forwardRRSet(w, r, zone, req, false)
+}
+
+// allowed reports whether the API token of r grants the RRSet name of
+// recordType in zone and answers 403 if it does not.
+func (h *handler) allowed(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone, name, recordType string) bool {
+	fqdn := rrSetFQDN(name, zone.Name)
+	t := middleware.APITokenFromContext(r.Context())
+	if middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, recordType, r.RemoteAddr) {
+		return true
+	}
+	logDenied(r, t.Name, fqdn, recordType)
+	writeError(w, http.StatusForbidden, errCodeForbidden, errRRSetNotAllowed)
+	return false
+}
+
+// rulesAllow reports whether auth.rules allow the record values of req, or
+// the RRSet without a value if req has no records, and answers 403 if they
+// do not.
+func (h *handler) rulesAllow(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone, req *rrSetRequest) bool {
+	fqdn := rrSetFQDN(req.Name, zone.Name)
+	values := []string{""}
+	if len(req.Records) > 0 {
+		values = values[:0]
+		for _, record := range req.Records {
+			values = append(values, record.Value)
+		}
+	}
+	for _, value := range values {
+		if !middleware.APITokenRulesAllow(h.cfg, r, config.EndpointCloudZones, fqdn, req.Type, value) {
+			writeError(w, http.StatusForbidden, errCodeForbidden, errRRSetNotAllowed)
+			return false
+		}
+	}
+	return true
+}
+
+// limitAllows reports whether changing an RRSet of zone with the API token
+// of r is within the scoped rate limits and answers 429 if it is not.
+func (h *handler) limitAllows(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) bool {
+	if h.limits.Allow(w, middleware.APITokenLimitUser(r), zone.Name) {
+		return true
+	}
+	writeError(w, http.StatusTooManyRequests, errCodeRateLimited, "rate limit exceeded")
+	return false
+}
+
+// validValues reports whether the record values of req are valid and
+// allowed by the address policy and auth.matchClientIP and answers 422 or
+// 403 if they are not.
+func (h *handler) validValues(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone, req *rrSetRequest) bool {
+	fqdn := rrSetFQDN(req.Name, zone.Name)
+	for _, record := range req.Records {
+		if err := middleware.ValidateValue(&h.cfg.AddressPolicy, fqdn, record.Value, req.Type); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, errCodeInvalidInput, err.Error())
+			return false
+		}
+		if !middleware.ClientIPAllowed(h.cfg, nil, fqdn, req.Type, record.Value, r.RemoteAddr) {
+			writeError(w, http.StatusForbidden, errCodeForbidden, errRRSetNotAllowed)
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRRSet decodes the RRSet of the body of r, which is passed on by
+// forwardRRSet.
+func decodeRRSet(w http.ResponseWriter, r *http.Request) (*rrSetRequest, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	req := &rrSetRequest{}
+	if err == nil {
+		err = json.Unmarshal(data, req)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidInput, "invalid request body")
+		return nil, false
+	}
+	req.Type = strings.ToUpper(req.Type)
+	return req, true
+}
+
+// forwardRRSet passes r on to the Cloud API with the body encoded from req,
+// with its name and type for withName. Fields of the original body the
+// proxy does not know are dropped.
+func (h *handler) forwardRRSet(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone, req *rrSetRequest, withName bool) {
+	body := map[string]any{}
+	if withName {
+		body["name"] = strings.ToLower(req.Name)
+		body["type"] = req.Type
+	}
+	if req.TTL.set {
+		body["ttl"] = req.TTL.value
+	}
+	if req.Labels != nil {
+		body["labels"] = req.Labels
+	}
+	if req.Records != nil {
+		body["records"] = req.Records
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	h.forward(w, r, zone)
+}
+
+// rrSetFQDN returns the FQDN of the RRSet name in zone.
+func rrSetFQDN(name, zone string) string {
+	name = strings.ToLower(name)
+	if name == apexName || name == "" {
+		return zone
+	}
+	return name + "." + zone
+}
+
+func logChange(r *http.Request, action, fqdn, recordType string) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	act := sanitize.LogValue(action)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request of API token '%s' to %s '%s' RRSet of '%s'", t, act, typ, name)
+}
+
+func logDenied(r *http.Request, token, fqdn, recordType string) {
+	t := sanitize.LogValue(token)
+	addr := sanitize.LogValue(r.RemoteAddr)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("API token '%s' of client '%s' is not allowed to change '%s' RRSet of '%s'", t, addr, typ, name)
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// AddressPolicy restricts the values of A and AAAA records on public zones.
+// Unless Disabled, private and reserved addresses are rejected, except for
+// those Allow grants to its domains.
+type AddressPolicy struct {
+	Disabled bool                 `yaml:"disabled,omitempty"`
+	Allow    []AddressPolicyAllow `yaml:"allow,omitempty"`
+}
+
+// AddressPolicyAllow allows private and reserved addresses in Networks for
+// records of Domains, in which *. matches all subdomains. Empty Networks
+// allow all of them, e.g. for split-horizon names.
+type AddressPolicyAllow struct {
+	Domains  []string       `yaml:"domains"`
+	Networks []string       `yaml:"networks,omitempty"`
+	Prefixes []netip.Prefix `yaml:"-"`
+}
+
+// Allows reports whether the entry allows addr, which is a private or
+// reserved address.
+func (a *AddressPolicyAllow) Allows(addr netip.Addr) bool {
+	if len(a.Prefixes) == 0 {
+		return true
+	}
+	return containsAddr(a.Prefixes, addr)
+}
+
+func parseAddressPolicy(p *AddressPolicy) error {
+	for i := range p.Allow {
+		allow := &p.Allow[i]
+		if len(allow.Domains) == 0 {
+			return fmt.Errorf("addressPolicy.allow[%d].domains cannot be empty", i)
+		}
+		prefixes, err := parsePrefixes(allow.Networks)
+		if err != nil {
+			return fmt.Errorf("invalid addressPolicy.allow[%d].networks entry %w", i, err)
+		}
+		allow.Prefixes = prefixes
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// APIToken grants Domains and the grants of Roles to clients of the emulated
+// DNS provider APIs sending Token. Name identifies the token in logs and
+// rate limits, the token itself is never forwarded to the Cloud API.
+// Clients signing requests with AWS Signature Version 4 use AccessKeyID as
+// access key ID and Token as secret access key.
+type APIToken struct {
+	Name        string   `yaml:"name"`
+	Token       string   `yaml:"token"`
+	AccessKeyID string   `yaml:"accessKeyID,omitempty"`
+	Domains     []string `yaml:"domains"`
+	Roles       []string `yaml:"roles,omitempty"`
+}
+
+func validateAPITokens(a *Auth) error {
+	names := map[string]struct{}{}
+	tokens := map[string]struct{}{}
+	accessKeyIDs := map[string]struct{}{}
+	for i := range a.APITokens {
+		t := &a.APITokens[i]
+		if t.Name == "" {
+			return fmt.Errorf("auth.apiTokens[%d].name cannot be empty", i)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicate auth.apiTokens[%d].name: %s", i, t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if t.Token == "" {
+			return fmt.Errorf("auth.apiTokens[%d].token cannot be empty", i)
+		}
+		if _, ok := tokens[t.Token]; ok {
+			return errors.New("auth.apiTokens tokens must be unique")
+		}
+		tokens[t.Token] = struct{}{}
+		if t.AccessKeyID != "" {
+			if _, ok := accessKeyIDs[t.AccessKeyID]; ok {
+				return fmt.Errorf("duplicate auth.apiTokens[%d].accessKeyID: %s", i, t.AccessKeyID)
+			}
+			accessKeyIDs[t.AccessKeyID] = struct{}{}
+		}
+		if len(t.Domains) == 0 && len(t.Roles) == 0 {
+			return fmt.Errorf("auth.apiTokens[%d] must have domains or roles", i)
+		}
+		if err := checkRoleRefs(a.Roles, t.Roles); err != nil {
+			return fmt.Errorf("invalid auth.apiTokens[%d].roles: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// ClientIPMatchExact requires A and AAAA values to equal the client IP.
+	ClientIPMatchExact = "exact"
+	// ClientIPMatchPrefix requires AAAA values to be in the /64 of the
+	// client IP. A values must still equal it.
+	ClientIPMatchPrefix = "prefix"
+)
+
+// ClientIPMatch enforces Mode on A and AAAA records of Domains, in which
+// *. matches all subdomains.
+type ClientIPMatch struct {
+	Domains []string `yaml:"domains"`
+	Mode    string   `yaml:"mode"`
+}
+
+func validateClientIPMatch(a *Auth) error {
+	for i, user := range a.Users {
+		if user.MatchClientIP != "" && !clientIPMatchModeIsValid(user.MatchClientIP) {
+			return fmt.Errorf("invalid auth.users[%d].matchClientIP: %s", i, user.MatchClientIP)
+		}
+	}
+	for i, m := range a.MatchClientIP {
+		if len(m.Domains) == 0 {
+			return fmt.Errorf("auth.matchClientIP[%d].domains cannot be empty", i)
+		}
+		if !clientIPMatchModeIsValid(m.Mode) {
+			return fmt.Errorf("invalid auth.matchClientIP[%d].mode: %s", i, m.Mode)
+		}
+	}
+	return nil
+}
+
+func clientIPMatchModeIsValid(mode string) bool {
+	return slices.Contains([]string{ClientIPMatchExact, ClientIPMatchPrefix}, mode)
+}
@@ -1,15 +1,22 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/goccy/go-yaml"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/htpasswd"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
 )
 
 type AllowedDomains map[string][]*net.IPNet
@@ -37,18 +44,29 @@ func (out *AllowedDomains) FromString(val string) error {
 }
 
 type Config struct {
-	BaseURL              string         `yaml:"baseURL"`
-	Token                string         `yaml:"token"`
-	Timeout              int            `yaml:"timeout"`
-	Auth                 Auth           `yaml:"auth"`
-	Endpoints            Endpoints      `yaml:"endpoints"`
-	RecordTTL            int            `yaml:"recordTTL"`
-	ListenAddr           string         `yaml:"listenAddr"`
-	TrustedProxies       []string       `yaml:"trustedProxies"`
-	TrustedProxyPrefixes []netip.Prefix `yaml:"-"`
-	RateLimit            RateLimit      `yaml:"rateLimit"`
-	Lockout              Lockout        `yaml:"lockout"`
-	Debug                bool           `yaml:"debug"`
+	BaseURL              string                `yaml:"baseURL"`
+	Token                string                `yaml:"token"`
+	Timeout              int                   `yaml:"timeout"`
+	Auth                 Auth                  `yaml:"auth"`
+	Endpoints            Endpoints             `yaml:"endpoints"`
+	RecordTTL            int                   `yaml:"recordTTL"`
+	ListenAddr           string                `yaml:"listenAddr"`
+	TLS                  TLS                   `yaml:"tls"`
+	ProxyProtocol        ProxyProtocol         `yaml:"proxyProtocol"`
+	TrustedProxies       []string              `yaml:"trustedProxies"`
+	TrustedProxyPrefixes []netip.Prefix        `yaml:"-"`
+	ClientIPHeaders      []string              `yaml:"clientIPHeaders,omitempty"`
+	TrustedProxyHeaders  []TrustedProxyHeaders `yaml:"trustedProxyHeaders,omitempty"`
+	DenyNetworks         NetworkList           `yaml:"denyNetworks"`
+	ExemptNetworks       NetworkList           `yaml:"exemptNetworks"`
+	RateLimit            RateLimit             `yaml:"rateLimit"`
+	Lockout              Lockout               `yaml:"lockout"`
+	AddressPolicy        AddressPolicy         `yaml:"addressPolicy"`
+	AcmeStrict           bool                  `yaml:"acmeStrict"`
+	RFC2136              *RFC2136              `yaml:"rfc2136,omitempty"`
+	Webhook              *Webhook              `yaml:"webhook,omitempty"`
+	DuckDNS              *DuckDNS              `yaml:"duckDNS,omitempty"`
+	Debug                bool                  `yaml:"debug"`
 }
 
 type Endpoints struct {
@@ -57,6 +75,14 @@ type Endpoints struct {
 	AcmeDNS     bool `yaml:"acmedns"`
 	HTTPReq     bool `yaml:"httpreq"`
 	DirectAdmin bool `yaml:"directadmin"`
+	HetznerDNS  bool `yaml:"hetznerdns"`
+	CloudZones  bool `yaml:"cloudzones"`
+	PowerDNS    bool `yaml:"powerdns"`
+	Cloudflare  bool `yaml:"cloudflare"`
+	Route53     bool `yaml:"route53"`
+	Namecheap   bool `yaml:"namecheap"`
+	CPanel      bool `yaml:"cpanel"`
+	DuckDNS     bool `yaml:"duckdns"`
 }
 
 func (e *Endpoints) Enabled() []string {
@@ -76,6 +102,30 @@ func (e *Endpoints) Enabled() []string {
 	if e.DirectAdmin {
 		names = append(names, EndpointDirectAdmin)
 	}
+	if e.HetznerDNS {
+		names = append(names, EndpointHetznerDNS)
+	}
+	if e.CloudZones {
+		names = append(names, EndpointCloudZones)
+	}
+	if e.PowerDNS {
+		names = append(names, EndpointPowerDNS)
+	}
+	if e.Cloudflare {
+		names = append(names, EndpointCloudflare)
+	}
+	if e.Route53 {
+		names = append(names, EndpointRoute53)
+	}
+	if e.Namecheap {
+		names = append(names, EndpointNamecheap)
+	}
+	if e.CPanel {
+		names = append(names, EndpointCPanel)
+	}
+	if e.DuckDNS {
+		names = append(names, EndpointDuckDNS)
+	}
 	return names
 }
 
@@ -89,10 +139,25 @@ func (e *Endpoints) UnmarshalYAML(unmarshal func(any) error) error {
 	return nil
 }
 
+// Auth configures how clients are authorized. Users are given inline or
+// loaded from the htpasswd file UsersFile with their domains listed in
+// UsersDomainsFile, both files are reloaded when they change.
 type Auth struct {
-	Method         string         `yaml:"method"`
-	AllowedDomains AllowedDomains `yaml:"allowedDomains"`
-	Users          []User         `yaml:"users"`
+	Method           string           `yaml:"method"`
+	AllowedDomains   AllowedDomains   `yaml:"allowedDomains"`
+	AllowedNetworks  []AllowedNetwork `yaml:"allowedNetworks,omitempty"`
+	Roles            map[string]*Role `yaml:"roles,omitempty"`
+	Users            []User           `yaml:"users"`
+	UsersFile        string           `yaml:"usersFile,omitempty"`
+	UsersDomainsFile string           `yaml:"usersDomainsFile,omitempty"`
+	FileUsers        *htpasswd.File   `yaml:"-"`
+	ClientCerts      []ClientCert     `yaml:"clientCerts,omitempty"`
+	JWT              *JWT             `yaml:"jwt,omitempty"`
+	ForwardAuth      *ForwardAuth     `yaml:"forwardAuth,omitempty"`
+	SignedRequests   *SignedRequests  `yaml:"signedRequests,omitempty"`
+	Rules            []Rule           `yaml:"rules,omitempty"`
+	MatchClientIP    []ClientIPMatch  `yaml:"matchClientIP,omitempty"`
+	APITokens        []APIToken       `yaml:"apiTokens,omitempty"`
 }
 
 const (
@@ -101,6 +166,14 @@ const (
 	EndpointAcmeDNS     = "acmedns"
 	EndpointHTTPReq     = "httpreq"
 	EndpointDirectAdmin = "directadmin"
+	EndpointHetznerDNS  = "hetznerdns"
+	EndpointCloudZones  = "cloudzones"
+	EndpointPowerDNS    = "powerdns"
+	EndpointCloudflare  = "cloudflare"
+	EndpointRoute53     = "route53"
+	EndpointNamecheap   = "namecheap"
+	EndpointCPanel      = "cpanel"
+	EndpointDuckDNS     = "duckdns"
 )
 
 const (
@@ -108,18 +181,172 @@ const (
 	AuthMethodUsers          = "users"
 	AuthMethodBoth           = "both"
 	AuthMethodAny            = "any"
+	AuthMethodClientCert     = "clientCert"
+	AuthMethodJWT            = "jwt"
+	AuthMethodForwardAuth    = "forwardAuth"
 )
 
+// User grants Domains and the grants of Roles to clients authenticating with
+// Username and Password.
+// Users of auth.usersFile have a PasswordHash instead of a Password. An entry
+// of auth.users without a Password adds its Domains, Roles and MatchClientIP
+// to the user of auth.usersFile with the same Username.
+// MatchClientIP is the ClientIPMatch mode enforced on the records of the user.
 type User struct {
-	Username string   `yaml:"username"`
-	Password string   `yaml:"password"`
+	Username      string   `yaml:"username"`
+	Password      string   `yaml:"password"`
+	Domains       []string `yaml:"domains"`
+	Roles         []string `yaml:"roles,omitempty"`
+	MatchClientIP string   `yaml:"matchClientIP,omitempty"`
+	PasswordHash  string   `yaml:"-"`
+}
+
+// AllUsers returns the users of auth.users followed by those of
+// auth.usersFile, which replace the entries without a password they are
+// merged with.
+func (a *Auth) AllUsers() []User {
+	fileUsers := a.FileUsers.Users()
+	if len(fileUsers) == 0 {
+		return a.Users
+	}
+	users := make([]User, 0, len(a.Users)+len(fileUsers))
+	users = append(users, a.Users...)
+	for _, u := range fileUsers {
+		user := User{Username: u.Username, Domains: u.Domains, PasswordHash: u.Hash}
+		i := slices.IndexFunc(users, func(c User) bool {
+			return c.Username == u.Username && c.Password == "" && c.PasswordHash == ""
+		})
+		if i < 0 {
+			users = append(users, user)
+			continue
+		}
+		user.Domains = slices.Concat(users[i].Domains, u.Domains)
+		user.Roles = users[i].Roles
+		user.MatchClientIP = users[i].MatchClientIP
+		users[i] = user
+	}
+	return users
+}
+
+// ClientCert grants Domains to clients presenting a certificate issued by
+// tls.clientCA. Exactly one of Subject, DNSName and SPIFFEID identifies the
+// certificate: Subject is matched against the distinguished name of the
+// certificate (e.g. "CN=sensor-1,O=Example"), DNSName and SPIFFEID against
+// its DNS and URI subject alternative names.
+type ClientCert struct {
+	Subject  string   `yaml:"subject,omitempty"`
+	DNSName  string   `yaml:"dnsName,omitempty"`
+	SPIFFEID string   `yaml:"spiffeID,omitempty"`
 	Domains  []string `yaml:"domains"`
 }
 
+// JWT authenticates clients by bearer tokens signed by a key of the JSON Web
+// Key Set in JWKSFile or at JWKSURL. Tokens must be issued by Issuer for
+// Audience. Grants are taken from the DomainsClaim and RecordTypesClaim of
+// the token and from the Subjects matching its sub claim.
+type JWT struct {
+	Issuer           string       `yaml:"issuer"`
+	Audience         string       `yaml:"audience"`
+	JWKSFile         string       `yaml:"jwksFile,omitempty"`
+	JWKSURL          string       `yaml:"jwksURL,omitempty"`
+	JWKSCacheSeconds int          `yaml:"jwksCacheSeconds,omitempty"`
+	DomainsClaim     string       `yaml:"domainsClaim,omitempty"`
+	RecordTypesClaim string       `yaml:"recordTypesClaim,omitempty"`
+	Subjects         []JWTSubject `yaml:"subjects,omitempty"`
+}
+
+// JWTSubject grants Domains and RecordTypes to tokens whose subject matches
+// Pattern, in which * matches any sequence of characters. Empty RecordTypes
+// allow all record types.
+type JWTSubject struct {
+	Pattern     string   `yaml:"pattern"`
+	Domains     []string `yaml:"domains"`
+	RecordTypes []string `yaml:"recordTypes,omitempty"`
+}
+
+// ForwardAuth delegates authorization to the service at URL. Decisions are
+// cached for CacheSeconds. If the service fails or does not answer within
+// TimeoutSeconds (default 5), requests are rejected unless FailOpen is set.
+type ForwardAuth struct {
+	URL            string `yaml:"url"`
+	TimeoutSeconds int    `yaml:"timeoutSeconds,omitempty"`
+	CacheSeconds   int    `yaml:"cacheSeconds,omitempty"`
+	FailOpen       bool   `yaml:"failOpen,omitempty"`
+}
+
+const DefaultForwardAuthTimeoutSeconds = 5
+
+// SignedRequests accepts requests to the plain and nic endpoints signed with
+// the password of a user in auth.users instead of sending it. Timestamps of
+// signed requests must be within WindowSeconds (default 300) of the current
+// time.
+type SignedRequests struct {
+	WindowSeconds int `yaml:"windowSeconds,omitempty"`
+}
+
+const DefaultSignedRequestsWindowSeconds = 300
+
+// TLS serves HTTPS on ListenAddr with the certificate in CertFile and
+// KeyFile. RedirectAddr optionally starts a plain HTTP listener that
+// redirects to HTTPS. Client certificates issued by ClientCA are verified
+// and can be used for authentication with auth.clientCerts.
+type TLS struct {
+	CertFile     string `yaml:"certFile,omitempty"`
+	KeyFile      string `yaml:"keyFile,omitempty"`
+	MinVersion   string `yaml:"minVersion,omitempty"`
+	RedirectAddr string `yaml:"redirectAddr,omitempty"`
+	ClientCA     string `yaml:"clientCA,omitempty"`
+	Version      uint16 `yaml:"-"`
+}
+
+func (t *TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// ProxyProtocol enables the PROXY protocol (v1 and v2) on the listener for
+// connections from TrustedNetworks.
+type ProxyProtocol struct {
+	Enabled         bool           `yaml:"enabled"`
+	TrustedNetworks []string       `yaml:"trustedNetworks,omitempty"`
+	TrustedPrefixes []netip.Prefix `yaml:"-"`
+}
+
+// TrustedProxyHeaders trusts proxies in Networks and takes the client IP
+// from the first of Headers present in their requests.
+type TrustedProxyHeaders struct {
+	Networks []string       `yaml:"networks"`
+	Headers  []string       `yaml:"headers"`
+	Prefixes []netip.Prefix `yaml:"-"`
+}
+
+// NetworkList is a list of networks given inline or loaded from files with
+// one IP address or CIDR range per line.
+type NetworkList struct {
+	Networks []string       `yaml:"networks,omitempty"`
+	Files    []string       `yaml:"files,omitempty"`
+	Prefixes []netip.Prefix `yaml:"-"`
+}
+
 type RateLimit struct {
-	RPS         float64 `yaml:"rps"`
-	Burst       int     `yaml:"burst"`
-	IdleSeconds int     `yaml:"idleSeconds"`
+	RPS         float64        `yaml:"rps"`
+	Burst       int            `yaml:"burst"`
+	IdleSeconds int            `yaml:"idleSeconds"`
+	User        RateLimitScope `yaml:"user"`
+	Zone        RateLimitScope `yaml:"zone"`
+	Upstream    RateLimitScope `yaml:"upstream"`
+}
+
+// RateLimitScope configures an additional rate limit dimension. A zero RPS
+// disables the token bucket, a zero DailyQuota disables the daily quota.
+type RateLimitScope struct {
+	RPS        float64 `yaml:"rps"`
+	Burst      int     `yaml:"burst"`
+	DailyQuota int     `yaml:"dailyQuota"`
 }
 
 type Lockout struct {
@@ -190,6 +417,16 @@ func ParseEnv() (*Config, error) {
 
 	envString("LISTEN_ADDR", &cfg.ListenAddr)
 	envTrustedProxies(cfg)
+	envList("CLIENT_IP_HEADERS", &cfg.ClientIPHeaders)
+	envString("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	envString("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	envString("TLS_MIN_VERSION", &cfg.TLS.MinVersion)
+	envString("TLS_REDIRECT_ADDR", &cfg.TLS.RedirectAddr)
+	envList("PROXY_PROTOCOL_TRUSTED_NETWORKS", &cfg.ProxyProtocol.TrustedNetworks)
+	envList("DENY_NETWORKS", &cfg.DenyNetworks.Networks)
+	envList("DENY_NETWORKS_FILES", &cfg.DenyNetworks.Files)
+	envList("EXEMPT_NETWORKS", &cfg.ExemptNetworks.Networks)
+	envList("EXEMPT_NETWORKS_FILES", &cfg.ExemptNetworks.Files)
 
 	if err := envBool("DEBUG", &cfg.Debug); err != nil {
 		return nil, err
@@ -197,20 +434,47 @@ func ParseEnv() (*Config, error) {
 	if err := envRateLimit(&cfg.RateLimit); err != nil {
 		return nil, err
 	}
+	if err := validateRateLimit(&cfg.RateLimit); err != nil {
+		return nil, err
+	}
 	if err := envLockout(&cfg.Lockout); err != nil {
 		return nil, err
 	}
 	if err := envEndpoints(&cfg.Endpoints); err != nil {
 		return nil, err
 	}
+	if err := validateDuckDNS(cfg.DuckDNS, cfg.Endpoints.DuckDNS, &cfg.Auth); err != nil {
+		return nil, err
+	}
+	if err := envBool("PROXY_PROTOCOL", &cfg.ProxyProtocol.Enabled); err != nil {
+		return nil, err
+	}
+	if err := envBool("ADDRESS_POLICY_DISABLED", &cfg.AddressPolicy.Disabled); err != nil {
+		return nil, err
+	}
+	if err := envBool("ACME_STRICT", &cfg.AcmeStrict); err != nil {
+		return nil, err
+	}
 
 	prefixes, parseErr := parseTrustedProxies(cfg.TrustedProxies)
 	if parseErr != nil {
 		return nil, parseErr
 	}
 	cfg.TrustedProxyPrefixes = prefixes
+	if err := parseTLS(&cfg.TLS); err != nil {
+		return nil, err
+	}
+	if err := parseProxyProtocol(&cfg.ProxyProtocol); err != nil {
+		return nil, err
+	}
+	if err := parseNetworkLists(cfg); err != nil {
+		return nil, err
+	}
 
 	setDefaultBaseURL(cfg)
+	if err := validateBaseURL(cfg.BaseURL); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
@@ -267,7 +531,26 @@ func envRateLimit(rl *RateLimit) error {
 	if err := envInt("RATE_LIMIT_BURST", &rl.Burst); err != nil {
 		return err
 	}
-	return envInt("RATE_LIMIT_IDLE_SECONDS", &rl.IdleSeconds)
+	if err := envInt("RATE_LIMIT_IDLE_SECONDS", &rl.IdleSeconds); err != nil {
+		return err
+	}
+	if err := envRateLimitScope("RATE_LIMIT_USER", &rl.User); err != nil {
+		return err
+	}
+	if err := envRateLimitScope("RATE_LIMIT_ZONE", &rl.Zone); err != nil {
+		return err
+	}
+	return envRateLimitScope("RATE_LIMIT_UPSTREAM", &rl.Upstream)
+}
+
+func envRateLimitScope(prefix string, s *RateLimitScope) error {
+	if err := envFloat(prefix+"_RPS", &s.RPS); err != nil {
+		return err
+	}
+	if err := envInt(prefix+"_BURST", &s.Burst); err != nil {
+		return err
+	}
+	return envInt(prefix+"_DAILY_QUOTA", &s.DailyQuota)
 }
 
 func envLockout(l *Lockout) error {
@@ -299,6 +582,22 @@ func envEndpoints(endpoints *Endpoints) error {
 			endpoints.HTTPReq = true
 		case EndpointDirectAdmin:
 			endpoints.DirectAdmin = true
+		case EndpointHetznerDNS:
+			endpoints.HetznerDNS = true
+		case EndpointCloudZones:
+			endpoints.CloudZones = true
+		case EndpointPowerDNS:
+			endpoints.PowerDNS = true
+		case EndpointCloudflare:
+			endpoints.Cloudflare = true
+		case EndpointRoute53:
+			endpoints.Route53 = true
+		case EndpointNamecheap:
+			endpoints.Namecheap = true
+		case EndpointCPanel:
+			endpoints.CPanel = true
+		case EndpointDuckDNS:
+			endpoints.DuckDNS = true
 		default:
 			return fmt.Errorf("invalid endpoint %q in ENDPOINTS", name)
 		}
@@ -306,6 +605,19 @@ func envEndpoints(endpoints *Endpoints) error {
 	return nil
 }
 
+func envList(key string, dst *[]string) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+	*dst = nil
+	for item := range strings.SplitSeq(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*dst = append(*dst, item)
+		}
+	}
+}
+
 func envTrustedProxies(cfg *Config) {
 	v, ok := os.LookupEnv("TRUSTED_PROXIES")
 	if !ok {
@@ -341,14 +653,30 @@ func ReadFile(path string) (*Config, error) {
 	if err := validateAuth(&cfg.Auth); err != nil {
 		return nil, err
 	}
-	prefixes, parseErr := parseTrustedProxies(cfg.TrustedProxies)
-	if parseErr != nil {
-		return nil, parseErr
+	if err := parseProxies(cfg); err != nil {
+		return nil, err
+	}
+	if err := parseTLS(&cfg.TLS); err != nil {
+		return nil, err
+	}
+	if len(cfg.Auth.ClientCerts) > 0 && cfg.TLS.ClientCA == "" {
+		return nil, errors.New("tls.clientCA is required with auth.clientCerts")
+	}
+	if err := parseNetworkLists(cfg); err != nil {
+		return nil, err
+	}
+	if err := parseAddressPolicy(&cfg.AddressPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateOptional(cfg); err != nil {
+		return nil, err
 	}
-	cfg.TrustedProxyPrefixes = prefixes
 
 	setDefaultIPMask(cfg.Auth.AllowedDomains)
 	setDefaultBaseURL(cfg)
+	if err := validateBaseURL(cfg.BaseURL); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
@@ -357,14 +685,196 @@ func validateAuth(a *Auth) error {
 	if !AuthMethodIsValid(a.Method) {
 		return fmt.Errorf("invalid auth method: %s", a.Method)
 	}
-	if len(a.AllowedDomains) == 0 && (a.Method == AuthMethodAllowedDomains || a.Method == AuthMethodBoth) {
-		return fmt.Errorf("auth.allowedDomains cannot be empty with auth method %s", a.Method)
+	if err := validateAuthSources(a); err != nil {
+		return err
+	}
+	if err := parseRoles(a); err != nil {
+		return err
+	}
+	if err := validateClientCerts(a.ClientCerts); err != nil {
+		return err
+	}
+	if err := validateJWT(a.JWT); err != nil {
+		return err
+	}
+	if err := validateForwardAuth(a.ForwardAuth); err != nil {
+		return err
+	}
+	if err := validateSignedRequests(a); err != nil {
+		return err
+	}
+	if err := compileRules(a.Rules); err != nil {
+		return err
+	}
+	if err := validateClientIPMatch(a); err != nil {
+		return err
+	}
+	if err := validateAPITokens(a); err != nil {
+		return err
+	}
+	return parseUsersFile(a)
+}
+
+// validateAuthSources checks that the sources required by the auth method
+// are configured. Client certificates and JWTs are credentials like users.
+func validateAuthSources(a *Auth) error {
+	hasAllowedDomains := len(a.AllowedDomains) > 0 || len(a.AllowedNetworks) > 0
+	hasUsers := len(a.Users) > 0 || a.UsersFile != ""
+	hasCredentials := hasUsers || len(a.ClientCerts) > 0 || a.JWT != nil
+	switch a.Method {
+	case AuthMethodAllowedDomains:
+		if !hasAllowedDomains {
+			return fmt.Errorf("auth.allowedDomains cannot be empty with auth method %s", a.Method)
+		}
+	case AuthMethodUsers:
+		if !hasUsers {
+			return fmt.Errorf("auth.users cannot be empty with auth method %s", a.Method)
+		}
+	case AuthMethodBoth:
+		if !hasAllowedDomains {
+			return fmt.Errorf("auth.allowedDomains cannot be empty with auth method %s", a.Method)
+		}
+		if !hasCredentials {
+			return fmt.Errorf("auth.users cannot be empty with auth method %s", a.Method)
+		}
+	case AuthMethodAny:
+		if !hasAllowedDomains && !hasCredentials {
+			return errors.New("auth.allowedDomains or auth.users cannot both be empty with auth method any")
+		}
+	case AuthMethodClientCert:
+		if len(a.ClientCerts) == 0 {
+			return fmt.Errorf("auth.clientCerts cannot be empty with auth method %s", a.Method)
+		}
+	case AuthMethodJWT:
+		if a.JWT == nil {
+			return fmt.Errorf("auth.jwt cannot be empty with auth method %s", a.Method)
+		}
+	case AuthMethodForwardAuth:
+		if a.ForwardAuth == nil {
+			return fmt.Errorf("auth.forwardAuth cannot be empty with auth method %s", a.Method)
+		}
+	}
+	return nil
+}
+
+func validateJWT(j *JWT) error {
+	if j == nil {
+		return nil
+	}
+	if j.Issuer == "" {
+		return errors.New("auth.jwt.issuer cannot be empty")
+	}
+	if j.Audience == "" {
+		return errors.New("auth.jwt.audience cannot be empty")
+	}
+	if (j.JWKSFile == "") == (j.JWKSURL == "") {
+		return errors.New("auth.jwt must set exactly one of jwksFile and jwksURL")
+	}
+	if err := validateJWKS(j); err != nil {
+		return err
+	}
+	if j.JWKSCacheSeconds < 0 {
+		return errors.New("auth.jwt.jwksCacheSeconds must be >= 0")
+	}
+	if j.DomainsClaim == "" && len(j.Subjects) == 0 {
+		return errors.New("auth.jwt.domainsClaim or auth.jwt.subjects cannot both be empty")
+	}
+	for i := range j.Subjects {
+		if j.Subjects[i].Pattern == "" {
+			return fmt.Errorf("auth.jwt.subjects[%d].pattern cannot be empty", i)
+		}
+		if len(j.Subjects[i].Domains) == 0 {
+			return fmt.Errorf("auth.jwt.subjects[%d].domains cannot be empty", i)
+		}
+	}
+	return nil
+}
+
+// parseUsersFile checks that the files of auth.usersFile can be loaded, so
+// that configuration errors surface on startup, and sets up their reloading.
+func parseUsersFile(a *Auth) error {
+	a.FileUsers = nil
+	if a.UsersFile == "" && a.UsersDomainsFile == "" {
+		return nil
+	}
+	if a.UsersFile == "" || a.UsersDomainsFile == "" {
+		return errors.New("auth.usersFile and auth.usersDomainsFile must be set together")
+	}
+	if _, err := htpasswd.ReadFile(a.UsersFile); err != nil {
+		return fmt.Errorf("invalid auth.usersFile: %w", err)
+	}
+	if _, err := htpasswd.ReadDomainsFile(a.UsersDomainsFile); err != nil {
+		return fmt.Errorf("invalid auth.usersDomainsFile: %w", err)
+	}
+	a.FileUsers = htpasswd.NewFile(a.UsersFile, a.UsersDomainsFile)
+	return nil
+}
+
+func validateSignedRequests(a *Auth) error {
+	if a.SignedRequests == nil {
+		return nil
+	}
+	if a.Method != AuthMethodUsers && a.Method != AuthMethodBoth && a.Method != AuthMethodAny {
+		return fmt.Errorf("auth.signedRequests cannot be used with auth method %s", a.Method)
+	}
+	if len(a.Users) == 0 {
+		return errors.New("auth.signedRequests requires auth.users")
+	}
+	if a.SignedRequests.WindowSeconds < 0 {
+		return errors.New("auth.signedRequests.windowSeconds must be >= 0")
+	}
+	return nil
+}
+
+func validateForwardAuth(f *ForwardAuth) error {
+	if f == nil {
+		return nil
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid auth.forwardAuth.url: %s", f.URL)
+	}
+	if f.TimeoutSeconds < 0 {
+		return errors.New("auth.forwardAuth.timeoutSeconds must be >= 0")
+	}
+	if f.CacheSeconds < 0 {
+		return errors.New("auth.forwardAuth.cacheSeconds must be >= 0")
+	}
+	return nil
+}
+
+func validateJWKS(j *JWT) error {
+	if j.JWKSFile != "" {
+		if _, err := jwt.ReadKeySetFile(j.JWKSFile); err != nil {
+			return fmt.Errorf("invalid auth.jwt.jwksFile: %w", err)
+		}
+		return nil
 	}
-	if len(a.Users) == 0 && (a.Method == AuthMethodUsers || a.Method == AuthMethodBoth) {
-		return fmt.Errorf("auth.users cannot be empty with auth method %s", a.Method)
+	u, err := url.Parse(j.JWKSURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid auth.jwt.jwksURL: %s", j.JWKSURL)
 	}
-	if len(a.AllowedDomains) == 0 && len(a.Users) == 0 && a.Method == AuthMethodAny {
-		return errors.New("auth.allowedDomains or auth.users cannot both be empty with auth method any")
+	return nil
+}
+
+func validateClientCerts(clientCerts []ClientCert) error {
+	for i := range clientCerts {
+		cc := &clientCerts[i]
+		set := 0
+		for _, id := range []string{cc.Subject, cc.DNSName, cc.SPIFFEID} {
+			if id != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("auth.clientCerts[%d] must set exactly one of subject, dnsName and spiffeID", i)
+		}
+		if cc.SPIFFEID != "" && !strings.HasPrefix(cc.SPIFFEID, "spiffe://") {
+			return fmt.Errorf("auth.clientCerts[%d].spiffeID must start with spiffe://", i)
+		}
+		if len(cc.Domains) == 0 {
+			return fmt.Errorf("auth.clientCerts[%d].domains cannot be empty", i)
+		}
 	}
 	return nil
 }
@@ -379,13 +889,58 @@ func validateRateLimit(rl *RateLimit) error {
 	if rl.IdleSeconds <= 0 {
 		return errors.New("rateLimit.idleSeconds must be > 0")
 	}
+	if err := validateRateLimitScope("rateLimit.user", &rl.User); err != nil {
+		return err
+	}
+	if err := validateRateLimitScope("rateLimit.zone", &rl.Zone); err != nil {
+		return err
+	}
+	return validateRateLimitScope("rateLimit.upstream", &rl.Upstream)
+}
+
+func validateRateLimitScope(name string, s *RateLimitScope) error {
+	if s.RPS < 0 {
+		return fmt.Errorf("%s.rps must be >= 0", name)
+	}
+	if s.RPS > 0 && s.Burst <= 0 {
+		return fmt.Errorf("%s.burst must be > 0 when %s.rps is set", name, name)
+	}
+	if s.DailyQuota < 0 {
+		return fmt.Errorf("%s.dailyQuota must be >= 0", name)
+	}
 	return nil
 }
 
+// parseProxies parses the trusted proxies, their headers and the PROXY
+// protocol settings.
+func parseProxies(cfg *Config) error {
+	prefixes, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	cfg.TrustedProxyPrefixes = prefixes
+	if err := parseTrustedProxyHeaders(cfg.TrustedProxyHeaders); err != nil {
+		return err
+	}
+	return parseProxyProtocol(&cfg.ProxyProtocol)
+}
+
+// validateOptional validates the optional blocks enabling the listeners next
+// to the HTTP(S) listener of the endpoints and further endpoints.
+func validateOptional(cfg *Config) error {
+	if err := validateRFC2136(cfg.RFC2136, cfg.Auth.Roles); err != nil {
+		return err
+	}
+	if err := validateWebhook(cfg.Webhook); err != nil {
+		return err
+	}
+	return validateDuckDNS(cfg.DuckDNS, cfg.Endpoints.DuckDNS, &cfg.Auth)
+}
+
 func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
 	prefixes := make([]netip.Prefix, 0, len(proxies))
 	for _, p := range proxies {
-		prefix, err := parseTrustedProxy(p)
+		prefix, err := netlist.ParsePrefix(p)
 		if err != nil {
 			return nil, fmt.Errorf("invalid trustedProxies entry %q: %w", p, err)
 		}
@@ -394,15 +949,90 @@ func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
 	return prefixes, nil
 }
 
-func parseTrustedProxy(s string) (netip.Prefix, error) {
-	if prefix, err := netip.ParsePrefix(s); err == nil {
-		return prefix.Masked(), nil
+func parseTLS(t *TLS) error {
+	if t.CertFile == "" && t.KeyFile == "" {
+		if t.MinVersion != "" || t.RedirectAddr != "" || t.ClientCA != "" {
+			return errors.New("tls.certFile and tls.keyFile are required when tls is configured")
+		}
+		return nil
 	}
-	addr, err := netip.ParseAddr(s)
-	if err != nil {
-		return netip.Prefix{}, fmt.Errorf("must be an IP address or CIDR range: %w", err)
+	if t.CertFile == "" || t.KeyFile == "" {
+		return errors.New("tls.certFile and tls.keyFile must be set together")
+	}
+	switch t.MinVersion {
+	case "", TLSVersion12:
+		t.Version = tls.VersionTLS12
+	case TLSVersion13:
+		t.Version = tls.VersionTLS13
+	default:
+		return fmt.Errorf("invalid tls.minVersion: %s, must be %s or %s", t.MinVersion, TLSVersion12, TLSVersion13)
 	}
-	return netip.PrefixFrom(addr, addr.BitLen()), nil
+	return nil
+}
+
+func parseProxyProtocol(pp *ProxyProtocol) error {
+	if !pp.Enabled {
+		return nil
+	}
+	if len(pp.TrustedNetworks) == 0 {
+		return errors.New("proxyProtocol.trustedNetworks cannot be empty when proxyProtocol is enabled")
+	}
+	pp.TrustedPrefixes = make([]netip.Prefix, 0, len(pp.TrustedNetworks))
+	for _, n := range pp.TrustedNetworks {
+		prefix, err := netlist.ParsePrefix(n)
+		if err != nil {
+			return fmt.Errorf("invalid proxyProtocol.trustedNetworks entry %q: %w", n, err)
+		}
+		pp.TrustedPrefixes = append(pp.TrustedPrefixes, prefix)
+	}
+	return nil
+}
+
+func parseTrustedProxyHeaders(proxyHeaders []TrustedProxyHeaders) error {
+	for i := range proxyHeaders {
+		ph := &proxyHeaders[i]
+		if len(ph.Networks) == 0 {
+			return fmt.Errorf("trustedProxyHeaders[%d].networks cannot be empty", i)
+		}
+		if len(ph.Headers) == 0 {
+			return fmt.Errorf("trustedProxyHeaders[%d].headers cannot be empty", i)
+		}
+		ph.Prefixes = make([]netip.Prefix, 0, len(ph.Networks))
+		for _, n := range ph.Networks {
+			prefix, err := netlist.ParsePrefix(n)
+			if err != nil {
+				return fmt.Errorf("invalid trustedProxyHeaders[%d].networks entry %q: %w", i, n, err)
+			}
+			ph.Prefixes = append(ph.Prefixes, prefix)
+		}
+	}
+	return nil
+}
+
+func parseNetworkLists(cfg *Config) error {
+	if err := parseNetworkList("denyNetworks", &cfg.DenyNetworks); err != nil {
+		return err
+	}
+	return parseNetworkList("exemptNetworks", &cfg.ExemptNetworks)
+}
+
+// parseNetworkList parses the inline networks of l and checks that its
+// files can be loaded, so that configuration errors surface on startup.
+func parseNetworkList(name string, l *NetworkList) error {
+	l.Prefixes = nil
+	for _, n := range l.Networks {
+		prefix, err := netlist.ParsePrefix(n)
+		if err != nil {
+			return fmt.Errorf("invalid %s.networks entry %q: %w", name, n, err)
+		}
+		l.Prefixes = append(l.Prefixes, prefix)
+	}
+	for _, file := range l.Files {
+		if _, err := netlist.ReadFile(file); err != nil {
+			return fmt.Errorf("invalid %s.files entry: %w", name, err)
+		}
+	}
+	return nil
 }
 
 func validateLockout(l *Lockout) error {
@@ -422,7 +1052,20 @@ func AuthMethodIsValid(authMethod string) bool {
 	return authMethod == AuthMethodAllowedDomains ||
 		authMethod == AuthMethodUsers ||
 		authMethod == AuthMethodBoth ||
-		authMethod == AuthMethodAny
+		authMethod == AuthMethodAny ||
+		authMethod == AuthMethodClientCert ||
+		authMethod == AuthMethodJWT ||
+		authMethod == AuthMethodForwardAuth
+}
+
+// validateBaseURL checks that baseURL is an absolute HTTP(S) URL, which the
+// cloudzones endpoint forwards requests to.
+func validateBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid baseURL: %s", baseURL)
+	}
+	return nil
 }
 
 func setDefaultBaseURL(c *Config) {
@@ -1,6 +1,7 @@
 package config_test
 
 import (
+	"crypto/tls"
 	"net"
 	"net/netip"
 	"os"
@@ -117,6 +118,7 @@ var _ = Describe("Config", func() {
 		listenAddr        = "127.0.0.1:8080"
 		trustedProxiesStr = "127.0.0.1,192.168.0.1,192.168.0.2"
 		debugStr          = "true"
+		jwks              = `{"keys":[{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`
 	)
 
 	var (
@@ -144,6 +146,8 @@ var _ = Describe("Config", func() {
 			envListenAddr     = "LISTEN_ADDR"
 			envTrustedProxies = "TRUSTED_PROXIES"
 			envDebug          = "DEBUG"
+			envRateLimitRPS   = "RATE_LIMIT_RPS"
+			envUserRPS        = "RATE_LIMIT_USER_RPS"
 		)
 
 		BeforeEach(func() {
@@ -166,6 +170,8 @@ var _ = Describe("Config", func() {
 			Expect(os.Unsetenv(envListenAddr)).To(Succeed())
 			Expect(os.Unsetenv(envTrustedProxies)).To(Succeed())
 			Expect(os.Unsetenv(envDebug)).To(Succeed())
+			Expect(os.Unsetenv(envRateLimitRPS)).To(Succeed())
+			Expect(os.Unsetenv(envUserRPS)).To(Succeed())
 		})
 
 		It("should parse environment successfully", func() {
@@ -242,6 +248,16 @@ var _ = Describe("Config", func() {
 				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
 				Expect(os.Setenv(envTrustedProxies, "10.0.0.0/99")).To(Succeed())
 			}, `invalid trustedProxies entry "10.0.0.0/99": must be an IP address or CIDR range`),
+			Entry("RATE_LIMIT_RPS not positive", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envRateLimitRPS, "0")).To(Succeed())
+			}, "rateLimit.rps must be > 0"),
+			Entry("RATE_LIMIT_USER_RPS without a burst", func() {
+				Expect(os.Setenv(envAPIToken, apiToken)).To(Succeed())
+				Expect(os.Setenv(envAllowedDomains, allowedDomainsStr)).To(Succeed())
+				Expect(os.Setenv(envUserRPS, "1")).To(Succeed())
+			}, "rateLimit.user.burst must be > 0 when rateLimit.user.rps is set"),
 		)
 	})
 
@@ -333,6 +349,257 @@ var _ = Describe("Config", func() {
 			}))
 		})
 
+		It("should parse trustedProxyHeaders", func() {
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:         config.AuthMethodAllowedDomains,
+					AllowedDomains: allowedDomains,
+				},
+				ClientIPHeaders: []string{"X-Forwarded-For"},
+				TrustedProxyHeaders: []config.TrustedProxyHeaders{{
+					Networks: []string{"173.245.48.0/20", "2400:cb00::/32"},
+					Headers:  []string{"CF-Connecting-IP"},
+				}},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.ClientIPHeaders).To(Equal([]string{"X-Forwarded-For"}))
+			Expect(cfgRead.TrustedProxyHeaders).To(HaveLen(1))
+			Expect(cfgRead.TrustedProxyHeaders[0].Headers).To(Equal([]string{"CF-Connecting-IP"}))
+			Expect(cfgRead.TrustedProxyHeaders[0].Prefixes).To(Equal([]netip.Prefix{
+				netip.MustParsePrefix("173.245.48.0/20"),
+				netip.MustParsePrefix("2400:cb00::/32"),
+			}))
+		})
+
+		It("should parse tls", func() {
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:         config.AuthMethodAllowedDomains,
+					AllowedDomains: allowedDomains,
+				},
+				TLS: config.TLS{
+					CertFile:     "/etc/ssl/proxy.crt",
+					KeyFile:      "/etc/ssl/proxy.key",
+					MinVersion:   config.TLSVersion13,
+					RedirectAddr: ":8080",
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.TLS.Enabled()).To(BeTrue())
+			Expect(cfgRead.TLS.RedirectAddr).To(Equal(":8080"))
+			Expect(cfgRead.TLS.Version).To(Equal(uint16(tls.VersionTLS13)))
+		})
+
+		It("should parse clientCerts", func() {
+			clientCerts := []config.ClientCert{
+				{Subject: "CN=sensor-1,O=Example", Domains: []string{"example.com"}},
+				{SPIFFEID: "spiffe://example.net/sensor/2", Domains: []string{"*.example.com"}},
+			}
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:      config.AuthMethodClientCert,
+					ClientCerts: clientCerts,
+				},
+				TLS: config.TLS{
+					CertFile: "/etc/ssl/proxy.crt",
+					KeyFile:  "/etc/ssl/proxy.key",
+					ClientCA: "/etc/ssl/clients.crt",
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Auth.ClientCerts).To(Equal(clientCerts))
+			Expect(cfgRead.TLS.ClientCA).To(Equal("/etc/ssl/clients.crt"))
+		})
+
+		It("should parse jwt", func() {
+			jwksFile := path.Join(GinkgoT().TempDir(), "jwks.json")
+			Expect(os.WriteFile(jwksFile, []byte(jwks), 0o600)).To(Succeed())
+			jwtCfg := &config.JWT{
+				Issuer:       "https://issuer.example.com",
+				Audience:     "hetzner-dnsapi-proxy",
+				JWKSFile:     jwksFile,
+				DomainsClaim: "dns_domains",
+				Subjects: []config.JWTSubject{{
+					Pattern:     "repo:example/*",
+					Domains:     []string{"*.example.com"},
+					RecordTypes: []string{"TXT"},
+				}},
+			}
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method: config.AuthMethodJWT,
+					JWT:    jwtCfg,
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Auth.JWT).To(Equal(jwtCfg))
+		})
+
+		It("should parse usersFile", func() {
+			dir := GinkgoT().TempDir()
+			usersFile := path.Join(dir, "htpasswd")
+			domainsFile := path.Join(dir, "domains")
+			Expect(os.WriteFile(usersFile, []byte("user:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600)).To(Succeed())
+			Expect(os.WriteFile(domainsFile, []byte("user:example.com\n"), 0o600)).To(Succeed())
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:           config.AuthMethodUsers,
+					UsersFile:        usersFile,
+					UsersDomainsFile: domainsFile,
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Auth.UsersFile).To(Equal(usersFile))
+			Expect(cfgRead.Auth.UsersDomainsFile).To(Equal(domainsFile))
+			Expect(cfgRead.Auth.FileUsers.Users()).To(HaveLen(1))
+		})
+
+		It("should parse signedRequests", func() {
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:         config.AuthMethodUsers,
+					Users:          []config.User{{Username: "user", Password: "pass", Domains: []string{"example.com"}}},
+					SignedRequests: &config.SignedRequests{WindowSeconds: 60},
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Auth.SignedRequests).To(Equal(&config.SignedRequests{WindowSeconds: 60}))
+		})
+
+		It("should parse forwardAuth", func() {
+			forwardAuth := &config.ForwardAuth{
+				URL:            "https://auth.example.com/check",
+				TimeoutSeconds: 2,
+				CacheSeconds:   30,
+				FailOpen:       true,
+			}
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:      config.AuthMethodForwardAuth,
+					ForwardAuth: forwardAuth,
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.Auth.ForwardAuth).To(Equal(forwardAuth))
+		})
+
+		It("should parse proxyProtocol", func() {
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:         config.AuthMethodAllowedDomains,
+					AllowedDomains: allowedDomains,
+				},
+				ProxyProtocol: config.ProxyProtocol{
+					Enabled:         true,
+					TrustedNetworks: []string{"10.0.0.1", "2001:db8::/32"},
+				},
+				RateLimit: validRL(),
+				Lockout:   validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.ProxyProtocol.Enabled).To(BeTrue())
+			Expect(cfgRead.ProxyProtocol.TrustedPrefixes).To(Equal([]netip.Prefix{
+				netip.MustParsePrefix("10.0.0.1/32"),
+				netip.MustParsePrefix("2001:db8::/32"),
+			}))
+		})
+
+		It("should parse deny and exempt networks", func() {
+			networksFile := path.Join(GinkgoT().TempDir(), "deny.txt")
+			Expect(os.WriteFile(networksFile, []byte("# scanners\n192.0.2.0/24\n"), 0o600)).To(Succeed())
+			cfg := &config.Config{
+				Token: apiToken,
+				Auth: config.Auth{
+					Method:         config.AuthMethodAllowedDomains,
+					AllowedDomains: allowedDomains,
+				},
+				DenyNetworks:   config.NetworkList{Networks: []string{"10.0.0.0/8"}, Files: []string{networksFile}},
+				ExemptNetworks: config.NetworkList{Networks: []string{"2001:db8::1"}},
+				RateLimit:      validRL(),
+				Lockout:        validLO(),
+			}
+
+			data, err := yaml.Marshal(cfg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+			cfgRead, err := config.ReadFile(filePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cfgRead.DenyNetworks.Prefixes).To(Equal([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}))
+			Expect(cfgRead.DenyNetworks.Files).To(Equal([]string{networksFile}))
+			Expect(cfgRead.ExemptNetworks.Prefixes).To(Equal([]netip.Prefix{netip.MustParsePrefix("2001:db8::1/128")}))
+		})
+
 		It("should set default ip mask", func() {
 			cfg := &config.Config{
 				Token: apiToken,
@@ -393,6 +660,32 @@ var _ = Describe("Config", func() {
 			}}))
 		})
 
+		DescribeTable(
+			"should accept webhook listeners", func(webhook *config.Webhook) {
+				data, err := yaml.Marshal(&config.Config{
+					Token:     apiToken,
+					RateLimit: validRL(),
+					Lockout:   validLO(),
+					Auth: config.Auth{
+						Method:         config.AuthMethodAllowedDomains,
+						AllowedDomains: allowedDomains,
+					},
+					Webhook: webhook,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(os.WriteFile(filePath, data, 0o600)).To(Succeed())
+
+				_, err = config.ReadFile(filePath)
+				Expect(err).ToNot(HaveOccurred())
+			},
+			Entry("on IPv4 loopback", &config.Webhook{ListenAddr: "127.0.0.1:8888", DomainFilter: []string{"example.com"}}),
+			Entry("on IPv6 loopback", &config.Webhook{ListenAddr: "[::1]:8888", DomainFilter: []string{"example.com"}}),
+			Entry("on localhost", &config.Webhook{ListenAddr: "localhost:8888", DomainFilter: []string{"example.com"}}),
+			Entry("with allowedNetworks", &config.Webhook{
+				ListenAddr: ":8888", DomainFilter: []string{"example.com"}, AllowedNetworks: []string{"10.0.0.0/8"},
+			}),
+		)
+
 		DescribeTable(
 			"should fail on ", func(cfgFn func() *config.Config, errMsg string) {
 				data, err := yaml.Marshal(cfgFn())
@@ -494,6 +787,909 @@ var _ = Describe("Config", func() {
 				},
 				"auth.allowedDomains or auth.users cannot both be empty with auth method any",
 			),
+			Entry(
+				"rateLimit.user.rps without burst",
+				func() *config.Config {
+					rl := validRL()
+					rl.User = config.RateLimitScope{RPS: 1}
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: rl,
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+					}
+				},
+				"rateLimit.user.burst must be > 0 when rateLimit.user.rps is set",
+			),
+			Entry(
+				"negative rateLimit.zone.dailyQuota",
+				func() *config.Config {
+					rl := validRL()
+					rl.Zone = config.RateLimitScope{DailyQuota: -1}
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: rl,
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+					}
+				},
+				"rateLimit.zone.dailyQuota must be >= 0",
+			),
+			Entry(
+				"trustedProxyHeaders without headers",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TrustedProxyHeaders: []config.TrustedProxyHeaders{{Networks: []string{"10.0.0.0/8"}}},
+					}
+				},
+				"trustedProxyHeaders[0].headers cannot be empty",
+			),
+			Entry(
+				"auth method clientCert without clientCerts",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth:      config.Auth{Method: config.AuthMethodClientCert},
+						TLS:       config.TLS{CertFile: "/etc/ssl/proxy.crt", KeyFile: "/etc/ssl/proxy.key", ClientCA: "/etc/ssl/clients.crt"},
+					}
+				},
+				"auth.clientCerts cannot be empty with auth method clientCert",
+			),
+			Entry(
+				"clientCerts without tls.clientCA",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodClientCert,
+							ClientCerts: []config.ClientCert{{Subject: "CN=a", Domains: []string{"example.com"}}},
+						},
+						TLS: config.TLS{CertFile: "/etc/ssl/proxy.crt", KeyFile: "/etc/ssl/proxy.key"},
+					}
+				},
+				"tls.clientCA is required with auth.clientCerts",
+			),
+			Entry(
+				"clientCerts entry with two identities",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodClientCert,
+							ClientCerts: []config.ClientCert{{Subject: "CN=a", DNSName: "a.example.net", Domains: []string{"example.com"}}},
+						},
+						TLS: config.TLS{CertFile: "/etc/ssl/proxy.crt", KeyFile: "/etc/ssl/proxy.key", ClientCA: "/etc/ssl/clients.crt"},
+					}
+				},
+				"auth.clientCerts[0] must set exactly one of subject, dnsName and spiffeID",
+			),
+			Entry(
+				"clientCerts entry with invalid spiffeID",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodClientCert,
+							ClientCerts: []config.ClientCert{{SPIFFEID: "example.net/a", Domains: []string{"example.com"}}},
+						},
+						TLS: config.TLS{CertFile: "/etc/ssl/proxy.crt", KeyFile: "/etc/ssl/proxy.key", ClientCA: "/etc/ssl/clients.crt"},
+					}
+				},
+				"auth.clientCerts[0].spiffeID must start with spiffe://",
+			),
+			Entry(
+				"clientCerts entry without domains",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodClientCert,
+							ClientCerts: []config.ClientCert{{Subject: "CN=a"}},
+						},
+						TLS: config.TLS{CertFile: "/etc/ssl/proxy.crt", KeyFile: "/etc/ssl/proxy.key", ClientCA: "/etc/ssl/clients.crt"},
+					}
+				},
+				"auth.clientCerts[0].domains cannot be empty",
+			),
+			Entry(
+				"auth method jwt without jwt",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT:    nil,
+						},
+					}
+				},
+				"auth.jwt cannot be empty with auth method jwt",
+			),
+			Entry(
+				"jwt without issuer",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT:    &config.JWT{Audience: "proxy", JWKSURL: "https://issuer.example.com/jwks", DomainsClaim: "domains"},
+						},
+					}
+				},
+				"auth.jwt.issuer cannot be empty",
+			),
+			Entry(
+				"jwt without audience",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT:    &config.JWT{Issuer: "https://issuer.example.com", JWKSURL: "https://issuer.example.com/jwks", DomainsClaim: "domains"},
+						},
+					}
+				},
+				"auth.jwt.audience cannot be empty",
+			),
+			Entry(
+				"jwt without jwks",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT:    &config.JWT{Issuer: "https://issuer.example.com", Audience: "proxy", DomainsClaim: "domains"},
+						},
+					}
+				},
+				"auth.jwt must set exactly one of jwksFile and jwksURL",
+			),
+			Entry(
+				"jwt with invalid jwksURL",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT: &config.JWT{
+								Issuer:       "https://issuer.example.com",
+								Audience:     "proxy",
+								JWKSURL:      "issuer.example.com/jwks",
+								DomainsClaim: "domains",
+							},
+						},
+					}
+				},
+				"invalid auth.jwt.jwksURL: issuer.example.com/jwks",
+			),
+			Entry(
+				"jwt with missing jwksFile",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT: &config.JWT{
+								Issuer:       "https://issuer.example.com",
+								Audience:     "proxy",
+								JWKSFile:     "/nonexistent/jwks.json",
+								DomainsClaim: "domains",
+							},
+						},
+					}
+				},
+				"invalid auth.jwt.jwksFile: open /nonexistent/jwks.json",
+			),
+			Entry(
+				"jwt without grants",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT:    &config.JWT{Issuer: "https://issuer.example.com", Audience: "proxy", JWKSURL: "https://issuer.example.com/jwks"},
+						},
+					}
+				},
+				"auth.jwt.domainsClaim or auth.jwt.subjects cannot both be empty",
+			),
+			Entry(
+				"jwt subject without domains",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodJWT,
+							JWT: &config.JWT{
+								Issuer:   "https://issuer.example.com",
+								Audience: "proxy",
+								JWKSURL:  "https://issuer.example.com/jwks",
+								Subjects: []config.JWTSubject{{Pattern: "repo:*"}},
+							},
+						},
+					}
+				},
+				"auth.jwt.subjects[0].domains cannot be empty",
+			),
+			Entry(
+				"usersFile without usersDomainsFile",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:    config.AuthMethodUsers,
+							UsersFile: "/etc/htpasswd",
+						},
+					}
+				},
+				"auth.usersFile and auth.usersDomainsFile must be set together",
+			),
+			Entry(
+				"missing usersFile",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:           config.AuthMethodUsers,
+							UsersFile:        "/nonexistent/htpasswd",
+							UsersDomainsFile: "/nonexistent/domains",
+						},
+					}
+				},
+				"invalid auth.usersFile: open /nonexistent/htpasswd",
+			),
+			Entry(
+				"signedRequests with auth method clientCert",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodClientCert,
+							ClientCerts:    []config.ClientCert{{Subject: "CN=a", Domains: []string{"example.com"}}},
+							SignedRequests: &config.SignedRequests{},
+						},
+					}
+				},
+				"auth.signedRequests cannot be used with auth method clientCert",
+			),
+			Entry(
+				"signedRequests with negative windowSeconds",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodUsers,
+							Users:          []config.User{{Username: "user", Password: "pass", Domains: []string{"example.com"}}},
+							SignedRequests: &config.SignedRequests{WindowSeconds: -1},
+						},
+					}
+				},
+				"auth.signedRequests.windowSeconds must be >= 0",
+			),
+			Entry(
+				"auth method forwardAuth without forwardAuth",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodForwardAuth,
+							ForwardAuth: nil,
+						},
+					}
+				},
+				"auth.forwardAuth cannot be empty with auth method forwardAuth",
+			),
+			Entry(
+				"forwardAuth with invalid url",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodForwardAuth,
+							ForwardAuth: &config.ForwardAuth{URL: "auth.example.com/check"},
+						},
+					}
+				},
+				"invalid auth.forwardAuth.url: auth.example.com/check",
+			),
+			Entry(
+				"forwardAuth with negative timeoutSeconds",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodForwardAuth,
+							ForwardAuth: &config.ForwardAuth{URL: "https://auth.example.com", TimeoutSeconds: -1},
+						},
+					}
+				},
+				"auth.forwardAuth.timeoutSeconds must be >= 0",
+			),
+			Entry(
+				"forwardAuth with negative cacheSeconds",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:      config.AuthMethodForwardAuth,
+							ForwardAuth: &config.ForwardAuth{URL: "https://auth.example.com", CacheSeconds: -1},
+						},
+					}
+				},
+				"auth.forwardAuth.cacheSeconds must be >= 0",
+			),
+			Entry(
+				"user with invalid matchClientIP",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method: config.AuthMethodUsers,
+							Users: []config.User{{
+								Username: "user", Password: "pass", Domains: []string{"example.com"}, MatchClientIP: "subnet",
+							}},
+						},
+					}
+				},
+				"invalid auth.users[0].matchClientIP: subnet",
+			),
+			Entry(
+				"matchClientIP with invalid mode",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							MatchClientIP:  []config.ClientIPMatch{{Domains: []string{"example.com"}, Mode: "any"}},
+						},
+					}
+				},
+				"invalid auth.matchClientIP[0].mode: any",
+			),
+			Entry(
+				"matchClientIP without domains",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							MatchClientIP:  []config.ClientIPMatch{{Mode: config.ClientIPMatchExact}},
+						},
+					}
+				},
+				"auth.matchClientIP[0].domains cannot be empty",
+			),
+			Entry(
+				"addressPolicy.allow without domains",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						AddressPolicy: config.AddressPolicy{Allow: []config.AddressPolicyAllow{{Networks: []string{"10.0.0.0/8"}}}},
+					}
+				},
+				"addressPolicy.allow[0].domains cannot be empty",
+			),
+			Entry(
+				"addressPolicy.allow with invalid network",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						AddressPolicy: config.AddressPolicy{Allow: []config.AddressPolicyAllow{{
+							Domains: []string{"lan.example.com"}, Networks: []string{"10.0.0.0/33"},
+						}}},
+					}
+				},
+				`invalid addressPolicy.allow[0].networks entry "10.0.0.0/33"`,
+			),
+			Entry(
+				"endpoints.duckdns without duckDNS",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						Endpoints: config.Endpoints{DuckDNS: true},
+					}
+				},
+				"endpoints.duckdns requires duckDNS",
+			),
+			Entry(
+				"duckDNS without domain",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						DuckDNS: &config.DuckDNS{},
+					}
+				},
+				"duckDNS.domain cannot be empty",
+			),
+			Entry(
+				"duckDNS token of an unknown user",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						DuckDNS: &config.DuckDNS{
+							Domain: "dyn.example.com",
+							Tokens: []config.DuckDNSToken{{Token: "secret", Username: "unknown"}},
+						},
+					}
+				},
+				"duckDNS.tokens[0] references unknown user: unknown",
+			),
+			Entry(
+				"duckDNS token of an API token",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							APITokens:      []config.APIToken{{Name: "team", Token: "secret", Domains: []string{"*.example.com"}}},
+						},
+						DuckDNS: &config.DuckDNS{
+							Domain: "dyn.example.com",
+							Tokens: []config.DuckDNSToken{{Token: "secret", Username: "user"}},
+						},
+					}
+				},
+				"duckDNS.tokens tokens must be unique and differ from auth.apiTokens tokens",
+			),
+			Entry(
+				"webhook without listenAddr",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						Webhook: &config.Webhook{DomainFilter: []string{"example.com"}},
+					}
+				},
+				"webhook.listenAddr cannot be empty",
+			),
+			Entry(
+				"webhook without domainFilter",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						Webhook: &config.Webhook{ListenAddr: ":8888"},
+					}
+				},
+				"webhook.domainFilter cannot be empty",
+			),
+			Entry(
+				"webhook with invalid allowedNetworks",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						Webhook: &config.Webhook{
+							ListenAddr: ":8888", DomainFilter: []string{"example.com"}, AllowedNetworks: []string{"10.0.0.0/33"},
+						},
+					}
+				},
+				`invalid webhook.allowedNetworks entry "10.0.0.0/33"`,
+			),
+			Entry(
+				"webhook on a public address without allowedNetworks",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						Webhook: &config.Webhook{ListenAddr: ":8888", DomainFilter: []string{"example.com"}},
+					}
+				},
+				"webhook.allowedNetworks is required unless webhook.listenAddr is a loopback address",
+			),
+			Entry(
+				"rfc2136 without listenAddr",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: &config.RFC2136{Keys: []config.TSIGKey{{Name: "certbot.", Secret: "c2VjcmV0", Domains: []string{"*.example.com"}}}},
+					}
+				},
+				"rfc2136.listenAddr cannot be empty",
+			),
+			Entry(
+				"rfc2136 without keys",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: &config.RFC2136{ListenAddr: ":53"},
+					}
+				},
+				"rfc2136.keys cannot be empty",
+			),
+			Entry(
+				"rfc2136 key with invalid algorithm",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: &config.RFC2136{ListenAddr: ":53", Keys: []config.TSIGKey{{
+							Name: "certbot.", Secret: "c2VjcmV0", Domains: []string{"*.example.com"}, Algorithm: "hmac-md5",
+						}}},
+					}
+				},
+				"invalid rfc2136.keys[0].algorithm: hmac-md5",
+			),
+			Entry(
+				"rfc2136 key with invalid secret",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: &config.RFC2136{ListenAddr: ":53", Keys: []config.TSIGKey{{
+							Name: "certbot.", Secret: "not base64!", Domains: []string{"*.example.com"},
+						}}},
+					}
+				},
+				"rfc2136.keys[0].secret must be base64 encoded",
+			),
+			Entry(
+				"rfc2136 key without domains",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						RFC2136: &config.RFC2136{ListenAddr: ":53", Keys: []config.TSIGKey{{Name: "certbot.", Secret: "c2VjcmV0"}}},
+					}
+				},
+				"rfc2136.keys[0] must have domains or roles",
+			),
+			Entry(
+				"auth.apiTokens without token",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							APITokens:      []config.APIToken{{Name: "team", Domains: []string{"*.example.com"}}},
+						},
+					}
+				},
+				"auth.apiTokens[0].token cannot be empty",
+			),
+			Entry(
+				"auth.apiTokens with duplicate tokens",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							APITokens: []config.APIToken{
+								{Name: "a", Token: "secret", Domains: []string{"*.example.com"}},
+								{Name: "b", Token: "secret", Domains: []string{"*.example.org"}},
+							},
+						},
+					}
+				},
+				"auth.apiTokens tokens must be unique",
+			),
+			Entry(
+				"auth.apiTokens with duplicate access key IDs",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							APITokens: []config.APIToken{
+								{Name: "a", Token: "secret-a", AccessKeyID: "AKID", Domains: []string{"*.example.com"}},
+								{Name: "b", Token: "secret-b", AccessKeyID: "AKID", Domains: []string{"*.example.org"}},
+							},
+						},
+					}
+				},
+				"duplicate auth.apiTokens[1].accessKeyID: AKID",
+			),
+			Entry(
+				"auth.apiTokens without domains",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							APITokens:      []config.APIToken{{Name: "team", Token: "secret"}},
+						},
+					}
+				},
+				"auth.apiTokens[0] must have domains or roles",
+			),
+			Entry(
+				"auth.apiTokens with unknown role",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+							APITokens:      []config.APIToken{{Name: "team", Token: "secret", Roles: []string{"missing"}}},
+						},
+					}
+				},
+				"invalid auth.apiTokens[0].roles: unknown role",
+			),
+			Entry(
+				"baseURL without scheme",
+				func() *config.Config {
+					return &config.Config{
+						BaseURL:   "api.hetzner.cloud/v1",
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+					}
+				},
+				"invalid baseURL: api.hetzner.cloud/v1",
+			),
+			Entry(
+				"tls.certFile without tls.keyFile",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{CertFile: "/etc/ssl/proxy.crt"},
+					}
+				},
+				"tls.certFile and tls.keyFile must be set together",
+			),
+			Entry(
+				"tls.redirectAddr without certificate",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{RedirectAddr: ":8080"},
+					}
+				},
+				"tls.certFile and tls.keyFile are required when tls is configured",
+			),
+			Entry(
+				"invalid tls.minVersion",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						TLS: config.TLS{CertFile: "/etc/ssl/proxy.crt", KeyFile: "/etc/ssl/proxy.key", MinVersion: "1.1"},
+					}
+				},
+				"invalid tls.minVersion: 1.1, must be 1.2 or 1.3",
+			),
+			Entry(
+				"proxyProtocol enabled without trustedNetworks",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						ProxyProtocol: config.ProxyProtocol{Enabled: true},
+					}
+				},
+				"proxyProtocol.trustedNetworks cannot be empty when proxyProtocol is enabled",
+			),
+			Entry(
+				"proxyProtocol.trustedNetworks entry is a hostname",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						ProxyProtocol: config.ProxyProtocol{Enabled: true, TrustedNetworks: []string{"lb.example.com"}},
+					}
+				},
+				`invalid proxyProtocol.trustedNetworks entry "lb.example.com": must be an IP address or CIDR range`,
+			),
+			Entry(
+				"denyNetworks entry is a hostname",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						DenyNetworks: config.NetworkList{Networks: []string{"scanner.example.com"}},
+					}
+				},
+				`invalid denyNetworks.networks entry "scanner.example.com": must be an IP address or CIDR range`,
+			),
+			Entry(
+				"exemptNetworks file is missing",
+				func() *config.Config {
+					return &config.Config{
+						Token:     apiToken,
+						RateLimit: validRL(),
+						Lockout:   validLO(),
+						Auth: config.Auth{
+							Method:         config.AuthMethodAllowedDomains,
+							AllowedDomains: allowedDomains,
+						},
+						ExemptNetworks: config.NetworkList{Files: []string{"/nonexistent/exempt.txt"}},
+					}
+				},
+				"invalid exemptNetworks.files entry: open /nonexistent/exempt.txt",
+			),
 			Entry(
 				"trustedProxies entry is a hostname",
 				func() *config.Config {
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DuckDNS configures the DuckDNS compatible update endpoint enabled by
+// endpoints.duckdns, which updates the subdomains of Domain. Clients send the token of an API token or one of
+// Tokens, which grant the domains and roles of a user.
+type DuckDNS struct {
+	Domain string         `yaml:"domain"`
+	Tokens []DuckDNSToken `yaml:"tokens,omitempty"`
+}
+
+// DuckDNSToken authenticates clients sending Token as the user Username.
+type DuckDNSToken struct {
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+}
+
+func validateDuckDNS(d *DuckDNS, enabled bool, a *Auth) error {
+	if d == nil {
+		if enabled {
+			return errors.New("endpoints.duckdns requires duckDNS")
+		}
+		return nil
+	}
+	d.Domain = strings.ToLower(strings.TrimSuffix(d.Domain, "."))
+	if d.Domain == "" {
+		return errors.New("duckDNS.domain cannot be empty")
+	}
+
+	tokens := map[string]struct{}{}
+	for i := range a.APITokens {
+		tokens[a.APITokens[i].Token] = struct{}{}
+	}
+	for i := range d.Tokens {
+		t := &d.Tokens[i]
+		if t.Token == "" {
+			return fmt.Errorf("duckDNS.tokens[%d].token cannot be empty", i)
+		}
+		if _, ok := tokens[t.Token]; ok {
+			return errors.New("duckDNS.tokens tokens must be unique and differ from auth.apiTokens tokens")
+		}
+		tokens[t.Token] = struct{}{}
+		if t.Username == "" {
+			return fmt.Errorf("duckDNS.tokens[%d].username cannot be empty", i)
+		}
+		// Users of auth.usersFile are only known once the file is loaded.
+		if a.UsersFile == "" && !slices.ContainsFunc(a.Users, func(u User) bool { return u.Username == t.Username }) {
+			return fmt.Errorf("duckDNS.tokens[%d] references unknown user: %s", i, t.Username)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	TSIGAlgorithmHMACSHA256 = "hmac-sha256"
+	TSIGAlgorithmHMACSHA512 = "hmac-sha512"
+	DefaultRFC2136PrimaryNS = "localhost"
+)
+
+// RFC2136 configures the DNS UPDATE listener on ListenAddr (UDP and TCP).
+// PrimaryNS is reported as primary name server of the zones in SOA
+// responses.
+type RFC2136 struct {
+	ListenAddr string    `yaml:"listenAddr"`
+	PrimaryNS  string    `yaml:"primaryNS,omitempty"`
+	Keys       []TSIGKey `yaml:"keys"`
+}
+
+// TSIGKey grants Domains and the grants of Roles to DNS UPDATE messages
+// signed with the TSIG key Name. Secret is base64 encoded, Algorithm
+// defaults to hmac-sha256.
+type TSIGKey struct {
+	Name      string   `yaml:"name"`
+	Algorithm string   `yaml:"algorithm,omitempty"`
+	Secret    string   `yaml:"secret"`
+	Domains   []string `yaml:"domains"`
+	Roles     []string `yaml:"roles,omitempty"`
+}
+
+func validateRFC2136(r *RFC2136, roles map[string]*Role) error {
+	if r == nil {
+		return nil
+	}
+	if r.ListenAddr == "" {
+		return errors.New("rfc2136.listenAddr cannot be empty")
+	}
+	if r.PrimaryNS == "" {
+		r.PrimaryNS = DefaultRFC2136PrimaryNS
+	}
+	if len(r.Keys) == 0 {
+		return errors.New("rfc2136.keys cannot be empty")
+	}
+
+	names := map[string]struct{}{}
+	for i := range r.Keys {
+		key := &r.Keys[i]
+		if key.Name == "" {
+			return fmt.Errorf("rfc2136.keys[%d].name cannot be empty", i)
+		}
+		name := strings.ToLower(strings.TrimSuffix(key.Name, "."))
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("duplicate rfc2136.keys[%d].name: %s", i, key.Name)
+		}
+		names[name] = struct{}{}
+		if key.Algorithm == "" {
+			key.Algorithm = TSIGAlgorithmHMACSHA256
+		}
+		if !slices.Contains([]string{TSIGAlgorithmHMACSHA256, TSIGAlgorithmHMACSHA512}, key.Algorithm) {
+			return fmt.Errorf("invalid rfc2136.keys[%d].algorithm: %s", i, key.Algorithm)
+		}
+		if secret, err := base64.StdEncoding.DecodeString(key.Secret); err != nil || len(secret) == 0 {
+			return fmt.Errorf("rfc2136.keys[%d].secret must be base64 encoded", i)
+		}
+		if len(key.Domains) == 0 && len(key.Roles) == 0 {
+			return fmt.Errorf("rfc2136.keys[%d] must have domains or roles", i)
+		}
+		if err := checkRoleRefs(roles, key.Roles); err != nil {
+			return fmt.Errorf("invalid rfc2136.keys[%d].roles: %w", i, err)
+		}
+	}
+	return nil
+}
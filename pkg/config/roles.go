@@ -0,0 +1,249 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+)
+
+// Role is a named set of grants that users and allowed networks can
+// reference. It grants Domains, in which *. matches all subdomains, for
+// RecordTypes to clients in Networks. Empty RecordTypes allow all record
+// types, empty Networks allow all clients.
+type Role struct {
+	Domains     []string       `yaml:"domains"`
+	RecordTypes []string       `yaml:"recordTypes,omitempty"`
+	Networks    []string       `yaml:"networks,omitempty"`
+	Prefixes    []netip.Prefix `yaml:"-"`
+}
+
+// AllowedNetwork grants the Roles to clients in Networks, like an
+// allowedDomains entry.
+type AllowedNetwork struct {
+	Networks []string       `yaml:"networks"`
+	Roles    []string       `yaml:"roles"`
+	Prefixes []netip.Prefix `yaml:"-"`
+}
+
+// AllowsNetwork reports whether clients with addr may use the role.
+func (r *Role) AllowsNetwork(addr netip.Addr) bool {
+	if len(r.Prefixes) == 0 {
+		return true
+	}
+	return containsAddr(r.Prefixes, addr)
+}
+
+// Contains reports whether addr is in the networks of the entry.
+func (n *AllowedNetwork) Contains(addr netip.Addr) bool {
+	return containsAddr(n.Prefixes, addr)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRoles validates the roles and their references and parses their
+// networks.
+func parseRoles(a *Auth) error {
+	for name, role := range a.Roles {
+		if name == "" {
+			return errors.New("auth.roles cannot contain an empty name")
+		}
+		if role == nil || len(role.Domains) == 0 {
+			return fmt.Errorf("auth.roles.%s.domains cannot be empty", name)
+		}
+		prefixes, err := parsePrefixes(role.Networks)
+		if err != nil {
+			return fmt.Errorf("invalid auth.roles.%s.networks entry %w", name, err)
+		}
+		role.Prefixes = prefixes
+	}
+
+	for i := range a.Users {
+		// Entries adding to a user of auth.usersFile may set matchClientIP only.
+		isFileUserEntry := a.UsersFile != "" && a.Users[i].Password == ""
+		if len(a.Users[i].Domains) == 0 && len(a.Users[i].Roles) == 0 && !isFileUserEntry {
+			return fmt.Errorf("auth.users[%d] must have domains or roles", i)
+		}
+		if err := checkRoleRefs(a.Roles, a.Users[i].Roles); err != nil {
+			return fmt.Errorf("invalid auth.users[%d].roles: %w", i, err)
+		}
+	}
+
+	for i := range a.AllowedNetworks {
+		n := &a.AllowedNetworks[i]
+		if len(n.Networks) == 0 {
+			return fmt.Errorf("auth.allowedNetworks[%d].networks cannot be empty", i)
+		}
+		if len(n.Roles) == 0 {
+			return fmt.Errorf("auth.allowedNetworks[%d].roles cannot be empty", i)
+		}
+		if err := checkRoleRefs(a.Roles, n.Roles); err != nil {
+			return fmt.Errorf("invalid auth.allowedNetworks[%d].roles: %w", i, err)
+		}
+		prefixes, err := parsePrefixes(n.Networks)
+		if err != nil {
+			return fmt.Errorf("invalid auth.allowedNetworks[%d].networks entry %w", i, err)
+		}
+		n.Prefixes = prefixes
+	}
+	return nil
+}
+
+func checkRoleRefs(roles map[string]*Role, refs []string) error {
+	for _, ref := range refs {
+		if _, ok := roles[ref]; !ok {
+			return fmt.Errorf("unknown role %q", ref)
+		}
+	}
+	return nil
+}
+
+func parsePrefixes(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, n := range networks {
+		prefix, err := netlist.ParsePrefix(n)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", n, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// Grant is a set of domains a principal may update with RecordTypes from
+// Networks, granted directly or by Role. Empty RecordTypes and Networks
+// allow all record types and clients.
+type Grant struct {
+	Domains     []string
+	RecordTypes []string
+	Networks    []string
+	Role        string
+}
+
+// PrincipalGrants are the effective grants of a principal.
+type PrincipalGrants struct {
+	Principal string
+	Grants    []Grant
+}
+
+// EffectiveGrants returns the grants of all principals of the config with
+// their roles resolved. Grants of JWT claims and forward auth are only
+// known at request time and are not included.
+func (a *Auth) EffectiveGrants() []PrincipalGrants {
+	var principals []PrincipalGrants
+
+	networkDomains := map[string][]string{}
+	for domain, ipNets := range a.AllowedDomains {
+		for _, ipNet := range ipNets {
+			networkDomains[ipNet.String()] = append(networkDomains[ipNet.String()], domain)
+		}
+	}
+	for _, network := range slices.Sorted(maps.Keys(networkDomains)) {
+		slices.Sort(networkDomains[network])
+		principals = append(principals, PrincipalGrants{
+			Principal: "network:" + network,
+			Grants:    []Grant{{Domains: networkDomains[network], Networks: []string{network}}},
+		})
+	}
+	for i := range a.AllowedNetworks {
+		n := &a.AllowedNetworks[i]
+		principals = append(principals, PrincipalGrants{
+			Principal: "network:" + strings.Join(n.Networks, ","),
+			Grants:    a.roleGrants(nil, n.Roles),
+		})
+	}
+
+	users := a.AllUsers()
+	for i := range users {
+		var grants []Grant
+		if len(users[i].Domains) > 0 {
+			grants = append(grants, Grant{Domains: users[i].Domains})
+		}
+		principals = append(principals, PrincipalGrants{
+			Principal: "user:" + users[i].Username,
+			Grants:    a.roleGrants(grants, users[i].Roles),
+		})
+	}
+
+	for i := range a.ClientCerts {
+		cc := &a.ClientCerts[i]
+		principals = append(principals, PrincipalGrants{
+			Principal: "clientCert:" + cc.Subject + cc.DNSName + cc.SPIFFEID,
+			Grants:    []Grant{{Domains: cc.Domains}},
+		})
+	}
+	for i := range a.APITokens {
+		t := &a.APITokens[i]
+		var grants []Grant
+		if len(t.Domains) > 0 {
+			grants = append(grants, Grant{Domains: t.Domains})
+		}
+		principals = append(principals, PrincipalGrants{
+			Principal: "apiToken:" + t.Name,
+			Grants:    a.roleGrants(grants, t.Roles),
+		})
+	}
+	if a.JWT != nil {
+		for _, s := range a.JWT.Subjects {
+			principals = append(principals, PrincipalGrants{
+				Principal: "jwt:" + s.Pattern,
+				Grants:    []Grant{{Domains: s.Domains, RecordTypes: s.RecordTypes}},
+			})
+		}
+	}
+	return principals
+}
+
+func (a *Auth) roleGrants(grants []Grant, names []string) []Grant {
+	for _, name := range names {
+		if role, ok := a.Roles[name]; ok {
+			grants = append(grants, Grant{
+				Domains:     role.Domains,
+				RecordTypes: role.RecordTypes,
+				Networks:    role.Networks,
+				Role:        name,
+			})
+		}
+	}
+	return grants
+}
+
+// WriteGrants writes the grants of principals in a human readable form.
+func WriteGrants(w io.Writer, principals []PrincipalGrants) error {
+	for _, p := range principals {
+		if _, err := fmt.Fprintln(w, p.Principal); err != nil {
+			return err
+		}
+		for _, g := range p.Grants {
+			line := fmt.Sprintf("  domains=%s recordTypes=%s networks=%s",
+				strings.Join(g.Domains, ","), listOrAll(g.RecordTypes), listOrAll(g.Networks))
+			if g.Role != "" {
+				line += " role=" + g.Role
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func listOrAll(values []string) string {
+	if len(values) == 0 {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
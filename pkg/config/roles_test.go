@@ -0,0 +1,109 @@
+package config_test
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+var _ = Describe("Roles", func() {
+	const rolesConfig = `token: verysecrettoken
+auth:
+  method: any
+  allowedDomains:
+    example.com:
+      - ip: 127.0.0.1
+        mask: [255, 255, 255, 255]
+  roles:
+    acme:
+      domains: ["*.example.org"]
+      recordTypes: [TXT]
+    lan:
+      domains: [home.example.net]
+      networks: [10.0.0.0/8]
+  allowedNetworks:
+    - networks: [192.168.0.0/16]
+      roles: [lan]
+  users:
+    - username: alice
+      password: pass
+      domains: [alice.example.com]
+      roles: [acme, lan]
+    - username: bob
+      password: pass
+      roles: [acme]
+`
+
+	readConfig := func(content string) (*config.Config, error) {
+		filePath := path.Join(GinkgoT().TempDir(), "config.yaml")
+		Expect(os.WriteFile(filePath, []byte(content), 0o600)).To(Succeed())
+		return config.ReadFile(filePath)
+	}
+
+	It("should parse roles", func() {
+		cfg, err := readConfig(rolesConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Auth.Roles).To(HaveLen(2))
+		Expect(cfg.Auth.Roles["lan"].Prefixes).To(HaveLen(1))
+		Expect(cfg.Auth.AllowedNetworks[0].Prefixes).To(HaveLen(1))
+		Expect(cfg.Auth.Users[1].Roles).To(Equal([]string{"acme"}))
+	})
+
+	It("should resolve the effective grants", func() {
+		cfg, err := readConfig(rolesConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Auth.EffectiveGrants()).To(Equal([]config.PrincipalGrants{
+			{Principal: "network:127.0.0.1/32", Grants: []config.Grant{
+				{Domains: []string{"example.com"}, Networks: []string{"127.0.0.1/32"}},
+			}},
+			{Principal: "network:192.168.0.0/16", Grants: []config.Grant{
+				{Domains: []string{"home.example.net"}, Networks: []string{"10.0.0.0/8"}, Role: "lan"},
+			}},
+			{Principal: "user:alice", Grants: []config.Grant{
+				{Domains: []string{"alice.example.com"}},
+				{Domains: []string{"*.example.org"}, RecordTypes: []string{"TXT"}, Role: "acme"},
+				{Domains: []string{"home.example.net"}, Networks: []string{"10.0.0.0/8"}, Role: "lan"},
+			}},
+			{Principal: "user:bob", Grants: []config.Grant{
+				{Domains: []string{"*.example.org"}, RecordTypes: []string{"TXT"}, Role: "acme"},
+			}},
+		}))
+	})
+
+	It("should write the effective grants", func() {
+		cfg, err := readConfig(rolesConfig)
+		Expect(err).ToNot(HaveOccurred())
+		var b strings.Builder
+		Expect(config.WriteGrants(&b, cfg.Auth.EffectiveGrants()[2:3])).To(Succeed())
+		Expect(b.String()).To(Equal(`user:alice
+  domains=alice.example.com recordTypes=* networks=*
+  domains=*.example.org recordTypes=TXT networks=* role=acme
+  domains=home.example.net recordTypes=* networks=10.0.0.0/8 role=lan
+`))
+	})
+
+	DescribeTable("should fail", func(replace, with, expectedErr string) {
+		_, err := readConfig(strings.Replace(rolesConfig, replace, with, 1))
+		Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("with a role without domains", `domains: ["*.example.org"]`, "domains: []",
+			"auth.roles.acme.domains cannot be empty"),
+		Entry("with an invalid role network", "networks: [10.0.0.0/8]", "networks: [10.0.0.0/33]",
+			`invalid auth.roles.lan.networks entry "10.0.0.0/33"`),
+		Entry("with an unknown user role", "roles: [acme]\n", "roles: [admin]\n",
+			`invalid auth.users[1].roles: unknown role "admin"`),
+		Entry("with a user without domains and roles", "roles: [acme]\n", "roles: []\n",
+			"auth.users[1] must have domains or roles"),
+		Entry("with an unknown allowedNetworks role", "roles: [lan]", "roles: [wan]",
+			`invalid auth.allowedNetworks[0].roles: unknown role "wan"`),
+		Entry("with an allowedNetworks entry without roles", "roles: [lan]", "roles: []",
+			"auth.allowedNetworks[0].roles cannot be empty"),
+		Entry("with an invalid allowedNetworks network", "networks: [192.168.0.0/16]", "networks: [invalid]",
+			`invalid auth.allowedNetworks[0].networks entry "invalid"`),
+	)
+})
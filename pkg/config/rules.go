@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+// Rule is an expression that must evaluate to true for authorized requests
+// to be allowed. Rules are compiled when the config is loaded.
+type Rule struct {
+	Name    string         `yaml:"name"`
+	Expr    string         `yaml:"expr"`
+	Program *rules.Program `yaml:"-"`
+}
+
+// RuleVariables are the variables available in rule expressions.
+var RuleVariables = map[string]rules.Type{
+	"fqdn":     rules.String,
+	"name":     rules.String,
+	"zone":     rules.String,
+	"type":     rules.String,
+	"value":    rules.String,
+	"username": rules.String,
+	"clientIP": rules.String,
+	"endpoint": rules.String,
+	"now":      rules.Int,
+	"hour":     rules.Int,
+	"weekday":  rules.Int,
+}
+
+func compileRules(rs []Rule) error {
+	for i := range rs {
+		if rs[i].Name == "" {
+			return fmt.Errorf("auth.rules[%d].name cannot be empty", i)
+		}
+		program, err := rules.Compile(rs[i].Expr, RuleVariables)
+		if err != nil {
+			return fmt.Errorf("invalid auth.rules[%d] %q: %w", i, rs[i].Name, err)
+		}
+		rs[i].Program = program
+	}
+	return nil
+}
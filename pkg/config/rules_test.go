@@ -0,0 +1,48 @@
+package config_test
+
+import (
+	"os"
+	"path"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+var _ = Describe("Rules", func() {
+	readConfig := func(rules string) (*config.Config, error) {
+		filePath := path.Join(GinkgoT().TempDir(), "config.yaml")
+		content := `token: verysecrettoken
+auth:
+  method: allowedDomains
+  allowedDomains:
+    example.com:
+      - ip: 127.0.0.1
+        mask: [255, 255, 255, 255]
+  rules:
+` + rules
+		Expect(os.WriteFile(filePath, []byte(content), 0o600)).To(Succeed())
+		return config.ReadFile(filePath)
+	}
+
+	It("should compile rules", func() {
+		cfg, err := readConfig(`    - name: a-in-net
+      expr: type != "A" || value.inCIDR("192.0.2.0/24")
+`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.Auth.Rules).To(HaveLen(1))
+		Expect(cfg.Auth.Rules[0].Program.Eval(map[string]any{"type": "A", "value": "192.0.2.1"})).To(BeTrue())
+	})
+
+	DescribeTable("should fail", func(rules, expectedErr string) {
+		_, err := readConfig(rules)
+		Expect(err).To(MatchError(expectedErr))
+	},
+		Entry("without name", "    - expr: 'true'\n", "auth.rules[0].name cannot be empty"),
+		Entry("with an unknown variable", "    - name: typo\n      expr: 'typ == \"A\"'\n",
+			`invalid auth.rules[0] "typo": column 1: unknown variable "typ"`),
+		Entry("with a non-bool expression", "    - name: value\n      expr: value\n",
+			`invalid auth.rules[0] "value": column 1: expression must be of type bool, got string`),
+	)
+})
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Webhook configures the listener of the external-dns webhook provider on
+// ListenAddr. Its clients are not authenticated: it manages the records of
+// the domains of DomainFilter and their subdomains except those of
+// ExcludeDomains, for clients from AllowedNetworks. Without AllowedNetworks,
+// ListenAddr must be a loopback address.
+type Webhook struct {
+	ListenAddr      string         `yaml:"listenAddr"`
+	DomainFilter    []string       `yaml:"domainFilter"`
+	ExcludeDomains  []string       `yaml:"excludeDomains,omitempty"`
+	AllowedNetworks []string       `yaml:"allowedNetworks,omitempty"`
+	Prefixes        []netip.Prefix `yaml:"-"`
+}
+
+func validateWebhook(w *Webhook) error {
+	if w == nil {
+		return nil
+	}
+	if w.ListenAddr == "" {
+		return errors.New("webhook.listenAddr cannot be empty")
+	}
+	if len(w.DomainFilter) == 0 {
+		return errors.New("webhook.domainFilter cannot be empty")
+	}
+	if err := normalizeDomains(w.DomainFilter); err != nil {
+		return fmt.Errorf("invalid webhook.domainFilter: %w", err)
+	}
+	if err := normalizeDomains(w.ExcludeDomains); err != nil {
+		return fmt.Errorf("invalid webhook.excludeDomains: %w", err)
+	}
+	prefixes, err := parsePrefixes(w.AllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("invalid webhook.allowedNetworks entry %w", err)
+	}
+	if len(prefixes) == 0 && !isLoopbackAddr(w.ListenAddr) {
+		return errors.New("webhook.allowedNetworks is required unless webhook.listenAddr is a loopback address")
+	}
+	w.Prefixes = prefixes
+	return nil
+}
+
+// isLoopbackAddr reports whether the host of addr is localhost or a loopback
+// IP address.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// normalizeDomains converts domains to lower case without a trailing dot.
+func normalizeDomains(domains []string) error {
+	for i, domain := range domains {
+		domains[i] = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if domains[i] == "" {
+			return fmt.Errorf("empty domain at index %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package cpanel
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	api2Version = "2"
+	api2Module  = "ZoneEdit"
+	paramFunc   = "cpanel_jsonapi_func"
+	paramDomain = "domain"
+	classIN     = "IN"
+
+	// zoneFileHeader is the first line of the zone files of cPanel, which
+	// dns_cpanel of acme.sh looks for in the zones of fetchzones.
+	zoneFileHeader = "; cPanel first:hetzner-dnsapi-proxy"
+	recordTypeTXT  = "TXT"
+)
+
+type api2Response struct {
+	Result api2Result `json:"cpanelresult"`
+}
+
+type api2Result struct {
+	APIVersion int       `json:"apiversion"`
+	Module     string    `json:"module"`
+	Func       string    `json:"func"`
+	Event      api2Event `json:"event"`
+	Data       any       `json:"data"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type api2Event struct {
+	Result int `json:"result"`
+}
+
+// api2Zones is the result of fetchzones, which maps zones to the lines of
+// their zone files. The zone files only consist of the header.
+type api2Zones struct {
+	Status    int                 `json:"status"`
+	StatusMsg string              `json:"statusmsg"`
+	Zones     map[string][]string `json:"zones"`
+}
+
+// api2Record is a record of fetchzone_records.
+type api2Record struct {
+	Line    int    `json:"line"`
+	Name    string `json:"name"`
+	Class   string `json:"class"`
+	TTL     int    `json:"ttl"`
+	Type    string `json:"type"`
+	Record  string `json:"record"`
+	Address string `json:"address,omitempty"`
+	TXTData string `json:"txtdata,omitempty"`
+}
+
+// api2Status is the result of add_zone_record and remove_zone_record. Failed
+// calls have no new serial.
+type api2Status struct {
+	Result api2StatusResult `json:"result"`
+}
+
+type api2StatusResult struct {
+	Status    int     `json:"status"`
+	StatusMsg string  `json:"statusmsg"`
+	NewSerial *string `json:"newserial"`
+}
+
+func newAPI2Response(form url.Values, data any) *api2Response {
+	return &api2Response{Result: api2Result{
+		APIVersion: 2,
+		Module:     api2Module,
+		Func:       form.Get(paramFunc),
+		Event:      api2Event{Result: 1},
+		Data:       data,
+	}}
+}
+
+func newAPI2Error(form url.Values, message string) *api2Response {
+	res := newAPI2Response(form, []api2Status{{Result: api2StatusResult{StatusMsg: message}}})
+	res.Result.Event.Result = 0
+	res.Result.Error = message
+	return res
+}
+
+func newAPI2Status(message, serial string) []api2Status {
+	return []api2Status{{Result: api2StatusResult{Status: 1, StatusMsg: message, NewSerial: &serial}}}
+}
+
+// api2 serves the calls of the ZoneEdit module of API 2.
+func (h *handler) api2(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, newAPI2Error(r.URL.Query(), "Could not parse the request"))
+		return
+	}
+	if r.Form.Get("cpanel_jsonapi_apiversion") != api2Version || r.Form.Get("cpanel_jsonapi_module") != api2Module {
+		writeJSON(w, http.StatusOK, newAPI2Error(r.Form, "Only the ZoneEdit module of API 2 is supported"))
+		return
+	}
+
+	ctx, cancel := h.context(r)
+	defer cancel()
+	var (
+		data any
+		err  error
+	)
+	switch r.Form.Get(paramFunc) {
+	case "fetchzones":
+		data, err = h.fetchZones(ctx, r)
+	case "fetchzone_records":
+		data, err = h.fetchZoneRecords(ctx, r)
+	case "add_zone_record":
+		data, err = h.addZoneRecord(ctx, w, r)
+	case "remove_zone_record":
+		data, err = h.removeZoneRecord(ctx, w, r)
+	default:
+		err = &apiError{message: "Could not find function"}
+	}
+	if err != nil {
+		writeJSON(w, http.StatusOK, newAPI2Error(r.Form, errorMessage(err)))
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPI2Response(r.Form, data))
+}
+
+// fetchZones lists the zones the API token of r covers.
+func (h *handler) fetchZones(ctx context.Context, r *http.Request) ([]api2Zones, error) {
+	zones, err := h.records.Zones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := middleware.APITokenFromContext(r.Context())
+	result := api2Zones{Status: 1, StatusMsg: "Zones fetched", Zones: map[string][]string{}}
+	for _, zone := range zones {
+		if middleware.APITokenCoversZone(&h.cfg.Auth, t, zone.Name, r.RemoteAddr) {
+			result.Zones[zone.Name] = []string{zoneFileHeader}
+		}
+	}
+	return []api2Zones{result}, nil
+}
+
+// fetchZoneRecords lists the records of the zone, optionally filtered by
+// name and type.
+func (h *handler) fetchZoneRecords(ctx context.Context, r *http.Request) ([]api2Record, error) {
+	s, err := h.loadZone(ctx, r, r.Form.Get(paramDomain))
+	if err != nil {
+		return nil, err
+	}
+	filterName := ""
+	if name := r.Form.Get("name"); name != "" {
+		if filterName, _, err = s.rrSetName(name); err != nil {
+			return nil, err
+		}
+	}
+	filterType := strings.ToUpper(r.Form.Get("type"))
+
+	records := []api2Record{}
+	for _, rec := range s.records() {
+		if (filterName != "" && rec.set.fqdn != filterName) || (filterType != "" && rec.set.recordType != filterType) {
+			continue
+		}
+		record := api2Record{
+			Line:   rec.line,
+			Name:   rec.set.fqdn + ".",
+			Class:  classIN,
+			TTL:    rec.set.ttl,
+			Type:   rec.set.recordType,
+			Record: rec.value,
+		}
+		if rec.set.recordType == recordTypeTXT {
+			record.TXTData = rec.value
+		} else {
+			record.Address = rec.value
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// addZoneRecord adds a record with the txtdata of TXT records or the
+// address of A and AAAA records.
+func (h *handler) addZoneRecord(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]api2Status, error) {
+	s, err := h.loadZone(ctx, r, r.Form.Get(paramDomain))
+	if err != nil {
+		return nil, err
+	}
+	recordType := strings.ToUpper(r.Form.Get("type"))
+	value := r.Form.Get("address")
+	if recordType == recordTypeTXT {
+		value = r.Form.Get("txtdata")
+	}
+	var values []string
+	if value != "" {
+		values = []string{value}
+	}
+	// Invalid TTLs fall back to the default like missing ones.
+	ttl, _ := strconv.Atoi(r.Form.Get("ttl"))
+	if err := s.add(r.Form.Get("name"), recordType, ttl, values); err != nil {
+		return nil, err
+	}
+	if err := h.apply(ctx, w, r, s); err != nil {
+		return nil, err
+	}
+	return newAPI2Status("Added record", s.formatSerial()), nil
+}
+
+// removeZoneRecord removes the record on line.
+func (h *handler) removeZoneRecord(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]api2Status, error) {
+	s, err := h.loadZone(ctx, r, r.Form.Get(paramDomain))
+	if err != nil {
+		return nil, err
+	}
+	line, err := strconv.Atoi(r.Form.Get("line"))
+	if err != nil {
+		return nil, &apiError{message: "You must specify a valid line"}
+	}
+	if err := s.remove(line); err != nil {
+		return nil, err
+	}
+	if err := h.apply(ctx, w, r, s); err != nil {
+		return nil, err
+	}
+	return newAPI2Status("Removed record", s.formatSerial()), nil
+}
@@ -0,0 +1,189 @@
+// Package cpanel implements the cPanel API 2 ZoneEdit and UAPI DNS calls
+// used by DNS clients like the cpanel provider of lego and dns_cpanel of
+// acme.sh on top of the zones and RRSets of the Cloud API. Clients
+// authenticate with API tokens issued by the proxy and only see the zones
+// and records their grants cover.
+package cpanel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// API2Path is the path of the calls of cPanel API 2.
+	API2Path = "/json-api/cpanel"
+	// UAPIPath is the path prefix of the calls of the UAPI DNS module.
+	UAPIPath = "/execute/DNS/"
+
+	authScheme         = "cpanel"
+	maxRequestBodySize = 64 << 10 // 64 KB
+	errInternal        = "Internal error"
+	errAccessDenied    = "Access denied"
+)
+
+// Records reads and changes the zones and RRSets of the Cloud API.
+type Records interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+	RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error)
+	SetRecords(ctx context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int) error
+	DeleteRRSet(ctx context.Context, zone *hcloud.Zone, name, recordType string) error
+}
+
+type handler struct {
+	cfg     *config.Config
+	limits  *middleware.ScopedRateLimits
+	records Records
+}
+
+// apiError is an error answered to the client with its message.
+type apiError struct {
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// New returns the handler of the API 2 and UAPI routes. It must run after
+// middleware.NewAPITokenAuth with APIToken. Changes are limited by the zone
+// and upstream limits of limits.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, records Records) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, limits: limits, records: records}
+	mux := http.NewServeMux()
+	mux.HandleFunc(API2Path, h.api2)
+	mux.HandleFunc(UAPIPath+"{func}", h.uapi)
+	mux.HandleFunc("/", http.NotFound)
+
+	return func(_ http.Handler) http.Handler {
+		return mux
+	}
+}
+
+// APIToken returns the token function of middleware.NewAPITokenAuth for the
+// Authorization header "cpanel <name>:<token>". It returns the token if
+// the API token with it is named name, and an empty token otherwise.
+func APIToken(cfg *config.Config) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		scheme, credentials, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, authScheme) {
+			return ""
+		}
+		name, token, ok := strings.Cut(strings.TrimSpace(credentials), ":")
+		if !ok {
+			return ""
+		}
+		t := middleware.LookupAPIToken(cfg.Auth.APITokens, token)
+		if t == nil || t.Name != name {
+			return ""
+		}
+		return token
+	}
+}
+
+// Unauthorized answers requests without valid credentials like cPanel.
+func Unauthorized(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, UAPIPath) {
+		writeJSON(w, http.StatusUnauthorized, newUAPIResponse(r, nil, &apiError{message: errAccessDenied}))
+		return
+	}
+	writeJSON(w, http.StatusUnauthorized, newAPI2Error(r.URL.Query(), errAccessDenied))
+}
+
+func (h *handler) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+// loadZone returns the state of the zone named name if the API token of r
+// covers it.
+func (h *handler) loadZone(ctx context.Context, r *http.Request, name string) (*zoneState, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return nil, &apiError{message: "You must specify a zone"}
+	}
+	zone, err := h.records.Zone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	t := middleware.APITokenFromContext(r.Context())
+	if zone == nil || !middleware.APITokenCoversZone(&h.cfg.Auth, t, zone.Name, r.RemoteAddr) {
+		return nil, &apiError{message: "The zone " + name + " does not exist"}
+	}
+	rrSets, err := h.records.RRSets(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	return newZoneState(h.cfg, r, zone, rrSets), nil
+}
+
+// apply applies the changes of s to the Cloud API if they are within the
+// scoped rate limits of the API token of r. The changed RRSets are replaced one after another, not
+// atomically.
+func (h *handler) apply(ctx context.Context, w http.ResponseWriter, r *http.Request, s *zoneState) error {
+	changed := s.changed()
+	if len(changed) > 0 && !h.limits.AllowN(w, middleware.APITokenLimitUser(r), s.zone.Name, len(changed)) {
+		return &apiError{message: "Rate limit exceeded, try again later"}
+	}
+	for _, set := range changed {
+		logChange(r, set)
+		var err error
+		if len(set.values) == 0 {
+			err = h.records.DeleteRRSet(ctx, s.zone, set.name, set.recordType)
+		} else {
+			err = h.records.SetRecords(ctx, s.zone, set.name, set.recordType, set.values, set.ttl)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errorMessage returns the message of err answered to the client, which is
+// the one of an apiError or a generic one for errors of the Cloud API.
+func errorMessage(err error) string {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e.message
+	}
+	log.Printf("failed to call the Cloud API: %v", err)
+	return errInternal
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func logChange(r *http.Request, set *rrSet) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	typ := sanitize.LogValue(set.recordType)
+	name := sanitize.LogValue(set.fqdn)
+	val := sanitize.LogValue(strings.Join(set.values, ", "))
+	//nolint:gosec // values are sanitized above
+	log.Printf("received cPanel request of API token '%s' to set '%s' data of '%s' to '%s'", t, typ, name, val)
+}
+
+func logDenied(r *http.Request, fqdn, recordType string) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	addr := sanitize.LogValue(r.RemoteAddr)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("API token '%s' of client '%s' is not allowed to change '%s' data of '%s'", t, addr, typ, name)
+}
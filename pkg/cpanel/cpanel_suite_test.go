@@ -0,0 +1,13 @@
+package cpanel_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestCPanel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "cpanel test suite")
+}
@@ -0,0 +1,332 @@
+package cpanel_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/cpanel"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+const (
+	tokenName = "team"
+	token     = "team-token"
+)
+
+// fakeRecords keeps the zones and RRSets of the Cloud API in memory.
+type fakeRecords struct {
+	zones  []*hcloud.Zone
+	rrSets map[int64][]*hcloud.ZoneRRSet
+	calls  []string
+}
+
+func (f *fakeRecords) Zones(_ context.Context) ([]*hcloud.Zone, error) {
+	return f.zones, nil
+}
+
+func (f *fakeRecords) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	for _, zone := range f.zones {
+		if zone.Name == idOrName {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRecords) RRSets(_ context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	return f.rrSets[zone.ID], nil
+}
+
+func (f *fakeRecords) SetRecords(_ context.Context, _ *hcloud.Zone, name, recordType string, values []string, ttl int) error {
+	f.calls = append(f.calls, "set "+name+" "+recordType+" "+strings.Join(values, ",")+" "+strconv.Itoa(ttl))
+	return nil
+}
+
+func (f *fakeRecords) DeleteRRSet(_ context.Context, _ *hcloud.Zone, name, recordType string) error {
+	f.calls = append(f.calls, "delete "+name+" "+recordType)
+	return nil
+}
+
+type api2Record struct {
+	Line    int    `json:"line"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TTL     int    `json:"ttl"`
+	Address string `json:"address"`
+	TXTData string `json:"txtdata"`
+}
+
+type api2Status struct {
+	Result struct {
+		Status    int     `json:"status"`
+		NewSerial *string `json:"newserial"`
+	} `json:"result"`
+}
+
+type uapiRecord struct {
+	LineIndex  int      `json:"line_index"`
+	RecordType string   `json:"record_type"`
+	DNameB64   string   `json:"dname_b64"`
+	DataB64    []string `json:"data_b64"`
+}
+
+var _ = Describe("cPanel API", func() {
+	var (
+		records *fakeRecords
+		limits  *middleware.ScopedRateLimits
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		ttl := 300
+		records = &fakeRecords{
+			zones: []*hcloud.Zone{
+				{ID: 1, Name: "example.com", TTL: 3600},
+				{ID: 2, Name: "example.org", TTL: 3600},
+			},
+			rrSets: map[int64][]*hcloud.ZoneRRSet{
+				1: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.4"}}},
+					{Name: "_acme-challenge.www", Type: hcloud.ZoneRRSetTypeTXT, Records: []hcloud.ZoneRRSetRecord{
+						{Value: `"token1"`}, {Value: `"token2"`},
+					}},
+					{Name: "@", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.5"}}},
+				},
+			},
+		}
+
+		cfg := &config.Config{
+			Timeout:   10,
+			RecordTTL: 60,
+			Auth: config.Auth{
+				APITokens: []config.APIToken{{Name: tokenName, Token: token, Domains: []string{"*.example.com"}}},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewAPITokenAuth(cfg, lockout, cpanel.APIToken(cfg), cpanel.Unauthorized)(
+			cpanel.New(cfg, limits, records)(nil),
+		)
+	})
+
+	doWith := func(authorization, path string, query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), http.NoBody)
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	api2 := func(fn string, query url.Values, data any) string {
+		query.Set("cpanel_jsonapi_apiversion", "2")
+		query.Set("cpanel_jsonapi_module", "ZoneEdit")
+		query.Set("cpanel_jsonapi_func", fn)
+		rec := doWith("cpanel "+tokenName+":"+token, cpanel.API2Path, query)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		res := struct {
+			Result struct {
+				Data  json.RawMessage `json:"data"`
+				Error string          `json:"error"`
+			} `json:"cpanelresult"`
+		}{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+		Expect(json.Unmarshal(res.Result.Data, data)).To(Succeed())
+		return res.Result.Error
+	}
+
+	uapi := func(fn string, query url.Values, data any) []string {
+		rec := doWith("cpanel "+tokenName+":"+token, cpanel.UAPIPath+fn, query)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		res := struct {
+			Result struct {
+				Data   json.RawMessage `json:"data"`
+				Errors []string        `json:"errors"`
+				Status int             `json:"status"`
+			} `json:"result"`
+		}{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+		if res.Result.Status == 1 && data != nil {
+			Expect(json.Unmarshal(res.Result.Data, data)).To(Succeed())
+		}
+		return res.Result.Errors
+	}
+
+	fetchRecords := func() []api2Record {
+		var recs []api2Record
+		Expect(api2("fetchzone_records", url.Values{"domain": {"example.com"}}, &recs)).To(BeEmpty())
+		return recs
+	}
+
+	parseZone := func() (serial string, recs []uapiRecord) {
+		Expect(uapi("parse_zone", url.Values{"zone": {"example.com"}}, &recs)).To(BeEmpty())
+		Expect(recs[0].RecordType).To(Equal("SOA"))
+		data, err := base64.StdEncoding.DecodeString(recs[0].DataB64[2])
+		Expect(err).ToNot(HaveOccurred())
+		return string(data), recs[1:]
+	}
+
+	DescribeTable("should reject", func(authorization string) {
+		Expect(doWith(authorization, cpanel.UAPIPath+"parse_zone", url.Values{"zone": {"example.com"}}).Code).
+			To(Equal(http.StatusUnauthorized))
+	},
+		Entry("invalid tokens", "cpanel "+tokenName+":invalid"),
+		Entry("other names", "cpanel other:"+token),
+		Entry("other schemes", "Bearer "+token),
+	)
+
+	It("should list only covered zones", func() {
+		var zones []struct {
+			Zones map[string][]string `json:"zones"`
+		}
+		Expect(api2("fetchzones", url.Values{}, &zones)).To(BeEmpty())
+		Expect(zones).To(HaveLen(1))
+		Expect(zones[0].Zones).To(HaveKey("example.com"))
+		Expect(zones[0].Zones).ToNot(HaveKey("example.org"))
+		Expect(zones[0].Zones["example.com"][0]).To(HavePrefix("; cPanel first"))
+	})
+
+	It("should list the granted records with stable lines", func() {
+		recs := fetchRecords()
+		Expect(recs).To(HaveLen(3))
+		Expect(recs[0]).To(And(
+			HaveField("Name", "www.example.com."), HaveField("Type", "A"), HaveField("TTL", 300), HaveField("Address", "1.2.3.4"),
+		))
+		Expect(recs[1]).To(And(HaveField("Type", "TXT"), HaveField("TTL", 3600), HaveField("TXTData", "token1")))
+		Expect(recs[2]).To(HaveField("TXTData", "token2"))
+
+		records.rrSets[1] = records.rrSets[1][1:]
+		Expect(fetchRecords()).To(Equal(recs[1:]))
+	})
+
+	It("should add records with a relative name", func() {
+		var status []api2Status
+		Expect(api2("add_zone_record", url.Values{
+			"domain": {"example.com"}, "name": {"_acme-challenge.www"}, "type": {"TXT"}, "txtdata": {"token3"}, "ttl": {"1"},
+		}, &status)).To(BeEmpty())
+		Expect(status[0].Result.Status).To(Equal(1))
+		Expect(status[0].Result.NewSerial).ToNot(BeNil())
+		Expect(records.calls).To(Equal([]string{"set _acme-challenge.www TXT token1,token2,token3 60"}))
+	})
+
+	It("should remove records by line", func() {
+		line := fetchRecords()[2].Line
+		var status []api2Status
+		Expect(api2("remove_zone_record", url.Values{"domain": {"example.com"}, "line": {strconv.Itoa(line)}}, &status)).
+			To(BeEmpty())
+		Expect(records.calls).To(Equal([]string{"set _acme-challenge.www TXT token1 3600"}))
+	})
+
+	It("should not apply changes exceeding the zone limit", func() {
+		limits.Zone = ratelimit.NewPolicy(nil, ratelimit.NewQuota(0))
+		var status []api2Status
+		Expect(api2("add_zone_record", url.Values{
+			"domain": {"example.com"}, "name": {"_acme-challenge.www"}, "type": {"TXT"}, "txtdata": {"token3"},
+		}, &status)).To(ContainSubstring("Rate limit exceeded"))
+		Expect(records.calls).To(BeEmpty())
+	})
+
+	It("should limit changes per API token", func() {
+		limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		var status []api2Status
+		Expect(api2("add_zone_record", url.Values{
+			"domain": {"example.com"}, "name": {"_acme-challenge.www"}, "type": {"TXT"}, "txtdata": {"token3"},
+		}, &status)).To(BeEmpty())
+		Expect(api2("add_zone_record", url.Values{
+			"domain": {"example.com"}, "name": {"_acme-challenge.www"}, "type": {"TXT"}, "txtdata": {"token4"},
+		}, &status)).To(ContainSubstring("Rate limit exceeded"))
+		Expect(records.calls).To(HaveLen(1))
+	})
+
+	DescribeTable("should fail to add", func(name, recordType, value string) {
+		var status []api2Status
+		Expect(api2("add_zone_record", url.Values{
+			"domain": {"example.com"}, "name": {name}, "type": {recordType}, "address": {value}, "txtdata": {value},
+		}, &status)).ToNot(BeEmpty())
+		Expect(status[0].Result.Status).To(Equal(0))
+		Expect(status[0].Result.NewSerial).To(BeNil())
+		Expect(records.calls).To(BeEmpty())
+	},
+		Entry("records outside the grants", "example.com.", "A", "1.2.3.6"),
+		Entry("records out of zone", "www.example.org.", "A", "1.2.3.6"),
+		Entry("unsupported types", "www", "MX", "mail.example.com."),
+		Entry("private addresses", "www", "A", "10.0.0.1"),
+		Entry("addresses other than the client IP", "home", "A", "1.2.3.8"),
+		Entry("records without data", "www", "A", ""),
+	)
+
+	It("should parse the zone with its serial and base64 encoded records", func() {
+		serial, recs := parseZone()
+		Expect(serial).ToNot(BeEmpty())
+		Expect(recs).To(HaveLen(3))
+		Expect(recs[0].DNameB64).To(Equal(base64.StdEncoding.EncodeToString([]byte("www.example.com."))))
+		Expect(recs[0].DataB64).To(Equal([]string{base64.StdEncoding.EncodeToString([]byte("1.2.3.4"))}))
+		Expect(recs[0].LineIndex).To(Equal(fetchRecords()[0].Line))
+	})
+
+	It("should mass edit the zone", func() {
+		serial, recs := parseZone()
+		var result struct {
+			NewSerial string `json:"new_serial"`
+		}
+		Expect(uapi("mass_edit_zone", url.Values{
+			"zone":   {"example.com"},
+			"serial": {serial},
+			"remove": {strconv.Itoa(recs[1].LineIndex)},
+			"edit": {
+				`{"line_index":` + strconv.Itoa(recs[0].LineIndex) +
+					`,"dname":"www.example.com.","ttl":120,"record_type":"A","data":["1.2.3.6"]}`,
+			},
+			"add": {`{"dname":"_acme-challenge.api.example.com.","ttl":300,"record_type":"TXT","data":["token3"]}`},
+		}, &result)).To(BeEmpty())
+		Expect(result.NewSerial).ToNot(Equal(serial))
+		Expect(records.calls).To(Equal([]string{
+			"set www A 1.2.3.6 120",
+			"set _acme-challenge.www TXT token2 3600",
+			"set _acme-challenge.api TXT token3 300",
+		}))
+	})
+
+	It("should delete RRSets without records", func() {
+		serial, recs := parseZone()
+		Expect(uapi("mass_edit_zone", url.Values{
+			"zone": {"example.com"}, "serial": {serial}, "remove": {strconv.Itoa(recs[0].LineIndex)},
+		}, nil)).To(BeEmpty())
+		Expect(records.calls).To(Equal([]string{"delete www A"}))
+	})
+
+	DescribeTable("should fail to mass edit", func(query url.Values) {
+		serial, _ := parseZone()
+		if !query.Has("serial") {
+			query.Set("serial", serial)
+		}
+		query.Set("zone", "example.com")
+		Expect(uapi("mass_edit_zone", query, nil)).ToNot(BeEmpty())
+		Expect(records.calls).To(BeEmpty())
+	},
+		Entry("with another serial", url.Values{"serial": {"1"}, "remove": {"2"}}),
+		Entry("unknown lines", url.Values{"remove": {"1"}}),
+		Entry("invalid records", url.Values{"add": {"{"}}),
+	)
+
+	It("should hide zones that are not covered", func() {
+		Expect(uapi("parse_zone", url.Values{"zone": {"example.org"}}, nil)).
+			To(ConsistOf("The zone example.org does not exist"))
+	})
+})
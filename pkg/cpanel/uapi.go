@@ -0,0 +1,207 @@
+package cpanel
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	uapiModule = "DNS"
+	typeRecord = "record"
+
+	// defaultNameserver is the primary nameserver of the SOA record of zones
+	// without assigned nameservers.
+	defaultNameserver = "hydrogen.ns.hetzner.com"
+
+	// Timers of the SOA record of parse_zone.
+	soaRefresh = 86400
+	soaRetry   = 10800
+	soaExpire  = 3600000
+	soaMinimum = 3600
+)
+
+type uapiResponse struct {
+	APIVersion int        `json:"apiversion"`
+	Module     string     `json:"module"`
+	Func       string     `json:"func"`
+	Result     uapiResult `json:"result"`
+}
+
+type uapiResult struct {
+	Data     any      `json:"data"`
+	Errors   []string `json:"errors"`
+	Messages []string `json:"messages"`
+	Warnings []string `json:"warnings"`
+	Metadata struct{} `json:"metadata"`
+	Status   int      `json:"status"`
+}
+
+// uapiRecord is a record of parse_zone with base64 encoded name and data.
+type uapiRecord struct {
+	LineIndex  int      `json:"line_index"`
+	Type       string   `json:"type"`
+	RecordType string   `json:"record_type"`
+	DNameB64   string   `json:"dname_b64"`
+	DataB64    []string `json:"data_b64"`
+	TTL        int      `json:"ttl"`
+}
+
+// uapiChange is a record of the add and edit parameters of mass_edit_zone.
+type uapiChange struct {
+	LineIndex  int      `json:"line_index"`
+	DName      string   `json:"dname"`
+	TTL        int      `json:"ttl"`
+	RecordType string   `json:"record_type"`
+	Data       []string `json:"data"`
+}
+
+// uapiSerial is the result of mass_edit_zone.
+type uapiSerial struct {
+	NewSerial string `json:"new_serial"`
+}
+
+// newUAPIResponse returns the response of the call of r with data, or with
+// the message of err if it is not nil.
+func newUAPIResponse(r *http.Request, data any, err error) *uapiResponse {
+	res := &uapiResponse{
+		APIVersion: 3,
+		Module:     uapiModule,
+		Func:       strings.TrimPrefix(r.URL.Path, UAPIPath),
+		Result:     uapiResult{Data: data, Status: 1},
+	}
+	if err != nil {
+		res.Result.Errors = []string{errorMessage(err)}
+		res.Result.Status = 0
+	}
+	return res
+}
+
+// uapi serves the calls of the DNS module of UAPI.
+func (h *handler) uapi(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, newUAPIResponse(r, nil, &apiError{message: "Could not parse the request"}))
+		return
+	}
+
+	ctx, cancel := h.context(r)
+	defer cancel()
+	var (
+		data any
+		err  error
+	)
+	switch r.PathValue("func") {
+	case "parse_zone":
+		data, err = h.parseZone(ctx, r)
+	case "mass_edit_zone":
+		data, err = h.massEditZone(ctx, w, r)
+	default:
+		err = &apiError{message: "Could not find function"}
+	}
+	writeJSON(w, http.StatusOK, newUAPIResponse(r, data, err))
+}
+
+// parseZone lists the SOA record and the records of the zone. Clients pass
+// the serial of the SOA record to mass_edit_zone.
+func (h *handler) parseZone(ctx context.Context, r *http.Request) ([]uapiRecord, error) {
+	s, err := h.loadZone(ctx, r, r.Form.Get("zone"))
+	if err != nil {
+		return nil, err
+	}
+	nameserver := defaultNameserver
+	if assigned := s.zone.AuthoritativeNameservers.Assigned; len(assigned) > 0 {
+		nameserver = strings.TrimSuffix(assigned[0], ".")
+	}
+	records := []uapiRecord{{
+		LineIndex:  soaLine,
+		Type:       typeRecord,
+		RecordType: "SOA",
+		DNameB64:   encode(s.zone.Name + "."),
+		DataB64: encodeAll(
+			nameserver+".", "hostmaster."+s.zone.Name+".", s.formatSerial(),
+			strconv.Itoa(soaRefresh), strconv.Itoa(soaRetry), strconv.Itoa(soaExpire), strconv.Itoa(soaMinimum),
+		),
+		TTL: s.zone.TTL,
+	}}
+	for _, rec := range s.records() {
+		records = append(records, uapiRecord{
+			LineIndex:  rec.line,
+			Type:       typeRecord,
+			RecordType: rec.set.recordType,
+			DNameB64:   encode(rec.set.fqdn + "."),
+			DataB64:    encodeAll(rec.value),
+			TTL:        rec.set.ttl,
+		})
+	}
+	return records, nil
+}
+
+// massEditZone removes, edits and adds the records of the remove, edit and
+// add parameters, in this order, if serial is the one of the zone. Each
+// string of the data of a record is a value of its RRSet.
+func (h *handler) massEditZone(ctx context.Context, w http.ResponseWriter, r *http.Request) (*uapiSerial, error) {
+	s, err := h.loadZone(ctx, r, r.Form.Get("zone"))
+	if err != nil {
+		return nil, err
+	}
+	if r.Form.Get("serial") != s.formatSerial() {
+		return nil, &apiError{message: "The serial does not match the one of the zone, fetch the zone again"}
+	}
+
+	for _, param := range r.Form["remove"] {
+		line, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, &apiError{message: "Invalid line " + param}
+		}
+		if err := s.remove(line); err != nil {
+			return nil, err
+		}
+	}
+	for _, param := range r.Form["edit"] {
+		c, err := decodeChange(param)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.edit(c.LineIndex, c.DName, c.RecordType, c.TTL, c.Data); err != nil {
+			return nil, err
+		}
+	}
+	for _, param := range r.Form["add"] {
+		c, err := decodeChange(param)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.add(c.DName, c.RecordType, c.TTL, c.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := h.apply(ctx, w, r, s); err != nil {
+		return nil, err
+	}
+	return &uapiSerial{NewSerial: s.formatSerial()}, nil
+}
+
+func decodeChange(param string) (*uapiChange, error) {
+	c := &uapiChange{}
+	if err := json.Unmarshal([]byte(param), c); err != nil {
+		return nil, &apiError{message: "Invalid record " + param}
+	}
+	return c, nil
+}
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func encodeAll(values ...string) []string {
+	encoded := make([]string, 0, len(values))
+	for _, value := range values {
+		encoded = append(encoded, encode(value))
+	}
+	return encoded
+}
@@ -0,0 +1,275 @@
+package cpanel
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	// apexName is the name of the RRSets at the apex of a zone.
+	apexName = "@"
+
+	// soaLine is the line of the SOA record, the records follow from
+	// firstRecordLine on.
+	soaLine         = 1
+	firstRecordLine = 2
+	maxRecordLines  = math.MaxInt32 - firstRecordLine
+
+	// minTTL is the lowest TTL the Cloud API accepts.
+	minTTL = 60
+)
+
+// rrSet is an RRSet of a zone with its unquoted values.
+type rrSet struct {
+	name       string
+	fqdn       string
+	recordType string
+	ttl        int
+	values     []string
+	changed    bool
+}
+
+// record is a value of an RRSet, which clients see as a line of the zone.
+type record struct {
+	line  int
+	set   *rrSet
+	value string
+}
+
+// zoneState holds the RRSets of a zone while the changes of a request are
+// made to them.
+type zoneState struct {
+	cfg    *config.Config
+	r      *http.Request
+	zone   *hcloud.Zone
+	rrSets []*rrSet
+}
+
+func newZoneState(cfg *config.Config, r *http.Request, zone *hcloud.Zone, rrSets []*hcloud.ZoneRRSet) *zoneState {
+	s := &zoneState{cfg: cfg, r: r, zone: zone}
+	for _, set := range rrSets {
+		ttl := zone.TTL
+		if set.TTL != nil {
+			ttl = *set.TTL
+		}
+		values := make([]string, 0, len(set.Records))
+		for _, rec := range set.Records {
+			values = append(values, hetzner.UnquoteIfRequired(rec.Value, set.Type))
+		}
+		s.rrSets = append(s.rrSets, &rrSet{
+			name:       set.Name,
+			fqdn:       recordFQDN(set.Name, zone.Name),
+			recordType: string(set.Type),
+			ttl:        ttl,
+			values:     values,
+		})
+	}
+	return s
+}
+
+// lineOf returns the line of the record with value in the RRSet name with
+// recordType. Lines are derived from the record alone, so they do not
+// change when other records of the zone are added or removed.
+func lineOf(name, recordType, value string) int {
+	sum := sha256.Sum256([]byte(name + "/" + recordType + "/" + value))
+	return firstRecordLine + int(binary.BigEndian.Uint32(sum[:4])%maxRecordLines)
+}
+
+// serial returns the serial of the zone, a hash of its RRSets that changes
+// with them.
+func (s *zoneState) serial() uint32 {
+	sets := make([]string, 0, len(s.rrSets))
+	for _, set := range s.rrSets {
+		if len(set.values) == 0 {
+			continue
+		}
+		values := slices.Sorted(slices.Values(set.values))
+		sets = append(sets, fmt.Sprintf("%s/%s/%d/%q", set.name, set.recordType, set.ttl, values))
+	}
+	slices.Sort(sets)
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strings.Join(sets, "\n")))
+	return h.Sum32()
+}
+
+// formatSerial returns the serial of the zone as clients see it.
+func (s *zoneState) formatSerial() string {
+	return strconv.FormatUint(uint64(s.serial()), 10)
+}
+
+// allows reports whether the API token of the request may change the
+// records of fqdn with recordType.
+func (s *zoneState) allows(fqdn, recordType string) bool {
+	t := middleware.APITokenFromContext(s.r.Context())
+	return middleware.APITokenAllows(&s.cfg.Auth, t, fqdn, recordType, s.r.RemoteAddr)
+}
+
+// rulesAllow reports whether auth.rules allow changing the records of fqdn
+// with recordType to value.
+func (s *zoneState) rulesAllow(fqdn, recordType, value string) bool {
+	return middleware.APITokenRulesAllow(s.cfg, s.r, config.EndpointCPanel, fqdn, recordType, value)
+}
+
+func notAllowed(fqdn, recordType string) error {
+	return &apiError{message: fmt.Sprintf("You may not change %s records of %s", recordType, fqdn)}
+}
+
+// records returns the records the API token of the request may change.
+func (s *zoneState) records() []*record {
+	var records []*record
+	for _, set := range s.rrSets {
+		if !s.allows(set.fqdn, set.recordType) {
+			continue
+		}
+		for _, value := range set.values {
+			records = append(records, &record{line: lineOf(set.name, set.recordType, value), set: set, value: value})
+		}
+	}
+	return records
+}
+
+// find returns the record on line.
+func (s *zoneState) find(line int) (*record, error) {
+	var found []*record
+	for _, rec := range s.records() {
+		if rec.line == line {
+			found = append(found, rec)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, &apiError{message: fmt.Sprintf("No record exists on line %d", line)}
+	case 1:
+		return found[0], nil
+	}
+	return nil, &apiError{message: fmt.Sprintf("Line %d is ambiguous", line)}
+}
+
+// rrSetName returns the FQDN and the RRSet name of the record name, which is
+// absolute with a trailing dot and relative to the zone otherwise.
+func (s *zoneState) rrSetName(name string) (fqdn, rrSet string, err error) {
+	zone := s.zone.Name
+	name = strings.ToLower(name)
+	switch {
+	case name == "" || name == apexName:
+		fqdn = zone
+	case strings.HasSuffix(name, "."):
+		fqdn = strings.TrimSuffix(name, ".")
+	default:
+		fqdn = name + "." + zone
+	}
+
+	switch {
+	case fqdn == zone:
+		return fqdn, apexName, nil
+	case strings.HasSuffix(fqdn, "."+zone):
+		return fqdn, strings.TrimSuffix(fqdn, "."+zone), nil
+	}
+	return "", "", &apiError{message: fmt.Sprintf("The name %s is out of the zone %s", name, zone)}
+}
+
+// add adds records with values to the RRSet name with recordType and sets
+// its TTL. Values the RRSet has already are skipped.
+func (s *zoneState) add(name, recordType string, ttl int, values []string) error {
+	fqdn, setName, err := s.rrSetName(name)
+	if err != nil {
+		return err
+	}
+	recordType = strings.ToUpper(recordType)
+	if _, err := hetzner.RRSetTypeFromString(recordType); err != nil {
+		return &apiError{message: err.Error()}
+	}
+	if !s.allows(fqdn, recordType) {
+		logDenied(s.r, fqdn, recordType)
+		return notAllowed(fqdn, recordType)
+	}
+	if len(values) == 0 {
+		return &apiError{message: "You must specify the record data"}
+	}
+	for _, value := range values {
+		if err := middleware.ValidateValue(&s.cfg.AddressPolicy, fqdn, value, recordType); err != nil {
+			return &apiError{message: err.Error()}
+		}
+		if !middleware.ClientIPAllowed(s.cfg, nil, fqdn, recordType, value, s.r.RemoteAddr) ||
+			!s.rulesAllow(fqdn, recordType, value) {
+			return notAllowed(fqdn, recordType)
+		}
+	}
+	if ttl < minTTL {
+		ttl = s.cfg.RecordTTL
+	}
+
+	idx := slices.IndexFunc(s.rrSets, func(set *rrSet) bool {
+		return set.name == setName && set.recordType == recordType
+	})
+	if idx < 0 {
+		s.rrSets = append(s.rrSets, &rrSet{name: setName, fqdn: fqdn, recordType: recordType})
+		idx = len(s.rrSets) - 1
+	}
+	set := s.rrSets[idx]
+	for _, value := range values {
+		if !slices.Contains(set.values, value) {
+			set.values = append(set.values, value)
+		}
+	}
+	set.ttl = ttl
+	set.changed = true
+	return nil
+}
+
+// remove removes the record on line.
+func (s *zoneState) remove(line int) error {
+	rec, err := s.find(line)
+	if err != nil {
+		return err
+	}
+	if !s.rulesAllow(rec.set.fqdn, rec.set.recordType, rec.value) {
+		return notAllowed(rec.set.fqdn, rec.set.recordType)
+	}
+	rec.set.values = slices.DeleteFunc(rec.set.values, func(value string) bool {
+		return value == rec.value
+	})
+	rec.set.changed = true
+	return nil
+}
+
+// edit replaces the record on line by records with values in the RRSet
+// name with recordType.
+func (s *zoneState) edit(line int, name, recordType string, ttl int, values []string) error {
+	if err := s.remove(line); err != nil {
+		return err
+	}
+	return s.add(name, recordType, ttl, values)
+}
+
+// changed returns the RRSets with changes.
+func (s *zoneState) changed() []*rrSet {
+	var changed []*rrSet
+	for _, set := range s.rrSets {
+		if set.changed {
+			changed = append(changed, set)
+		}
+	}
+	return changed
+}
+
+// recordFQDN returns the FQDN of the RRSet name relative to zone.
+func recordFQDN(name, zone string) string {
+	if name == apexName {
+		return zone
+	}
+	return name + "." + zone
+}
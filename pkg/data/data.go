@@ -2,7 +2,10 @@ package data
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
 )
 
 type ReqData struct {
@@ -14,6 +17,14 @@ type ReqData struct {
 	Username  string
 	Password  string
 	BasicAuth bool
+	// AuthUser is the name of the credentials the request was authorized
+	// by, set by the authorizer. It is empty for requests authorized by
+	// their IP only.
+	AuthUser string
+	// ClientCert is the verified TLS client certificate, if any.
+	ClientCert *x509.Certificate
+	// Claims are the claims of the verified bearer token, if any.
+	Claims jwt.Claims
 }
 
 // key is an unexported type for keys defined in this package.
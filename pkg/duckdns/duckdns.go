@@ -0,0 +1,377 @@
+// Package duckdns implements the update endpoint of DuckDNS for the
+// subdomains of a configured domain, so clients with DuckDNS support built
+// in update their records with the updater and cleaner of the HTTP
+// endpoints.
+package duckdns
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// Path is the path of the update endpoint.
+	Path = "/duckdns/update"
+
+	responseOK    = "OK"
+	responseKO    = "KO"
+	stateUpdated  = "UPDATED"
+	stateNoChange = "NOCHANGE"
+	paramTXT      = "txt"
+	paramTrue     = "true"
+	textPlainUTF8 = "text/plain; charset=utf-8"
+
+	recordTypeA    = "A"
+	recordTypeAAAA = "AAAA"
+	recordTypeTXT  = "TXT"
+
+	// maxDomains limits the domains updated by a request.
+	maxDomains = 16
+)
+
+// Updater sets the record of reqData, replacing the records of its RRset.
+type Updater interface {
+	Update(ctx context.Context, reqData *data.ReqData) error
+}
+
+// Cleaner removes the record of reqData from its RRset.
+type Cleaner interface {
+	Clean(ctx context.Context, reqData *data.ReqData) error
+}
+
+// Records looks up the current values of records to skip unchanged ones
+// and to clear RRsets.
+type Records interface {
+	Values(ctx context.Context, zone, name, recordType string) ([]string, error)
+}
+
+type handler struct {
+	cfg     *config.Config
+	limits  *middleware.ScopedRateLimits
+	updater Updater
+	cleaner Cleaner
+	records Records
+}
+
+// target is the value of recordType set on all domains of a request. An
+// empty value clears the RRset.
+type target struct {
+	recordType string
+	value      string
+}
+
+// Token returns the token sent with r.
+func Token(r *http.Request) string {
+	return r.URL.Query().Get("token")
+}
+
+// Lookup returns the lookup of middleware.NewTokenAuth, which returns the
+// API token with token or the grants of the user that token maps to.
+func Lookup(cfg *config.Config) func(token string) *config.APIToken {
+	return func(token string) *config.APIToken {
+		if t := middleware.LookupAPIToken(cfg.Auth.APITokens, token); t != nil {
+			return t
+		}
+		user := tokenUser(cfg, token)
+		if user == nil {
+			return nil
+		}
+		return &config.APIToken{Name: user.Username, Domains: user.Domains, Roles: user.Roles}
+	}
+}
+
+// tokenUser returns the user token maps to, or nil if token is not one of
+// the tokens of duckDNS.
+func tokenUser(cfg *config.Config, token string) *config.User {
+	if token == "" {
+		return nil
+	}
+	// All tokens are compared to not leak which ones exist.
+	var username string
+	for _, t := range cfg.DuckDNS.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			username = t.Username
+		}
+	}
+	if username == "" {
+		return nil
+	}
+	return middleware.LookupUser(cfg, username)
+}
+
+// KO answers failed requests like DuckDNS does.
+func KO(w http.ResponseWriter, _ *http.Request) {
+	write(w, responseKO)
+}
+
+// New returns the handler of the update endpoint, which runs behind
+// middleware.NewTokenAuth with Lookup. Changes are limited by limits per
+// zone and upstream, and per user if the token maps to one.
+func New(
+	cfg *config.Config, limits *middleware.ScopedRateLimits, updater Updater, cleaner Cleaner, records Records,
+) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, limits: limits, updater: updater, cleaner: cleaner, records: records}
+	return func(_ http.Handler) http.Handler {
+		return h
+	}
+}
+
+// ServeHTTP sets the addresses or the TXT record of all domains of r, or
+// clears them with clear=true. Updates are applied one after another, a
+// failing update leaves the previous ones in place.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := middleware.APITokenFromContext(r.Context())
+	if t == nil {
+		KO(w, r)
+		return
+	}
+	query := r.URL.Query()
+	fqdns, err := h.fqdns(query.Get("domains"))
+	if err != nil {
+		refuse(w, r, err)
+		return
+	}
+	targets, err := requestTargets(query, r.RemoteAddr)
+	if err != nil {
+		refuse(w, r, err)
+		return
+	}
+	user := tokenUser(h.cfg, Token(r))
+	changes, err := h.changes(t, user, r.RemoteAddr, fqdns, targets)
+	if err != nil {
+		refuse(w, r, err)
+		return
+	}
+	if !h.allow(w, user, changes) {
+		KO(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+	defer cancel()
+	updated := false
+	for _, reqData := range changes {
+		changed, err := h.apply(ctx, reqData)
+		if err != nil {
+			name := sanitize.LogValue(reqData.FullName)
+			//nolint:gosec // value is sanitized above
+			log.Printf("failed to apply DuckDNS update of '%s': %v", name, err)
+			KO(w, r)
+			return
+		}
+		updated = updated || changed
+	}
+
+	if query.Get("verbose") != paramTrue {
+		write(w, responseOK)
+		return
+	}
+	write(w, verboseResponse(query.Has(paramTXT), targets, updated))
+}
+
+// fqdns returns the names of the comma separated domains, which are
+// subdomains of the configured domain with or without it.
+func (h *handler) fqdns(domains string) ([]string, error) {
+	names := strings.Split(domains, ",")
+	if domains == "" || len(names) > maxDomains {
+		return nil, errors.New("invalid number of domains")
+	}
+	base := h.cfg.DuckDNS.Domain
+	fqdns := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+		name = strings.TrimSuffix(name, "."+base)
+		if !validName(name) {
+			return nil, fmt.Errorf("invalid domain %s", name)
+		}
+		fqdns = append(fqdns, name+"."+base)
+	}
+	return fqdns, nil
+}
+
+// validName reports whether name consists of labels of letters, digits,
+// hyphens and underscores.
+func validName(name string) bool {
+	return !slices.ContainsFunc(strings.Split(name, "."), func(label string) bool {
+		return label == "" || strings.ContainsFunc(label, func(c rune) bool {
+			return (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_'
+		})
+	})
+}
+
+// requestTargets returns the TXT record if txt is set and the addresses
+// otherwise. Without ip the address of the client is used.
+func requestTargets(query url.Values, remoteAddr string) ([]target, error) {
+	clearRecords := query.Get("clear") == paramTrue
+	if query.Has(paramTXT) {
+		if clearRecords {
+			return []target{{recordType: recordTypeTXT}}, nil
+		}
+		if query.Get(paramTXT) == "" {
+			return nil, errors.New("txt is missing")
+		}
+		return []target{{recordType: recordTypeTXT, value: query.Get(paramTXT)}}, nil
+	}
+	if clearRecords {
+		return []target{{recordType: recordTypeA}, {recordType: recordTypeAAAA}}, nil
+	}
+
+	ip, ipv6 := query.Get("ip"), query.Get("ipv6")
+	if ip == "" {
+		ip = remoteAddr
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, errors.New("invalid ip address")
+	}
+	if !addr.Unmap().Is4() {
+		if ipv6 == "" {
+			ipv6 = ip
+		}
+		ip = ""
+	}
+
+	var targets []target
+	if ip != "" {
+		targets = append(targets, target{recordType: recordTypeA, value: ip})
+	}
+	if ipv6 != "" {
+		targets = append(targets, target{recordType: recordTypeAAAA, value: ipv6})
+	}
+	return targets, nil
+}
+
+// changes returns the changes of targets on fqdns, if t is allowed to make
+// all of them. Addresses must match the client IP as required by
+// auth.matchClientIP and the matchClientIP mode of user, which is nil for
+// API tokens.
+func (h *handler) changes(
+	t *config.APIToken, user *config.User, remoteAddr string, fqdns []string, targets []target,
+) ([]*data.ReqData, error) {
+	changes := make([]*data.ReqData, 0, len(fqdns)*len(targets))
+	for _, fqdn := range fqdns {
+		for _, tg := range targets {
+			if !middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, tg.recordType, remoteAddr) {
+				return nil, fmt.Errorf("'%s' is not allowed to update %s data of %s", t.Name, tg.recordType, fqdn)
+			}
+			if tg.value != "" {
+				if err := middleware.ValidateValue(&h.cfg.AddressPolicy, fqdn, tg.value, tg.recordType); err != nil {
+					return nil, err
+				}
+				if !middleware.ClientIPAllowed(h.cfg, user, fqdn, tg.recordType, tg.value, remoteAddr) {
+					return nil, fmt.Errorf("%s data of %s does not match the client IP", tg.recordType, fqdn)
+				}
+			}
+			name, zone, err := middleware.SplitFQDN(fqdn)
+			if err != nil {
+				return nil, err
+			}
+			reqData := &data.ReqData{
+				FullName: fqdn,
+				Name:     name,
+				Zone:     zone,
+				Value:    tg.value,
+				Type:     tg.recordType,
+				Username: t.Name,
+			}
+			if !middleware.CheckRules(h.cfg, reqData, config.EndpointDuckDNS, remoteAddr, t.Name) {
+				return nil, fmt.Errorf("rules deny '%s' to update %s data of %s", t.Name, tg.recordType, fqdn)
+			}
+			changes = append(changes, reqData)
+		}
+	}
+	return changes, nil
+}
+
+// allow reports whether all changes are within the scoped rate limits,
+// before the first one is applied.
+func (h *handler) allow(w http.ResponseWriter, user *config.User, changes []*data.ReqData) bool {
+	username := ""
+	if user != nil {
+		username = user.Username
+	}
+	for _, reqData := range changes {
+		if !h.limits.Allow(w, username, reqData.Zone) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply sets the record of reqData unless it is the only one of its RRset
+// already, or removes all records of its RRset if its value is empty. It
+// reports whether records changed.
+func (h *handler) apply(ctx context.Context, reqData *data.ReqData) (bool, error) {
+	current, err := h.records.Values(ctx, reqData.Zone, reqData.Name, reqData.Type)
+	if err != nil {
+		return false, err
+	}
+	name := sanitize.LogValue(reqData.FullName)
+	if reqData.Value != "" {
+		if slices.Equal(current, []string{reqData.Value}) {
+			return false, nil
+		}
+		value := sanitize.LogValue(reqData.Value)
+		//nolint:gosec // values are sanitized above
+		log.Printf("received DuckDNS request to update '%s' data of '%s' to '%s'", reqData.Type, name, value)
+		return true, h.updater.Update(ctx, reqData)
+	}
+
+	for _, value := range current {
+		//nolint:gosec // value is sanitized above
+		log.Printf("received DuckDNS request to clean '%s' data of '%s'", reqData.Type, name)
+		clean := *reqData
+		clean.Value = value
+		if err := h.cleaner.Clean(ctx, &clean); err != nil {
+			return false, err
+		}
+	}
+	return len(current) > 0, nil
+}
+
+// verboseResponse returns the response to verbose requests, which lists the
+// TXT record or the IPv4 and IPv6 addresses.
+func verboseResponse(txt bool, targets []target, updated bool) string {
+	state := stateNoChange
+	if updated {
+		state = stateUpdated
+	}
+	values := map[string]string{}
+	for _, tg := range targets {
+		values[tg.recordType] = tg.value
+	}
+	if txt {
+		return strings.Join([]string{responseOK, values[recordTypeTXT], state}, "\n")
+	}
+	return strings.Join([]string{responseOK, values[recordTypeA], values[recordTypeAAAA], state}, "\n")
+}
+
+func refuse(w http.ResponseWriter, r *http.Request, err error) {
+	addr := sanitize.LogValue(r.RemoteAddr)
+	reason := sanitize.LogValue(err.Error())
+	//nolint:gosec // values are sanitized above
+	log.Printf("refused DuckDNS request from '%s': %s", addr, reason)
+	KO(w, r)
+}
+
+func write(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", textPlainUTF8)
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprint(w, body); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
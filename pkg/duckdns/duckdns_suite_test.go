@@ -0,0 +1,13 @@
+package duckdns_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDuckDNS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "duckdns test suite")
+}
@@ -0,0 +1,170 @@
+package duckdns_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/duckdns"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+const (
+	userToken  = "user-token"
+	exactToken = "exact-token"
+	teamToken  = "team-token"
+)
+
+type fakeBackend struct {
+	updated []data.ReqData
+	cleaned []data.ReqData
+	values  map[string][]string
+}
+
+func (f *fakeBackend) Update(_ context.Context, reqData *data.ReqData) error {
+	f.updated = append(f.updated, *reqData)
+	return nil
+}
+
+func (f *fakeBackend) Clean(_ context.Context, reqData *data.ReqData) error {
+	f.cleaned = append(f.cleaned, *reqData)
+	return nil
+}
+
+func (f *fakeBackend) Values(_ context.Context, zone, name, recordType string) ([]string, error) {
+	return f.values[name+"."+zone+"/"+recordType], nil
+}
+
+var _ = Describe("DuckDNS", func() {
+	var (
+		backend *fakeBackend
+		limits  *middleware.ScopedRateLimits
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		backend = &fakeBackend{values: map[string][]string{
+			"home.dyn.example.com/A":   {"1.2.3.4"},
+			"home.dyn.example.com/TXT": {"a", "b"},
+		}}
+		expr := `endpoint != "duckdns" || fqdn != "denied.dyn.example.com"`
+		program, err := rules.Compile(expr, config.RuleVariables)
+		Expect(err).ToNot(HaveOccurred())
+		cfg := &config.Config{
+			Timeout: 10,
+			Auth: config.Auth{
+				Rules: []config.Rule{{Name: "denied-domain", Expr: expr, Program: program}},
+				Users: []config.User{
+					{Username: "alice", Password: "secret", Domains: []string{"*.dyn.example.com"}},
+					{
+						Username: "bob", Password: "secret", Domains: []string{"*.dyn.example.com"},
+						MatchClientIP: config.ClientIPMatchExact,
+					},
+				},
+				APITokens: []config.APIToken{
+					{Name: "team", Token: teamToken, Domains: []string{"office.dyn.example.com"}},
+				},
+			},
+			DuckDNS: &config.DuckDNS{
+				Domain: "dyn.example.com",
+				Tokens: []config.DuckDNSToken{
+					{Token: userToken, Username: "alice"},
+					{Token: exactToken, Username: "bob"},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewTokenAuth(lockout, duckdns.Lookup(cfg), duckdns.Token, duckdns.KO)(
+			duckdns.New(cfg, limits, backend, backend, backend)(nil),
+		)
+	})
+
+	do := func(query string) string {
+		req := httptest.NewRequest(http.MethodGet, duckdns.Path+"?"+query, http.NoBody)
+		req.RemoteAddr = "1.2.3.100"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		return rec.Body.String()
+	}
+
+	It("should update the address of the client", func() {
+		Expect(do("domains=new&token=" + userToken)).To(Equal("OK"))
+		Expect(backend.updated).To(ConsistOf(data.ReqData{
+			FullName: "new.dyn.example.com", Name: "new.dyn", Zone: "example.com", Value: "1.2.3.100", Type: "A", Username: "alice",
+		}))
+	})
+
+	It("should update several domains with IPv4 and IPv6 addresses", func() {
+		Expect(do("domains=a,b.dyn.example.com&ip=1.2.3.5&ipv6=2a01:4f8::1&token=" + userToken)).To(Equal("OK"))
+		Expect(backend.updated).To(HaveLen(4))
+		Expect(backend.updated[1]).To(HaveField("FullName", "a.dyn.example.com"))
+		Expect(backend.updated[1]).To(HaveField("Value", "2a01:4f8::1"))
+		Expect(backend.updated[2]).To(HaveField("FullName", "b.dyn.example.com"))
+	})
+
+	It("should skip unchanged addresses", func() {
+		Expect(do("domains=home&ip=1.2.3.4&verbose=true&token=" + userToken)).To(Equal("OK\n1.2.3.4\n\nNOCHANGE"))
+		Expect(backend.updated).To(BeEmpty())
+	})
+
+	It("should answer verbose requests", func() {
+		Expect(do("domains=home&ip=1.2.3.5&ipv6=2a01:4f8::1&verbose=true&token=" + userToken)).
+			To(Equal("OK\n1.2.3.5\n2a01:4f8::1\nUPDATED"))
+	})
+
+	It("should set and clear TXT records", func() {
+		Expect(do("domains=home&txt=token&verbose=true&token=" + userToken)).To(Equal("OK\ntoken\nUPDATED"))
+		Expect(backend.updated).To(ConsistOf(HaveField("Type", "TXT")))
+		Expect(do("domains=home&txt=&clear=true&token=" + userToken)).To(Equal("OK"))
+		Expect(backend.cleaned).To(HaveLen(2))
+		Expect(backend.cleaned[0]).To(HaveField("Value", "a"))
+		Expect(backend.cleaned[1]).To(HaveField("Value", "b"))
+	})
+
+	It("should authenticate API tokens", func() {
+		Expect(do("domains=office&ip=1.2.3.5&token=" + teamToken)).To(Equal("OK"))
+		Expect(backend.updated).To(ConsistOf(HaveField("Username", "team")))
+	})
+
+	It("should require the client IP with matchClientIP of the user", func() {
+		Expect(do("domains=home&ip=1.2.3.5&token=" + exactToken)).To(Equal("KO"))
+		Expect(do("domains=home&token=" + exactToken)).To(Equal("OK"))
+		Expect(backend.updated).To(ConsistOf(HaveField("Value", "1.2.3.100")))
+	})
+
+	It("should limit changes per user and zone", func() {
+		limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(2))
+		Expect(do("domains=a,b&ip=1.2.3.5&token=" + userToken)).To(Equal("OK"))
+		Expect(do("domains=c&ip=1.2.3.5&token=" + userToken)).To(Equal("KO"))
+		Expect(do("domains=office&ip=1.2.3.5&token=" + teamToken)).To(Equal("OK"))
+
+		limits.Zone = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		Expect(do("domains=office&ip=1.2.3.6&token=" + teamToken)).To(Equal("OK"))
+		Expect(do("domains=office&ip=1.2.3.7&token=" + teamToken)).To(Equal("KO"))
+		Expect(backend.updated).To(HaveLen(4))
+	})
+
+	DescribeTable("should fail", func(query string) {
+		Expect(do(query)).To(Equal("KO"))
+		Expect(backend.updated).To(BeEmpty())
+	},
+		Entry("with an invalid token", "domains=home&token=invalid"),
+		Entry("without domains", "token="+userToken),
+		Entry("with an invalid domain", "domains=a/b&token="+userToken),
+		Entry("with an invalid ip", "domains=home&ip=invalid&token="+userToken),
+		Entry("with a private address", "domains=home&ip=10.0.0.1&token="+userToken),
+		Entry("outside of the grants of the token", "domains=home&ip=1.2.3.5&token="+teamToken),
+		Entry("with an empty txt", "domains=home&txt=&token="+userToken),
+		Entry("denied by rules", "domains=home,denied&ip=1.2.3.5&token="+userToken),
+	)
+})
@@ -0,0 +1,72 @@
+package filewatch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is the minimum time between two checks for changes.
+const DefaultInterval = 5 * time.Second
+
+type stamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Watcher detects modifications of a set of files by polling their
+// modification time and size. Polling happens lazily on calls to Changed,
+// at most once per interval, so no background goroutine is required.
+type Watcher struct {
+	mu        sync.Mutex
+	paths     []string
+	stamps    []stamp
+	interval  time.Duration
+	now       func() time.Time
+	lastCheck time.Time
+}
+
+func New(interval time.Duration, paths ...string) *Watcher {
+	w := &Watcher{
+		paths:    paths,
+		stamps:   make([]stamp, len(paths)),
+		interval: interval,
+		now:      time.Now,
+	}
+	for i, path := range paths {
+		w.stamps[i] = stat(path)
+	}
+	w.lastCheck = w.now()
+	return w
+}
+
+// Changed reports whether any of the files was modified, created or removed
+// since the watcher was created or Changed last returned true.
+func (w *Watcher) Changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if now.Sub(w.lastCheck) < w.interval {
+		return false
+	}
+	w.lastCheck = now
+
+	changed := false
+	for i, path := range w.paths {
+		if s := stat(path); s != w.stamps[i] {
+			w.stamps[i] = s
+			changed = true
+		}
+	}
+	return changed
+}
+
+func stat(path string) stamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
@@ -0,0 +1,175 @@
+package forwardauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Headers sent to the authorization service in addition to Authorization.
+const (
+	HeaderForwardedFor    = "X-Forwarded-For"
+	HeaderForwardedMethod = "X-Forwarded-Method"
+	HeaderForwardedURI    = "X-Forwarded-Uri"
+	HeaderName            = "X-DNS-Name"
+	HeaderType            = "X-DNS-Type"
+	HeaderValue           = "X-DNS-Value"
+)
+
+const (
+	// cacheMaxEntries caps memory use of the decision cache. When full,
+	// expired entries are removed and, if none expired, the cache is
+	// cleared.
+	cacheMaxEntries = 1 << 14
+	maxDrainSize    = 4 << 10
+)
+
+// Request describes the update a client wants to authorize.
+type Request struct {
+	ClientIP string
+	Method   string
+	URI      string
+	// Username and Password are sent as basic auth credentials. If they are
+	// empty, Authorization is forwarded as is.
+	Username      string
+	Password      string
+	Authorization string
+	FullName      string
+	Type          string
+	Value         string
+}
+
+type entry struct {
+	allowed bool
+	expires time.Time
+}
+
+// Client asks an external authorization service whether a request is
+// allowed. A 2xx response allows the request, any other response denies
+// it, except for 5xx responses which are errors like failed requests.
+// Decisions are cached for the TTL, errors are not cached.
+type Client struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]entry
+}
+
+func New(url string, timeout, ttl time.Duration) *Client {
+	return &Client{
+		url: url,
+		ttl: ttl,
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		now:   time.Now,
+		cache: make(map[[sha256.Size]byte]entry),
+	}
+}
+
+// Check reports whether the authorization service allows req. An error is
+// returned if no decision could be obtained.
+func (c *Client) Check(ctx context.Context, req *Request) (bool, error) {
+	key := req.cacheKey()
+	if allowed, ok := c.cached(key); ok {
+		return allowed, nil
+	}
+
+	allowed, err := c.ask(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	c.store(key, allowed)
+	return allowed, nil
+}
+
+func (c *Client) ask(ctx context.Context, req *Request) (bool, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, http.NoBody)
+	if err != nil {
+		return false, err
+	}
+	r.Header.Set(HeaderForwardedFor, req.ClientIP)
+	r.Header.Set(HeaderForwardedMethod, req.Method)
+	r.Header.Set(HeaderForwardedURI, req.URI)
+	r.Header.Set(HeaderName, req.FullName)
+	r.Header.Set(HeaderType, req.Type)
+	r.Header.Set(HeaderValue, req.Value)
+	if req.Username != "" || req.Password != "" {
+		r.SetBasicAuth(req.Username, req.Password)
+	} else if req.Authorization != "" {
+		r.Header.Set("Authorization", req.Authorization)
+	}
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainSize))
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return false, fmt.Errorf("authorization service returned status %d", resp.StatusCode)
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c *Client) cached(key [sha256.Size]byte) (allowed, ok bool) {
+	if c.ttl <= 0 {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.cache[key]
+	if !ok || !c.now().Before(e.expires) {
+		return false, false
+	}
+	return e.allowed, true
+}
+
+func (c *Client) store(key [sha256.Size]byte, allowed bool) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if len(c.cache) >= cacheMaxEntries {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= cacheMaxEntries {
+			clear(c.cache)
+		}
+	}
+	c.cache[key] = entry{allowed: allowed, expires: now.Add(c.ttl)}
+}
+
+// cacheKey hashes the request, so that no credentials are kept in memory.
+func (req *Request) cacheKey() [sha256.Size]byte {
+	h := sha256.New()
+	for _, field := range []string{
+		req.ClientIP, req.Method, req.URI, req.Username, req.Password,
+		req.Authorization, req.FullName, req.Type, req.Value,
+	} {
+		// Length-prefix the fields, so that no two requests share a key.
+		_, _ = fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
@@ -0,0 +1,13 @@
+package forwardauth_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestForwardauth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "forwardauth test suite")
+}
@@ -0,0 +1,134 @@
+package forwardauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server   *httptest.Server
+		status   atomic.Int32
+		requests atomic.Int32
+		received *http.Request
+		client   *Client
+		now      time.Time
+	)
+
+	BeforeEach(func() {
+		status.Store(http.StatusOK)
+		requests.Store(0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			received = r
+			if status.Load() == http.StatusFound {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+			w.WriteHeader(int(status.Load()))
+		}))
+		DeferCleanup(server.Close)
+
+		now = time.Unix(1_700_000_000, 0)
+		client = New(server.URL+"/auth", time.Second, time.Minute)
+		client.now = func() time.Time { return now }
+	})
+
+	newRequest := func() *Request {
+		return &Request{
+			ClientIP: "192.0.2.7",
+			Method:   http.MethodGet,
+			URI:      "/plain/update?hostname=a.example.com&ip=192.0.2.7",
+			Username: "user",
+			Password: "pass",
+			FullName: "a.example.com",
+			Type:     "A",
+			Value:    "192.0.2.7",
+		}
+	}
+
+	It("should send the request details", func() {
+		allowed, err := client.Check(context.Background(), newRequest())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(allowed).To(BeTrue())
+
+		Expect(received.URL.Path).To(Equal("/auth"))
+		Expect(received.Header.Get(HeaderForwardedFor)).To(Equal("192.0.2.7"))
+		Expect(received.Header.Get(HeaderForwardedMethod)).To(Equal(http.MethodGet))
+		Expect(received.Header.Get(HeaderForwardedURI)).To(Equal("/plain/update?hostname=a.example.com&ip=192.0.2.7"))
+		Expect(received.Header.Get(HeaderName)).To(Equal("a.example.com"))
+		Expect(received.Header.Get(HeaderType)).To(Equal("A"))
+		Expect(received.Header.Get(HeaderValue)).To(Equal("192.0.2.7"))
+		username, password, ok := received.BasicAuth()
+		Expect(ok).To(BeTrue())
+		Expect(username).To(Equal("user"))
+		Expect(password).To(Equal("pass"))
+	})
+
+	It("should forward the Authorization header without credentials", func() {
+		req := newRequest()
+		req.Username, req.Password = "", ""
+		req.Authorization = "Bearer token"
+		_, err := client.Check(context.Background(), req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received.Header.Get("Authorization")).To(Equal("Bearer token"))
+	})
+
+	DescribeTable("should interpret the response status", func(code int, expected bool) {
+		status.Store(int32(code))
+		allowed, err := client.Check(context.Background(), newRequest())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(allowed).To(Equal(expected))
+	},
+		Entry("200 allows", http.StatusOK, true),
+		Entry("204 allows", http.StatusNoContent, true),
+		Entry("302 denies", http.StatusFound, false),
+		Entry("401 denies", http.StatusUnauthorized, false),
+		Entry("403 denies", http.StatusForbidden, false),
+	)
+
+	It("should fail on server errors", func() {
+		status.Store(http.StatusBadGateway)
+		_, err := client.Check(context.Background(), newRequest())
+		Expect(err).To(MatchError("authorization service returned status 502"))
+	})
+
+	It("should fail when the service is unreachable", func() {
+		server.Close()
+		_, err := client.Check(context.Background(), newRequest())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should cache decisions for the TTL", func() {
+		status.Store(http.StatusForbidden)
+		Expect(client.Check(context.Background(), newRequest())).To(BeFalse())
+		status.Store(http.StatusOK)
+		Expect(client.Check(context.Background(), newRequest())).To(BeFalse())
+		Expect(requests.Load()).To(BeEquivalentTo(1))
+
+		other := newRequest()
+		other.Value = "192.0.2.8"
+		Expect(client.Check(context.Background(), other)).To(BeTrue())
+		Expect(requests.Load()).To(BeEquivalentTo(2))
+
+		now = now.Add(time.Minute)
+		Expect(client.Check(context.Background(), newRequest())).To(BeTrue())
+		Expect(requests.Load()).To(BeEquivalentTo(3))
+	})
+
+	It("should not cache errors", func() {
+		status.Store(http.StatusServiceUnavailable)
+		_, err := client.Check(context.Background(), newRequest())
+		Expect(err).To(HaveOccurred())
+
+		status.Store(http.StatusOK)
+		Expect(client.Check(context.Background(), newRequest())).To(BeTrue())
+		Expect(requests.Load()).To(BeEquivalentTo(2))
+	})
+})
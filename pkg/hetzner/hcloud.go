@@ -61,3 +61,15 @@ func QuoteIfRequired(val string, rrSetType hcloud.ZoneRRSetType) string {
 	}
 	return val
 }
+
+// UnquoteIfRequired returns the value of a record as set with
+// QuoteIfRequired.
+func UnquoteIfRequired(val string, rrSetType hcloud.ZoneRRSetType) string {
+	if rrSetType != hcloud.ZoneRRSetTypeTXT {
+		return val
+	}
+	if unquoted, err := strconv.Unquote(val); err == nil {
+		return unquoted
+	}
+	return val
+}
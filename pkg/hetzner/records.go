@@ -0,0 +1,247 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+// apexName is the name of the records at the apex of a zone.
+const apexName = "@"
+
+// Records reads and changes the records of zones.
+type Records struct {
+	client *hcloud.Client
+	// cname allows CNAME RRSets next to the types of RRSetTypeFromString.
+	cname bool
+}
+
+func NewRecords(cfg *config.Config) *Records {
+	return &Records{client: NewHCloudClient(cfg)}
+}
+
+// WithCNAME returns a copy of r that also reads and changes CNAME RRSets.
+func (r *Records) WithCNAME() *Records {
+	c := *r
+	c.cname = true
+	return &c
+}
+
+// Values returns the values of the records of type recordType named name in
+// zone, or nil if there are none.
+func (r *Records) Values(ctx context.Context, zone, name, recordType string) ([]string, error) {
+	rrSetType, err := r.rrSetType(recordType)
+	if err != nil {
+		return nil, err
+	}
+	z, err := r.zone(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	rrSet, _, err := r.client.Zone.GetRRSetByNameAndType(ctx, z, apexIfEmpty(name), rrSetType)
+	if err != nil || rrSet == nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(rrSet.Records))
+	for _, record := range rrSet.Records {
+		values = append(values, UnquoteIfRequired(record.Value, rrSetType))
+	}
+	return values, nil
+}
+
+// NameInUse reports whether zone has records of any type named name.
+func (r *Records) NameInUse(ctx context.Context, zone, name string) (bool, error) {
+	z, err := r.zone(ctx, zone)
+	if err != nil {
+		return false, err
+	}
+	rrSets, _, err := r.client.Zone.ListRRSets(ctx, z, hcloud.ZoneRRSetListOpts{Name: apexIfEmpty(name)})
+	if err != nil {
+		return false, err
+	}
+	return len(rrSets) > 0, nil
+}
+
+// Zones returns all zones.
+func (r *Records) Zones(ctx context.Context) ([]*hcloud.Zone, error) {
+	return r.client.Zone.All(ctx)
+}
+
+// Zone returns the zone with idOrName, or nil if there is none.
+func (r *Records) Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error) {
+	zone, _, err := r.client.Zone.Get(ctx, idOrName)
+	return zone, err
+}
+
+// RRSets returns the RRSets of zone with a supported type.
+func (r *Records) RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	rrSets, err := r.client.Zone.AllRRSets(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	supported := make([]*hcloud.ZoneRRSet, 0, len(rrSets))
+	for _, rrSet := range rrSets {
+		if _, err := r.rrSetType(string(rrSet.Type)); err == nil {
+			supported = append(supported, rrSet)
+		}
+	}
+	return supported, nil
+}
+
+// AddRecord adds a record with value to the RRSet of zone named name with
+// recordType. The RRSet is created with ttl if it does not exist.
+func (r *Records) AddRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string, ttl int) error {
+	rrSet, err := r.newRRSet(zone, name, recordType)
+	if err != nil {
+		return err
+	}
+	action, _, err := r.client.Zone.AddRRSetRecords(ctx, rrSet, hcloud.ZoneRRSetAddRecordsOpts{
+		Records: []hcloud.ZoneRRSetRecord{{Value: QuoteIfRequired(value, rrSet.Type)}},
+		TTL:     &ttl,
+	})
+	return r.waitFor(ctx, action, err)
+}
+
+// RemoveRecord removes the record with value from the RRSet of zone named
+// name with recordType. RRSets without records are deleted.
+func (r *Records) RemoveRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string) error {
+	rrSet, err := r.newRRSet(zone, name, recordType)
+	if err != nil {
+		return err
+	}
+	action, _, err := r.client.Zone.RemoveRRSetRecords(ctx, rrSet, hcloud.ZoneRRSetRemoveRecordsOpts{
+		Records: []hcloud.ZoneRRSetRecord{{Value: QuoteIfRequired(value, rrSet.Type)}},
+	})
+	return r.waitFor(ctx, action, err)
+}
+
+// ChangeTTL changes the TTL of the RRSet of zone named name with recordType.
+func (r *Records) ChangeTTL(ctx context.Context, zone *hcloud.Zone, name, recordType string, ttl int) error {
+	rrSet, err := r.newRRSet(zone, name, recordType)
+	if err != nil {
+		return err
+	}
+	action, _, err := r.client.Zone.ChangeRRSetTTL(ctx, rrSet, hcloud.ZoneRRSetChangeTTLOpts{TTL: &ttl})
+	return r.waitFor(ctx, action, err)
+}
+
+// SetRecords replaces the records of the RRSet of zone named name with
+// recordType by records with values and sets its TTL. The RRSet is created
+// if it does not exist.
+func (r *Records) SetRecords(ctx context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int) error {
+	action, err := r.StartSetRecords(ctx, zone, name, recordType, values, ttl)
+	return r.waitFor(ctx, action, err)
+}
+
+// StartSetRecords is SetRecords returning the action of the last step
+// without waiting for it.
+func (r *Records) StartSetRecords(
+	ctx context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int,
+) (*hcloud.Action, error) {
+	rrSet, err := r.newRRSet(zone, name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]hcloud.ZoneRRSetRecord, 0, len(values))
+	for _, value := range values {
+		records = append(records, hcloud.ZoneRRSetRecord{Value: QuoteIfRequired(value, rrSet.Type)})
+	}
+
+	existing, _, err := r.client.Zone.GetRRSetByNameAndType(ctx, zone, rrSet.Name, rrSet.Type)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		result, _, err := r.client.Zone.CreateRRSet(ctx, zone, hcloud.ZoneRRSetCreateOpts{
+			Name: rrSet.Name, Type: rrSet.Type, TTL: &ttl, Records: records,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result.Action, nil
+	}
+
+	action, _, err := r.client.Zone.SetRRSetRecords(ctx, existing, hcloud.ZoneRRSetSetRecordsOpts{Records: records})
+	if existing.TTL != nil && *existing.TTL == ttl {
+		return action, err
+	}
+	if err := r.waitFor(ctx, action, err); err != nil {
+		return nil, err
+	}
+	action, _, err = r.client.Zone.ChangeRRSetTTL(ctx, existing, hcloud.ZoneRRSetChangeTTLOpts{TTL: &ttl})
+	return action, err
+}
+
+// DeleteRRSet deletes the RRSet of zone named name with recordType if it
+// exists.
+func (r *Records) DeleteRRSet(ctx context.Context, zone *hcloud.Zone, name, recordType string) error {
+	action, err := r.StartDeleteRRSet(ctx, zone, name, recordType)
+	return r.waitFor(ctx, action, err)
+}
+
+// StartDeleteRRSet is DeleteRRSet returning the action without waiting for
+// it. The action is nil if the RRSet does not exist.
+func (r *Records) StartDeleteRRSet(ctx context.Context, zone *hcloud.Zone, name, recordType string) (*hcloud.Action, error) {
+	rrSet, err := r.newRRSet(zone, name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	result, _, err := r.client.Zone.DeleteRRSet(ctx, rrSet)
+	if hcloud.IsError(err, hcloud.ErrorCodeNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result.Action, nil
+}
+
+// Action returns the action with id, or nil if there is none.
+func (r *Records) Action(ctx context.Context, id int64) (*hcloud.Action, error) {
+	action, _, err := r.client.Action.GetByID(ctx, id)
+	return action, err
+}
+
+func (r *Records) waitFor(ctx context.Context, action *hcloud.Action, err error) error {
+	if err != nil || action == nil {
+		return err
+	}
+	return r.client.Action.WaitFor(ctx, action)
+}
+
+func (r *Records) zone(ctx context.Context, name string) (*hcloud.Zone, error) {
+	zone, err := r.Zone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, fmt.Errorf("zone %s not found", name)
+	}
+	return zone, nil
+}
+
+func (r *Records) newRRSet(zone *hcloud.Zone, name, recordType string) (*hcloud.ZoneRRSet, error) {
+	rrSetType, err := r.rrSetType(recordType)
+	if err != nil {
+		return nil, err
+	}
+	return &hcloud.ZoneRRSet{Zone: zone, Name: apexIfEmpty(name), Type: rrSetType}, nil
+}
+
+func (r *Records) rrSetType(recordType string) (hcloud.ZoneRRSetType, error) {
+	if r.cname && recordType == string(hcloud.ZoneRRSetTypeCNAME) {
+		return hcloud.ZoneRRSetTypeCNAME, nil
+	}
+	return RRSetTypeFromString(recordType)
+}
+
+func apexIfEmpty(name string) string {
+	if name == "" {
+		return apexName
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+// Package hetznerdns emulates the legacy Hetzner DNS API
+// (dns.hetzner.com/api/v1) on top of the zones and RRSets of the Cloud API.
+// Clients authenticate with API tokens issued by the proxy and only see the
+// zones and records their grants cover.
+package hetznerdns
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/zoneapi"
+)
+
+const (
+	// HeaderAuthAPIToken is the header carrying the API token.
+	HeaderAuthAPIToken = "Auth-API-Token"
+
+	maxRequestBodySize = 64 << 10 // 64 KB
+	defaultPerPage     = 100
+	maxPerPage         = 100
+)
+
+// Records reads and changes the zones and records of the Cloud API.
+type Records interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+	RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error)
+	AddRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string, ttl int) error
+	RemoveRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string) error
+	ChangeTTL(ctx context.Context, zone *hcloud.Zone, name, recordType string, ttl int) error
+}
+
+type handler struct {
+	cfg     *config.Config
+	limits  *middleware.ScopedRateLimits
+	records Records
+}
+
+// New returns the handler of the /api/v1/zones and /api/v1/records routes.
+// It must run after middleware.NewAPITokenAuth. Changes are limited by the
+// zone and upstream limits of limits.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, records Records) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, limits: limits, records: records}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/zones", h.listZones)
+	mux.HandleFunc("GET /api/v1/zones/{id}", h.getZone)
+	mux.HandleFunc("GET /api/v1/records", h.listRecords)
+	mux.HandleFunc("POST /api/v1/records", h.createRecord)
+	mux.HandleFunc("GET /api/v1/records/{id}", h.getRecord)
+	mux.HandleFunc("PUT /api/v1/records/{id}", h.updateRecord)
+	mux.HandleFunc("DELETE /api/v1/records/{id}", h.deleteRecord)
+
+	return func(_ http.Handler) http.Handler {
+		return mux
+	}
+}
+
+// APIToken returns the API token of r.
+func APIToken(r *http.Request) string {
+	return r.Header.Get(HeaderAuthAPIToken)
+}
+
+// Unauthorized answers requests without a valid API token like the legacy
+// API.
+func Unauthorized(w http.ResponseWriter, _ *http.Request) {
+	zoneapi.WriteJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid authentication credentials"})
+}
+
+func (h *handler) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+type pagination struct {
+	Page         int `json:"page"`
+	PerPage      int `json:"per_page"`
+	PreviousPage int `json:"previous_page"`
+	NextPage     int `json:"next_page"`
+	LastPage     int `json:"last_page"`
+	TotalEntries int `json:"total_entries"`
+}
+
+type meta struct {
+	Pagination pagination `json:"pagination"`
+}
+
+// paginate returns the page of items requested by the page and per_page
+// query parameters of r.
+func paginate[T any](r *http.Request, items []T) ([]T, meta) {
+	items, page := zoneapi.Paginate(r, items, defaultPerPage, maxPerPage)
+	p := pagination{Page: page.Number, PerPage: page.PerPage, LastPage: max(1, page.Pages), TotalEntries: page.Total}
+	if page.Number > 1 {
+		p.PreviousPage = page.Number - 1
+	}
+	if page.Number < p.LastPage {
+		p.NextPage = page.Number + 1
+	}
+	return items, meta{Pagination: p}
+}
+
+// allow reports whether changing records of zones with the API token of r is
+// within the scoped rate limits, and answers 429 Too Many Requests otherwise.
+func (h *handler) allow(w http.ResponseWriter, r *http.Request, zones ...*hcloud.Zone) bool {
+	for _, zone := range zones {
+		if !h.limits.Allow(w, middleware.APITokenLimitUser(r), zone.Name) {
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return false
+		}
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	zoneapi.WriteJSON(w, code, map[string]any{
+		"error": map[string]any{"message": message, "code": code},
+	})
+}
+
+func failed(w http.ResponseWriter, err error) {
+	log.Printf("failed to call the Cloud API: %v", err)
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
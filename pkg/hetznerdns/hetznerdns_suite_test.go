@@ -0,0 +1,13 @@
+package hetznerdns_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHetznerDNS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "hetznerdns test suite")
+}
@@ -0,0 +1,347 @@
+package hetznerdns_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetznerdns"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+const (
+	token      = "team-token"
+	zoneID     = "1"
+	otherZone  = "2"
+	recordsURL = "/api/v1/records"
+)
+
+// fakeRecords keeps the zones and RRSets of the Cloud API in memory.
+type fakeRecords struct {
+	zones  []*hcloud.Zone
+	rrSets map[int64][]*hcloud.ZoneRRSet
+	calls  []string
+}
+
+func (f *fakeRecords) Zones(_ context.Context) ([]*hcloud.Zone, error) {
+	return f.zones, nil
+}
+
+func (f *fakeRecords) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	for _, zone := range f.zones {
+		if strconv.FormatInt(zone.ID, 10) == idOrName || zone.Name == idOrName {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRecords) RRSets(_ context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	return f.rrSets[zone.ID], nil
+}
+
+func (f *fakeRecords) rrSet(zone *hcloud.Zone, name, recordType string) *hcloud.ZoneRRSet {
+	for _, rrSet := range f.rrSets[zone.ID] {
+		if rrSet.Name == name && string(rrSet.Type) == recordType {
+			return rrSet
+		}
+	}
+	return nil
+}
+
+func (f *fakeRecords) AddRecord(_ context.Context, zone *hcloud.Zone, name, recordType, value string, ttl int) error {
+	f.calls = append(f.calls, "add "+name+" "+recordType+" "+value)
+	record := hcloud.ZoneRRSetRecord{Value: hetzner.QuoteIfRequired(value, hcloud.ZoneRRSetType(recordType))}
+	if rrSet := f.rrSet(zone, name, recordType); rrSet != nil {
+		rrSet.Records = append(rrSet.Records, record)
+		return nil
+	}
+	f.rrSets[zone.ID] = append(f.rrSets[zone.ID], &hcloud.ZoneRRSet{
+		Name: name, Type: hcloud.ZoneRRSetType(recordType), TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{record},
+	})
+	return nil
+}
+
+func (f *fakeRecords) RemoveRecord(_ context.Context, zone *hcloud.Zone, name, recordType, value string) error {
+	f.calls = append(f.calls, "remove "+name+" "+recordType+" "+value)
+	if rrSet := f.rrSet(zone, name, recordType); rrSet != nil {
+		quoted := hetzner.QuoteIfRequired(value, rrSet.Type)
+		rrSet.Records = slices.DeleteFunc(rrSet.Records, func(r hcloud.ZoneRRSetRecord) bool {
+			return r.Value == quoted
+		})
+	}
+	return nil
+}
+
+func (f *fakeRecords) ChangeTTL(_ context.Context, zone *hcloud.Zone, name, recordType string, ttl int) error {
+	f.calls = append(f.calls, "ttl "+name+" "+recordType+" "+strconv.Itoa(ttl))
+	f.rrSet(zone, name, recordType).TTL = &ttl
+	return nil
+}
+
+type recordJSON struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	ZoneID string `json:"zone_id"`
+	TTL    *int   `json:"ttl"`
+}
+
+var _ = Describe("Legacy Hetzner DNS API", func() {
+	var (
+		records *fakeRecords
+		limits  *middleware.ScopedRateLimits
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		ttl := 300
+		records = &fakeRecords{
+			zones: []*hcloud.Zone{
+				{ID: 1, Name: "example.com", TTL: 3600, Status: hcloud.ZoneStatusOk, Created: time.Unix(0, 0)},
+				{ID: 2, Name: "example.org", TTL: 3600, Status: hcloud.ZoneStatusOk},
+			},
+			rrSets: map[int64][]*hcloud.ZoneRRSet{
+				1: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.4"}}},
+					{Name: "_acme-challenge.www", Type: hcloud.ZoneRRSetTypeTXT, Records: []hcloud.ZoneRRSetRecord{{Value: `"token"`}}},
+					{Name: "@", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.5"}}},
+				},
+				2: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.6"}}},
+				},
+			},
+		}
+
+		expr := `endpoint != "hetznerdns" || value != "1.2.3.99"`
+		program, err := rules.Compile(expr, config.RuleVariables)
+		Expect(err).ToNot(HaveOccurred())
+		cfg := &config.Config{
+			Timeout:   10,
+			RecordTTL: 60,
+			Auth: config.Auth{
+				APITokens: []config.APIToken{{Name: "team", Token: token, Domains: []string{"*.example.com"}}},
+				Rules:     []config.Rule{{Name: "denied-address", Expr: expr, Program: program}},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewAPITokenAuth(cfg, lockout, hetznerdns.APIToken, hetznerdns.Unauthorized)(
+			hetznerdns.New(cfg, limits, records)(nil),
+		)
+	})
+
+	do := func(method, target string, body any) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+		var b strings.Builder
+		if body != nil {
+			Expect(json.NewEncoder(&b).Encode(body)).To(Succeed())
+		}
+		req := httptest.NewRequest(method, target, strings.NewReader(b.String()))
+		req.Header.Set(hetznerdns.HeaderAuthAPIToken, token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := map[string]json.RawMessage{}
+		if rec.Body.Len() > 0 {
+			Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+		}
+		return rec, res
+	}
+
+	listRecords := func(query string) []recordJSON {
+		rec, res := do(http.MethodGet, recordsURL+query, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var list []recordJSON
+		Expect(json.Unmarshal(res["records"], &list)).To(Succeed())
+		return list
+	}
+
+	findRecord := func(name, recordType string) recordJSON {
+		for _, r := range listRecords("?zone_id=" + zoneID) {
+			if r.Name == name && r.Type == recordType {
+				return r
+			}
+		}
+		Fail("record not found")
+		return recordJSON{}
+	}
+
+	It("should reject invalid API tokens", func() {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/zones", http.NoBody)
+		req.Header.Set(hetznerdns.HeaderAuthAPIToken, "invalid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	Context("zones", func() {
+		It("should only list zones covered by the token", func() {
+			rec, res := do(http.MethodGet, "/api/v1/zones", nil)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var zones []map[string]any
+			Expect(json.Unmarshal(res["zones"], &zones)).To(Succeed())
+			Expect(zones).To(HaveLen(1))
+			Expect(zones[0]).To(HaveKeyWithValue("id", zoneID))
+			Expect(zones[0]).To(HaveKeyWithValue("name", "example.com"))
+			Expect(zones[0]).To(HaveKeyWithValue("status", "verified"))
+			Expect(string(res["meta"])).To(ContainSubstring(`"total_entries":1`))
+		})
+
+		It("should answer pages past the end with no zones", func() {
+			rec, res := do(http.MethodGet, "/api/v1/zones?page=100000000000000000", nil)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(string(res["zones"])).To(Equal("[]"))
+			Expect(string(res["meta"])).To(ContainSubstring(`"total_entries":1`))
+		})
+
+		It("should filter zones by name", func() {
+			rec, _ := do(http.MethodGet, "/api/v1/zones?name=example.org", nil)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should get a zone", func() {
+			rec, res := do(http.MethodGet, "/api/v1/zones/"+zoneID, nil)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(string(res["zone"])).To(ContainSubstring(`"name":"example.com"`))
+		})
+
+		It("should hide zones not covered by the token", func() {
+			rec, _ := do(http.MethodGet, "/api/v1/zones/"+otherZone, nil)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("records", func() {
+		It("should only list records the token may change", func() {
+			list := listRecords("")
+			Expect(list).To(HaveLen(2))
+			Expect(list).To(ContainElement(HaveField("Value", "1.2.3.4")))
+			Expect(list).To(ContainElement(HaveField("Value", "token")))
+		})
+
+		It("should hide records of zones not covered by the token", func() {
+			rec, _ := do(http.MethodGet, recordsURL+"?zone_id="+otherZone, nil)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should get a record by its ID", func() {
+			r := findRecord("www", "A")
+			rec, res := do(http.MethodGet, recordsURL+"/"+r.ID, nil)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(string(res["record"])).To(ContainSubstring(`"value":"1.2.3.4"`))
+			Expect(*r.TTL).To(Equal(300))
+		})
+
+		It("should not find records of other zones", func() {
+			forged := base64.RawURLEncoding.EncodeToString([]byte(otherZone + "/www/A/1.2.3.6"))
+			rec, _ := do(http.MethodGet, recordsURL+"/"+forged, nil)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should create a record", func() {
+			rec, res := do(http.MethodPost, recordsURL, map[string]any{
+				"zone_id": zoneID, "type": "TXT", "name": "_acme-challenge.api", "value": "new",
+			})
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(string(res["record"])).To(ContainSubstring(`"ttl":60`))
+			Expect(records.calls).To(Equal([]string{"add _acme-challenge.api TXT new"}))
+			Expect(findRecord("_acme-challenge.api", "TXT").Value).To(Equal("new"))
+		})
+
+		DescribeTable("should reject creating", func(body map[string]any, code int) {
+			body["zone_id"] = zoneID
+			rec, _ := do(http.MethodPost, recordsURL, body)
+			Expect(rec.Code).To(Equal(code))
+			Expect(records.calls).To(BeEmpty())
+		},
+			Entry("records outside the grants", map[string]any{"type": "A", "name": "@", "value": "1.2.3.4"},
+				http.StatusForbidden),
+			Entry("records denied by rules", map[string]any{"type": "A", "name": "www", "value": "1.2.3.99"},
+				http.StatusForbidden),
+			Entry("addresses other than the client IP", map[string]any{"type": "A", "name": "home", "value": "1.2.3.8"},
+				http.StatusForbidden),
+			Entry("unsupported types", map[string]any{"type": "MX", "name": "mail", "value": "10 mx.example.com."},
+				http.StatusUnprocessableEntity),
+			Entry("invalid values", map[string]any{"type": "A", "name": "www", "value": "invalid"},
+				http.StatusUnprocessableEntity),
+			Entry("private addresses", map[string]any{"type": "A", "name": "www", "value": "10.0.0.1"},
+				http.StatusUnprocessableEntity),
+			Entry("records without value", map[string]any{"type": "A", "name": "www"},
+				http.StatusUnprocessableEntity),
+		)
+
+		It("should update the value of a record", func() {
+			r := findRecord("www", "A")
+			rec, res := do(http.MethodPut, recordsURL+"/"+r.ID, map[string]any{
+				"zone_id": zoneID, "type": "A", "name": "www", "value": "1.2.3.7",
+			})
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(string(res["record"])).To(ContainSubstring(`"ttl":300`))
+			Expect(records.calls).To(Equal([]string{"add www A 1.2.3.7", "remove www A 1.2.3.4"}))
+			Expect(findRecord("www", "A").Value).To(Equal("1.2.3.7"))
+		})
+
+		It("should update the TTL of a record", func() {
+			r := findRecord("www", "A")
+			rec, _ := do(http.MethodPut, recordsURL+"/"+r.ID, map[string]any{
+				"zone_id": zoneID, "type": "A", "name": "www", "value": "1.2.3.4", "ttl": 120,
+			})
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(records.calls).To(Equal([]string{"ttl www A 120"}))
+		})
+
+		It("should delete a record", func() {
+			r := findRecord("_acme-challenge.www", "TXT")
+			rec, _ := do(http.MethodDelete, recordsURL+"/"+r.ID, nil)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(records.calls).To(Equal([]string{"remove _acme-challenge.www TXT token"}))
+
+			rec, _ = do(http.MethodDelete, recordsURL+"/"+r.ID, nil)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("should limit changes per zone", func() {
+			limits.Zone = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+			body := map[string]any{"zone_id": zoneID, "type": "TXT", "name": "_acme-challenge.api", "value": "new"}
+			rec, _ := do(http.MethodPost, recordsURL, body)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			body["value"] = "other"
+			rec, _ = do(http.MethodPost, recordsURL, body)
+			Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(rec.Header().Get("Retry-After")).ToNot(BeEmpty())
+			Expect(records.calls).To(Equal([]string{"add _acme-challenge.api TXT new"}))
+		})
+
+		It("should limit changes per API token", func() {
+			limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+			body := map[string]any{"zone_id": zoneID, "type": "TXT", "name": "_acme-challenge.api", "value": "new"}
+			rec, _ := do(http.MethodPost, recordsURL, body)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			body["name"] = "_acme-challenge.www"
+			rec, _ = do(http.MethodPost, recordsURL, body)
+			Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(records.calls).To(Equal([]string{"add _acme-challenge.api TXT new"}))
+		})
+	})
+})
@@ -0,0 +1,402 @@
+package hetznerdns
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/zoneapi"
+)
+
+const (
+	errRecordNotFound   = "record not found"
+	errRecordNotAllowed = "record not allowed"
+	apexName            = "@"
+)
+
+// apiError is an error answered to the client with code and message.
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// recordJSON is a record of the legacy API, a single value of an RRSet.
+type recordJSON struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	ZoneID string `json:"zone_id"`
+	TTL    *int   `json:"ttl,omitempty"`
+}
+
+type recordRequest struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Value  string `json:"value"`
+	ZoneID string `json:"zone_id"`
+	TTL    *int   `json:"ttl"`
+}
+
+func newRecordJSON(zoneID int64, name, recordType, value string, ttl *int) recordJSON {
+	rec := recordJSON{
+		Type:   recordType,
+		Name:   name,
+		Value:  value,
+		ZoneID: strconv.FormatInt(zoneID, 10),
+		TTL:    ttl,
+	}
+	rec.ID = recordID(rec.ZoneID, name, recordType, value)
+	return rec
+}
+
+// recordID returns the ID of a record, which encodes its zone, RRSet and
+// value. IDs are stable as long as the value of the record is unchanged.
+func recordID(zoneID, name, recordType, value string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(zoneID + "/" + name + "/" + recordType + "/" + value))
+}
+
+// parseRecordID returns the zone ID, name, type and value encoded in id.
+func parseRecordID(id string) (zoneID, name, recordType, value string, ok bool) {
+	const parts = 4
+	b, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return "", "", "", "", false
+	}
+	p := strings.SplitN(string(b), "/", parts)
+	if len(p) != parts {
+		return "", "", "", "", false
+	}
+	return p[0], p[1], p[2], p[3], true
+}
+
+// recordFQDN returns the fully qualified name of the record named name in
+// zone.
+func recordFQDN(name, zone string) string {
+	if name == apexName {
+		return zone
+	}
+	return name + "." + zone
+}
+
+// listRecords lists the records of the API token, optionally only those of
+// the zone with zone_id.
+func (h *handler) listRecords(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.recordZones(ctx, r)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	result := []recordJSON{}
+	for _, zone := range zones {
+		records, err := h.zoneRecords(ctx, r, zone)
+		if err != nil {
+			failed(w, err)
+			return
+		}
+		result = append(result, records...)
+	}
+
+	page, m := paginate(r, result)
+	zoneapi.WriteJSON(w, http.StatusOK, map[string]any{"records": page, "meta": m})
+}
+
+// recordZones returns the zone with the zone_id of r, or all zones of the API
+// token without it.
+func (h *handler) recordZones(ctx context.Context, r *http.Request) ([]*hcloud.Zone, error) {
+	id := r.URL.Query().Get("zone_id")
+	if id == "" {
+		return zoneapi.VisibleZones(ctx, &h.cfg.Auth, h.records, r)
+	}
+	zone, err := zoneapi.VisibleZone(ctx, &h.cfg.Auth, h.records, r, id)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, &apiError{code: http.StatusNotFound, message: errZoneNotFound}
+	}
+	return []*hcloud.Zone{zone}, nil
+}
+
+// zoneRecords returns the records of zone the API token of r may change.
+func (h *handler) zoneRecords(ctx context.Context, r *http.Request, zone *hcloud.Zone) ([]recordJSON, error) {
+	rrSets, err := h.records.RRSets(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	t := middleware.APITokenFromContext(r.Context())
+	var records []recordJSON
+	for _, rrSet := range rrSets {
+		if !middleware.APITokenAllows(&h.cfg.Auth, t, recordFQDN(rrSet.Name, zone.Name), string(rrSet.Type), r.RemoteAddr) {
+			continue
+		}
+		for _, record := range rrSet.Records {
+			value := hetzner.UnquoteIfRequired(record.Value, rrSet.Type)
+			records = append(records, newRecordJSON(zone.ID, rrSet.Name, string(rrSet.Type), value, rrSet.TTL))
+		}
+	}
+	return records, nil
+}
+
+// findRecord returns the record with id and its zone if the API token of r
+// may change it.
+func (h *handler) findRecord(ctx context.Context, r *http.Request, id string) (*hcloud.Zone, *recordJSON, error) {
+	notFound := &apiError{code: http.StatusNotFound, message: errRecordNotFound}
+	zoneID, name, recordType, value, ok := parseRecordID(id)
+	if !ok {
+		return nil, nil, notFound
+	}
+	zone, err := zoneapi.VisibleZone(ctx, &h.cfg.Auth, h.records, r, zoneID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if zone == nil {
+		return nil, nil, notFound
+	}
+
+	records, err := h.zoneRecords(ctx, r, zone)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range records {
+		if records[i].Name == name && records[i].Type == recordType && records[i].Value == value {
+			return zone, &records[i], nil
+		}
+	}
+	return nil, nil, notFound
+}
+
+func (h *handler) getRecord(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	_, record, err := h.findRecord(ctx, r, r.PathValue("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	zoneapi.WriteJSON(w, http.StatusOK, map[string]any{"record": record})
+}
+
+func (h *handler) createRecord(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRecord(w, r)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zone, err := h.checkRecord(ctx, r, req)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	if !h.allow(w, r, zone) {
+		return
+	}
+
+	ttl := h.cfg.RecordTTL
+	if req.TTL != nil {
+		ttl = *req.TTL
+	}
+	logChange(r, "add", recordFQDN(req.Name, zone.Name), req.Type, req.Value)
+	if err := h.records.AddRecord(ctx, zone, req.Name, req.Type, req.Value, ttl); err != nil {
+		failed(w, err)
+		return
+	}
+	zoneapi.WriteJSON(w, http.StatusOK, map[string]any{"record": newRecordJSON(zone.ID, req.Name, req.Type, req.Value, &ttl)})
+}
+
+// updateRecord replaces the record with id. If its RRSet or value change,
+// the new record is added before the old one is removed.
+func (h *handler) updateRecord(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeRecord(w, r)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	ctx, cancel := h.context(r)
+	defer cancel()
+	oldZone, old, err := h.findRecord(ctx, r, r.PathValue("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	zone, err := h.checkRecord(ctx, r, req)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	zones := []*hcloud.Zone{zone}
+	if oldZone.ID != zone.ID {
+		zones = append(zones, oldZone)
+	}
+	if !h.allow(w, r, zones...) {
+		return
+	}
+
+	ttl := req.TTL
+	if ttl == nil {
+		ttl = &h.cfg.RecordTTL
+		if sameRRSet(oldZone, old, zone, req) && old.TTL != nil {
+			ttl = old.TTL
+		}
+	}
+	logChange(r, "update", recordFQDN(req.Name, zone.Name), req.Type, req.Value)
+	if err := h.replace(ctx, oldZone, old, zone, req, *ttl); err != nil {
+		failed(w, err)
+		return
+	}
+	zoneapi.WriteJSON(w, http.StatusOK, map[string]any{"record": newRecordJSON(zone.ID, req.Name, req.Type, req.Value, ttl)})
+}
+
+func (h *handler) replace(
+	ctx context.Context, oldZone *hcloud.Zone, old *recordJSON, zone *hcloud.Zone, req *recordRequest, ttl int,
+) error {
+	same := sameRRSet(oldZone, old, zone, req)
+	if !same || old.Value != req.Value {
+		if err := h.records.AddRecord(ctx, zone, req.Name, req.Type, req.Value, ttl); err != nil {
+			return err
+		}
+		if err := h.records.RemoveRecord(ctx, oldZone, old.Name, old.Type, old.Value); err != nil {
+			return err
+		}
+	}
+	if same && (old.TTL == nil || *old.TTL != ttl) {
+		return h.records.ChangeTTL(ctx, zone, req.Name, req.Type, ttl)
+	}
+	return nil
+}
+
+// sameRRSet reports whether the record of req is in the RRSet of old.
+func sameRRSet(oldZone *hcloud.Zone, old *recordJSON, zone *hcloud.Zone, req *recordRequest) bool {
+	return oldZone.ID == zone.ID && old.Name == req.Name && old.Type == req.Type
+}
+
+func (h *handler) deleteRecord(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zone, record, err := h.findRecord(ctx, r, r.PathValue("id"))
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	fqdn := recordFQDN(record.Name, zone.Name)
+	if !middleware.APITokenRulesAllow(h.cfg, r, config.EndpointHetznerDNS, fqdn, record.Type, record.Value) {
+		writeError(w, http.StatusForbidden, errRecordNotAllowed)
+		return
+	}
+	if !h.allow(w, r, zone) {
+		return
+	}
+	logChange(r, "delete", fqdn, record.Type, record.Value)
+	if err := h.records.RemoveRecord(ctx, zone, record.Name, record.Type, record.Value); err != nil {
+		failed(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkRecord returns the zone of req if the API token of r may set the
+// record of req.
+func (h *handler) checkRecord(ctx context.Context, r *http.Request, req *recordRequest) (*hcloud.Zone, error) {
+	zone, err := zoneapi.VisibleZone(ctx, &h.cfg.Auth, h.records, r, req.ZoneID)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, &apiError{code: http.StatusNotFound, message: errZoneNotFound}
+	}
+	if _, err := hetzner.RRSetTypeFromString(req.Type); err != nil {
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: err.Error()}
+	}
+
+	fqdn := recordFQDN(req.Name, zone.Name)
+	t := middleware.APITokenFromContext(r.Context())
+	if !middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, req.Type, r.RemoteAddr) {
+		logDenied(r, t.Name, fqdn, req.Type)
+		return nil, &apiError{code: http.StatusForbidden, message: errRecordNotAllowed}
+	}
+	if err := middleware.ValidateValue(&h.cfg.AddressPolicy, fqdn, req.Value, req.Type); err != nil {
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: err.Error()}
+	}
+	if !middleware.ClientIPAllowed(h.cfg, nil, fqdn, req.Type, req.Value, r.RemoteAddr) {
+		return nil, &apiError{code: http.StatusForbidden, message: errRecordNotAllowed}
+	}
+	if !middleware.APITokenRulesAllow(h.cfg, r, config.EndpointHetznerDNS, fqdn, req.Type, req.Value) {
+		return nil, &apiError{code: http.StatusForbidden, message: errRecordNotAllowed}
+	}
+	return zone, nil
+}
+
+// decodeRecord decodes the record of the body of r. Names are relative to
+// the zone, with @ for its apex.
+func decodeRecord(w http.ResponseWriter, r *http.Request) (*recordRequest, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	req := &recordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, &apiError{code: http.StatusBadRequest, message: "invalid request body"}
+	}
+
+	req.Name = strings.ToLower(strings.TrimSuffix(req.Name, "."))
+	if req.Name == "" {
+		req.Name = apexName
+	}
+	req.Type = strings.ToUpper(req.Type)
+	switch {
+	case req.ZoneID == "":
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: "zone_id is missing"}
+	case req.Value == "":
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: "value is missing"}
+	case req.TTL != nil && *req.TTL <= 0:
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: "ttl must be > 0"}
+	}
+	return req, nil
+}
+
+// writeErr answers err, which is an apiError or an error of the Cloud API.
+func writeErr(w http.ResponseWriter, err error) {
+	var e *apiError
+	if errors.As(err, &e) {
+		writeError(w, e.code, e.message)
+		return
+	}
+	failed(w, err)
+}
+
+func logChange(r *http.Request, action, fqdn, recordType, value string) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	val := sanitize.LogValue(value)
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request of API token '%s' to %s '%s' data of '%s' with value '%s'", t, action, typ, name, val)
+}
+
+func logDenied(r *http.Request, token, fqdn, recordType string) {
+	t := sanitize.LogValue(token)
+	addr := sanitize.LogValue(r.RemoteAddr)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("API token '%s' of client '%s' is not allowed to change '%s' data of '%s'", t, addr, typ, name)
+}
@@ -0,0 +1,91 @@
+package hetznerdns
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/zoneapi"
+)
+
+const (
+	errZoneNotFound = "zone not found"
+	timeLayout      = "2006-01-02 15:04:05.000 -0700 MST"
+)
+
+type zoneJSON struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	TTL            int    `json:"ttl"`
+	Status         string `json:"status"`
+	IsSecondaryDNS bool   `json:"is_secondary_dns"`
+	Created        string `json:"created"`
+}
+
+func newZoneJSON(zone *hcloud.Zone) zoneJSON {
+	return zoneJSON{
+		ID:             strconv.FormatInt(zone.ID, 10),
+		Name:           zone.Name,
+		TTL:            zone.TTL,
+		Status:         zoneStatus(zone.Status),
+		IsSecondaryDNS: zone.Mode == hcloud.ZoneModeSecondary,
+		Created:        zone.Created.UTC().Format(timeLayout),
+	}
+}
+
+// zoneStatus maps the status of a zone to the statuses of the legacy API.
+func zoneStatus(status hcloud.ZoneStatus) string {
+	switch status {
+	case hcloud.ZoneStatusOk:
+		return "verified"
+	case hcloud.ZoneStatusError:
+		return "failed"
+	case hcloud.ZoneStatusUpdating:
+	}
+	return "pending"
+}
+
+// listZones lists the zones of the API token, optionally filtered by the
+// exact name or by search_name.
+func (h *handler) listZones(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := zoneapi.VisibleZones(ctx, &h.cfg.Auth, h.records, r)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	name := strings.ToLower(r.URL.Query().Get("name"))
+	search := strings.ToLower(r.URL.Query().Get("search_name"))
+	result := make([]zoneJSON, 0, len(zones))
+	for _, zone := range zones {
+		if (name == "" || zone.Name == name) && strings.Contains(zone.Name, search) {
+			result = append(result, newZoneJSON(zone))
+		}
+	}
+	if name != "" && len(result) == 0 {
+		writeError(w, http.StatusNotFound, errZoneNotFound)
+		return
+	}
+
+	page, m := paginate(r, result)
+	zoneapi.WriteJSON(w, http.StatusOK, map[string]any{"zones": page, "meta": m})
+}
+
+func (h *handler) getZone(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zone, err := zoneapi.VisibleZone(ctx, &h.cfg.Auth, h.records, r, r.PathValue("id"))
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	if zone == nil {
+		writeError(w, http.StatusNotFound, errZoneNotFound)
+		return
+	}
+	zoneapi.WriteJSON(w, http.StatusOK, map[string]any{"zone": newZoneJSON(zone)})
+}
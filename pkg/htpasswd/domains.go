@@ -0,0 +1,45 @@
+package htpasswd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadDomainsFile reads the domains of users from path, with one
+// username:domain,domain,... entry per line. Empty lines and lines starting
+// with # are ignored, the domains of repeated usernames are combined.
+func ReadDomainsFile(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	domains := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, list, ok := strings.Cut(line, ":")
+		username = strings.TrimSpace(username)
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s:%d: invalid entry, must be username:domain,...", path, lineNo)
+		}
+		for domain := range strings.SplitSeq(list, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains[username] = append(domains[username], domain)
+			}
+		}
+		if len(domains[username]) == 0 {
+			return nil, fmt.Errorf("%s:%d: no domains for user %q", path, lineNo, username)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return domains, nil
+}
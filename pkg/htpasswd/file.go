@@ -0,0 +1,84 @@
+package htpasswd
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+// User is a user of an htpasswd file with the domains granted to it.
+type User struct {
+	Username string
+	Hash     string
+	Domains  []string
+}
+
+// File provides the users of an htpasswd file and their domains from a
+// domains file. Both files are reloaded when they change. A nil File has no
+// users.
+type File struct {
+	mu          sync.Mutex
+	usersFile   string
+	domainsFile string
+	watcher     *filewatch.Watcher
+	users       atomic.Pointer[[]User]
+}
+
+// NewFile returns the users of usersFile and their domains in domainsFile.
+// Files that fail to load are logged, they are expected to have been
+// validated with ReadFile and ReadDomainsFile beforehand.
+func NewFile(usersFile, domainsFile string) *File {
+	f := &File{
+		usersFile:   usersFile,
+		domainsFile: domainsFile,
+		watcher:     filewatch.New(filewatch.DefaultInterval, usersFile, domainsFile),
+	}
+	f.users.Store(f.load(&[]User{}))
+	return f
+}
+
+func (f *File) Users() []User {
+	if f == nil {
+		return nil
+	}
+	if f.watcher.Changed() {
+		f.reload()
+	}
+	return *f.users.Load()
+}
+
+func (f *File) reload() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users.Store(f.load(f.users.Load()))
+}
+
+// load returns the users from the files, or prev if a file fails to load.
+// Users without domains are skipped.
+func (f *File) load(prev *[]User) *[]User {
+	hashes, err := ReadFile(f.usersFile)
+	if err != nil {
+		log.Printf("failed to load users, keeping previous users: %v", err)
+		return prev
+	}
+	domains, err := ReadDomainsFile(f.domainsFile)
+	if err != nil {
+		log.Printf("failed to load user domains, keeping previous users: %v", err)
+		return prev
+	}
+
+	users := make([]User, 0, len(hashes))
+	for username, hash := range hashes {
+		if len(domains[username]) > 0 {
+			users = append(users, User{Username: username, Hash: hash, Domains: domains[username]})
+		}
+	}
+	slices.SortFunc(users, func(a, b User) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+	return &users
+}
@@ -0,0 +1,83 @@
+package htpasswd
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+var _ = Describe("File", func() {
+	const (
+		aliceHash = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+		bobHash   = "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"
+	)
+
+	var (
+		usersFile   string
+		domainsFile string
+		modTime     time.Time
+	)
+
+	write := func(path, content string) {
+		Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+		// Ensure the watcher notices the change even on coarse timestamps.
+		modTime = modTime.Add(time.Second)
+		Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+	}
+
+	newFile := func() *File {
+		f := NewFile(usersFile, domainsFile)
+		f.watcher = filewatch.New(0, usersFile, domainsFile)
+		return f
+	}
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		usersFile = filepath.Join(dir, "htpasswd")
+		domainsFile = filepath.Join(dir, "domains")
+		modTime = time.Now()
+		write(usersFile, "alice:"+aliceHash+"\nbob:"+bobHash+"\ncarol:"+aliceHash+"\n")
+		write(domainsFile, "alice:example.com\nbob:*.example.org\n")
+	})
+
+	It("should return users with domains", func() {
+		Expect(newFile().Users()).To(Equal([]User{
+			{Username: "alice", Hash: aliceHash, Domains: []string{"example.com"}},
+			{Username: "bob", Hash: bobHash, Domains: []string{"*.example.org"}},
+		}))
+	})
+
+	It("should reload changed files", func() {
+		f := newFile()
+		Expect(f.Users()).To(HaveLen(2))
+
+		write(usersFile, "alice:"+bobHash+"\n")
+		Expect(f.Users()).To(Equal([]User{
+			{Username: "alice", Hash: bobHash, Domains: []string{"example.com"}},
+		}))
+
+		write(domainsFile, "alice:example.net\n")
+		Expect(f.Users()).To(Equal([]User{
+			{Username: "alice", Hash: bobHash, Domains: []string{"example.net"}},
+		}))
+	})
+
+	It("should keep the previous users if a file fails to load", func() {
+		f := newFile()
+		write(usersFile, "alice:secret\n")
+		Expect(f.Users()).To(HaveLen(2))
+
+		Expect(os.Remove(domainsFile)).To(Succeed())
+		Expect(f.Users()).To(HaveLen(2))
+	})
+
+	It("should have no users if nil", func() {
+		var f *File
+		Expect(f.Users()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,168 @@
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/md5"  //nolint:gosec // required by the apr1 format
+	"crypto/sha1" //nolint:gosec // required by the SHA format
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	prefixSHA  = "{SHA}"
+	prefixAPR1 = "$apr1$"
+)
+
+var errUnsupportedHash = errors.New("unsupported hash format, must be bcrypt, SHA or apr1")
+
+// ReadFile reads an htpasswd file with one username:hash pair per line and
+// returns the hashes by username. Empty lines and lines starting with # are
+// ignored.
+func ReadFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s:%d: invalid entry, must be username:hash", path, lineNo)
+		}
+		if err := checkHash(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid hash of user %q: %w", path, lineNo, username, err)
+		}
+		hashes[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hashes, nil
+}
+
+func checkHash(hash string) error {
+	switch {
+	case isBcrypt(hash):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, prefixSHA):
+		decoded, err := base64.StdEncoding.DecodeString(hash[len(prefixSHA):])
+		if err != nil || len(decoded) != sha1.Size {
+			return errors.New("invalid SHA hash")
+		}
+		return nil
+	case strings.HasPrefix(hash, prefixAPR1):
+		if _, _, ok := strings.Cut(hash[len(prefixAPR1):], "$"); !ok {
+			return errors.New("invalid apr1 hash")
+		}
+		return nil
+	}
+	return errUnsupportedHash
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// Verify reports whether password matches hash.
+func Verify(hash, password string) bool {
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, prefixSHA):
+		sum := sha1.Sum([]byte(password)) //nolint:gosec // required by the SHA format
+		expected := prefixSHA + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	case strings.HasPrefix(hash, prefixAPR1):
+		salt, _, _ := strings.Cut(hash[len(prefixAPR1):], "$")
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(apr1(password, salt))) == 1
+	}
+	return false
+}
+
+const (
+	apr1SaltMaxLen = 8
+	apr1Rounds     = 1000
+	itoa64         = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// apr1 implements the Apache variant of the MD5-based crypt algorithm.
+//
+//nolint:mnd // constants of the algorithm
+func apr1(password, salt string) string {
+	if len(salt) > apr1SaltMaxLen {
+		salt = salt[:apr1SaltMaxLen]
+	}
+	pw := []byte(password)
+
+	alt := md5.New() //nolint:gosec // required by the apr1 format
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	h := md5.New() //nolint:gosec // required by the apr1 format
+	h.Write(pw)
+	h.Write([]byte(prefixAPR1))
+	h.Write([]byte(salt))
+	for n := len(pw); n > 0; n -= md5.Size {
+		h.Write(altSum[:min(n, md5.Size)])
+	}
+	for i := len(pw); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	for i := range apr1Rounds {
+		r := md5.New() //nolint:gosec // required by the apr1 format
+		if i&1 != 0 {
+			r.Write(pw)
+		} else {
+			r.Write(sum)
+		}
+		if i%3 != 0 {
+			r.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			r.Write(pw)
+		}
+		if i&1 != 0 {
+			r.Write(sum)
+		} else {
+			r.Write(pw)
+		}
+		sum = r.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(prefixAPR1 + salt + "$")
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(&b, uint(sum[g[0]])<<16|uint(sum[g[1]])<<8|uint(sum[g[2]]), 4)
+	}
+	to64(&b, uint(sum[11]), 2)
+	return b.String()
+}
+
+func to64(b *strings.Builder, v uint, n int) {
+	for range n {
+		b.WriteByte(itoa64[v&0x3f])
+		v >>= 6
+	}
+}
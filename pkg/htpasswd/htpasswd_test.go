@@ -0,0 +1,85 @@
+package htpasswd_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/htpasswd"
+)
+
+const (
+	sha1Secret = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+	apr1Secret = "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0"
+)
+
+func writeFile(content string) string {
+	path := filepath.Join(GinkgoT().TempDir(), "file")
+	Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	return path
+}
+
+var _ = Describe("Verify", func() {
+	bcryptSecret := func(prefix string) string {
+		hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+		return prefix + string(hash[len(prefix):])
+	}
+
+	DescribeTable("should verify passwords", func(hash func() string, password string, expected bool) {
+		Expect(htpasswd.Verify(hash(), password)).To(Equal(expected))
+	},
+		Entry("bcrypt $2a$", func() string { return bcryptSecret("$2a$") }, "secret", true),
+		Entry("bcrypt $2y$", func() string { return bcryptSecret("$2y$") }, "secret", true),
+		Entry("bcrypt wrong password", func() string { return bcryptSecret("$2y$") }, "wrong", false),
+		Entry("SHA", func() string { return sha1Secret }, "secret", true),
+		Entry("SHA wrong password", func() string { return sha1Secret }, "wrong", false),
+		Entry("apr1", func() string { return apr1Secret }, "secret", true),
+		Entry("apr1 long password",
+			func() string { return "$apr1$abc$77rKqJqY.58.YAtshgYSi1" }, "a much longer password than sixteen", true),
+		Entry("apr1 wrong password", func() string { return apr1Secret }, "wrong", false),
+		Entry("plain text", func() string { return "secret" }, "secret", false),
+	)
+})
+
+var _ = Describe("ReadFile", func() {
+	It("should read users", func() {
+		path := writeFile("# users\nalice:" + sha1Secret + "\n\nbob:" + apr1Secret + "\n")
+		Expect(htpasswd.ReadFile(path)).To(Equal(map[string]string{
+			"alice": sha1Secret,
+			"bob":   apr1Secret,
+		}))
+	})
+
+	DescribeTable("should fail", func(content, expectedErr string) {
+		_, err := htpasswd.ReadFile(writeFile(content))
+		Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("without separator", "alice", ":1: invalid entry, must be username:hash"),
+		Entry("without username", ":"+sha1Secret, ":1: invalid entry, must be username:hash"),
+		Entry("with plain text password", "alice:secret", `:1: invalid hash of user "alice": unsupported hash format`),
+		Entry("with invalid SHA hash", "alice:{SHA}abc", `:1: invalid hash of user "alice": invalid SHA hash`),
+		Entry("with invalid bcrypt hash", "alice:$2y$xx", `:1: invalid hash of user "alice"`),
+	)
+})
+
+var _ = Describe("ReadDomainsFile", func() {
+	It("should read domains", func() {
+		path := writeFile("# domains\nalice: example.com, *.example.org\n\nbob:example.net\nalice:example.de\n")
+		Expect(htpasswd.ReadDomainsFile(path)).To(Equal(map[string][]string{
+			"alice": {"example.com", "*.example.org", "example.de"},
+			"bob":   {"example.net"},
+		}))
+	})
+
+	DescribeTable("should fail", func(content, expectedErr string) {
+		_, err := htpasswd.ReadDomainsFile(writeFile(content))
+		Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+	},
+		Entry("without separator", "alice", ":1: invalid entry, must be username:domain,..."),
+		Entry("without domains", "alice: ,", `:1: no domains for user "alice"`),
+	)
+})
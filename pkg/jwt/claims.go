@@ -0,0 +1,60 @@
+package jwt
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Claims are the claims of a verified token.
+type Claims map[string]any
+
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// Strings returns the claim name as a list of strings. A string claim is
+// split at spaces and commas, a list claim returns its string elements.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// hasAudience reports whether the aud claim, a string or a list of
+// strings, contains audience.
+func (c Claims) hasAudience(audience string) bool {
+	switch v := c["aud"].(type) {
+	case string:
+		return v == audience
+	case []any:
+		return slices.Contains(v, any(audience))
+	}
+	return false
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
@@ -0,0 +1,96 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	. "github.com/onsi/gomega"
+)
+
+// testKeys is a locally generated key set with one key per key type.
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newTestKeys() *testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ToNot(HaveOccurred())
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	return &testKeys{rsa: rsaKey, ecdsa: ecKey, ed25519: edKey}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (k *testKeys) jwks() []byte {
+	ecPoint, err := k.ecdsa.PublicKey.Bytes()
+	Expect(err).ToNot(HaveOccurred())
+	data, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "alg": "ES256", "crv": "P-256",
+			"x": b64(ecPoint[1:33]), "y": b64(ecPoint[33:]),
+		},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(k.ed25519.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(k.rsa.N.Bytes()), "e": "AQAB"},
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+	}})
+	Expect(err).ToNot(HaveOccurred())
+	return data
+}
+
+func (k *testKeys) sign(alg, kid string, claims map[string]any) string {
+	h, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	Expect(err).ToNot(HaveOccurred())
+	c, err := json.Marshal(claims)
+	Expect(err).ToNot(HaveOccurred())
+	signed := b64(h) + "." + b64(c)
+	return signed + "." + b64(k.signature(alg, []byte(signed)))
+}
+
+func (k *testKeys) signature(alg string, signed []byte) []byte {
+	hash := algorithms[alg]
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	var (
+		sig []byte
+		err error
+	)
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest)
+	case "PS256":
+		sig, err = rsa.SignPSS(rand.Reader, k.rsa, crypto.SHA256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ecdsa, digest)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "EdDSA":
+		sig = ed25519.Sign(k.ed25519, signed)
+	default:
+		sig = []byte("signature")
+	}
+	Expect(err).ToNot(HaveOccurred())
+	return sig
+}
@@ -0,0 +1,325 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+const (
+	// DefaultCacheDuration is the time a key set fetched from a URL is used
+	// before it is fetched again.
+	DefaultCacheDuration = time.Hour
+	// minRefetchInterval limits refetches of a key set caused by tokens
+	// signed with unknown keys.
+	minRefetchInterval = time.Minute
+	fetchTimeout       = 10 * time.Second
+	maxKeySetSize      = 1 << 20
+)
+
+// Key is a public key of a key set.
+type Key struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+// KeySet provides the keys tokens are verified with.
+type KeySet interface {
+	Keys(ctx context.Context) []Key
+	// Refresh fetches the keys again when a token was signed with an
+	// unknown key. Implementations may ignore the call.
+	Refresh(ctx context.Context)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseKeySet parses a JSON Web Key Set. Keys not meant for signatures and
+// keys of unsupported types are skipped.
+func ParseKeySet(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	keys := make([]Key, 0, len(set.Keys))
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", k.Kid, err)
+		}
+		if public != nil {
+			keys = append(keys, Key{ID: k.Kid, Algorithm: k.Alg, Public: public})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("key set contains no supported signing keys")
+	}
+	return keys, nil
+}
+
+// ReadKeySetFile reads and parses a JSON Web Key Set from path.
+func ReadKeySetFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		return k.ecdsaPublicKey()
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+func (k *jwk) ecdsaPublicKey() (crypto.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, nil
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, errors.New("invalid x coordinate")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, errors.New("invalid y coordinate")
+	}
+	size := (curve.Params().BitSize + 7) / 8 //nolint:mnd // bits to bytes
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("invalid coordinate length")
+	}
+	// Build the uncompressed point encoding so that the point is validated
+	// to be on the curve.
+	point := append(append([]byte{4}, x...), y...)
+	key, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// FileKeySet is a key set read from a file. The file is reloaded when it
+// changes, if a reload fails the previous keys are kept.
+type FileKeySet struct {
+	mu      sync.Mutex
+	path    string
+	watcher *filewatch.Watcher
+	keys    atomic.Pointer[[]Key]
+}
+
+// NewFileKeySet returns a key set read from path. It is expected to have
+// been validated with ReadKeySetFile beforehand, a failing initial load is
+// logged and results in an empty key set.
+func NewFileKeySet(path string) *FileKeySet {
+	s := &FileKeySet{
+		path:    path,
+		watcher: filewatch.New(filewatch.DefaultInterval, path),
+	}
+	keys, err := ReadKeySetFile(path)
+	if err != nil {
+		log.Printf("failed to load key set: %v", err)
+	}
+	s.keys.Store(&keys)
+	return s
+}
+
+func (s *FileKeySet) Keys(context.Context) []Key {
+	if s.watcher.Changed() {
+		s.reload()
+	}
+	return *s.keys.Load()
+}
+
+func (s *FileKeySet) Refresh(context.Context) {}
+
+func (s *FileKeySet) reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, err := ReadKeySetFile(s.path)
+	if err != nil {
+		log.Printf("failed to reload key set, keeping previous keys: %v", err)
+		return
+	}
+	s.keys.Store(&keys)
+}
+
+// URLKeySet is a key set fetched from a URL. Fetched keys are cached for
+// the cache duration. If a fetch fails, the previous keys are kept.
+type URLKeySet struct {
+	mu        sync.Mutex
+	url       string
+	cache     time.Duration
+	client    *http.Client
+	now       func() time.Time
+	keys      []Key
+	fetchedAt time.Time
+	triedAt   time.Time
+	// fetching is closed when the running fetch is done, it is nil if no
+	// fetch is running.
+	fetching chan struct{}
+}
+
+func NewURLKeySet(url string, cache time.Duration) *URLKeySet {
+	if cache <= 0 {
+		cache = DefaultCacheDuration
+	}
+	return &URLKeySet{
+		url:    url,
+		cache:  cache,
+		client: &http.Client{Timeout: fetchTimeout},
+		now:    time.Now,
+	}
+}
+
+func (s *URLKeySet) Keys(ctx context.Context) []Key {
+	s.refresh(ctx, func(now time.Time) bool {
+		return now.Sub(s.fetchedAt) >= s.cache && now.Sub(s.triedAt) >= minRefetchInterval
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys
+}
+
+func (s *URLKeySet) Refresh(ctx context.Context) {
+	s.refresh(ctx, func(now time.Time) bool {
+		return now.Sub(s.triedAt) >= minRefetchInterval
+	})
+}
+
+// refresh starts a fetch if due reports one is due and waits for the running
+// fetch until ctx is done. The fetch does not hold the mutex and is not
+// canceled with ctx, so that a canceled request neither fails it for the
+// other callers nor delays the next fetch.
+func (s *URLKeySet) refresh(ctx context.Context, due func(now time.Time) bool) {
+	s.mu.Lock()
+	done := s.fetching
+	if done == nil {
+		now := s.now()
+		if !due(now) {
+			s.mu.Unlock()
+			return
+		}
+		done = make(chan struct{})
+		s.fetching, s.triedAt = done, now
+		go s.fetch(context.WithoutCancel(ctx), done)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (s *URLKeySet) fetch(ctx context.Context, done chan struct{}) {
+	keys, err := s.get(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(done)
+	s.fetching = nil
+	if err != nil {
+		log.Printf("failed to fetch key set from %s, keeping previous keys: %v", s.url, err)
+		return
+	}
+	s.keys = keys
+	s.fetchedAt = s.triedAt
+}
+
+func (s *URLKeySet) get(ctx context.Context) ([]Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySetSize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeySet(data)
+}
@@ -0,0 +1,142 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+func keyIDs(keys []Key) []string {
+	ids := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
+
+var _ = Describe("ParseKeySet", func() {
+	DescribeTable("should fail", func(data, errMsg string) {
+		_, err := ParseKeySet([]byte(data))
+		Expect(err).To(MatchError(ContainSubstring(errMsg)))
+	},
+		Entry("on invalid JSON", "{", "invalid key set"),
+		Entry("without signing keys", `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`, "no supported signing keys"),
+		Entry("on an invalid RSA key", `{"keys":[{"kty":"RSA","kid":"a","n":"","e":"AQAB"}]}`, `invalid key "a"`),
+		Entry("on an EC point off the curve", `{"keys":[{"kty":"EC","kid":"a","crv":"P-256",`+
+			`"x":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA","y":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}]}`,
+			`invalid key "a"`),
+	)
+})
+
+var _ = Describe("FileKeySet", func() {
+	It("should reload the key set on change", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "jwks.json")
+		Expect(os.WriteFile(path, newTestKeys().jwks(), 0o600)).To(Succeed())
+
+		s := NewFileKeySet(path)
+		s.watcher = filewatch.New(0, path)
+		Expect(keyIDs(s.Keys(context.Background()))).To(ConsistOf("rsa", "ec", "ed"))
+
+		Expect(os.WriteFile(path, []byte(`{"keys":[{"kty":"OKP","kid":"new","crv":"Ed25519",`+
+			`"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}]}`), 0o600)).To(Succeed())
+		Expect(keyIDs(s.Keys(context.Background()))).To(ConsistOf("new"))
+
+		Expect(os.WriteFile(path, []byte("invalid"), 0o600)).To(Succeed())
+		Expect(keyIDs(s.Keys(context.Background()))).To(ConsistOf("new"))
+	})
+})
+
+var _ = Describe("URLKeySet", func() {
+	var (
+		server   *httptest.Server
+		requests atomic.Int32
+		status   atomic.Int32
+		blocked  atomic.Pointer[chan struct{}]
+		s        *URLKeySet
+		now      time.Time
+	)
+
+	BeforeEach(func() {
+		jwks := newTestKeys().jwks()
+		requests.Store(0)
+		status.Store(http.StatusOK)
+		blocked.Store(nil)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests.Add(1)
+			if release := blocked.Load(); release != nil {
+				<-*release
+			}
+			w.WriteHeader(int(status.Load()))
+			_, _ = w.Write(jwks)
+		}))
+		DeferCleanup(server.Close)
+
+		now = time.Unix(1_700_000_000, 0)
+		s = NewURLKeySet(server.URL, time.Hour)
+		s.now = func() time.Time { return now }
+	})
+
+	It("should cache the key set", func() {
+		Expect(keyIDs(s.Keys(context.Background()))).To(ConsistOf("rsa", "ec", "ed"))
+		Expect(keyIDs(s.Keys(context.Background()))).To(ConsistOf("rsa", "ec", "ed"))
+		Expect(requests.Load()).To(BeEquivalentTo(1))
+
+		now = now.Add(time.Hour)
+		s.Keys(context.Background())
+		Expect(requests.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should limit refreshes", func() {
+		s.Keys(context.Background())
+		s.Refresh(context.Background())
+		Expect(requests.Load()).To(BeEquivalentTo(1))
+
+		now = now.Add(minRefetchInterval)
+		s.Refresh(context.Background())
+		Expect(requests.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should keep the previous keys when a fetch fails", func() {
+		s.Keys(context.Background())
+		status.Store(http.StatusInternalServerError)
+
+		now = now.Add(time.Hour)
+		Expect(keyIDs(s.Keys(context.Background()))).To(ConsistOf("rsa", "ec", "ed"))
+		Expect(requests.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should finish fetches of canceled requests", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s.Keys(ctx)
+
+		Eventually(func() []string {
+			return keyIDs(s.Keys(context.Background()))
+		}).Should(ConsistOf("rsa", "ec", "ed"))
+		Expect(requests.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should answer with the previous keys while a fetch is slow", func() {
+		s.Keys(context.Background())
+		release := make(chan struct{})
+		blocked.Store(&release)
+
+		now = now.Add(time.Hour)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		Expect(keyIDs(s.Keys(ctx))).To(ConsistOf("rsa", "ec", "ed"))
+		s.Refresh(ctx)
+
+		close(release)
+		Eventually(requests.Load).Should(BeEquivalentTo(2))
+	})
+})
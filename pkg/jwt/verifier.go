@@ -0,0 +1,195 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// leeway is the clock skew tolerated when checking exp and nbf.
+const leeway = time.Minute
+
+const (
+	tokenParts = 3
+	maxSize    = 16 << 10
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+)
+
+// Verifier verifies signed JSON Web Tokens (RFC 7519) against a key set and
+// checks their issuer, audience and validity period.
+type Verifier struct {
+	issuer   string
+	audience string
+	keys     KeySet
+	now      func() time.Time
+}
+
+func NewVerifier(issuer, audience string, keys KeySet) *Verifier {
+	return &Verifier{
+		issuer:   issuer,
+		audience: audience,
+		keys:     keys,
+		now:      time.Now,
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify returns the claims of token if it is valid.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	if len(token) > maxSize {
+		return nil, fmt.Errorf("%w: too large", ErrInvalidToken)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != tokenParts {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidToken, err)
+	}
+	if err := v.verifySignature(ctx, &h, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verifySignature(ctx context.Context, h *header, signed string, signature []byte) error {
+	if _, ok := algorithms[h.Alg]; !ok {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Alg)
+	}
+
+	keys := v.candidates(v.keys.Keys(ctx), h)
+	if len(keys) == 0 {
+		v.keys.Refresh(ctx)
+		keys = v.candidates(v.keys.Keys(ctx), h)
+	}
+	for _, key := range keys {
+		if verify(h.Alg, key.Public, []byte(signed), signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// candidates returns the keys that may have signed a token with header h.
+func (v *Verifier) candidates(keys []Key, h *header) []Key {
+	var matching []Key
+	for _, key := range keys {
+		if h.Kid != "" && key.ID != h.Kid {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != h.Alg {
+			continue
+		}
+		matching = append(matching, key)
+	}
+	return matching
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !claims.hasAudience(v.audience) {
+		return errors.New("unexpected audience")
+	}
+
+	now := v.now()
+	exp, ok := claims.time("exp")
+	if !ok {
+		return errors.New("missing expiry")
+	}
+	if now.After(exp.Add(leeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+func verify(alg string, public crypto.PublicKey, signed, signature []byte) bool {
+	hash := algorithms[alg]
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		case "PS":
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.VerifyPSS(key, hash, digest, signature, opts) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8 //nolint:mnd // bits to bytes
+		if alg[:2] != "ES" || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(key, signed, signature)
+	}
+	return false
+}
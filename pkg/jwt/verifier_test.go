@@ -0,0 +1,117 @@
+package jwt
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type staticKeySet []Key
+
+func (s staticKeySet) Keys(context.Context) []Key { return s }
+
+func (s staticKeySet) Refresh(context.Context) {}
+
+var _ = Describe("Verifier", func() {
+	const (
+		issuer   = "https://issuer.example.com"
+		audience = "hetzner-dnsapi-proxy"
+	)
+
+	var (
+		keys     *testKeys
+		verifier *Verifier
+		now      time.Time
+	)
+
+	BeforeEach(func() {
+		keys = newTestKeys()
+		parsed, err := ParseKeySet(keys.jwks())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(HaveLen(3))
+
+		now = time.Unix(1_700_000_000, 0)
+		verifier = NewVerifier(issuer, audience, staticKeySet(parsed))
+		verifier.now = func() time.Time { return now }
+	})
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss": issuer,
+			"aud": audience,
+			"sub": "repo:example/infra:ref:refs/heads/main",
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+
+	DescribeTable("should accept valid tokens", func(alg, kid string) {
+		claims, err := verifier.Verify(context.Background(), keys.sign(alg, kid, validClaims()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.Subject()).To(Equal("repo:example/infra:ref:refs/heads/main"))
+	},
+		Entry("signed with RS256", "RS256", "rsa"),
+		Entry("signed with PS256", "PS256", "rsa"),
+		Entry("signed with ES256", "ES256", "ec"),
+		Entry("signed with EdDSA", "EdDSA", "ed"),
+		Entry("signed without kid", "EdDSA", ""),
+	)
+
+	It("should accept an audience list", func() {
+		claims := validClaims()
+		claims["aud"] = []string{"other", audience}
+		_, err := verifier.Verify(context.Background(), keys.sign("ES256", "ec", claims))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should tolerate clock skew", func() {
+		claims := validClaims()
+		claims["exp"] = now.Add(-30 * time.Second).Unix()
+		claims["nbf"] = now.Add(30 * time.Second).Unix()
+		_, err := verifier.Verify(context.Background(), keys.sign("ES256", "ec", claims))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	DescribeTable("should reject invalid claims", func(modify func(map[string]any), errMsg string) {
+		claims := validClaims()
+		modify(claims)
+		_, err := verifier.Verify(context.Background(), keys.sign("ES256", "ec", claims))
+		Expect(err).To(MatchError(ErrInvalidToken))
+		Expect(err).To(MatchError(ContainSubstring(errMsg)))
+	},
+		Entry("with another issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, "unexpected issuer"),
+		Entry("with another audience", func(c map[string]any) { c["aud"] = "other" }, "unexpected audience"),
+		Entry("with another audience list", func(c map[string]any) { c["aud"] = []string{"other"} }, "unexpected audience"),
+		Entry("without audience", func(c map[string]any) { delete(c, "aud") }, "unexpected audience"),
+		Entry("without expiry", func(c map[string]any) { delete(c, "exp") }, "missing expiry"),
+		Entry("when expired", func(c map[string]any) { c["exp"] = time.Unix(1_700_000_000, 0).Add(-2 * time.Minute).Unix() },
+			"token is expired"),
+		Entry("when not valid yet", func(c map[string]any) { c["nbf"] = time.Unix(1_700_000_000, 0).Add(2 * time.Minute).Unix() },
+			"token is not valid yet"),
+	)
+
+	DescribeTable("should reject invalid signatures", func(token func() string, expected error) {
+		_, err := verifier.Verify(context.Background(), token())
+		Expect(err).To(MatchError(expected))
+	},
+		Entry("with algorithm none", func() string {
+			return strings.TrimSuffix(keys.sign("none", "", validClaims()), b64([]byte("signature")))
+		}, ErrInvalidToken),
+		Entry("with HS256", func() string { return keys.sign("HS256", "hmac", validClaims()) }, ErrInvalidToken),
+		Entry("with an unknown kid", func() string { return keys.sign("ES256", "unknown", validClaims()) }, ErrInvalidSignature),
+		Entry("with a mismatching key algorithm", func() string { return keys.sign("ES256", "rsa", validClaims()) },
+			ErrInvalidSignature),
+		Entry("with a key meant for encryption", func() string { return keys.sign("RS256", "enc", validClaims()) },
+			ErrInvalidSignature),
+		Entry("with a modified payload", func() string {
+			parts := strings.Split(keys.sign("ES256", "ec", validClaims()), ".")
+			claims := validClaims()
+			claims["sub"] = "repo:evil/repo"
+			parts[1] = strings.Split(keys.sign("ES256", "ec", claims), ".")[1]
+			return strings.Join(parts, ".")
+		}, ErrInvalidSignature),
+		Entry("with a malformed token", func() string { return "not-a-token" }, ErrInvalidToken),
+	)
+})
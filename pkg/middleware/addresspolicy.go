@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/net/publicsuffix"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+// reservedPrefixes are the private and reserved ranges that must not be
+// published on public zones.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private
+	netip.MustParsePrefix("100.64.0.0/10"),   // CGNAT
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link-local
+	netip.MustParsePrefix("172.16.0.0/12"),   // private
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.168.0.0/16"),  // private
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved and broadcast
+	netip.MustParsePrefix("::/128"),          // unspecified
+	netip.MustParsePrefix("::1/128"),         // loopback
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link-local
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// IsReservedAddress reports whether addr is a private or reserved address.
+func IsReservedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range reservedPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddressPolicy returns an error if policy does not allow addr for
+// fqdn. Only zones under ICANN-managed public suffixes are public, names
+// under private TLDs like .lan or .internal are not restricted.
+func checkAddressPolicy(policy *config.AddressPolicy, fqdn string, addr netip.Addr) error {
+	if policy == nil || policy.Disabled || !IsReservedAddress(addr) {
+		return nil
+	}
+	if _, icann := publicsuffix.PublicSuffix(fqdn); !icann {
+		return nil
+	}
+	for i := range policy.Allow {
+		allow := &policy.Allow[i]
+		if domainsMatch(fqdn, allow.Domains) && allow.Allows(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("private or reserved ip address not allowed: %s", addr)
+}
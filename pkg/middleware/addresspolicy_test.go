@@ -0,0 +1,100 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+var _ = Describe("AddressPolicy", func() {
+	DescribeTable("IsReservedAddress", func(addr string, expected bool) {
+		Expect(middleware.IsReservedAddress(netip.MustParseAddr(addr))).To(Equal(expected))
+	},
+		Entry("RFC1918", "10.0.0.1", true),
+		Entry("CGNAT", "100.64.0.1", true),
+		Entry("loopback", "127.0.0.1", true),
+		Entry("link-local", "169.254.1.1", true),
+		Entry("documentation", "203.0.113.1", true),
+		Entry("multicast", "224.0.0.1", true),
+		Entry("IPv6 loopback", "::1", true),
+		Entry("ULA", "fd00::1", true),
+		Entry("IPv6 link-local", "fe80::1", true),
+		Entry("IPv6 documentation", "2001:db8::1", true),
+		Entry("mapped private IPv4", "::ffff:192.168.0.1", true),
+		Entry("public IPv4", "1.1.1.1", false),
+		Entry("public IPv6", "2a01:4f8::1", false),
+	)
+
+	Context("binders", func() {
+		var (
+			cfg     *config.Config
+			reqData *data.ReqData
+		)
+
+		BeforeEach(func() {
+			cfg = &config.Config{
+				AddressPolicy: config.AddressPolicy{
+					Allow: []config.AddressPolicyAllow{{
+						Domains:  []string{"*.lan." + exampleDomain},
+						Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+					}},
+				},
+			}
+			reqData = nil
+		})
+
+		run := func(binder func(*config.Config) func(http.Handler) http.Handler, target string) *httptest.ResponseRecorder {
+			handler := binder(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				reqData, err = data.ReqDataFromContext(r.Context())
+				Expect(err).ToNot(HaveOccurred())
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+			req.RemoteAddr = "10.0.0.1"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		DescribeTable("BindPlain", func(hostname, ip string, expected int) {
+			Expect(run(middleware.BindPlain, "/plain/update?hostname="+hostname+"&ip="+ip).Code).To(Equal(expected))
+		},
+			Entry("allows public addresses", "a."+exampleDomain, "1.1.1.1", http.StatusNoContent),
+			Entry("rejects private addresses", "a."+exampleDomain, "10.0.0.1", http.StatusBadRequest),
+			Entry("rejects ULA addresses", "a."+exampleDomain, "fd00::1", http.StatusBadRequest),
+			Entry("allows overridden networks", "a.lan."+exampleDomain, "10.0.0.1", http.StatusNoContent),
+			Entry("rejects other networks on overridden domains", "a.lan."+exampleDomain, "192.168.0.1", http.StatusBadRequest),
+			Entry("allows private addresses on private zones", "router.home.lan", "192.168.0.1", http.StatusNoContent),
+		)
+
+		It("allows private addresses if disabled", func() {
+			cfg.AddressPolicy.Disabled = true
+			Expect(run(middleware.BindPlain, "/plain/update?hostname=a."+exampleDomain+"&ip=10.0.0.1").Code).
+				To(Equal(http.StatusNoContent))
+		})
+
+		It("responds with dnserr on the nic update endpoint", func() {
+			rec := run(middleware.BindNicUpdate, "/nic/update?hostname=a."+exampleDomain)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(Equal("dnserr"))
+			Expect(reqData).To(BeNil())
+		})
+
+		DescribeTable("BindDirectAdmin", func(recordType, value string, expected int) {
+			target := "/directadmin/CMD_API_DNS_CONTROL?domain=" + exampleDomain + "&action=add&name=a&type=" + recordType + "&value=" + value
+			Expect(run(middleware.BindDirectAdmin, target).Code).To(Equal(expected))
+		},
+			Entry("allows public addresses", "A", "1.1.1.1", http.StatusNoContent),
+			Entry("rejects loopback addresses", "AAAA", "::1", http.StatusBadRequest),
+			Entry("does not check TXT records", "TXT", "10.0.0.1", http.StatusNoContent),
+		)
+	})
+})
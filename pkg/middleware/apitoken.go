@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+type apiTokenKey struct{}
+
+// NewAPITokenAuth authenticates requests by the API token returned by token
+// and passes it on in the request context. Requests without a valid token
+// are handled by onUnauthorized and count towards the lockout.
+func NewAPITokenAuth(
+	cfg *config.Config, lockout *ratelimit.Lockout, token func(r *http.Request) string, onUnauthorized http.HandlerFunc,
+) func(http.Handler) http.Handler {
+	lookup := func(t string) *config.APIToken {
+		return LookupAPIToken(cfg.Auth.APITokens, t)
+	}
+	return NewTokenAuth(lockout, lookup, token, onUnauthorized)
+}
+
+// NewTokenAuth authenticates requests like NewAPITokenAuth, with the API
+// token returned by lookup for the token returned by token.
+func NewTokenAuth(
+	lockout *ratelimit.Lockout, lookup func(token string) *config.APIToken, token func(r *http.Request) string,
+	onUnauthorized http.HandlerFunc,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isLockedOut(r, lockout) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			t := lookup(token(r))
+			if t == nil {
+				addr := sanitize.LogValue(r.RemoteAddr)
+				//nolint:gosec // value is sanitized above
+				log.Printf("client '%s' sent an invalid API token", addr)
+				recordAuthFailure(r, lockout)
+				onUnauthorized(w, r)
+				return
+			}
+
+			lockout.Reset(r.RemoteAddr)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, t)))
+		})
+	}
+}
+
+// LookupAPIToken returns the entry of tokens with token, or nil if there is
+// none. All tokens are compared to not leak which ones exist.
+func LookupAPIToken(tokens []config.APIToken, token string) *config.APIToken {
+	var found *config.APIToken
+	if token == "" {
+		return nil
+	}
+	for i := range tokens {
+		if constantTimeEqual(tokens[i].Token, token) == 1 {
+			found = &tokens[i]
+		}
+	}
+	return found
+}
+
+// APITokenFromContext returns the API token authenticated by
+// NewAPITokenAuth.
+func APITokenFromContext(ctx context.Context) *config.APIToken {
+	t, _ := ctx.Value(apiTokenKey{}).(*config.APIToken)
+	return t
+}
+
+// APITokenLimitUser returns the user the changes made with the API token of
+// r count towards in the per-user rate limit, apart from users of the same
+// name.
+func APITokenLimitUser(r *http.Request) string {
+	return "token:" + APITokenFromContext(r.Context()).Name
+}
+
+// APITokenAllows reports whether t may change records of fqdn with
+// recordType from remoteAddr.
+func APITokenAllows(auth *config.Auth, t *config.APIToken, fqdn, recordType, remoteAddr string) bool {
+	return CheckGrant(auth, t.Domains, t.Roles, fqdn, recordType, remoteAddr)
+}
+
+// APITokenRulesAllow reports whether changing the records of fqdn with
+// recordType to value with the API token of r satisfies all auth.rules,
+// which see the name of the API token as username.
+func APITokenRulesAllow(cfg *config.Config, r *http.Request, endpoint, fqdn, recordType, value string) bool {
+	t := APITokenFromContext(r.Context())
+	return CheckRules(cfg, NewRuleData(fqdn, recordType, value), endpoint, r.RemoteAddr, t.Name)
+}
+
+// APITokenCoversZone reports whether t may change any record of zone from
+// remoteAddr, i.e. whether the zone is visible to its clients.
+func APITokenCoversZone(auth *config.Auth, t *config.APIToken, zone, remoteAddr string) bool {
+	if zoneCovered(zone, t.Domains) {
+		return true
+	}
+	addr := parseAddr(remoteAddr)
+	for _, name := range t.Roles {
+		if role, ok := auth.Roles[name]; ok && role.AllowsNetwork(addr) && zoneCovered(zone, role.Domains) {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneCovered reports whether one of domains is zone, a name in zone or a
+// wildcard matching zone.
+func zoneCovered(zone string, domains []string) bool {
+	for _, domain := range domains {
+		if domain == zone || strings.HasSuffix(domain, "."+zone) || IsSubDomain(zone, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+var _ = Describe("APIToken", func() {
+	const (
+		token    = "secret-token"
+		clientIP = "192.0.2.1"
+	)
+
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Roles: map[string]*config.Role{
+					"lan": {
+						Domains:  []string{"*.lan." + testDomain},
+						Prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+					},
+				},
+				APITokens: []config.APIToken{
+					{Name: "team-a", Token: token, Domains: []string{"*." + exampleDomain}},
+					{Name: "team-b", Token: "other-token", Roles: []string{"lan"}},
+				},
+			},
+		}
+	})
+
+	Context("NewAPITokenAuth", func() {
+		var lockout *ratelimit.Lockout
+
+		BeforeEach(func() {
+			lockout = ratelimit.NewLockout(2, time.Hour, time.Hour)
+		})
+
+		run := func(header string) (*httptest.ResponseRecorder, *config.APIToken) {
+			var authenticated *config.APIToken
+			handler := middleware.NewAPITokenAuth(cfg, lockout, func(r *http.Request) string {
+				return r.Header.Get("X-Token")
+			}, func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authenticated = middleware.APITokenFromContext(r.Context())
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.RemoteAddr = clientIP
+			if header != "" {
+				req.Header.Set("X-Token", header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec, authenticated
+		}
+
+		It("should pass the token on", func() {
+			rec, t := run(token)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			Expect(t).ToNot(BeNil())
+			Expect(t.Name).To(Equal("team-a"))
+		})
+
+		It("should reject missing tokens", func() {
+			rec, _ := run("")
+			Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("should lock out clients sending invalid tokens", func() {
+			for range 2 {
+				rec, _ := run("invalid")
+				Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+			}
+			rec, _ := run(token)
+			Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		})
+	})
+
+	DescribeTable("APITokenAllows", func(tokenIndex int, fqdn, remoteAddr string, expected bool) {
+		Expect(middleware.APITokenAllows(&cfg.Auth, &cfg.Auth.APITokens[tokenIndex], fqdn, "A", remoteAddr)).To(Equal(expected))
+	},
+		Entry("domain of the token", 0, "a."+exampleDomain, clientIP, true),
+		Entry("other domain", 0, "a."+testDomain, clientIP, false),
+		Entry("domain of a role", 1, "a.lan."+testDomain, clientIP, true),
+		Entry("domain of a role from another network", 1, "a.lan."+testDomain, "198.51.100.1", false),
+	)
+
+	DescribeTable("APITokenCoversZone", func(tokenIndex int, zone, remoteAddr string, expected bool) {
+		Expect(middleware.APITokenCoversZone(&cfg.Auth, &cfg.Auth.APITokens[tokenIndex], zone, remoteAddr)).To(Equal(expected))
+	},
+		Entry("zone of a wildcard", 0, exampleDomain, clientIP, true),
+		Entry("other zone", 0, testDomain, clientIP, false),
+		Entry("zone of a role", 1, testDomain, clientIP, true),
+		Entry("zone of a role from another network", 1, testDomain, "198.51.100.1", false),
+	)
+})
@@ -10,6 +10,7 @@ import (
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/htpasswd"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
@@ -24,16 +25,17 @@ func NewAuthorizer(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Han
 				return
 			}
 
-			if lockout.IsBlocked(r.RemoteAddr) {
-				logLockedOut(r.RemoteAddr)
+			if isLockedOut(r, lockout) {
 				w.WriteHeader(http.StatusTooManyRequests)
 				return
 			}
 
+			reqData.ClientCert = clientCertificate(r)
+			reqData.Claims = bearerClaims(r)
 			if !CheckPermission(cfg, reqData, r.RemoteAddr) {
 				logPermissionDenied(r.RemoteAddr, reqData)
-				lockout.RecordFailure(r.RemoteAddr)
-				if cfg.Auth.Method != config.AuthMethodAllowedDomains && reqData.BasicAuth {
+				recordAuthFailure(r, lockout)
+				if authMethodUsesUsers(cfg.Auth.Method) && reqData.BasicAuth {
 					w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 				}
 				w.WriteHeader(http.StatusUnauthorized)
@@ -41,11 +43,30 @@ func NewAuthorizer(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Han
 			}
 
 			lockout.Reset(r.RemoteAddr)
+			reqData.AuthUser = authenticatedUser(cfg, reqData)
+			if !checkRules(cfg, reqData, r) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+func isLockedOut(r *http.Request, lockout *ratelimit.Lockout) bool {
+	if isExempt(r) || !lockout.IsBlocked(r.RemoteAddr) {
+		return false
+	}
+	logLockedOut(r.RemoteAddr)
+	return true
+}
+
+func recordAuthFailure(r *http.Request, lockout *ratelimit.Lockout) {
+	if !isExempt(r) {
+		lockout.RecordFailure(r.RemoteAddr)
+	}
+}
+
 func logLockedOut(remoteAddr string) {
 	addr := sanitize.LogValue(remoteAddr)
 	//nolint:gosec // value is sanitized above
@@ -67,27 +88,56 @@ func CheckPermission(cfg *config.Config, reqData *data.ReqData, remoteAddr strin
 		return false
 	}
 
-	allowedAllowedDomains := CheckAllowedDomains(reqData.FullName, remoteAddr, cfg.Auth.AllowedDomains)
+	allowedAllowedDomains := CheckAllowedDomains(reqData.FullName, remoteAddr, cfg.Auth.AllowedDomains) ||
+		checkAllowedNetworks(cfg, reqData, remoteAddr)
 	if cfg.Auth.Method == config.AuthMethodAllowedDomains {
 		return allowedAllowedDomains
 	}
 
-	allowedUsers := CheckUsers(reqData.FullName, reqData.Username, reqData.Password, cfg.Auth.Users)
+	allowedUsers := CheckUsers(reqData.FullName, reqData.Username, reqData.Password, authUsers(cfg)) ||
+		checkUserRoles(cfg, reqData, remoteAddr)
 	if cfg.Auth.Method == config.AuthMethodUsers {
 		return allowedUsers
 	}
 
+	// Client certificates and bearer tokens are credentials like users, with
+	// both and any they are an alternative to a username and password.
+	allowedClientCert := CheckClientCert(reqData.FullName, reqData.ClientCert, cfg.Auth.ClientCerts)
+	if cfg.Auth.Method == config.AuthMethodClientCert {
+		return allowedClientCert
+	}
+
+	allowedJWT := CheckJWT(reqData.FullName, reqData.Type, reqData.Claims, cfg.Auth.JWT)
+	if cfg.Auth.Method == config.AuthMethodJWT {
+		return allowedJWT
+	}
+
+	allowedCredentials := allowedUsers || allowedClientCert || allowedJWT
 	if cfg.Auth.Method == config.AuthMethodBoth {
-		return allowedAllowedDomains && allowedUsers
+		return allowedAllowedDomains && allowedCredentials
 	}
 
 	if cfg.Auth.Method == config.AuthMethodAny {
-		return allowedAllowedDomains || allowedUsers
+		return allowedAllowedDomains || allowedCredentials
 	}
 
 	return false
 }
 
+// credentialsName returns the name of the valid credentials of a request,
+// or an empty string if it has none.
+func credentialsName(cfg *config.Config, reqData *data.ReqData) string {
+	switch {
+	case checkUserCredentials(reqData.Username, reqData.Password, authUsers(cfg)):
+		return reqData.Username
+	case checkClientCertKnown(reqData.ClientCert, cfg.Auth.ClientCerts):
+		return clientCertName(reqData.ClientCert)
+	case cfg.Auth.JWT != nil && reqData.Claims != nil:
+		return jwtName(reqData.Claims)
+	}
+	return ""
+}
+
 func CheckAllowedDomains(fqdn, clientIP string, allowedDomains config.AllowedDomains) bool {
 	for domain, ipNets := range allowedDomains {
 		if fqdn != domain && !IsSubDomain(fqdn, domain) {
@@ -104,21 +154,73 @@ func CheckAllowedDomains(fqdn, clientIP string, allowedDomains config.AllowedDom
 }
 
 func CheckUsers(fqdn, username, password string, users []config.User) bool {
-	if fqdn == "" || username == "" || password == "" {
+	if fqdn == "" {
 		return false
 	}
-	allowed := 0
-	for _, user := range users {
-		credsMatch := constantTimeEqual(user.Username, username) & constantTimeEqual(user.Password, password)
-		domainMatch := 0
-		for _, domain := range user.Domains {
-			if fqdn == domain || IsSubDomain(fqdn, domain) {
-				domainMatch = 1
+	user := matchUser(users, username, password)
+	return user != nil && domainsMatch(fqdn, user.Domains)
+}
+
+// authUsers returns the users of auth.users and auth.usersFile.
+func authUsers(cfg *config.Config) []config.User {
+	return cfg.Auth.AllUsers()
+}
+
+// LookupUser returns the user of auth.users or auth.usersFile named
+// username, or nil if there is none.
+func LookupUser(cfg *config.Config, username string) *config.User {
+	users := authUsers(cfg)
+	i := slices.IndexFunc(users, func(u config.User) bool {
+		return u.Username == username
+	})
+	if i < 0 {
+		return nil
+	}
+	return &users[i]
+}
+
+// dummyHash is verified instead of the hash of a user if no user with a
+// hashed password has the username of a request.
+const dummyHash = "$2a$10$uMncD9tUrdUrq8ZS47Kak..2wy79/qbIrknHhsKjLix4b/Lk3/o2y"
+
+// matchUser returns the user of users with username and password, or nil if
+// there is none. All usernames are compared, and if any user has a password
+// hash exactly one hash is verified, so that the response time does not
+// reveal which usernames exist.
+func matchUser(users []config.User, username, password string) *config.User {
+	if username == "" || password == "" {
+		return nil
+	}
+	var plain, hashed *config.User
+	hasHashes := false
+	for i := range users {
+		user := &users[i]
+		hasHashes = hasHashes || user.PasswordHash != ""
+		if constantTimeEqual(user.Username, username) == 0 {
+			continue
+		}
+		switch {
+		case user.PasswordHash != "":
+			if hashed == nil {
+				hashed = user
+			}
+		case user.Password != "" && constantTimeEqual(user.Password, password) == 1:
+			if plain == nil {
+				plain = user
 			}
 		}
-		allowed |= credsMatch & domainMatch
 	}
-	return allowed == 1
+	if !hasHashes {
+		return plain
+	}
+	hash := dummyHash
+	if hashed != nil {
+		hash = hashed.PasswordHash
+	}
+	if htpasswd.Verify(hash, password) && hashed != nil {
+		return hashed
+	}
+	return plain
 }
 
 func constantTimeEqual(a, b string) int {
@@ -7,10 +7,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 
 	"golang.org/x/net/publicsuffix"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 )
 
@@ -18,247 +20,296 @@ const (
 	recordTypeA           = "A"
 	recordTypeAAAA        = "AAAA"
 	recordTypeTXT         = "TXT"
+	prefixAcmeChallenge   = "_acme-challenge."
+	acmeChallengeLen      = 43 // base64url encoded SHA-256 digest
 	failedParseRequestFmt = "failed to parse request: %v"
 	maxRequestBodySize    = 1 << 10 // 1 KB
 )
 
-func BindPlain(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-		if err := r.ParseForm(); err != nil {
-			log.Printf(failedParseRequestFmt, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		hostname := r.Form.Get("hostname")
-		ip := r.Form.Get("ip")
-		if hostname == "" || ip == "" {
-			http.Error(w, "hostname or ip address is missing", http.StatusBadRequest)
-			return
-		}
-
-		parsedIP := net.ParseIP(ip)
-		if parsedIP == nil {
-			http.Error(w, "invalid ip address", http.StatusBadRequest)
-			return
-		}
-
-		recordType := recordTypeA
-		if parsedIP.To4() == nil {
-			recordType = recordTypeAAAA
-		}
-
-		name, zone, err := SplitFQDN(hostname)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		username, password, _ := r.BasicAuth()
-		next.ServeHTTP(
-			w, r.WithContext(
-				data.NewContextWithReqData(
-					r.Context(),
-					&data.ReqData{
-						FullName:  hostname,
-						Name:      name,
-						Zone:      zone,
-						Value:     ip,
-						Type:      recordType,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
-					},
+func BindPlain(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+			if err := r.ParseForm(); err != nil {
+				log.Printf(failedParseRequestFmt, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			hostname := r.Form.Get("hostname")
+			ip := r.Form.Get("ip")
+			if hostname == "" || ip == "" {
+				http.Error(w, "hostname or ip address is missing", http.StatusBadRequest)
+				return
+			}
+
+			parsedIP := net.ParseIP(ip)
+			if parsedIP == nil {
+				http.Error(w, "invalid ip address", http.StatusBadRequest)
+				return
+			}
+
+			recordType := recordTypeA
+			if parsedIP.To4() == nil {
+				recordType = recordTypeAAAA
+			}
+
+			if err := ValidateValue(&cfg.AddressPolicy, hostname, ip, recordType); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			name, zone, err := SplitFQDN(hostname)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			username, password, _ := r.BasicAuth()
+			next.ServeHTTP(
+				w, r.WithContext(
+					data.NewContextWithReqData(
+						r.Context(),
+						&data.ReqData{
+							FullName:  hostname,
+							Name:      name,
+							Zone:      zone,
+							Value:     ip,
+							Type:      recordType,
+							Username:  username,
+							Password:  password,
+							BasicAuth: true,
+						},
+					),
 				),
-			),
-		)
-	})
+			)
+		})
+	}
 }
 
-func BindAcmeDNS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-		d := &struct {
-			Subdomain string `json:"subdomain"`
-			TXT       string `json:"txt"`
-		}{}
-		if err := json.NewDecoder(r.Body).Decode(d); err != nil {
-			log.Printf(failedParseRequestFmt, err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		if d.Subdomain == "" || d.TXT == "" {
-			http.Error(w, "subdomain or txt is missing", http.StatusBadRequest)
-			return
-		}
-
-		name, zone, err := SplitFQDN(d.Subdomain)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		// prepend prefix if not already given
-		const prefixAcmeChallenge = "_acme-challenge."
-		if !strings.HasPrefix(d.Subdomain, prefixAcmeChallenge) {
-			d.Subdomain = prefixAcmeChallenge + d.Subdomain
-			name = prefixAcmeChallenge + name
-		}
-
-		next.ServeHTTP(
-			w, r.WithContext(
-				data.NewContextWithReqData(
-					r.Context(),
-					&data.ReqData{
-						FullName:  d.Subdomain,
-						Name:      name,
-						Zone:      zone,
-						Value:     d.TXT,
-						Type:      recordTypeTXT,
-						Username:  r.Header.Get("X-Api-User"),
-						Password:  r.Header.Get("X-Api-Key"),
-						BasicAuth: false,
-					},
+func BindAcmeDNS(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+			d := &struct {
+				Subdomain string `json:"subdomain"`
+				TXT       string `json:"txt"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(d); err != nil {
+				log.Printf(failedParseRequestFmt, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if d.Subdomain == "" || d.TXT == "" {
+				http.Error(w, "subdomain or txt is missing", http.StatusBadRequest)
+				return
+			}
+
+			name, zone, err := SplitFQDN(d.Subdomain)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			// prepend prefix if not already given
+			if !strings.HasPrefix(d.Subdomain, prefixAcmeChallenge) {
+				d.Subdomain = prefixAcmeChallenge + d.Subdomain
+				name = prefixAcmeChallenge + name
+			}
+
+			if cfg.AcmeStrict {
+				if err := validateACMEChallenge(d.Subdomain, d.TXT); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			next.ServeHTTP(
+				w, r.WithContext(
+					data.NewContextWithReqData(
+						r.Context(),
+						&data.ReqData{
+							FullName:  d.Subdomain,
+							Name:      name,
+							Zone:      zone,
+							Value:     d.TXT,
+							Type:      recordTypeTXT,
+							Username:  r.Header.Get("X-Api-User"),
+							Password:  r.Header.Get("X-Api-Key"),
+							BasicAuth: false,
+						},
+					),
 				),
-			),
-		)
-	})
+			)
+		})
+	}
 }
 
-func BindHTTPReq(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-		d := &struct {
-			FQDN  string `json:"fqdn"`
-			Value string `json:"value"`
-		}{}
-		if err := json.NewDecoder(r.Body).Decode(d); err != nil {
-			log.Printf(failedParseRequestFmt, err)
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-
-		if d.FQDN == "" {
-			http.Error(w, "fqdn is missing", http.StatusBadRequest)
-			return
-		}
-
-		if d.Value == "" {
-			http.Error(w, "value is missing", http.StatusBadRequest)
-			return
-		}
-
-		d.FQDN = strings.TrimRight(d.FQDN, ".")
-		name, zone, err := SplitFQDN(d.FQDN)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		username, password, _ := r.BasicAuth()
-		next.ServeHTTP(
-			w, r.WithContext(
-				data.NewContextWithReqData(
-					r.Context(),
-					&data.ReqData{
-						FullName:  d.FQDN,
-						Name:      name,
-						Zone:      zone,
-						Value:     d.Value,
-						Type:      recordTypeTXT,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
-					},
+func BindHTTPReq(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+			d := &struct {
+				FQDN  string `json:"fqdn"`
+				Value string `json:"value"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(d); err != nil {
+				log.Printf(failedParseRequestFmt, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if d.FQDN == "" {
+				http.Error(w, "fqdn is missing", http.StatusBadRequest)
+				return
+			}
+
+			if d.Value == "" {
+				http.Error(w, "value is missing", http.StatusBadRequest)
+				return
+			}
+
+			d.FQDN = strings.TrimRight(d.FQDN, ".")
+			name, zone, err := SplitFQDN(d.FQDN)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if cfg.AcmeStrict {
+				if err := validateACMEChallenge(d.FQDN, d.Value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+
+			username, password, _ := r.BasicAuth()
+			next.ServeHTTP(
+				w, r.WithContext(
+					data.NewContextWithReqData(
+						r.Context(),
+						&data.ReqData{
+							FullName:  d.FQDN,
+							Name:      name,
+							Zone:      zone,
+							Value:     d.Value,
+							Type:      recordTypeTXT,
+							Username:  username,
+							Password:  password,
+							BasicAuth: true,
+						},
+					),
 				),
-			),
-		)
-	})
+			)
+		})
+	}
 }
 
-func BindDirectAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-		if err := r.ParseForm(); err != nil {
-			log.Printf(failedParseRequestFmt, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		domain := r.Form.Get("domain")
-		action := r.Form.Get("action")
-		if domain == "" || action == "" {
-			http.Error(w, "domain or action is missing", http.StatusBadRequest)
-			return
-		}
-
-		if action != "add" {
-			StatusOkDirectAdmin(next).ServeHTTP(w, r)
-			return
-		}
-
-		recordType := r.Form.Get("type")
-		if recordType != recordTypeA && recordType != recordTypeAAAA && recordType != recordTypeTXT {
-			http.Error(w, "type can only be A, AAAA or TXT", http.StatusBadRequest)
-			return
-		}
-
-		value := r.Form.Get("value")
-		if err := validateValue(value, recordType); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		fqdn := domain
-		if name := r.Form.Get("name"); name != "" {
-			fqdn = name + "." + domain
-		}
-
-		name, zone, err := SplitFQDN(fqdn)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		username, password, _ := r.BasicAuth()
-		next.ServeHTTP(
-			w, r.WithContext(
-				data.NewContextWithReqData(
-					r.Context(),
-					&data.ReqData{
-						FullName:  fqdn,
-						Name:      name,
-						Zone:      zone,
-						Value:     value,
-						Type:      recordType,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
-					},
+func BindDirectAdmin(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+			if err := r.ParseForm(); err != nil {
+				log.Printf(failedParseRequestFmt, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			domain := r.Form.Get("domain")
+			action := r.Form.Get("action")
+			if domain == "" || action == "" {
+				http.Error(w, "domain or action is missing", http.StatusBadRequest)
+				return
+			}
+
+			if action != "add" {
+				StatusOkDirectAdmin(next).ServeHTTP(w, r)
+				return
+			}
+
+			recordType := r.Form.Get("type")
+			if recordType != recordTypeA && recordType != recordTypeAAAA && recordType != recordTypeTXT {
+				http.Error(w, "type can only be A, AAAA or TXT", http.StatusBadRequest)
+				return
+			}
+
+			fqdn := domain
+			if name := r.Form.Get("name"); name != "" {
+				fqdn = name + "." + domain
+			}
+
+			value := r.Form.Get("value")
+			if err := ValidateValue(&cfg.AddressPolicy, fqdn, value, recordType); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			name, zone, err := SplitFQDN(fqdn)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			username, password, _ := r.BasicAuth()
+			next.ServeHTTP(
+				w, r.WithContext(
+					data.NewContextWithReqData(
+						r.Context(),
+						&data.ReqData{
+							FullName:  fqdn,
+							Name:      name,
+							Zone:      zone,
+							Value:     value,
+							Type:      recordType,
+							Username:  username,
+							Password:  password,
+							BasicAuth: true,
+						},
+					),
 				),
-			),
-		)
-	})
+			)
+		})
+	}
 }
 
-func validateValue(value, recordType string) error {
-	if recordType == recordTypeA || recordType == recordTypeAAAA {
-		parsedIP := net.ParseIP(value)
-		if parsedIP == nil {
-			return errors.New("invalid ip address")
-		}
-		if recordType == recordTypeA && parsedIP.To4() == nil {
-			return errors.New("invalid ipv4 address")
-		}
-		if recordType == recordTypeAAAA && parsedIP.To4() != nil {
-			return errors.New("invalid ipv6 address")
-		}
+// ValidateValue checks that value is valid for recordType and that A and
+// AAAA values of fqdn are allowed by policy.
+func ValidateValue(policy *config.AddressPolicy, fqdn, value, recordType string) error {
+	if recordType != recordTypeA && recordType != recordTypeAAAA {
+		return nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil || addr.Zone() != "" {
+		return errors.New("invalid ip address")
+	}
+	if recordType == recordTypeA && !addr.Unmap().Is4() {
+		return errors.New("invalid ipv4 address")
+	}
+	if recordType == recordTypeAAAA && addr.Unmap().Is4() {
+		return errors.New("invalid ipv6 address")
+	}
+	return checkAddressPolicy(policy, fqdn, addr)
+}
+
+// validateACMEChallenge checks that fqdn is an ACME challenge name and that
+// value is a key authorization digest as defined by RFC 8555, section 8.4.
+func validateACMEChallenge(fqdn, value string) error {
+	if !strings.HasPrefix(fqdn, prefixAcmeChallenge) {
+		return fmt.Errorf("name must start with %s", prefixAcmeChallenge)
+	}
+	if len(value) != acmeChallengeLen || strings.ContainsFunc(value, func(c rune) bool {
+		return !isBase64URL(c)
+	}) {
+		return errors.New("value must be a 43 character base64url digest")
 	}
 	return nil
 }
 
+func isBase64URL(c rune) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
 func SplitFQDN(fqdn string) (name, zone string, err error) {
 	zone, err = publicsuffix.EffectiveTLDPlusOne(fqdn)
 	if err != nil {
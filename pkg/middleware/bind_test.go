@@ -1,9 +1,14 @@
 package middleware_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
 )
 
@@ -32,3 +37,48 @@ var _ = Describe("SplitFQDN", func() {
 		Expect(zone).To(BeEmpty())
 	})
 })
+
+var _ = Describe("ACME strict mode", func() {
+	const digest = "LPJNul-wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ"
+
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{AcmeStrict: true}
+	})
+
+	run := func(binder func(*config.Config) func(http.Handler) http.Handler, target, body string) int {
+		handler := binder(cfg)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	DescribeTable("BindHTTPReq", func(fqdn, value string, expected int) {
+		body := `{"fqdn":"` + fqdn + `","value":"` + value + `"}`
+		Expect(run(middleware.BindHTTPReq, "/httpreq/present", body)).To(Equal(expected))
+	},
+		Entry("allows challenges", "_acme-challenge.a."+exampleDomain+".", digest, http.StatusNoContent),
+		Entry("rejects other names", "a."+exampleDomain, digest, http.StatusBadRequest),
+		Entry("rejects SPF values", "_acme-challenge.a."+exampleDomain, "v=spf1 -all", http.StatusBadRequest),
+		Entry("rejects short values", "_acme-challenge.a."+exampleDomain, digest[1:], http.StatusBadRequest),
+		Entry("rejects padded values", "_acme-challenge.a."+exampleDomain, digest[1:]+"=", http.StatusBadRequest),
+	)
+
+	DescribeTable("BindAcmeDNS", func(value string, expected int) {
+		body := `{"subdomain":"a.` + exampleDomain + `","txt":"` + value + `"}`
+		Expect(run(middleware.BindAcmeDNS, "/acmedns/update", body)).To(Equal(expected))
+	},
+		Entry("allows challenges", digest, http.StatusNoContent),
+		Entry("rejects other values", "v=DMARC1; p=none", http.StatusBadRequest),
+	)
+
+	It("accepts any value if disabled", func() {
+		cfg.AcmeStrict = false
+		body := `{"fqdn":"a.` + exampleDomain + `","value":"v=spf1 -all"}`
+		Expect(run(middleware.BindHTTPReq, "/httpreq/present", body)).To(Equal(http.StatusNoContent))
+	})
+})
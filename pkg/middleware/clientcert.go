@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+)
+
+// clientCertificate returns the client certificate of r if it was verified
+// against tls.clientCA during the handshake.
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func CheckClientCert(fqdn string, cert *x509.Certificate, clientCerts []config.ClientCert) bool {
+	if fqdn == "" || cert == nil {
+		return false
+	}
+	for i := range clientCerts {
+		if !clientCertMatches(&clientCerts[i], cert) {
+			continue
+		}
+		for _, domain := range clientCerts[i].Domains {
+			if fqdn == domain || IsSubDomain(fqdn, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkClientCertKnown(cert *x509.Certificate, clientCerts []config.ClientCert) bool {
+	if cert == nil {
+		return false
+	}
+	for i := range clientCerts {
+		if clientCertMatches(&clientCerts[i], cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func getDomainsFromClientCert(clientCerts []config.ClientCert, cert *x509.Certificate) map[string]struct{} {
+	domains := map[string]struct{}{}
+	if cert == nil {
+		return domains
+	}
+	for i := range clientCerts {
+		if clientCertMatches(&clientCerts[i], cert) {
+			for _, domain := range clientCerts[i].Domains {
+				domains[domain] = struct{}{}
+			}
+		}
+	}
+	return domains
+}
+
+func clientCertMatches(cc *config.ClientCert, cert *x509.Certificate) bool {
+	switch {
+	case cc.Subject != "":
+		return cert.Subject.String() == cc.Subject
+	case cc.DNSName != "":
+		return slices.Contains(cert.DNSNames, cc.DNSName)
+	case cc.SPIFFEID != "":
+		return slices.ContainsFunc(cert.URIs, func(uri *url.URL) bool {
+			return uri.String() == cc.SPIFFEID
+		})
+	}
+	return false
+}
+
+// clientCertName identifies a client certificate in logs and rate limits.
+func clientCertName(cert *x509.Certificate) string {
+	return cert.Subject.String()
+}
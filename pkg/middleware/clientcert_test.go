@@ -0,0 +1,147 @@
+package middleware_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+const (
+	certSubject  = "CN=sensor-1,O=Example"
+	certDNSName  = "sensor-1.devices.example.net"
+	certSPIFFEID = "spiffe://example.net/sensor/1"
+)
+
+func newClientCert() *x509.Certificate {
+	return &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "sensor-1", Organization: []string{"Example"}},
+		DNSNames: []string{certDNSName},
+		URIs:     []*url.URL{{Scheme: "spiffe", Host: "example.net", Path: "/sensor/1"}},
+	}
+}
+
+var _ = Describe("CheckClientCert", func() {
+	DescribeTable("should allow access", func(clientCert config.ClientCert, fqdn string) {
+		Expect(middleware.CheckClientCert(fqdn, newClientCert(), []config.ClientCert{clientCert})).To(BeTrue())
+	},
+		Entry("by subject", config.ClientCert{Subject: certSubject, Domains: []string{exampleDomain}}, exampleDomain),
+		Entry("by DNS name", config.ClientCert{DNSName: certDNSName, Domains: []string{exampleDomain}}, exampleDomain),
+		Entry("by SPIFFE ID", config.ClientCert{SPIFFEID: certSPIFFEID, Domains: []string{exampleDomain}}, exampleDomain),
+		Entry("with a wildcard domain",
+			config.ClientCert{Subject: certSubject, Domains: []string{wildcardExample}}, subExampleDomain),
+	)
+
+	DescribeTable("should deny access", func(clientCert config.ClientCert, fqdn string, cert *x509.Certificate) {
+		Expect(middleware.CheckClientCert(fqdn, cert, []config.ClientCert{clientCert})).To(BeFalse())
+	},
+		Entry("without certificate",
+			config.ClientCert{Subject: certSubject, Domains: []string{exampleDomain}}, exampleDomain, nil),
+		Entry("with a different subject",
+			config.ClientCert{Subject: "CN=sensor-2,O=Example", Domains: []string{exampleDomain}}, exampleDomain, newClientCert()),
+		Entry("with a different DNS name",
+			config.ClientCert{DNSName: "sensor-2.devices.example.net", Domains: []string{exampleDomain}}, exampleDomain, newClientCert()),
+		Entry("with a different SPIFFE ID",
+			config.ClientCert{SPIFFEID: "spiffe://example.net/sensor/2", Domains: []string{exampleDomain}}, exampleDomain, newClientCert()),
+		Entry("with a domain not granted",
+			config.ClientCert{Subject: certSubject, Domains: []string{exampleDomain}}, testDomain, newClientCert()),
+		Entry("without domain",
+			config.ClientCert{Subject: certSubject, Domains: []string{exampleDomain}}, "", newClientCert()),
+	)
+})
+
+var _ = Describe("Client certificate authentication", func() {
+	const ip = "127.0.0.1"
+
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodClientCert,
+				AllowedDomains: config.AllowedDomains{testDomain: []*net.IPNet{{
+					IP:   net.IPv4(127, 0, 0, 1),
+					Mask: net.IPv4Mask(255, 255, 255, 255),
+				}}},
+				Users: []config.User{{
+					Username: username,
+					Password: password,
+					Domains:  []string{testDomain},
+				}},
+				ClientCerts: []config.ClientCert{{
+					SPIFFEID: certSPIFFEID,
+					Domains:  []string{exampleDomain},
+				}},
+			},
+		}
+	})
+
+	DescribeTable("CheckPermission", func(method string, cert *x509.Certificate, remoteAddr string, expected bool) {
+		cfg.Auth.Method = method
+		reqData := &data.ReqData{FullName: exampleDomain, ClientCert: cert}
+		Expect(middleware.CheckPermission(cfg, reqData, remoteAddr)).To(Equal(expected))
+	},
+		Entry("allows clientCert with a matching certificate", config.AuthMethodClientCert, newClientCert(), "", true),
+		Entry("denies clientCert without certificate", config.AuthMethodClientCert, nil, ip, false),
+		Entry("allows any with a matching certificate", config.AuthMethodAny, newClientCert(), "", true),
+		Entry("denies both with a matching certificate from another IP",
+			config.AuthMethodBoth, newClientCert(), "192.0.2.1", false),
+		Entry("denies users with a matching certificate", config.AuthMethodUsers, newClientCert(), ip, false),
+	)
+
+	It("allows both with a matching certificate from an allowed IP", func() {
+		cfg.Auth.Method = config.AuthMethodBoth
+		cfg.Auth.AllowedDomains[exampleDomain] = cfg.Auth.AllowedDomains[testDomain]
+		reqData := &data.ReqData{FullName: exampleDomain, ClientCert: newClientCert()}
+		Expect(middleware.CheckPermission(cfg, reqData, ip)).To(BeTrue())
+	})
+
+	DescribeTable("GetDomains", func(method string, expected map[string]struct{}) {
+		cfg.Auth.Method = method
+		Expect(middleware.GetDomains(cfg, ip, &data.ReqData{ClientCert: newClientCert()})).To(Equal(expected))
+	},
+		Entry("returns the certificate domains with clientCert", config.AuthMethodClientCert,
+			map[string]struct{}{exampleDomain: {}}),
+		Entry("merges the certificate domains with any", config.AuthMethodAny,
+			map[string]struct{}{exampleDomain: {}, testDomain: {}}),
+		Entry("intersects the certificate domains with both", config.AuthMethodBoth, map[string]struct{}{}),
+	)
+
+	It("authorizes requests with a verified client certificate", func() {
+		lockout := ratelimit.NewLockout(3, time.Hour, 15*time.Minute)
+		called := false
+		authorizer := middleware.NewAuthorizer(cfg, lockout)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			called = true
+		}))
+
+		run := func(state *tls.ConnectionState) int {
+			req := httptest.NewRequest(http.MethodGet, "/plain/update", http.NoBody)
+			req.RemoteAddr = ip
+			req.TLS = state
+			req = req.WithContext(data.NewContextWithReqData(req.Context(), &data.ReqData{FullName: exampleDomain}))
+			rec := httptest.NewRecorder()
+			authorizer.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		Expect(run(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{newClientCert()}})).
+			To(Equal(http.StatusUnauthorized))
+		Expect(called).To(BeFalse())
+
+		Expect(run(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{newClientCert()}}})).
+			To(Equal(http.StatusOK))
+		Expect(called).To(BeTrue())
+	})
+})
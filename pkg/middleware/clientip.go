@@ -6,10 +6,42 @@ import (
 	"net/netip"
 	"strings"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
 
-func NewSetClientIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+const (
+	headerForwarded     = "Forwarded"
+	headerXForwardedFor = "X-Forwarded-For"
+	headerXRealIP       = "X-Real-Ip"
+)
+
+// defaultClientIPHeaders are honored from trusted proxies unless configured
+// otherwise. The first header present in a request is used.
+var defaultClientIPHeaders = []string{headerXRealIP, headerForwarded, headerXForwardedFor}
+
+// NewSetClientIP replaces r.RemoteAddr with the client IP. If the peer is a
+// trusted proxy, the client IP is taken from the first of headers present in
+// the request, or from the headers configured for the proxy's range in
+// proxyHeaders. Forwarded and X-Forwarded-For are walked from the right,
+// skipping trusted proxies, and the first untrusted hop is used.
+func NewSetClientIP(
+	trustedProxies []netip.Prefix, headers []string, proxyHeaders []config.TrustedProxyHeaders,
+) func(http.Handler) http.Handler {
+	trusted := &netlist.Trie{}
+	for _, p := range trustedProxies {
+		trusted.Insert(p)
+	}
+	for _, ph := range proxyHeaders {
+		for _, p := range ph.Prefixes {
+			trusted.Insert(p)
+		}
+	}
+	if len(headers) == 0 {
+		headers = defaultClientIPHeaders
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
@@ -23,22 +55,8 @@ func NewSetClientIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handl
 
 			remote := addrPort.Addr()
 			r.RemoteAddr = remote.String()
-			if isTrustedProxy(trustedProxies, remote) {
-				ip := r.Header.Get("X-Real-Ip")
-				if ip == "" {
-					ipList := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
-					ip = strings.TrimSpace(ipList[0])
-				}
-				if ip != "" {
-					parsed, err := netip.ParseAddr(ip)
-					if err != nil {
-						sanitized := sanitize.LogValue(ip)
-						//nolint:gosec // sanitized is sanitized above; r.RemoteAddr is already a parsed IP
-						log.Printf("ignoring invalid forwarded client IP %q from proxy %s", sanitized, r.RemoteAddr)
-					} else {
-						r.RemoteAddr = parsed.String()
-					}
-				}
+			if trusted.Contains(remote) {
+				r.RemoteAddr = forwardedClientIP(r.Header, remote, headersFor(remote, headers, proxyHeaders), trusted).String()
 			}
 
 			next.ServeHTTP(w, r)
@@ -46,11 +64,79 @@ func NewSetClientIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handl
 	}
 }
 
-func isTrustedProxy(prefixes []netip.Prefix, addr netip.Addr) bool {
-	for _, p := range prefixes {
-		if p.Contains(addr) {
-			return true
+func headersFor(proxy netip.Addr, headers []string, proxyHeaders []config.TrustedProxyHeaders) []string {
+	for _, ph := range proxyHeaders {
+		for _, p := range ph.Prefixes {
+			if p.Contains(proxy) {
+				return ph.Headers
+			}
 		}
 	}
-	return false
+	return headers
+}
+
+// forwardedClientIP returns the client IP from the first of headers present
+// in h. If the header is invalid, the address of the nearest trusted proxy
+// is returned.
+func forwardedClientIP(h http.Header, proxy netip.Addr, headers []string, trusted *netlist.Trie) netip.Addr {
+	for _, name := range headers {
+		values := h.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+
+		switch http.CanonicalHeaderKey(name) {
+		case headerForwarded:
+			return walkHops(parseForwardedFor(values), proxy, trusted)
+		case headerXForwardedFor:
+			var hops []string
+			for _, v := range values {
+				hops = append(hops, strings.Split(v, ",")...)
+			}
+			return walkHops(hops, proxy, trusted)
+		default:
+			addr, ok := parseHop(values[0])
+			if !ok {
+				logInvalidForwardedIP(values[0], proxy)
+				return proxy
+			}
+			return addr
+		}
+	}
+	return proxy
+}
+
+// walkHops walks hops from the right, starting at proxy, and returns the
+// first hop that is not a trusted proxy. If all hops are trusted, the
+// leftmost one is returned. On an invalid hop the walk stops at the last
+// trusted one.
+func walkHops(hops []string, proxy netip.Addr, trusted *netlist.Trie) netip.Addr {
+	current := proxy
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			logInvalidForwardedIP(hops[i], current)
+			return current
+		}
+		if !trusted.Contains(addr) {
+			return addr
+		}
+		current = addr
+	}
+	return current
+}
+
+// parseHop parses a bare IP address without zone.
+func parseHop(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr, true
+}
+
+func logInvalidForwardedIP(value string, proxy netip.Addr) {
+	sanitized := sanitize.LogValue(value)
+	//nolint:gosec // sanitized is sanitized above; proxy is a parsed IP
+	log.Printf("ignoring invalid forwarded client IP %q from proxy %s", sanitized, proxy)
 }
@@ -8,6 +8,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
 )
 
@@ -29,8 +30,9 @@ var _ = Describe("SetClientIP", func() {
 		})
 	})
 
-	run := func(trustedProxies []netip.Prefix, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
-		handler := middleware.NewSetClientIP(trustedProxies)(inner)
+	runWith := func(
+		handler http.Handler, remoteAddr string, headers map[string]string,
+	) *httptest.ResponseRecorder {
 		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
 		req.RemoteAddr = remoteAddr
 		for k, v := range headers {
@@ -41,6 +43,10 @@ var _ = Describe("SetClientIP", func() {
 		return rec
 	}
 
+	run := func(trustedProxies []netip.Prefix, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		return runWith(middleware.NewSetClientIP(trustedProxies, nil, nil)(inner), remoteAddr, headers)
+	}
+
 	It("strips the port from a valid address", func() {
 		rec := run(nil, "10.0.0.1:1234", nil)
 		Expect(rec.Code).To(Equal(http.StatusOK))
@@ -70,7 +76,7 @@ var _ = Describe("SetClientIP", func() {
 		Expect(captured).To(Equal(forwardedIP))
 	})
 
-	It("honors the first X-Forwarded-For entry from a trusted proxy when X-Real-Ip is absent", func() {
+	It("honors the rightmost untrusted X-Forwarded-For entry from a trusted proxy when X-Real-Ip is absent", func() {
 		rec := run(
 			[]netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
 			"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.7, 10.0.0.1"},
@@ -79,6 +85,102 @@ var _ = Describe("SetClientIP", func() {
 		Expect(captured).To(Equal(forwardedIP))
 	})
 
+	It("ignores X-Forwarded-For entries spoofed by the client", func() {
+		rec := run(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "203.0.113.1, 192.0.2.7, 10.0.0.2"},
+		)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(captured).To(Equal(forwardedIP))
+	})
+
+	It("combines multiple X-Forwarded-For headers", func() {
+		handler := middleware.NewSetClientIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, nil, nil)(inner)
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Add("X-Forwarded-For", "203.0.113.1")
+		req.Header.Add("X-Forwarded-For", "192.0.2.7, 10.0.0.2")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(captured).To(Equal(forwardedIP))
+	})
+
+	It("uses the leftmost X-Forwarded-For entry when all hops are trusted", func() {
+		rec := run(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+		)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(captured).To(Equal("10.0.0.3"))
+	})
+
+	DescribeTable(
+		"honors the Forwarded header from a trusted proxy",
+		func(value, expected string) {
+			rec := run(
+				[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				"10.0.0.1:1234", map[string]string{"Forwarded": value},
+			)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(captured).To(Equal(expected))
+		},
+		Entry("single element", "for=192.0.2.7", forwardedIP),
+		Entry("with other parameters", "proto=https;for=192.0.2.7;by=10.0.0.1", forwardedIP),
+		Entry("with a port", `for="192.0.2.7:4711"`, forwardedIP),
+		Entry("with a quoted IPv6 address and port", `for="[2001:db8:cafe::17]:4711"`, "2001:db8:cafe::17"),
+		Entry("with a trusted hop", "for=203.0.113.1, for=192.0.2.7, for=10.0.0.2", forwardedIP),
+		Entry("with a case-insensitive parameter name", "For=192.0.2.7", forwardedIP),
+		Entry("with an obfuscated identifier", "for=192.0.2.7, for=_hidden", "10.0.0.1"),
+		Entry("with an unknown identifier", "for=unknown", "10.0.0.1"),
+		Entry("without a for parameter", "proto=https", "10.0.0.1"),
+	)
+
+	It("prefers X-Real-Ip over Forwarded and X-Forwarded-For by default", func() {
+		rec := run(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")},
+			"10.0.0.1:1234", map[string]string{
+				headerRealIP:      forwardedIP,
+				"Forwarded":       "for=203.0.113.1",
+				"X-Forwarded-For": "203.0.113.2",
+			},
+		)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(captured).To(Equal(forwardedIP))
+	})
+
+	It("honors only the configured headers", func() {
+		handler := middleware.NewSetClientIP(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}, []string{"True-Client-IP"}, nil,
+		)(inner)
+		rec := runWith(handler, "10.0.0.1:1234", map[string]string{
+			headerRealIP:     "203.0.113.1",
+			"True-Client-IP": forwardedIP,
+		})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(captured).To(Equal(forwardedIP))
+	})
+
+	It("honors headers configured per trusted proxy range", func() {
+		handler := middleware.NewSetClientIP(
+			[]netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}, nil,
+			[]config.TrustedProxyHeaders{{
+				Prefixes: []netip.Prefix{netip.MustParsePrefix("173.245.48.0/20")},
+				Headers:  []string{"CF-Connecting-IP"},
+			}},
+		)(inner)
+		headers := map[string]string{
+			headerRealIP:       "203.0.113.1",
+			"CF-Connecting-IP": forwardedIP,
+		}
+
+		rec := runWith(handler, "173.245.48.1:1234", headers)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(captured).To(Equal(forwardedIP))
+
+		rec = runWith(handler, "10.0.0.1:1234", headers)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(captured).To(Equal("203.0.113.1"))
+	})
+
 	It("ignores forwarded headers from an untrusted proxy", func() {
 		rec := run(nil, "10.0.0.1:1234", map[string]string{headerRealIP: forwardedIP})
 		Expect(rec.Code).To(Equal(http.StatusOK))
@@ -106,8 +208,8 @@ var _ = Describe("SetClientIP", func() {
 		},
 		Entry("invalid X-Real-Ip", headerRealIP, "not-an-ip"),
 		Entry("X-Real-Ip with port", headerRealIP, "192.0.2.7:80"),
-		Entry("invalid first X-Forwarded-For", "X-Forwarded-For", "bogus, 192.0.2.7"),
-		Entry("empty first X-Forwarded-For", "X-Forwarded-For", ", 192.0.2.7"),
+		Entry("invalid last X-Forwarded-For", "X-Forwarded-For", "192.0.2.7, bogus"),
+		Entry("empty last X-Forwarded-For", "X-Forwarded-For", "192.0.2.7, "),
 	)
 
 	It("normalizes IPv6 addresses", func() {
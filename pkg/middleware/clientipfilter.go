@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/netip"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+// exemptKey is the context key marking requests from exempt networks.
+type exemptKey struct{}
+
+// NewClientIPFilter rejects requests from denied networks and marks
+// requests from exempt networks, which bypass the per-client-IP rate limit
+// and the lockout. It must run after NewSetClientIP.
+func NewClientIPFilter(deny, exempt *netlist.List) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, err := netip.ParseAddr(r.RemoteAddr)
+			if err != nil {
+				addrStr := sanitize.LogValue(r.RemoteAddr)
+				//nolint:gosec // addrStr is sanitized above
+				log.Printf("failed to parse client address %s: %v", addrStr, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if deny.Contains(addr) {
+				log.Printf("client '%s' is in a denied network", addr)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			if exempt.Contains(addr) {
+				r = r.WithContext(context.WithValue(r.Context(), exemptKey{}, true))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isExempt(r *http.Request) bool {
+	exempt, _ := r.Context().Value(exemptKey{}).(bool)
+	return exempt
+}
@@ -0,0 +1,90 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+var _ = Describe("ClientIPFilter", func() {
+	const (
+		deniedIP = "192.0.2.1"
+		exemptIP = "198.51.100.1"
+		otherIP  = "203.0.113.1"
+	)
+
+	var (
+		filter    func(http.Handler) http.Handler
+		innerHits int
+		inner     http.Handler
+	)
+
+	BeforeEach(func() {
+		filter = middleware.NewClientIPFilter(
+			netlist.New([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, nil),
+			netlist.New([]netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}, nil),
+		)
+		innerHits = 0
+		inner = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			innerHits++
+			w.WriteHeader(http.StatusOK)
+		})
+	})
+
+	run := func(handler http.Handler, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	It("rejects clients in a denied network", func() {
+		Expect(run(filter(inner), deniedIP)).To(Equal(http.StatusForbidden))
+		Expect(innerHits).To(BeZero())
+	})
+
+	It("passes other clients", func() {
+		Expect(run(filter(inner), otherIP)).To(Equal(http.StatusOK))
+		Expect(innerHits).To(Equal(1))
+	})
+
+	It("exempts clients from the rate limit", func() {
+		limiter := ratelimit.NewLimiter(1, 1, time.Minute)
+		handler := filter(middleware.NewRateLimit(limiter, middleware.RateLimitExceeded)(inner))
+
+		for range 3 {
+			Expect(run(handler, exemptIP)).To(Equal(http.StatusOK))
+		}
+		Expect(run(handler, otherIP)).To(Equal(http.StatusOK))
+		Expect(run(handler, otherIP)).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("exempts clients from the lockout", func() {
+		cfg := &config.Config{Auth: config.Auth{Method: config.AuthMethodUsers}}
+		lockout := ratelimit.NewLockout(1, time.Hour, time.Hour)
+		authorizer := middleware.NewAuthorizer(cfg, lockout)(inner)
+		bind := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := data.NewContextWithReqData(r.Context(), &data.ReqData{FullName: exampleDomain})
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		}
+		handler := filter(bind(authorizer))
+
+		Expect(run(handler, exemptIP)).To(Equal(http.StatusUnauthorized))
+		Expect(run(handler, exemptIP)).To(Equal(http.StatusUnauthorized))
+		Expect(run(handler, otherIP)).To(Equal(http.StatusUnauthorized))
+		Expect(run(handler, otherIP)).To(Equal(http.StatusTooManyRequests))
+	})
+})
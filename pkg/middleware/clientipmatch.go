@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const ipv6MatchPrefixLen = 64
+
+// NewClientIPMatch rejects A and AAAA records whose value does not match the
+// client IP as required by auth.matchClientIP and the matchClientIP mode of
+// the authenticated user. It must run after the authorizer.
+func NewClientIPMatch(cfg *config.Config, onMismatch http.HandlerFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				log.Printf("%v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if reqData.Type != recordTypeA && reqData.Type != recordTypeAAAA {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !clientIPAllowed(cfg, reqData.FullName, reqData.Value, r.RemoteAddr, userClientIPModes(cfg, reqData)) {
+				onMismatch(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIPAllowed reports whether an A or AAAA record of fqdn may be set to
+// value from remoteAddr as required by auth.matchClientIP and the
+// matchClientIP mode of user, which may be nil. Mismatches are logged.
+func ClientIPAllowed(cfg *config.Config, user *config.User, fqdn, recordType, value, remoteAddr string) bool {
+	if recordType != recordTypeA && recordType != recordTypeAAAA {
+		return true
+	}
+	var modes []string
+	if user != nil {
+		modes = append(modes, user.MatchClientIP)
+	}
+	return clientIPAllowed(cfg, fqdn, value, remoteAddr, modes)
+}
+
+func clientIPAllowed(cfg *config.Config, fqdn, value, remoteAddr string, userModes []string) bool {
+	mode := clientIPMatchMode(cfg, fqdn, userModes)
+	if mode == "" || ClientIPMatches(mode, value, remoteAddr) {
+		return true
+	}
+	addr := sanitize.LogValue(remoteAddr)
+	v := sanitize.LogValue(value)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("client '%s' is not allowed to set %s to '%s' (matchClientIP %s)", addr, name, v, mode)
+	return false
+}
+
+// ClientIPMatches reports whether value matches clientIP in mode. In prefix
+// mode IPv6 values may be anywhere in the /64 of clientIP, IPv4 values must
+// equal it in both modes.
+func ClientIPMatches(mode, value, clientIP string) bool {
+	v, err := netip.ParseAddr(value)
+	if err != nil {
+		return false
+	}
+	c, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	v, c = v.Unmap(), c.Unmap()
+	if v == c {
+		return true
+	}
+	if mode != config.ClientIPMatchPrefix || !v.Is6() || !c.Is6() {
+		return false
+	}
+	p, err := c.Prefix(ipv6MatchPrefixLen)
+	return err == nil && p.Contains(v)
+}
+
+// clientIPMatchMode returns the strictest of userModes and the modes of
+// auth.matchClientIP that apply to fqdn, or an empty string if none does.
+func clientIPMatchMode(cfg *config.Config, fqdn string, userModes []string) string {
+	modes := slices.Clone(userModes)
+	for _, m := range cfg.Auth.MatchClientIP {
+		if domainsMatch(fqdn, m.Domains) {
+			modes = append(modes, m.Mode)
+		}
+	}
+
+	mode := ""
+	for _, m := range modes {
+		if m == config.ClientIPMatchExact {
+			return m
+		}
+		if m != "" {
+			mode = m
+		}
+	}
+	return mode
+}
+
+// userClientIPModes returns the matchClientIP modes of the user a request
+// was authorized as by its credentials.
+func userClientIPModes(cfg *config.Config, reqData *data.ReqData) []string {
+	var modes []string
+	if username := reqData.AuthUser; username != "" && username == reqData.Username {
+		for _, user := range authUsers(cfg) {
+			if user.Username == username && user.MatchClientIP != "" {
+				modes = append(modes, user.MatchClientIP)
+			}
+		}
+	}
+	return modes
+}
+
+func ClientIPMismatch(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+}
+
+func NicClientIPMismatch(w http.ResponseWriter, _ *http.Request) {
+	writeNicToken(w, http.StatusOK, nicTokenNoHost)
+}
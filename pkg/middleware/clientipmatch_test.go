@@ -0,0 +1,96 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+var _ = Describe("ClientIPMatch", func() {
+	DescribeTable("ClientIPMatches", func(mode, value, clientIP string, expected bool) {
+		Expect(middleware.ClientIPMatches(mode, value, clientIP)).To(Equal(expected))
+	},
+		Entry("exact IPv4", config.ClientIPMatchExact, "192.0.2.1", "192.0.2.1", true),
+		Entry("other IPv4", config.ClientIPMatchExact, "192.0.2.2", "192.0.2.1", false),
+		Entry("mapped IPv4", config.ClientIPMatchExact, "192.0.2.1", "::ffff:192.0.2.1", true),
+		Entry("IPv4 in prefix mode", config.ClientIPMatchPrefix, "192.0.2.2", "192.0.2.1", false),
+		Entry("exact IPv6", config.ClientIPMatchExact, "2001:db8::1", "2001:db8::1", true),
+		Entry("IPv6 in the /64 in exact mode", config.ClientIPMatchExact, "2001:db8::2", "2001:db8::1", false),
+		Entry("IPv6 in the /64 in prefix mode", config.ClientIPMatchPrefix, "2001:db8::ffff:2", "2001:db8::1", true),
+		Entry("IPv6 outside the /64", config.ClientIPMatchPrefix, "2001:db8:0:1::1", "2001:db8::1", false),
+		Entry("other address family", config.ClientIPMatchPrefix, "2001:db8::1", "192.0.2.1", false),
+		Entry("invalid value", config.ClientIPMatchExact, "invalid", "192.0.2.1", false),
+	)
+
+	Context("NewClientIPMatch", func() {
+		const ip = "192.0.2.1"
+
+		var cfg *config.Config
+
+		BeforeEach(func() {
+			cfg = &config.Config{
+				Auth: config.Auth{
+					Method: config.AuthMethodUsers,
+					Users: []config.User{{
+						Username:      username,
+						Password:      password,
+						Domains:       []string{"*." + exampleDomain, "*." + testDomain},
+						MatchClientIP: config.ClientIPMatchPrefix,
+					}},
+					MatchClientIP: []config.ClientIPMatch{{
+						Domains: []string{"*." + testDomain},
+						Mode:    config.ClientIPMatchExact,
+					}},
+				},
+			}
+		})
+
+		run := func(onMismatch http.HandlerFunc, reqData *data.ReqData) *httptest.ResponseRecorder {
+			handler := middleware.NewClientIPMatch(cfg, onMismatch)(
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			)
+			req := httptest.NewRequest(http.MethodGet, "/plain/update", http.NoBody)
+			req.RemoteAddr = ip
+			req = req.WithContext(data.NewContextWithReqData(req.Context(), reqData))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		DescribeTable("should enforce the policies", func(reqData *data.ReqData, expected int) {
+			reqData.Username = username
+			reqData.Password = password
+			reqData.AuthUser = username
+			Expect(run(middleware.ClientIPMismatch, reqData).Code).To(Equal(expected))
+		},
+			Entry("allows the client IP",
+				&data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: ip}, http.StatusNoContent),
+			Entry("forbids other IPs of the user",
+				&data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: "192.0.2.2"}, http.StatusForbidden),
+			Entry("allows TXT records",
+				&data.ReqData{FullName: "a." + exampleDomain, Type: "TXT", Value: "192.0.2.2"}, http.StatusNoContent),
+			Entry("forbids other IPs of the domain",
+				&data.ReqData{FullName: "a." + testDomain, Type: "A", Value: "192.0.2.2"}, http.StatusForbidden),
+		)
+
+		It("does not enforce the policy of a user on other clients", func() {
+			reqData := &data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: "192.0.2.2"}
+			Expect(run(middleware.ClientIPMismatch, reqData).Code).To(Equal(http.StatusNoContent))
+		})
+
+		It("responds with nohost on the nic update endpoint", func() {
+			reqData := &data.ReqData{FullName: "a." + testDomain, Type: "A", Value: "192.0.2.2"}
+			rec := run(middleware.NicClientIPMismatch, reqData)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(Equal("nohost"))
+		})
+	})
+})
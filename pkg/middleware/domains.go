@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
@@ -22,8 +23,7 @@ func NewShowDomainsDirectAdmin(cfg *config.Config, lockout *ratelimit.Lockout) f
 				return
 			}
 
-			if lockout.IsBlocked(r.RemoteAddr) {
-				logLockedOut(r.RemoteAddr)
+			if isLockedOut(r, lockout) {
 				w.WriteHeader(http.StatusTooManyRequests)
 				return
 			}
@@ -31,14 +31,19 @@ func NewShowDomainsDirectAdmin(cfg *config.Config, lockout *ratelimit.Lockout) f
 			username, password, _ := r.BasicAuth()
 			usesUsers := authMethodUsesUsers(cfg.Auth.Method)
 			if usesUsers && (username != "" || password != "") {
-				if checkUserCredentials(username, password, cfg.Auth.Users) {
+				if checkUserCredentials(username, password, authUsers(cfg)) {
 					lockout.Reset(r.RemoteAddr)
 				} else {
-					lockout.RecordFailure(r.RemoteAddr)
+					recordAuthFailure(r, lockout)
 				}
 			}
 
-			domains := GetDomains(cfg, r.RemoteAddr, username, password)
+			domains := GetDomains(cfg, r.RemoteAddr, &data.ReqData{
+				Username:   username,
+				Password:   password,
+				ClientCert: clientCertificate(r),
+				Claims:     bearerClaims(r),
+			})
 			if len(domains) == 0 {
 				addr := sanitize.LogValue(r.RemoteAddr)
 				//nolint:gosec // value is sanitized above
@@ -70,17 +75,34 @@ func authMethodUsesUsers(method string) bool {
 		method == config.AuthMethodAny
 }
 
-func GetDomains(cfg *config.Config, remoteAddr, username, password string) map[string]struct{} {
+// GetDomains returns the domains a client may update, authorized by its
+// address and the credentials in creds.
+func GetDomains(cfg *config.Config, remoteAddr string, creds *data.ReqData) map[string]struct{} {
 	domainsAllowedDomains := getDomainsFromAllowedDomains(cfg.Auth.AllowedDomains, remoteAddr)
+	maps.Copy(domainsAllowedDomains, getDomainsFromAllowedNetworks(&cfg.Auth, remoteAddr))
 	if cfg.Auth.Method == config.AuthMethodAllowedDomains {
 		return stripWildcards(domainsAllowedDomains)
 	}
 
-	domainsUsers := getDomainsFromUsers(cfg.Auth.Users, username, password)
+	domainsUsers := getDomainsFromUsers(&cfg.Auth, authUsers(cfg), remoteAddr, creds.Username, creds.Password)
 	if cfg.Auth.Method == config.AuthMethodUsers {
 		return stripWildcards(domainsUsers)
 	}
 
+	domainsClientCert := getDomainsFromClientCert(cfg.Auth.ClientCerts, creds.ClientCert)
+	if cfg.Auth.Method == config.AuthMethodClientCert {
+		return stripWildcards(domainsClientCert)
+	}
+
+	domainsJWT := getDomainsFromJWT(cfg.Auth.JWT, creds.Claims)
+	if cfg.Auth.Method == config.AuthMethodJWT {
+		return stripWildcards(domainsJWT)
+	}
+
+	// Domains of all credentials are combined like those of a single user.
+	maps.Copy(domainsUsers, domainsClientCert)
+	maps.Copy(domainsUsers, domainsJWT)
+
 	domains := map[string]struct{}{}
 	switch cfg.Auth.Method {
 	case config.AuthMethodBoth:
@@ -118,18 +140,18 @@ func getDomainsFromAllowedDomains(allowedDomains config.AllowedDomains, remoteAd
 	return domains
 }
 
-func getDomainsFromUsers(users []config.User, username, password string) map[string]struct{} {
+func getDomainsFromUsers(
+	auth *config.Auth, users []config.User, remoteAddr, username, password string,
+) map[string]struct{} {
 	domains := map[string]struct{}{}
-	if username == "" || password == "" {
+	user := matchUser(users, username, password)
+	if user == nil {
 		return domains
 	}
-	for _, user := range users {
-		if constantTimeEqual(user.Username, username)&constantTimeEqual(user.Password, password) == 1 {
-			for _, domain := range user.Domains {
-				domains[domain] = struct{}{}
-			}
-		}
+	for _, domain := range user.Domains {
+		domains[domain] = struct{}{}
 	}
+	roleDomains(domains, auth.Roles, user.Roles, parseAddr(remoteAddr))
 
 	return domains
 }
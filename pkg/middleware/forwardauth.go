@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/forwardauth"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+// forwardAuthResult is the outcome of asking the authorization service.
+type forwardAuthResult int
+
+const (
+	forwardAuthAllowed forwardAuthResult = iota
+	forwardAuthDenied
+	forwardAuthUnavailable
+)
+
+// NewForwardAuthorizer authorizes requests by asking the authorization
+// service behind client. If the service is unavailable, requests are
+// allowed when failOpen is set and rejected with 503 otherwise. Only
+// denials count as failed attempts for the lockout. Allowed requests must
+// satisfy auth.rules.
+func NewForwardAuthorizer(
+	cfg *config.Config, client *forwardauth.Client, failOpen bool, lockout *ratelimit.Lockout,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				log.Printf("%v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if isLockedOut(r, lockout) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+
+			switch forwardAuthorize(r, reqData, client, failOpen, lockout) {
+			case forwardAuthAllowed:
+				if !checkRules(cfg, reqData, r) {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+			case forwardAuthDenied:
+				w.WriteHeader(http.StatusUnauthorized)
+			case forwardAuthUnavailable:
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// NewNicForwardAuth is NewForwardAuthorizer for the nic update endpoint,
+// which reports failures with nic tokens.
+func NewNicForwardAuth(
+	cfg *config.Config, client *forwardauth.Client, failOpen bool, lockout *ratelimit.Lockout,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				log.Printf("%v", err)
+				writeNicToken(w, http.StatusOK, nicToken911)
+				return
+			}
+
+			if isLockedOut(r, lockout) {
+				writeNicToken(w, http.StatusOK, nicTokenAbuse)
+				return
+			}
+
+			switch forwardAuthorize(r, reqData, client, failOpen, lockout) {
+			case forwardAuthAllowed:
+				if !checkRules(cfg, reqData, r) {
+					writeNicToken(w, http.StatusOK, nicTokenNoHost)
+					return
+				}
+				next.ServeHTTP(w, r)
+			case forwardAuthDenied:
+				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+				writeNicToken(w, http.StatusUnauthorized, nicTokenBadAuth)
+			case forwardAuthUnavailable:
+				writeNicToken(w, http.StatusOK, nicToken911)
+			}
+		})
+	}
+}
+
+func forwardAuthorize(
+	r *http.Request, reqData *data.ReqData, client *forwardauth.Client, failOpen bool, lockout *ratelimit.Lockout,
+) forwardAuthResult {
+	allowed, err := client.Check(r.Context(), &forwardauth.Request{
+		ClientIP:      r.RemoteAddr,
+		Method:        r.Method,
+		URI:           r.URL.RequestURI(),
+		Username:      reqData.Username,
+		Password:      reqData.Password,
+		Authorization: r.Header.Get("Authorization"),
+		FullName:      reqData.FullName,
+		Type:          reqData.Type,
+		Value:         reqData.Value,
+	})
+	if err != nil {
+		log.Printf("forward auth failed: %v", err)
+		if failOpen {
+			logForwardAuthFailOpen(r.RemoteAddr)
+			return forwardAuthAllowed
+		}
+		return forwardAuthUnavailable
+	}
+	if !allowed {
+		logPermissionDenied(r.RemoteAddr, reqData)
+		recordAuthFailure(r, lockout)
+		return forwardAuthDenied
+	}
+	lockout.Reset(r.RemoteAddr)
+	reqData.AuthUser = reqData.Username
+	return forwardAuthAllowed
+}
+
+func logForwardAuthFailOpen(remoteAddr string) {
+	addr := sanitize.LogValue(remoteAddr)
+	//nolint:gosec // value is sanitized above
+	log.Printf("authorization service unavailable, allowing client '%s'", addr)
+}
@@ -0,0 +1,132 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/forwardauth"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+var _ = Describe("Forward auth", func() {
+	const ip = "127.0.0.1"
+
+	var (
+		status  atomic.Int32
+		server  *httptest.Server
+		client  *forwardauth.Client
+		lockout *ratelimit.Lockout
+		cfg     *config.Config
+	)
+
+	BeforeEach(func() {
+		status.Store(http.StatusOK)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get(forwardauth.HeaderName)).To(Equal(exampleDomain))
+			w.WriteHeader(int(status.Load()))
+		}))
+		DeferCleanup(server.Close)
+		client = forwardauth.New(server.URL, time.Second, 0)
+		lockout = ratelimit.NewLockout(2, time.Hour, 15*time.Minute)
+		cfg = &config.Config{}
+	})
+
+	run := func(authorizer func(http.Handler) http.Handler) *httptest.ResponseRecorder {
+		handler := authorizer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/plain/update", http.NoBody)
+		req.RemoteAddr = ip
+		req = req.WithContext(data.NewContextWithReqData(req.Context(), &data.ReqData{
+			FullName: exampleDomain,
+			Type:     "A",
+			Value:    ip,
+			Username: "user",
+			Password: "pass",
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	DescribeTable("NewForwardAuthorizer", func(code int, failOpen bool, expected int) {
+		status.Store(int32(code))
+		Expect(run(middleware.NewForwardAuthorizer(cfg, client, failOpen, lockout)).Code).To(Equal(expected))
+	},
+		Entry("allows when the service allows", http.StatusOK, false, http.StatusNoContent),
+		Entry("denies when the service denies", http.StatusForbidden, false, http.StatusUnauthorized),
+		Entry("rejects when the service fails closed", http.StatusBadGateway, false, http.StatusServiceUnavailable),
+		Entry("allows when the service fails open", http.StatusBadGateway, true, http.StatusNoContent),
+		Entry("denies when the service denies with fail open", http.StatusForbidden, true, http.StatusUnauthorized),
+	)
+
+	DescribeTable("NewNicForwardAuth", func(code int, failOpen bool, expectedCode int, expectedBody string) {
+		status.Store(int32(code))
+		rec := run(middleware.NewNicForwardAuth(cfg, client, failOpen, lockout))
+		Expect(rec.Code).To(Equal(expectedCode))
+		Expect(rec.Body.String()).To(Equal(expectedBody))
+	},
+		Entry("allows when the service allows", http.StatusOK, false, http.StatusNoContent, ""),
+		Entry("denies when the service denies", http.StatusForbidden, false, http.StatusUnauthorized, "badauth"),
+		Entry("fails when the service fails closed", http.StatusBadGateway, false, http.StatusOK, "911"),
+		Entry("allows when the service fails open", http.StatusBadGateway, true, http.StatusNoContent, ""),
+	)
+
+	DescribeTable("passes on the user confirmed by the service only", func(code int, expected string) {
+		status.Store(int32(code))
+		var authUser string
+		handler := middleware.NewForwardAuthorizer(cfg, client, true, lockout)(
+			http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				reqData, err := data.ReqDataFromContext(r.Context())
+				Expect(err).ToNot(HaveOccurred())
+				authUser = reqData.AuthUser
+			}),
+		)
+		req := httptest.NewRequest(http.MethodGet, "/plain/update", http.NoBody)
+		req.RemoteAddr = ip
+		req = req.WithContext(data.NewContextWithReqData(req.Context(), &data.ReqData{
+			FullName: exampleDomain, Type: "A", Value: ip, Username: "user", Password: "pass",
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(authUser).To(Equal(expected))
+	},
+		Entry("when the service allows", http.StatusOK, "user"),
+		Entry("not when the service fails open", http.StatusBadGateway, ""),
+	)
+
+	It("forbids allowed requests denied by rules", func() {
+		program, err := rules.Compile(`type != "A"`, config.RuleVariables)
+		Expect(err).ToNot(HaveOccurred())
+		cfg.Auth.Rules = []config.Rule{{Name: "no-a", Program: program}}
+
+		Expect(run(middleware.NewForwardAuthorizer(cfg, client, false, lockout)).Code).To(Equal(http.StatusForbidden))
+		Expect(run(middleware.NewNicForwardAuth(cfg, client, false, lockout)).Body.String()).To(Equal("nohost"))
+		Expect(lockout.IsBlocked(ip)).To(BeFalse())
+	})
+
+	It("locks out clients after denials only", func() {
+		authorizer := middleware.NewForwardAuthorizer(cfg, client, false, lockout)
+
+		status.Store(http.StatusBadGateway)
+		Expect(run(authorizer).Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(run(authorizer).Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(lockout.IsBlocked(ip)).To(BeFalse())
+
+		status.Store(http.StatusForbidden)
+		Expect(run(authorizer).Code).To(Equal(http.StatusUnauthorized))
+		Expect(run(authorizer).Code).To(Equal(http.StatusUnauthorized))
+		Expect(lockout.IsBlocked(ip)).To(BeTrue())
+
+		status.Store(http.StatusOK)
+		Expect(run(authorizer).Code).To(Equal(http.StatusTooManyRequests))
+	})
+})
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// parseForwardedFor returns the IP addresses of the for= parameters of every
+// element of the RFC 7239 Forwarded header values, in order, with ports
+// and brackets removed. Elements without a for= parameter yield an empty
+// hop, so that walking the chain stops there.
+func parseForwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			hop := ""
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(pair, "=")
+				if ok && strings.EqualFold(strings.TrimSpace(key), "for") {
+					hop = forwardedNodeAddr(unquote(strings.TrimSpace(value)))
+					break
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// forwardedNodeAddr strips the port and brackets from a node identifier.
+// Obfuscated and unknown identifiers are returned unchanged.
+func forwardedNodeAddr(node string) string {
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().String()
+	}
+	if strings.HasPrefix(node, "[") && strings.HasSuffix(node, "]") {
+		return node[1 : len(node)-1]
+	}
+	return node
+}
+
+// splitQuoted splits s at sep outside of quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts   []string
+		quoted  bool
+		escaped bool
+		start   int
+	)
+	for i := range len(s) {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes of an RFC 7230 quoted-string.
+func unquote(s string) string {
+	const minQuotedLen = 2
+	if len(s) < minQuotedLen || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for i := 1; i < len(s)-1; i++ {
+		if !escaped && s[i] == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
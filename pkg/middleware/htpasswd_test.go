@@ -0,0 +1,115 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/htpasswd"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+var _ = Describe("Users file", func() {
+	const ip = "127.0.0.1"
+
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		Expect(err).ToNot(HaveOccurred())
+
+		dir := GinkgoT().TempDir()
+		usersFile := filepath.Join(dir, "htpasswd")
+		domainsFile := filepath.Join(dir, "domains")
+		Expect(os.WriteFile(usersFile, []byte(username+":"+string(hash)+"\n"), 0o600)).To(Succeed())
+		Expect(os.WriteFile(domainsFile, []byte(username+":*."+testDomain+"\n"), 0o600)).To(Succeed())
+
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodUsers,
+				Users: []config.User{{
+					Username: "inline",
+					Password: "inline",
+					Domains:  []string{exampleDomain},
+				}},
+				FileUsers: htpasswd.NewFile(usersFile, domainsFile),
+			},
+		}
+	})
+
+	DescribeTable("CheckPermission", func(fqdn, username, password string, expected bool) {
+		reqData := &data.ReqData{FullName: fqdn, Username: username, Password: password}
+		Expect(middleware.CheckPermission(cfg, reqData, ip)).To(Equal(expected))
+	},
+		Entry("allows a file user", "a."+testDomain, username, password, true),
+		Entry("allows an inline user", exampleDomain, "inline", "inline", true),
+		Entry("denies a file user with a wrong password", "a."+testDomain, username, "wrong", false),
+		Entry("denies a file user for another domain", exampleDomain, username, password, false),
+		Entry("denies an unknown user", "a."+testDomain, "unknown", password, false),
+		Entry("denies an inline user with the password of a file user", exampleDomain, "inline", password, false),
+	)
+
+	It("adds the roles and matchClientIP of an auth.users entry without password to a file user", func() {
+		cfg.Auth.Roles = map[string]*config.Role{"example": {Domains: []string{"*." + exampleDomain}}}
+		cfg.Auth.Users = append(cfg.Auth.Users, config.User{
+			Username:      username,
+			Roles:         []string{"example"},
+			MatchClientIP: config.ClientIPMatchExact,
+		})
+
+		reqData := &data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: "192.0.2.1", Username: username, Password: password}
+		Expect(middleware.CheckPermission(cfg, reqData, ip)).To(BeTrue())
+
+		reqData.AuthUser = username
+		handler := middleware.NewClientIPMatch(cfg, middleware.ClientIPMismatch)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/plain/update", http.NoBody)
+		req.RemoteAddr = ip
+		req = req.WithContext(data.NewContextWithReqData(req.Context(), reqData))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("returns the domains of a file user", func() {
+		Expect(middleware.GetDomains(cfg, ip, &data.ReqData{Username: username, Password: password})).To(Equal(
+			map[string]struct{}{testDomain: {}},
+		))
+	})
+
+	It("lists the domains of a file user with DirectAdmin", func() {
+		handler := middleware.NewShowDomainsDirectAdmin(cfg, ratelimit.NewLockout(3, time.Hour, 15*time.Minute))(nil)
+		req := httptest.NewRequest(http.MethodGet, "/directadmin/CMD_API_SHOW_DOMAINS", http.NoBody)
+		req.RemoteAddr = ip
+		req.SetBasicAuth(username, password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal("list=" + testDomain))
+	})
+
+	It("rejects a file user with a wrong password on nic update", func() {
+		handler := middleware.NicAuth(cfg, ratelimit.NewLockout(3, time.Hour, 15*time.Minute))(nil)
+		req := httptest.NewRequest(http.MethodGet, "/nic/update", http.NoBody)
+		req.RemoteAddr = ip
+		req = req.WithContext(data.NewContextWithReqData(req.Context(), &data.ReqData{
+			FullName: "a." + testDomain,
+			Username: username,
+			Password: "wrong",
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Body.String()).To(Equal("badauth"))
+	})
+})
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+type bearerClaimsKey struct{}
+
+// NewBearerAuth verifies the bearer token of requests and passes its claims
+// on to the authorizers. Requests with invalid tokens continue without
+// claims, so that they fail authorization and count towards the lockout.
+func NewBearerAuth(verifier *jwt.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := verifier.Verify(r.Context(), token)
+			if err != nil {
+				addr := sanitize.LogValue(r.RemoteAddr)
+				msg := sanitize.LogValue(err.Error())
+				//nolint:gosec // values are sanitized above
+				log.Printf("client '%s' sent an invalid bearer token: %s", addr, msg)
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bearerClaimsKey{}, claims)))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// bearerClaims returns the claims of the verified bearer token of r.
+func bearerClaims(r *http.Request) jwt.Claims {
+	claims, _ := r.Context().Value(bearerClaimsKey{}).(jwt.Claims)
+	return claims
+}
+
+type jwtGrant struct {
+	domains     []string
+	recordTypes []string
+}
+
+// jwtGrants returns the grants of a token from the domains claim and the
+// subject patterns. Without a record types claim configured, the domains
+// claim allows all record types.
+func jwtGrants(cfg *config.JWT, claims jwt.Claims) []jwtGrant {
+	if cfg == nil || claims == nil {
+		return nil
+	}
+
+	var grants []jwtGrant
+	if cfg.DomainsClaim != "" {
+		grant := jwtGrant{domains: claims.Strings(cfg.DomainsClaim)}
+		if cfg.RecordTypesClaim != "" {
+			grant.recordTypes = claims.Strings(cfg.RecordTypesClaim)
+			if len(grant.recordTypes) == 0 {
+				grant.domains = nil
+			}
+		}
+		grants = append(grants, grant)
+	}
+
+	sub := claims.Subject()
+	for i := range cfg.Subjects {
+		if sub != "" && matchPattern(cfg.Subjects[i].Pattern, sub) {
+			grants = append(grants, jwtGrant{
+				domains:     cfg.Subjects[i].Domains,
+				recordTypes: cfg.Subjects[i].RecordTypes,
+			})
+		}
+	}
+	return grants
+}
+
+func CheckJWT(fqdn, recordType string, claims jwt.Claims, cfg *config.JWT) bool {
+	if fqdn == "" {
+		return false
+	}
+	for _, grant := range jwtGrants(cfg, claims) {
+		if len(grant.recordTypes) > 0 && !slices.ContainsFunc(grant.recordTypes, func(t string) bool {
+			return strings.EqualFold(t, recordType)
+		}) {
+			continue
+		}
+		for _, domain := range grant.domains {
+			if fqdn == domain || IsSubDomain(fqdn, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getDomainsFromJWT(cfg *config.JWT, claims jwt.Claims) map[string]struct{} {
+	domains := map[string]struct{}{}
+	for _, grant := range jwtGrants(cfg, claims) {
+		for _, domain := range grant.domains {
+			domains[domain] = struct{}{}
+		}
+	}
+	return domains
+}
+
+// jwtName identifies a token in logs and rate limits.
+func jwtName(claims jwt.Claims) string {
+	return "jwt:" + claims.Subject()
+}
+
+// matchPattern reports whether s matches pattern, in which * matches any
+// sequence of characters.
+func matchPattern(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,192 @@
+package middleware_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/jwt"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+const (
+	jwtIssuer   = "https://issuer.example.com"
+	jwtAudience = "hetzner-dnsapi-proxy"
+	jwtSubject  = "repo:example/infra:ref:refs/heads/main"
+)
+
+func newJWTConfig() *config.JWT {
+	return &config.JWT{
+		Issuer:           jwtIssuer,
+		Audience:         jwtAudience,
+		DomainsClaim:     "dns_domains",
+		RecordTypesClaim: "dns_record_types",
+		Subjects: []config.JWTSubject{{
+			Pattern:     "repo:example/*:ref:refs/heads/main",
+			Domains:     []string{"*." + testDomain},
+			RecordTypes: []string{"TXT"},
+		}},
+	}
+}
+
+func signJWT(key ed25519.PrivateKey, claims map[string]any) string {
+	enc := base64.RawURLEncoding
+	h, err := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT"})
+	Expect(err).ToNot(HaveOccurred())
+	c, err := json.Marshal(claims)
+	Expect(err).ToNot(HaveOccurred())
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(c)
+	return signed + "." + enc.EncodeToString(ed25519.Sign(key, []byte(signed)))
+}
+
+var _ = Describe("CheckJWT", func() {
+	DescribeTable("should allow access", func(claims jwt.Claims, fqdn, recordType string) {
+		Expect(middleware.CheckJWT(fqdn, recordType, claims, newJWTConfig())).To(BeTrue())
+	},
+		Entry("by domains claim list",
+			jwt.Claims{"dns_domains": []any{exampleDomain}, "dns_record_types": []any{"A", "AAAA"}}, exampleDomain, "A"),
+		Entry("by domains claim string",
+			jwt.Claims{"dns_domains": "other.com " + exampleDomain, "dns_record_types": "A,TXT"}, exampleDomain, "TXT"),
+		Entry("by subject pattern", jwt.Claims{"sub": jwtSubject}, "_acme-challenge."+testDomain, "TXT"),
+	)
+
+	DescribeTable("should deny access", func(claims jwt.Claims, fqdn, recordType string) {
+		Expect(middleware.CheckJWT(fqdn, recordType, claims, newJWTConfig())).To(BeFalse())
+	},
+		Entry("without claims", nil, exampleDomain, "A"),
+		Entry("with a domain not granted",
+			jwt.Claims{"dns_domains": []any{exampleDomain}, "dns_record_types": []any{"A"}}, testDomain, "A"),
+		Entry("with a record type not granted",
+			jwt.Claims{"dns_domains": []any{exampleDomain}, "dns_record_types": []any{"A"}}, exampleDomain, "TXT"),
+		Entry("without record types claim", jwt.Claims{"dns_domains": []any{exampleDomain}}, exampleDomain, "A"),
+		Entry("with a record type not granted to the subject", jwt.Claims{"sub": jwtSubject}, "_acme-challenge."+testDomain, "A"),
+		Entry("with a subject not matching", jwt.Claims{"sub": "repo:example/infra:ref:refs/heads/dev"},
+			"_acme-challenge."+testDomain, "TXT"),
+		Entry("without domain", jwt.Claims{"sub": jwtSubject}, "", "TXT"),
+	)
+})
+
+var _ = Describe("JWT authentication", func() {
+	const ip = "127.0.0.1"
+
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodJWT,
+				AllowedDomains: config.AllowedDomains{exampleDomain: {{
+					IP:   []byte{127, 0, 0, 1},
+					Mask: []byte{255, 255, 255, 255},
+				}}},
+				JWT: newJWTConfig(),
+			},
+		}
+	})
+
+	DescribeTable("CheckPermission", func(method string, claims jwt.Claims, remoteAddr string, expected bool) {
+		cfg.Auth.Method = method
+		reqData := &data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "TXT", Claims: claims}
+		Expect(middleware.CheckPermission(cfg, reqData, remoteAddr)).To(Equal(expected))
+	},
+		Entry("allows jwt with matching claims", config.AuthMethodJWT, jwt.Claims{"sub": jwtSubject}, "", true),
+		Entry("denies jwt without claims", config.AuthMethodJWT, nil, ip, false),
+		Entry("allows any with matching claims", config.AuthMethodAny, jwt.Claims{"sub": jwtSubject}, "", true),
+		Entry("denies both from another IP", config.AuthMethodBoth, jwt.Claims{"sub": jwtSubject}, ip, false),
+	)
+
+	It("returns the granted domains", func() {
+		claims := jwt.Claims{"sub": jwtSubject, "dns_domains": []any{exampleDomain}, "dns_record_types": []any{"A"}}
+		Expect(middleware.GetDomains(cfg, ip, &data.ReqData{Claims: claims})).To(Equal(map[string]struct{}{
+			exampleDomain: {},
+			testDomain:    {},
+		}))
+
+		cfg.Auth.Method = config.AuthMethodBoth
+		Expect(middleware.GetDomains(cfg, ip, &data.ReqData{Claims: claims})).To(Equal(map[string]struct{}{
+			exampleDomain: {},
+		}))
+	})
+
+	Context("with bearer tokens", func() {
+		var (
+			key     ed25519.PrivateKey
+			lockout *ratelimit.Lockout
+			handler http.Handler
+		)
+
+		BeforeEach(func() {
+			var (
+				public ed25519.PublicKey
+				err    error
+			)
+			public, key, err = ed25519.GenerateKey(rand.Reader)
+			Expect(err).ToNot(HaveOccurred())
+			jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+				"kty": "OKP", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(public),
+			}}})
+			Expect(err).ToNot(HaveOccurred())
+			path := filepath.Join(GinkgoT().TempDir(), "jwks.json")
+			Expect(os.WriteFile(path, jwks, 0o600)).To(Succeed())
+
+			lockout = ratelimit.NewLockout(3, time.Hour, 15*time.Minute)
+			verifier := jwt.NewVerifier(jwtIssuer, jwtAudience, jwt.NewFileKeySet(path))
+			handler = middleware.NewBearerAuth(verifier)(middleware.NewAuthorizer(cfg, lockout)(
+				http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}),
+			))
+		})
+
+		run := func(authorization string) int {
+			req := httptest.NewRequest(http.MethodGet, "/plain/update", http.NoBody)
+			req.RemoteAddr = ip
+			req.Header.Set("Authorization", authorization)
+			req = req.WithContext(data.NewContextWithReqData(req.Context(), &data.ReqData{
+				FullName: "_acme-challenge." + testDomain,
+				Type:     "TXT",
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		claims := func() map[string]any {
+			return map[string]any{
+				"iss": jwtIssuer,
+				"aud": jwtAudience,
+				"sub": jwtSubject,
+				"exp": time.Now().Add(time.Hour).Unix(),
+			}
+		}
+
+		It("authorizes requests with a valid token", func() {
+			Expect(run("Bearer " + signJWT(key, claims()))).To(Equal(http.StatusNoContent))
+		})
+
+		It("rejects requests with an invalid token", func() {
+			c := claims()
+			c["aud"] = "other"
+			Expect(run("Bearer " + signJWT(key, c))).To(Equal(http.StatusUnauthorized))
+			Expect(run("bearer invalid")).To(Equal(http.StatusUnauthorized))
+			c["aud"] = jwtAudience
+			c["exp"] = time.Now().Add(-time.Hour).Unix()
+			Expect(run("Bearer " + signJWT(key, c))).To(Equal(http.StatusUnauthorized))
+
+			Expect(lockout.IsBlocked(ip)).To(BeTrue())
+			Expect(run("Bearer " + signJWT(key, claims()))).To(Equal(http.StatusTooManyRequests))
+		})
+	})
+})
@@ -7,11 +7,18 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
 
-var redactedHeaders = []string{"Authorization", "X-Api-User", "X-Api-Key"}
+const redacted = "[REDACTED]"
+
+var (
+	redactedHeaders = []string{"Authorization", "X-Api-User", "X-Api-Key", "Auth-Api-Token", "X-Auth-Key"}
+	// redactedParams are the query parameters carrying credentials.
+	redactedParams = []string{"token", "password"}
+)
 
 func LogDebug(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,8 +48,27 @@ func redactHeader(h http.Header) http.Header {
 	clone := h.Clone()
 	for _, key := range redactedHeaders {
 		if len(clone.Values(key)) > 0 {
-			clone.Set(key, "[REDACTED]")
+			clone.Set(key, redacted)
 		}
 	}
 	return clone
 }
+
+// RedactURL returns u with the values of query parameters carrying
+// credentials redacted, for logging.
+func RedactURL(u *url.URL) string {
+	query := u.Query()
+	found := false
+	for _, key := range redactedParams {
+		if query.Has(key) {
+			query.Set(key, redacted)
+			found = true
+		}
+	}
+	if !found {
+		return u.String()
+	}
+	clone := *u
+	clone.RawQuery = query.Encode()
+	return clone.String()
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -47,6 +48,8 @@ var _ = Describe("LogDebug", func() {
 		req.Header.Set("Authorization", "Basic c2VjcmV0")
 		req.Header.Set("X-Api-User", "admin")
 		req.Header.Set("X-Api-Key", "supersecret")
+		req.Header.Set("Auth-API-Token", "legacytoken")
+		req.Header.Set("X-Auth-Key", "cloudflarekey")
 		req.Header.Set("User-Agent", "probe/1.0")
 		rec := httptest.NewRecorder()
 		middleware.LogDebug(inner).ServeHTTP(rec, req)
@@ -58,6 +61,8 @@ var _ = Describe("LogDebug", func() {
 		Expect(logged).NotTo(ContainSubstring("c2VjcmV0"))
 		Expect(logged).NotTo(ContainSubstring("supersecret"))
 		Expect(logged).NotTo(ContainSubstring("admin"))
+		Expect(logged).NotTo(ContainSubstring("legacytoken"))
+		Expect(logged).NotTo(ContainSubstring("cloudflarekey"))
 		Expect(logged).To(ContainSubstring("[REDACTED]"))
 		Expect(logged).To(ContainSubstring("probe/1.0"))
 	})
@@ -89,3 +94,28 @@ var _ = Describe("LogDebug", func() {
 		Expect(string(received)).To(Equal(payload))
 	})
 })
+
+var _ = Describe("RedactURL", func() {
+	It("redacts credentials in the query", func() {
+		u, err := url.Parse("/duckdns/update?domains=home&token=supersecret")
+		Expect(err).ToNot(HaveOccurred())
+		redacted := middleware.RedactURL(u)
+		Expect(redacted).NotTo(ContainSubstring("supersecret"))
+		Expect(redacted).To(ContainSubstring("domains=home"))
+	})
+
+	It("redacts the password of Namecheap updates", func() {
+		u, err := url.Parse("/update?host=x&domain=y&password=s3cret")
+		Expect(err).ToNot(HaveOccurred())
+		redacted := middleware.RedactURL(u)
+		Expect(redacted).NotTo(ContainSubstring("s3cret"))
+		Expect(redacted).To(ContainSubstring("domain=y"))
+		Expect(redacted).To(ContainSubstring("host=x"))
+	})
+
+	It("keeps other URLs unchanged", func() {
+		u, err := url.Parse("/plain/update?hostname=a.example.com&ip=1.2.3.4")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(middleware.RedactURL(u)).To(Equal(u.String()))
+	})
+})
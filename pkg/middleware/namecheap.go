@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"encoding/xml"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+)
+
+const (
+	namecheapCommand  = "SETDNSHOST"
+	namecheapLanguage = "eng"
+	namecheapApex     = "@"
+	textXMLUTF8       = "text/xml; charset=utf-8"
+
+	namecheapErrDomain = "Domain name not found"
+	namecheapErrIP     = "Invalid IP"
+)
+
+// namecheapResponse is the interface-response of the dynamic DNS endpoint of
+// Namecheap. Clients check ErrCount for success.
+type namecheapResponse struct {
+	XMLName       xml.Name        `xml:"interface-response"`
+	Command       string          `xml:"Command"`
+	Language      string          `xml:"Language"`
+	IP            string          `xml:"IP,omitempty"`
+	ErrCount      int             `xml:"ErrCount"`
+	Errors        namecheapErrors `xml:"errors"`
+	ResponseCount int             `xml:"ResponseCount"`
+	Done          bool            `xml:"Done"`
+}
+
+type namecheapErrors struct {
+	Err1 string `xml:"Err1,omitempty"`
+}
+
+// BindNamecheap binds the host, domain, password and ip of the dynamic DNS
+// endpoint of Namecheap. The password authenticates as the user named like
+// domain; without ip the client IP is used.
+func BindNamecheap(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+			if err := r.ParseForm(); err != nil {
+				log.Printf(failedParseRequestFmt, err)
+				writeNamecheapError(w, "Invalid request")
+				return
+			}
+
+			domain := r.Form.Get("domain")
+			if domain == "" {
+				writeNamecheapError(w, namecheapErrDomain)
+				return
+			}
+			fqdn := domain
+			if host := r.Form.Get("host"); host != "" && host != namecheapApex {
+				fqdn = host + "." + domain
+			}
+
+			ip := r.Form.Get("ip")
+			if ip == "" {
+				ip = r.RemoteAddr
+			}
+			parsedIP := net.ParseIP(ip)
+			if parsedIP == nil {
+				writeNamecheapError(w, namecheapErrIP)
+				return
+			}
+			recordType := recordTypeA
+			if parsedIP.To4() == nil {
+				recordType = recordTypeAAAA
+			}
+
+			if err := ValidateValue(&cfg.AddressPolicy, fqdn, ip, recordType); err != nil {
+				log.Printf("invalid ip: %v", err)
+				writeNamecheapError(w, namecheapErrIP)
+				return
+			}
+
+			name, zone, err := SplitFQDN(fqdn)
+			if err != nil {
+				writeNamecheapError(w, namecheapErrDomain)
+				return
+			}
+
+			next.ServeHTTP(
+				w, r.WithContext(
+					data.NewContextWithReqData(
+						r.Context(),
+						&data.ReqData{
+							FullName:  fqdn,
+							Name:      name,
+							Zone:      zone,
+							Value:     ip,
+							Type:      recordType,
+							Username:  domain,
+							Password:  r.Form.Get("password"),
+							BasicAuth: false,
+						},
+					),
+				),
+			)
+		})
+	}
+}
+
+// NamecheapErrors answers the errors of the handlers after it, e.g. of the
+// authorizer or the updater, with an interface-response.
+func NamecheapErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&nicErrorWriter{
+			ResponseWriter: w,
+			mapStatus: func(code int) (int, string) {
+				return http.StatusOK, namecheapBody(namecheapErrorResponse(namecheapErrorMessage(code)))
+			},
+			contentType: textXMLUTF8,
+		}, r)
+	})
+}
+
+func namecheapErrorMessage(code int) string {
+	switch code {
+	case http.StatusUnauthorized:
+		return "Passwords do not match"
+	case http.StatusForbidden:
+		return "Not allowed"
+	case http.StatusTooManyRequests:
+		return "Too many requests"
+	default:
+		return "Failed to update the record"
+	}
+}
+
+func StatusOkNamecheap(_ http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqData, err := data.ReqDataFromContext(r.Context())
+		if err != nil {
+			log.Printf("%v", err)
+			writeNamecheapError(w, namecheapErrorMessage(http.StatusInternalServerError))
+			return
+		}
+		writeNamecheapResponse(w, &namecheapResponse{
+			Command:  namecheapCommand,
+			Language: namecheapLanguage,
+			IP:       reqData.Value,
+			Done:     true,
+		})
+	})
+}
+
+func namecheapErrorResponse(msg string) *namecheapResponse {
+	return &namecheapResponse{
+		Command:       namecheapCommand,
+		Language:      namecheapLanguage,
+		ErrCount:      1,
+		Errors:        namecheapErrors{Err1: msg},
+		ResponseCount: 1,
+		Done:          true,
+	}
+}
+
+func writeNamecheapError(w http.ResponseWriter, msg string) {
+	writeNamecheapResponse(w, namecheapErrorResponse(msg))
+}
+
+func writeNamecheapResponse(w http.ResponseWriter, res *namecheapResponse) {
+	w.Header().Set(headerContentType, textXMLUTF8)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(namecheapBody(res))); err != nil {
+		log.Printf(failedWriteResponseFmt, err)
+	}
+}
+
+func namecheapBody(res *namecheapResponse) string {
+	body, err := xml.Marshal(res)
+	if err != nil {
+		log.Printf("failed to marshal response: %v", err)
+	}
+	return xml.Header + string(body)
+}
@@ -0,0 +1,114 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+var _ = Describe("Namecheap", func() {
+	const password = "secret"
+
+	var (
+		updated    []data.ReqData
+		updateCode int
+		handler    http.Handler
+	)
+
+	BeforeEach(func() {
+		updated = nil
+		updateCode = http.StatusOK
+		cfg := &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodUsers,
+				Users: []config.User{{
+					Username: exampleDomain,
+					Password: password,
+					Domains:  []string{exampleDomain, "*." + exampleDomain},
+				}},
+			},
+		}
+		update := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if updateCode != http.StatusOK {
+					w.WriteHeader(updateCode)
+					return
+				}
+				reqData, err := data.ReqDataFromContext(r.Context())
+				Expect(err).ToNot(HaveOccurred())
+				updated = append(updated, *reqData)
+				next.ServeHTTP(w, r)
+			})
+		}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NamecheapErrors(middleware.BindNamecheap(cfg)(
+			middleware.NewAuthorizer(cfg, lockout)(update(middleware.StatusOkNamecheap(nil))),
+		))
+	})
+
+	do := func(query string) string {
+		req := httptest.NewRequest(http.MethodGet, "/update?"+query, http.NoBody)
+		req.RemoteAddr = "1.2.3.100"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("text/xml; charset=utf-8"))
+		return rec.Body.String()
+	}
+
+	It("should update the host with the client IP", func() {
+		Expect(do("host=home&domain=" + exampleDomain + "&password=" + password)).To(And(
+			ContainSubstring("<IP>1.2.3.100</IP>"),
+			ContainSubstring("<ErrCount>0</ErrCount>"),
+			ContainSubstring("<Done>true</Done>"),
+		))
+		Expect(updated).To(ConsistOf(data.ReqData{
+			FullName: "home." + exampleDomain,
+			Name:     "home",
+			Zone:     exampleDomain,
+			Value:    "1.2.3.100",
+			Type:     "A",
+			Username: exampleDomain,
+			Password: password,
+			AuthUser: exampleDomain,
+		}))
+	})
+
+	It("should update the apex with an IPv6 address", func() {
+		Expect(do("host=@&domain=" + exampleDomain + "&password=" + password + "&ip=2a01:4f8::1")).
+			To(ContainSubstring("<ErrCount>0</ErrCount>"))
+		Expect(updated).To(ConsistOf(And(
+			HaveField("FullName", exampleDomain),
+			HaveField("Type", "AAAA"),
+			HaveField("Value", "2a01:4f8::1"),
+		)))
+	})
+
+	It("should answer failed updates", func() {
+		updateCode = http.StatusInternalServerError
+		Expect(do("host=home&domain=" + exampleDomain + "&password=" + password)).
+			To(ContainSubstring("<Err1>Failed to update the record</Err1>"))
+	})
+
+	DescribeTable("should fail", func(query, msg string) {
+		Expect(do(query)).To(And(
+			ContainSubstring("<ErrCount>1</ErrCount>"),
+			ContainSubstring("<Err1>"+msg+"</Err1>"),
+		))
+		Expect(updated).To(BeEmpty())
+	},
+		Entry("with a wrong password", "host=home&domain="+exampleDomain+"&password=wrong", "Passwords do not match"),
+		Entry("with another domain", "host=home&domain=example.org&password="+password, "Passwords do not match"),
+		Entry("without domain", "host=home&password="+password, "Domain name not found"),
+		Entry("with an invalid ip", "host=home&domain="+exampleDomain+"&password="+password+"&ip=invalid", "Invalid IP"),
+		Entry("with a private address", "host=home&domain="+exampleDomain+"&password="+password+"&ip=10.0.0.1", "Invalid IP"),
+	)
+})
@@ -22,62 +22,70 @@ const (
 	textPlainUTF8   = "text/plain; charset=utf-8"
 )
 
-func BindNicUpdate(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
-		if err := r.ParseForm(); err != nil {
-			log.Printf(failedParseRequestFmt, err)
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
+func BindNicUpdate(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+			if err := r.ParseForm(); err != nil {
+				log.Printf(failedParseRequestFmt, err)
+				writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
+				return
+			}
 
-		hostname := r.Form.Get("hostname")
-		if hostname == "" {
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
+			hostname := r.Form.Get("hostname")
+			if hostname == "" {
+				writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
+				return
+			}
 
-		ip := r.Form.Get("myip")
-		if ip == "" {
-			ip = r.RemoteAddr
-		}
+			ip := r.Form.Get("myip")
+			if ip == "" {
+				ip = r.RemoteAddr
+			}
 
-		parsedIP := net.ParseIP(ip)
-		if parsedIP == nil {
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
+			parsedIP := net.ParseIP(ip)
+			if parsedIP == nil {
+				writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
+				return
+			}
 
-		recordType := recordTypeA
-		if parsedIP.To4() == nil {
-			recordType = recordTypeAAAA
-		}
+			recordType := recordTypeA
+			if parsedIP.To4() == nil {
+				recordType = recordTypeAAAA
+			}
 
-		name, zone, err := SplitFQDN(hostname)
-		if err != nil {
-			writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
-			return
-		}
+			if err := ValidateValue(&cfg.AddressPolicy, hostname, ip, recordType); err != nil {
+				log.Printf("invalid myip: %v", err)
+				writeNicToken(w, http.StatusOK, nicTokenDNSErr)
+				return
+			}
 
-		username, password, _ := r.BasicAuth()
-		next.ServeHTTP(
-			w, r.WithContext(
-				data.NewContextWithReqData(
-					r.Context(),
-					&data.ReqData{
-						FullName:  hostname,
-						Name:      name,
-						Zone:      zone,
-						Value:     ip,
-						Type:      recordType,
-						Username:  username,
-						Password:  password,
-						BasicAuth: true,
-					},
+			name, zone, err := SplitFQDN(hostname)
+			if err != nil {
+				writeNicToken(w, http.StatusOK, nicTokenNotFQDN)
+				return
+			}
+
+			username, password, _ := r.BasicAuth()
+			next.ServeHTTP(
+				w, r.WithContext(
+					data.NewContextWithReqData(
+						r.Context(),
+						&data.ReqData{
+							FullName:  hostname,
+							Name:      name,
+							Zone:      zone,
+							Value:     ip,
+							Type:      recordType,
+							Username:  username,
+							Password:  password,
+							BasicAuth: true,
+						},
+					),
 				),
-			),
-		)
-	})
+			)
+		})
+	}
 }
 
 func NicAuth(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Handler) http.Handler {
@@ -90,20 +98,26 @@ func NicAuth(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Handler)
 				return
 			}
 
-			if lockout.IsBlocked(r.RemoteAddr) {
-				logLockedOut(r.RemoteAddr)
+			if isLockedOut(r, lockout) {
 				writeNicToken(w, http.StatusOK, nicTokenAbuse)
 				return
 			}
 
+			reqData.ClientCert = clientCertificate(r)
+			reqData.Claims = bearerClaims(r)
 			if CheckPermission(cfg, reqData, r.RemoteAddr) {
 				lockout.Reset(r.RemoteAddr)
+				reqData.AuthUser = authenticatedUser(cfg, reqData)
+				if !checkRules(cfg, reqData, r) {
+					writeNicToken(w, http.StatusOK, nicTokenNoHost)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			logPermissionDenied(r.RemoteAddr, reqData)
-			lockout.RecordFailure(r.RemoteAddr)
+			recordAuthFailure(r, lockout)
 			if isBadAuth(cfg, reqData) {
 				w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 				writeNicToken(w, http.StatusUnauthorized, nicTokenBadAuth)
@@ -116,21 +130,20 @@ func NicAuth(cfg *config.Config, lockout *ratelimit.Lockout) func(http.Handler)
 
 func isBadAuth(cfg *config.Config, reqData *data.ReqData) bool {
 	switch cfg.Auth.Method {
-	case config.AuthMethodUsers, config.AuthMethodBoth, config.AuthMethodAny:
-		return !checkUserCredentials(reqData.Username, reqData.Password, cfg.Auth.Users)
+	case config.AuthMethodUsers:
+		return !checkUserCredentials(reqData.Username, reqData.Password, authUsers(cfg))
+	case config.AuthMethodClientCert:
+		return !checkClientCertKnown(reqData.ClientCert, cfg.Auth.ClientCerts)
+	case config.AuthMethodJWT:
+		return reqData.Claims == nil
+	case config.AuthMethodBoth, config.AuthMethodAny:
+		return credentialsName(cfg, reqData) == ""
 	}
 	return false
 }
 
 func checkUserCredentials(username, password string, users []config.User) bool {
-	if username == "" || password == "" {
-		return false
-	}
-	matched := 0
-	for _, user := range users {
-		matched |= constantTimeEqual(user.Username, username) & constantTimeEqual(user.Password, password)
-	}
-	return matched == 1
+	return matchUser(users, username, password) != nil
 }
 
 func NicUpdate(updater func(http.Handler) http.Handler) func(http.Handler) http.Handler {
@@ -167,10 +180,13 @@ func writeNicToken(w http.ResponseWriter, status int, token string) {
 	}
 }
 
+// nicErrorWriter replaces error responses with the status and body returned
+// by mapStatus, sent as contentType or as plain text if it is empty.
 type nicErrorWriter struct {
 	http.ResponseWriter
-	mapStatus func(code int) (int, string)
-	handled   bool
+	mapStatus   func(code int) (int, string)
+	contentType string
+	handled     bool
 }
 
 func (w *nicErrorWriter) WriteHeader(code int) {
@@ -183,7 +199,11 @@ func (w *nicErrorWriter) WriteHeader(code int) {
 	}
 	w.handled = true
 	status, token := w.mapStatus(code)
-	w.ResponseWriter.Header().Set(headerContentType, textPlainUTF8)
+	contentType := w.contentType
+	if contentType == "" {
+		contentType = textPlainUTF8
+	}
+	w.ResponseWriter.Header().Set(headerContentType, contentType)
 	w.ResponseWriter.WriteHeader(status)
 	if _, err := fmt.Fprint(w.ResponseWriter, token); err != nil {
 		log.Printf(failedWriteResponseFmt, err)
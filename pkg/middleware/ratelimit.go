@@ -2,16 +2,36 @@ package middleware
 
 import (
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
 	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
 )
 
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+)
+
 func NewRateLimit(limiter *ratelimit.Limiter, onExceeded http.HandlerFunc) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !limiter.Allow(r.RemoteAddr) {
+			if isExempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			d := limiter.Check(r.RemoteAddr)
+			setRateLimitHeaders(w, d)
+			if !d.Allowed {
 				addr := sanitize.LogValue(r.RemoteAddr)
 				//nolint:gosec // value is sanitized above
 				log.Printf("rate limit exceeded for %s", addr)
@@ -23,6 +43,146 @@ func NewRateLimit(limiter *ratelimit.Limiter, onExceeded http.HandlerFunc) func(
 	}
 }
 
+// ScopedRateLimits holds the rate limit policies applied to authorized
+// requests. Nil policies are disabled.
+type ScopedRateLimits struct {
+	User     *ratelimit.Policy
+	Zone     *ratelimit.Policy
+	Upstream *ratelimit.Policy
+
+	// mu serializes checks, so that no other check charges a policy
+	// between checking and charging all of them.
+	mu sync.Mutex
+}
+
+// NewScopedRateLimit limits authorized requests per user, per zone and
+// against the global upstream write budget. It must run after the binder
+// and the authorizer, so that only authenticated users and authorized zones
+// consume tokens.
+func NewScopedRateLimit(
+	cfg *config.Config, limits *ScopedRateLimits, onExceeded http.HandlerFunc,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				log.Printf("%v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !limits.Allow(w, reqData.AuthUser, reqData.Zone) {
+				onExceeded(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Allow reports whether a request of user changing records of zone is within
+// the limits and sets the RateLimit-* headers on w, unless w is nil as for
+// DNS updates. An empty user is only limited per zone and upstream.
+func (l *ScopedRateLimits) Allow(w http.ResponseWriter, user, zone string) bool {
+	return l.AllowN(w, user, zone, 1)
+}
+
+// AllowN is Allow for n changes, which are charged all or none.
+func (l *ScopedRateLimits) AllowN(w http.ResponseWriter, user, zone string, n int) bool {
+	scope, d := l.check(user, zone, n)
+	setRateLimitHeaders(w, d)
+	if !d.Allowed {
+		key := sanitize.LogValue(scope)
+		//nolint:gosec // value is sanitized above
+		log.Printf("%s rate limit exceeded", key)
+		return false
+	}
+	return true
+}
+
+// check charges the policies only if all of them allow the changes, so that
+// a rejection in one scope does not consume the budget of the others.
+func (l *ScopedRateLimits) check(user, zone string, n int) (scope string, d ratelimit.Decision) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if scope, d := l.decide(user, zone, n, (*ratelimit.Policy).Peek); !d.Allowed {
+		return scope, d
+	}
+	return l.decide(user, zone, n, (*ratelimit.Policy).CheckN)
+}
+
+func (l *ScopedRateLimits) decide(
+	user, zone string, n int, check func(p *ratelimit.Policy, key string, n int) ratelimit.Decision,
+) (scope string, d ratelimit.Decision) {
+	d = ratelimit.Decision{Allowed: true}
+	if user != "" {
+		d = check(l.User, user, n)
+		if !d.Allowed {
+			return "user '" + user + "'", d
+		}
+	}
+
+	zd := check(l.Zone, zone, n)
+	if !zd.Allowed {
+		return "zone '" + zone + "'", zd
+	}
+	d = ratelimit.Stricter(d, zd)
+
+	ud := check(l.Upstream, "", n)
+	if !ud.Allowed {
+		return "upstream", ud
+	}
+	return "", ratelimit.Stricter(d, ud)
+}
+
+// authenticatedUser returns the name of the credentials of an authorized
+// request if it was authorized by its credentials, so that clients
+// authorized by their IP cannot consume the budget of arbitrary users. The
+// authorizer stores it as ReqData.AuthUser, as it may check a password
+// hash.
+func authenticatedUser(cfg *config.Config, reqData *data.ReqData) string {
+	switch cfg.Auth.Method {
+	case config.AuthMethodUsers, config.AuthMethodForwardAuth:
+		return reqData.Username
+	case config.AuthMethodClientCert:
+		return clientCertName(reqData.ClientCert)
+	case config.AuthMethodJWT:
+		return jwtName(reqData.Claims)
+	case config.AuthMethodBoth, config.AuthMethodAny:
+		return credentialsName(cfg, reqData)
+	}
+	return ""
+}
+
+// setRateLimitHeaders sets the RateLimit-* headers, and Retry-After on
+// rejection, unless a stricter decision was already reported by an earlier
+// limiter in the chain.
+func setRateLimitHeaders(w http.ResponseWriter, d ratelimit.Decision) {
+	if w == nil || d.Limit == 0 {
+		return
+	}
+
+	h := w.Header()
+	if prev := h.Get(headerRateLimitRemaining); prev != "" && d.Allowed {
+		if remaining, err := strconv.Atoi(prev); err == nil && remaining <= d.Remaining {
+			return
+		}
+	}
+
+	reset := strconv.Itoa(ceilSeconds(d.Reset))
+	h.Set(headerRateLimitLimit, strconv.Itoa(d.Limit))
+	h.Set(headerRateLimitRemaining, strconv.Itoa(d.Remaining))
+	h.Set(headerRateLimitReset, reset)
+	if !d.Allowed {
+		h.Set(headerRetryAfter, reset)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
 func RateLimitExceeded(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusTooManyRequests)
 }
@@ -0,0 +1,154 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+var _ = Describe("RateLimit", func() {
+	var inner http.Handler
+
+	BeforeEach(func() {
+		inner = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	})
+
+	It("sets RateLimit headers and Retry-After when exceeded", func() {
+		limiter := ratelimit.NewLimiter(1, 1, time.Minute)
+		handler := middleware.NewRateLimit(limiter, middleware.RateLimitExceeded)(inner)
+
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("RateLimit-Limit")).To(Equal("1"))
+		Expect(rec.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+		Expect(rec.Header().Get("Retry-After")).To(BeEmpty())
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+		Expect(rec.Header().Get("RateLimit-Reset")).To(Equal("1"))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+	})
+
+	It("returns the abuse token on /nic/update", func() {
+		limiter := ratelimit.NewLimiter(1, 1, time.Minute)
+		handler := middleware.NewRateLimit(limiter, middleware.NicRateLimitExceeded)(inner)
+
+		req := httptest.NewRequest(http.MethodGet, "/nic/update", http.NoBody)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal("abuse"))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+	})
+})
+
+var _ = Describe("ScopedRateLimit", func() {
+	var (
+		cfg   *config.Config
+		inner http.Handler
+	)
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodAny,
+				Users:  []config.User{{Username: username, Password: password, Domains: []string{exampleDomain}}},
+			},
+		}
+		inner = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	})
+
+	run := func(handler http.Handler, reqData *data.ReqData) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req = req.WithContext(data.NewContextWithReqData(req.Context(), reqData))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("limits authenticated users", func() {
+		limits := &middleware.ScopedRateLimits{User: ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))}
+		handler := middleware.NewScopedRateLimit(cfg, limits, middleware.RateLimitExceeded)(inner)
+		reqData := &data.ReqData{Zone: exampleDomain, Username: username, Password: password, AuthUser: username}
+
+		Expect(run(handler, reqData).Code).To(Equal(http.StatusOK))
+		rec := run(handler, reqData)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("RateLimit-Limit")).To(Equal("1"))
+		Expect(rec.Header().Get("Retry-After")).NotTo(BeEmpty())
+	})
+
+	It("does not limit usernames the request was not authorized by", func() {
+		limits := &middleware.ScopedRateLimits{User: ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))}
+		handler := middleware.NewScopedRateLimit(cfg, limits, middleware.RateLimitExceeded)(inner)
+		reqData := &data.ReqData{Zone: exampleDomain, Username: username, Password: "wrong"}
+
+		Expect(run(handler, reqData).Code).To(Equal(http.StatusOK))
+		Expect(run(handler, reqData).Code).To(Equal(http.StatusOK))
+	})
+
+	It("does not charge the user for requests rejected by the upstream limit", func() {
+		limits := &middleware.ScopedRateLimits{
+			User:     ratelimit.NewPolicy(nil, ratelimit.NewQuota(2)),
+			Upstream: ratelimit.NewPolicy(nil, ratelimit.NewQuota(1)),
+		}
+		handler := middleware.NewScopedRateLimit(cfg, limits, middleware.RateLimitExceeded)(inner)
+		reqData := &data.ReqData{Zone: exampleDomain, Username: username, Password: password, AuthUser: username}
+
+		Expect(run(handler, reqData).Code).To(Equal(http.StatusOK))
+		Expect(run(handler, reqData).Code).To(Equal(http.StatusTooManyRequests))
+
+		limits.Upstream = nil
+		rec := run(handler, reqData)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("RateLimit-Remaining")).To(Equal("0"))
+		Expect(run(handler, reqData).Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("limits zones independently", func() {
+		limits := &middleware.ScopedRateLimits{Zone: ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))}
+		handler := middleware.NewScopedRateLimit(cfg, limits, middleware.RateLimitExceeded)(inner)
+
+		Expect(run(handler, &data.ReqData{Zone: exampleDomain}).Code).To(Equal(http.StatusOK))
+		Expect(run(handler, &data.ReqData{Zone: exampleDomain}).Code).To(Equal(http.StatusTooManyRequests))
+		Expect(run(handler, &data.ReqData{Zone: testDomain}).Code).To(Equal(http.StatusOK))
+	})
+
+	It("limits all requests against the upstream budget", func() {
+		limits := &middleware.ScopedRateLimits{Upstream: ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))}
+		handler := middleware.NewScopedRateLimit(cfg, limits, middleware.RateLimitExceeded)(inner)
+
+		Expect(run(handler, &data.ReqData{Zone: exampleDomain}).Code).To(Equal(http.StatusOK))
+		Expect(run(handler, &data.ReqData{Zone: testDomain}).Code).To(Equal(http.StatusTooManyRequests))
+	})
+
+	It("reports the stricter of the IP and scoped limits", func() {
+		limiter := ratelimit.NewLimiter(1, 10, time.Minute)
+		limits := &middleware.ScopedRateLimits{Zone: ratelimit.NewPolicy(nil, ratelimit.NewQuota(5))}
+		handler := middleware.NewRateLimit(limiter, middleware.RateLimitExceeded)(
+			middleware.NewScopedRateLimit(cfg, limits, middleware.RateLimitExceeded)(inner),
+		)
+
+		rec := run(handler, &data.ReqData{Zone: exampleDomain})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("RateLimit-Limit")).To(Equal("5"))
+		Expect(rec.Header().Get("RateLimit-Remaining")).To(Equal("4"))
+	})
+})
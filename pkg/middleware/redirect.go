@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// NewHTTPSRedirect redirects requests permanently to the same URL on HTTPS.
+// httpsPort is appended to the host unless it is empty or 443.
+func NewHTTPSRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,41 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+var _ = Describe("HTTPSRedirect", func() {
+	DescribeTable("redirects to HTTPS", func(httpsPort, host, target, expected string) {
+		r := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		r.Host = host
+		rec := httptest.NewRecorder()
+		middleware.NewHTTPSRedirect(httpsPort).ServeHTTP(rec, r)
+
+		Expect(rec.Code).To(Equal(http.StatusPermanentRedirect))
+		Expect(rec.Header().Get("Location")).To(Equal(expected))
+	},
+		Entry("on the default port", "443", "dyn.example.com", "/plain/update?hostname=a.example.com",
+			"https://dyn.example.com/plain/update?hostname=a.example.com"),
+		Entry("dropping the HTTP port", "", "dyn.example.com:8080", "/nic/update",
+			"https://dyn.example.com/nic/update"),
+		Entry("on a custom port", "8443", "dyn.example.com:8080", "/nic/update",
+			"https://dyn.example.com:8443/nic/update"),
+		Entry("to an IPv6 host", "443", "[2001:db8::1]:80", "/", "https://[2001:db8::1]/"),
+		Entry("to an IPv6 host on a custom port", "8443", "[2001:db8::1]", "/", "https://[2001:db8::1]:8443/"),
+	)
+
+	It("rejects requests without host", func() {
+		r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		r.Host = ""
+		rec := httptest.NewRecorder()
+		middleware.NewHTTPSRedirect("443").ServeHTTP(rec, r)
+
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	})
+})
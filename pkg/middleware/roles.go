@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+)
+
+// checkUserRoles reports whether the roles of the user with the credentials
+// of reqData allow the update.
+func checkUserRoles(cfg *config.Config, reqData *data.ReqData, remoteAddr string) bool {
+	if len(cfg.Auth.Roles) == 0 || reqData.FullName == "" || reqData.Username == "" || reqData.Password == "" {
+		return false
+	}
+	user := matchUser(authUsers(cfg), reqData.Username, reqData.Password)
+	return user != nil && rolesAllow(cfg.Auth.Roles, user.Roles, reqData.FullName, reqData.Type, parseAddr(remoteAddr))
+}
+
+// checkAllowedNetworks reports whether the roles of the allowedNetworks
+// entries containing the client allow the update.
+func checkAllowedNetworks(cfg *config.Config, reqData *data.ReqData, remoteAddr string) bool {
+	if reqData.FullName == "" {
+		return false
+	}
+	addr := parseAddr(remoteAddr)
+	for i := range cfg.Auth.AllowedNetworks {
+		n := &cfg.Auth.AllowedNetworks[i]
+		if n.Contains(addr) && rolesAllow(cfg.Auth.Roles, n.Roles, reqData.FullName, reqData.Type, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func rolesAllow(roles map[string]*config.Role, names []string, fqdn, recordType string, addr netip.Addr) bool {
+	for _, name := range names {
+		role, ok := roles[name]
+		if !ok || !role.AllowsNetwork(addr) || !domainsMatch(fqdn, role.Domains) {
+			continue
+		}
+		if len(role.RecordTypes) == 0 || slices.ContainsFunc(role.RecordTypes, func(t string) bool {
+			return strings.EqualFold(t, recordType)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// roleDomains adds the domains of the roles usable by clients with addr to
+// domains.
+func roleDomains(domains map[string]struct{}, roles map[string]*config.Role, names []string, addr netip.Addr) {
+	for _, name := range names {
+		if role, ok := roles[name]; ok && role.AllowsNetwork(addr) {
+			for _, domain := range role.Domains {
+				domains[domain] = struct{}{}
+			}
+		}
+	}
+}
+
+func getDomainsFromAllowedNetworks(auth *config.Auth, remoteAddr string) map[string]struct{} {
+	domains := map[string]struct{}{}
+	addr := parseAddr(remoteAddr)
+	for i := range auth.AllowedNetworks {
+		if auth.AllowedNetworks[i].Contains(addr) {
+			roleDomains(domains, auth.Roles, auth.AllowedNetworks[i].Roles, addr)
+		}
+	}
+	return domains
+}
+
+func domainsMatch(fqdn string, domains []string) bool {
+	for _, domain := range domains {
+		if fqdn == domain || IsSubDomain(fqdn, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(remoteAddr string) netip.Addr {
+	addr, _ := netip.ParseAddr(remoteAddr)
+	return addr
+}
+
+// CheckGrant reports whether domains and the grants of roles allow updating
+// records of fqdn with recordType from remoteAddr, like those of a user.
+func CheckGrant(auth *config.Auth, domains, roles []string, fqdn, recordType, remoteAddr string) bool {
+	if fqdn == "" {
+		return false
+	}
+	return domainsMatch(fqdn, domains) || rolesAllow(auth.Roles, roles, fqdn, recordType, parseAddr(remoteAddr))
+}
@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"net/netip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+var _ = Describe("Roles", func() {
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodAny,
+				Roles: map[string]*config.Role{
+					"acme": {Domains: []string{"*." + testDomain}, RecordTypes: []string{"TXT"}},
+					"lan": {
+						Domains:  []string{exampleDomain},
+						Networks: []string{"10.0.0.0/8"},
+						Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+					},
+				},
+				AllowedNetworks: []config.AllowedNetwork{{
+					Networks: []string{"192.168.0.0/16"},
+					Roles:    []string{"acme"},
+					Prefixes: []netip.Prefix{netip.MustParsePrefix("192.168.0.0/16")},
+				}},
+				Users: []config.User{{
+					Username: username,
+					Password: password,
+					Roles:    []string{"acme", "lan"},
+				}},
+			},
+		}
+	})
+
+	DescribeTable("CheckPermission", func(reqData *data.ReqData, remoteAddr string, expected bool) {
+		Expect(middleware.CheckPermission(cfg, reqData, remoteAddr)).To(Equal(expected))
+	},
+		Entry("allows a user role",
+			&data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "TXT", Username: username, Password: password},
+			"198.51.100.1", true),
+		Entry("denies a user role for another record type",
+			&data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "A", Username: username, Password: password},
+			"198.51.100.1", false),
+		Entry("denies a user role with a wrong password",
+			&data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "TXT", Username: username, Password: "wrong"},
+			"198.51.100.1", false),
+		Entry("allows a user role from its networks",
+			&data.ReqData{FullName: exampleDomain, Type: "A", Username: username, Password: password},
+			"10.1.2.3", true),
+		Entry("denies a user role from other networks",
+			&data.ReqData{FullName: exampleDomain, Type: "A", Username: username, Password: password},
+			"198.51.100.1", false),
+		Entry("allows an allowed network role",
+			&data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "TXT"}, "192.168.1.1", true),
+		Entry("denies an allowed network role from other networks",
+			&data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "TXT"}, "198.51.100.1", false),
+	)
+
+	It("denies without a matching allowed network with auth method both", func() {
+		cfg.Auth.Method = config.AuthMethodBoth
+		reqData := &data.ReqData{FullName: "_acme-challenge." + testDomain, Type: "TXT", Username: username, Password: password}
+		Expect(middleware.CheckPermission(cfg, reqData, "198.51.100.1")).To(BeFalse())
+		Expect(middleware.CheckPermission(cfg, reqData, "192.168.1.1")).To(BeTrue())
+	})
+
+	It("returns the domains of roles", func() {
+		creds := &data.ReqData{Username: username, Password: password}
+		Expect(middleware.GetDomains(cfg, "10.1.2.3", creds)).To(Equal(map[string]struct{}{
+			testDomain:    {},
+			exampleDomain: {},
+		}))
+		Expect(middleware.GetDomains(cfg, "198.51.100.1", creds)).To(Equal(map[string]struct{}{
+			testDomain: {},
+		}))
+		Expect(middleware.GetDomains(cfg, "192.168.1.1", &data.ReqData{})).To(Equal(map[string]struct{}{
+			testDomain: {},
+		}))
+	})
+})
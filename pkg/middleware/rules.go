@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+// checkRules reports whether the request satisfies all auth.rules.
+func checkRules(cfg *config.Config, reqData *data.ReqData, r *http.Request) bool {
+	return CheckRules(cfg, reqData, endpointName(r.URL.Path), r.RemoteAddr, reqData.AuthUser)
+}
+
+// CheckRules reports whether the change of reqData by username over
+// endpoint from remoteAddr satisfies all auth.rules. Rules that fail to
+// evaluate deny the change. Endpoints authorizing without NewAuthorizer
+// call it for every change they make.
+func CheckRules(cfg *config.Config, reqData *data.ReqData, endpoint, remoteAddr, username string) bool {
+	if len(cfg.Auth.Rules) == 0 {
+		return true
+	}
+
+	now := time.Now().UTC()
+	vars := rules.Vars{
+		"fqdn":     reqData.FullName,
+		"name":     reqData.Name,
+		"zone":     reqData.Zone,
+		"type":     reqData.Type,
+		"value":    reqData.Value,
+		"username": username,
+		"clientIP": remoteAddr,
+		"endpoint": endpoint,
+		"now":      int(now.Unix()),
+		"hour":     now.Hour(),
+		"weekday":  int(now.Weekday()),
+	}
+	for i := range cfg.Auth.Rules {
+		rule := &cfg.Auth.Rules[i]
+		allowed, err := rule.Program.Eval(vars)
+		if err != nil {
+			log.Printf("failed to evaluate rule %q: %v", rule.Name, err)
+		}
+		if !allowed {
+			logRuleDenied(remoteAddr, rule.Name, reqData)
+			return false
+		}
+	}
+	return true
+}
+
+// NewRuleData returns the request data of a change of the records of fqdn
+// with recordType to value for CheckRules.
+func NewRuleData(fqdn, recordType, value string) *data.ReqData {
+	name, zone, _ := SplitFQDN(fqdn)
+	return &data.ReqData{FullName: fqdn, Name: name, Zone: zone, Type: recordType, Value: value}
+}
+
+// endpointName returns the name of the endpoint serving path, which is its
+// first segment, e.g. plain for /plain/update.
+func endpointName(path string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return name
+}
+
+func logRuleDenied(remoteAddr, rule string, reqData *data.ReqData) {
+	addr := sanitize.LogValue(remoteAddr)
+	typ := sanitize.LogValue(reqData.Type)
+	name := sanitize.LogValue(reqData.FullName)
+	val := sanitize.LogValue(reqData.Value)
+	//nolint:gosec // values are sanitized above
+	log.Printf("rule '%s' denies client '%s' to update '%s' data of '%s' to '%s'", rule, addr, typ, name, val)
+}
@@ -0,0 +1,125 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+var _ = Describe("Rules", func() {
+	const ip = "127.0.0.1"
+
+	var (
+		cfg     *config.Config
+		lockout *ratelimit.Lockout
+	)
+
+	newRule := func(name, expr string) config.Rule {
+		program, err := rules.Compile(expr, config.RuleVariables)
+		Expect(err).ToNot(HaveOccurred())
+		return config.Rule{Name: name, Expr: expr, Program: program}
+	}
+
+	BeforeEach(func() {
+		cfg = &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodUsers,
+				Users: []config.User{{
+					Username: username,
+					Password: password,
+					Domains:  []string{"*." + exampleDomain},
+				}},
+				Rules: []config.Rule{
+					newRule("a-in-net", `type != "A" || value.inCIDR("192.0.2.0/24")`),
+					newRule("txt-acme", `type != "TXT" || fqdn.startsWith("_acme-challenge.")`),
+					newRule("own-host", `endpoint != "nic" || fqdn.startsWith(username + ".")`),
+				},
+			},
+		}
+		lockout = ratelimit.NewLockout(2, time.Hour, 15*time.Minute)
+	})
+
+	run := func(authorizer func(http.Handler) http.Handler, target string, reqData *data.ReqData) *httptest.ResponseRecorder {
+		handler := authorizer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.RemoteAddr = ip
+		reqData.Username = username
+		reqData.Password = password
+		req = req.WithContext(data.NewContextWithReqData(req.Context(), reqData))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	DescribeTable("NewAuthorizer", func(reqData *data.ReqData, expected int) {
+		Expect(run(middleware.NewAuthorizer(cfg, lockout), "/plain/update", reqData).Code).To(Equal(expected))
+	},
+		Entry("allows A records inside the network",
+			&data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: "192.0.2.1"}, http.StatusNoContent),
+		Entry("forbids A records outside the network",
+			&data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: "198.51.100.1"}, http.StatusForbidden),
+		Entry("allows TXT records under _acme-challenge",
+			&data.ReqData{FullName: "_acme-challenge.a." + exampleDomain, Type: "TXT", Value: "x"}, http.StatusNoContent),
+		Entry("forbids other TXT records",
+			&data.ReqData{FullName: "a." + exampleDomain, Type: "TXT", Value: "x"}, http.StatusForbidden),
+		Entry("allows other record types",
+			&data.ReqData{FullName: "a." + exampleDomain, Type: "AAAA", Value: "2001:db8::1"}, http.StatusNoContent),
+	)
+
+	DescribeTable("NicAuth", func(fqdn, expectedBody string) {
+		rec := run(middleware.NicAuth(cfg, lockout), "/nic/update",
+			&data.ReqData{FullName: fqdn, Type: "A", Value: "192.0.2.1"})
+		Expect(rec.Body.String()).To(Equal(expectedBody))
+	},
+		Entry("allows the host of the user", username+"."+exampleDomain, ""),
+		Entry("denies other hosts with nohost", "other."+exampleDomain, "nohost"),
+	)
+
+	apiTokenRulesAllow := func(endpoint, fqdn, recordType, value string) bool {
+		var allowed bool
+		token := &config.APIToken{Name: "acme", Token: "secret"}
+		lookup := func(string) *config.APIToken { return token }
+		handler := middleware.NewTokenAuth(lockout, lookup, func(*http.Request) string { return "" }, nil)(
+			http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				allowed = middleware.APITokenRulesAllow(cfg, r, endpoint, fqdn, recordType, value)
+			}),
+		)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		return allowed
+	}
+
+	DescribeTable("APITokenRulesAllow", func(fqdn, recordType, value string, expected bool) {
+		Expect(apiTokenRulesAllow(config.EndpointHetznerDNS, fqdn, recordType, value)).To(Equal(expected))
+	},
+		Entry("allows A records inside the network", "a."+exampleDomain, "A", "192.0.2.1", true),
+		Entry("forbids A records outside the network", "a."+exampleDomain, "A", "198.51.100.1", false),
+		Entry("forbids TXT records outside _acme-challenge", "a."+exampleDomain, "TXT", "x", false),
+	)
+
+	It("passes the endpoint and the name of the API token to rules", func() {
+		cfg.Auth.Rules = []config.Rule{newRule("cloudflare-acme", `endpoint == "cloudflare" && username == "acme"`)}
+		Expect(apiTokenRulesAllow(config.EndpointCloudflare, exampleDomain, "A", "192.0.2.1")).To(BeTrue())
+		Expect(apiTokenRulesAllow(config.EndpointHetznerDNS, exampleDomain, "A", "192.0.2.1")).To(BeFalse())
+	})
+
+	It("does not count rule denials towards the lockout", func() {
+		reqData := func() *data.ReqData {
+			return &data.ReqData{FullName: "a." + exampleDomain, Type: "A", Value: "198.51.100.1"}
+		}
+		for range 3 {
+			Expect(run(middleware.NewAuthorizer(cfg, lockout), "/plain/update", reqData()).Code).To(Equal(http.StatusForbidden))
+		}
+		Expect(lockout.IsBlocked(ip)).To(BeFalse())
+	})
+})
@@ -2,13 +2,22 @@ package middleware
 
 import "net/http"
 
-func SecurityHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h := w.Header()
-		h.Set("X-Content-Type-Options", "nosniff")
-		h.Set("X-Frame-Options", "DENY")
-		h.Set("Content-Security-Policy", "default-src 'none'")
-		h.Set("Cache-Control", "no-store")
-		next.ServeHTTP(w, r)
-	})
+const hstsMaxAge = "max-age=31536000"
+
+// NewSecurityHeaders sets security headers on every response. With hsts,
+// Strict-Transport-Security is added to responses sent over TLS.
+func NewSecurityHeaders(hsts bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Content-Security-Policy", "default-src 'none'")
+			h.Set("Cache-Control", "no-store")
+			if hsts && r.TLS != nil {
+				h.Set("Strict-Transport-Security", hstsMaxAge)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
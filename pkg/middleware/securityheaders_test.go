@@ -1,6 +1,7 @@
 package middleware_test
 
 import (
+	"crypto/tls"
 	"net/http"
 	"net/http/httptest"
 
@@ -11,17 +12,37 @@ import (
 )
 
 var _ = Describe("SecurityHeaders", func() {
-	It("sets security headers on the response", func() {
-		handler := middleware.SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	serve := func(hsts bool, r *http.Request) *httptest.ResponseRecorder {
+		handler := middleware.NewSecurityHeaders(hsts)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 			w.WriteHeader(http.StatusOK)
 		}))
 		rec := httptest.NewRecorder()
-		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	It("sets security headers on the response", func() {
+		rec := serve(false, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
 
 		Expect(rec.Code).To(Equal(http.StatusOK))
 		Expect(rec.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
 		Expect(rec.Header().Get("X-Frame-Options")).To(Equal("DENY"))
 		Expect(rec.Header().Get("Content-Security-Policy")).To(Equal("default-src 'none'"))
 		Expect(rec.Header().Get("Cache-Control")).To(Equal("no-store"))
+		Expect(rec.Header().Get("Strict-Transport-Security")).To(BeEmpty())
+	})
+
+	It("sets HSTS on responses sent over TLS", func() {
+		r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		r.TLS = &tls.ConnectionState{}
+		rec := serve(true, r)
+
+		Expect(rec.Header().Get("Strict-Transport-Security")).To(Equal("max-age=31536000"))
+	})
+
+	It("does not set HSTS on plain HTTP responses", func() {
+		rec := serve(true, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+		Expect(rec.Header().Get("Strict-Transport-Security")).To(BeEmpty())
 	})
 })
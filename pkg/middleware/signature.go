@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/signature"
+)
+
+// NewSignedRequestAuth verifies signed requests and sets the credentials of
+// the signing user in their ReqData, so that they are authorized like
+// requests with a username and password. Requests with an invalid
+// signature continue without credentials, so that they fail authorization
+// and count towards the lockout.
+func NewSignedRequestAuth(cfg *config.Config, verifier *signature.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !signature.Signed(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			reqData, err := data.ReqDataFromContext(r.Context())
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			reqData.BasicAuth = false
+			reqData.Username, reqData.Password, err = verifier.Verify(r, func(key string) (string, bool) {
+				return signingSecret(cfg.Auth.Users, key)
+			})
+			if err != nil {
+				addr := sanitize.LogValue(r.RemoteAddr)
+				msg := sanitize.LogValue(err.Error())
+				//nolint:gosec // values are sanitized above
+				log.Printf("client '%s' sent an invalid signed request: %s", addr, msg)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// signingSecret returns the password of the first user named username.
+func signingSecret(users []config.User, username string) (string, bool) {
+	for _, user := range users {
+		if user.Username == username && user.Password != "" {
+			return user.Password, true
+		}
+	}
+	return "", false
+}
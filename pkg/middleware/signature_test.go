@@ -0,0 +1,94 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/signature"
+)
+
+var _ = Describe("Signed requests", func() {
+	const (
+		ip     = "127.0.0.1"
+		target = "/plain/update?hostname=" + exampleDomain + "&ip=192.0.2.1"
+	)
+
+	var (
+		lockout *ratelimit.Lockout
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		cfg := &config.Config{
+			Auth: config.Auth{
+				Method: config.AuthMethodUsers,
+				Users: []config.User{{
+					Username: username,
+					Password: password,
+					Domains:  []string{exampleDomain},
+				}},
+				SignedRequests: &config.SignedRequests{},
+			},
+			AddressPolicy: config.AddressPolicy{Disabled: true},
+		}
+		lockout = ratelimit.NewLockout(2, time.Hour, 15*time.Minute)
+		handler = middleware.BindPlain(cfg)(
+			middleware.NewSignedRequestAuth(cfg, signature.NewVerifier(5*time.Minute))(
+				middleware.NewAuthorizer(cfg, lockout)(
+					http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+						w.WriteHeader(http.StatusNoContent)
+					}),
+				),
+			),
+		)
+	})
+
+	newRequest := func(secret, nonce string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		req.RemoteAddr = ip
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(signature.HeaderKey, username)
+		req.Header.Set(signature.HeaderTimestamp, timestamp)
+		req.Header.Set(signature.HeaderNonce, nonce)
+		req.Header.Set(signature.HeaderSignature, signature.Sign(secret,
+			signature.StringToSign(req.Method, req.URL.Path, req.URL.Query(), timestamp, nonce)))
+		return req
+	}
+
+	run := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("authorizes requests signed with the password of a user", func() {
+		Expect(run(newRequest(password, "0123456789abcdef")).Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("rejects replayed requests", func() {
+		req := newRequest(password, "0123456789abcdef")
+		Expect(run(req.Clone(req.Context())).Code).To(Equal(http.StatusNoContent))
+		Expect(run(req.Clone(req.Context())).Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("rejects requests with an invalid signature and locks out the client", func() {
+		rec := run(newRequest("wrong", "0123456789abcdef"))
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Header().Get("WWW-Authenticate")).To(BeEmpty())
+
+		req := newRequest("wrong", "fedcba9876543210")
+		req.SetBasicAuth(username, password)
+		Expect(run(req).Code).To(Equal(http.StatusUnauthorized))
+
+		Expect(lockout.IsBlocked(ip)).To(BeTrue())
+		Expect(run(newRequest(password, "00112233445566778899")).Code).To(Equal(http.StatusTooManyRequests))
+	})
+})
@@ -0,0 +1,116 @@
+package netlist
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+// List is a set of networks given inline or loaded from files. Files are
+// reloaded when they change. A nil List contains no addresses.
+type List struct {
+	mu       sync.Mutex
+	prefixes []netip.Prefix
+	files    []string
+	watcher  *filewatch.Watcher
+	trie     atomic.Pointer[Trie]
+}
+
+// New returns a List of prefixes and the networks listed in files, or nil
+// if both are empty. Files that fail to load are logged and skipped, they
+// are expected to have been validated with ReadFile beforehand.
+func New(prefixes []netip.Prefix, files []string) *List {
+	if len(prefixes) == 0 && len(files) == 0 {
+		return nil
+	}
+	l := &List{
+		prefixes: prefixes,
+		files:    files,
+		watcher:  filewatch.New(filewatch.DefaultInterval, files...),
+	}
+	l.trie.Store(l.build(&Trie{}))
+	return l
+}
+
+func (l *List) Contains(addr netip.Addr) bool {
+	if l == nil {
+		return false
+	}
+	if len(l.files) > 0 && l.watcher.Changed() {
+		l.reload()
+	}
+	return l.trie.Load().Contains(addr)
+}
+
+func (l *List) reload() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.trie.Store(l.build(l.trie.Load()))
+}
+
+// build returns a new trie from the inline prefixes and files. If a file
+// fails to load, the networks it contributed to prev are kept.
+func (l *List) build(prev *Trie) *Trie {
+	t := &Trie{}
+	for _, p := range l.prefixes {
+		t.Insert(p)
+	}
+	for _, file := range l.files {
+		prefixes, err := ReadFile(file)
+		if err != nil {
+			log.Printf("failed to load networks, keeping previous list: %v", err)
+			return prev
+		}
+		for _, p := range prefixes {
+			t.Insert(p)
+		}
+	}
+	return t
+}
+
+// ReadFile reads networks from path, one IP address or CIDR range per line.
+// Empty lines and lines starting with # are ignored.
+func ReadFile(path string) ([]netip.Prefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := ParsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid entry %q: %w", path, lineNo, line, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return prefixes, nil
+}
+
+// ParsePrefix parses s as a CIDR range or a single IP address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(s); err == nil {
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("must be an IP address or CIDR range: %w", err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,62 @@
+package netlist
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+var _ = Describe("List", func() {
+	var file string
+
+	BeforeEach(func() {
+		file = filepath.Join(GinkgoT().TempDir(), "networks.txt")
+		Expect(os.WriteFile(file, []byte("# scanners\n192.0.2.0/24\n\n2001:db8::1\n"), 0o600)).To(Succeed())
+	})
+
+	It("should be nil without networks", func() {
+		l := New(nil, nil)
+		Expect(l).To(BeNil())
+		Expect(l.Contains(netip.MustParseAddr("192.0.2.1"))).To(BeFalse())
+	})
+
+	It("should contain inline and file networks", func() {
+		l := New([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, []string{file})
+		Expect(l.Contains(netip.MustParseAddr("10.1.2.3"))).To(BeTrue())
+		Expect(l.Contains(netip.MustParseAddr("192.0.2.1"))).To(BeTrue())
+		Expect(l.Contains(netip.MustParseAddr("2001:db8::1"))).To(BeTrue())
+		Expect(l.Contains(netip.MustParseAddr("2001:db8::2"))).To(BeFalse())
+	})
+
+	It("should reload files on change", func() {
+		l := New(nil, []string{file})
+		l.watcher = filewatch.New(0, file)
+		Expect(l.Contains(netip.MustParseAddr("198.51.100.1"))).To(BeFalse())
+
+		Expect(os.WriteFile(file, []byte("198.51.100.0/24\n"), 0o600)).To(Succeed())
+		Expect(l.Contains(netip.MustParseAddr("198.51.100.1"))).To(BeTrue())
+		Expect(l.Contains(netip.MustParseAddr("192.0.2.1"))).To(BeFalse())
+	})
+
+	It("should keep the previous networks when a reload fails", func() {
+		l := New(nil, []string{file})
+		l.watcher = filewatch.New(0, file)
+
+		Expect(os.WriteFile(file, []byte("not-a-network\n"), 0o600)).To(Succeed())
+		Expect(l.Contains(netip.MustParseAddr("192.0.2.1"))).To(BeTrue())
+	})
+})
+
+var _ = Describe("ReadFile", func() {
+	It("should report the line of an invalid entry", func() {
+		file := filepath.Join(GinkgoT().TempDir(), "networks.txt")
+		Expect(os.WriteFile(file, []byte("192.0.2.0/24\nbogus\n"), 0o600)).To(Succeed())
+		_, err := ReadFile(file)
+		Expect(err).To(MatchError(ContainSubstring(`networks.txt:2: invalid entry "bogus"`)))
+	})
+})
@@ -0,0 +1,74 @@
+package netlist
+
+import "net/netip"
+
+// Trie is a binary prefix trie. Lookups take at most one step per address
+// bit regardless of the number of prefixes stored.
+type Trie struct {
+	v4 node
+	v6 node
+}
+
+type node struct {
+	children [2]*node
+	terminal bool
+}
+
+// Insert adds p to the trie. IPv4-mapped IPv6 prefixes are stored as
+// IPv4 prefixes.
+func (t *Trie) Insert(p netip.Prefix) {
+	const v4InV6Bits = 96
+	if p.Addr().Is4In6() && p.Bits() >= v4InV6Bits {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-v4InV6Bits)
+	}
+	p = p.Masked()
+	addr := p.Addr()
+	n := t.root(addr)
+	bytes := addr.AsSlice()
+	for i := range p.Bits() {
+		if n.terminal {
+			// A shorter prefix already covers p.
+			return
+		}
+		b := bit(bytes, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	n.terminal = true
+	n.children = [2]*node{}
+}
+
+// Contains reports whether addr is covered by any prefix in the trie.
+// IPv4-mapped IPv6 addresses are matched against IPv4 prefixes.
+func (t *Trie) Contains(addr netip.Addr) bool {
+	if t == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	n := t.root(addr)
+	bytes := addr.AsSlice()
+	for i := range addr.BitLen() {
+		if n.terminal {
+			return true
+		}
+		n = n.children[bit(bytes, i)]
+		if n == nil {
+			return false
+		}
+	}
+	return n.terminal
+}
+
+func (t *Trie) root(addr netip.Addr) *node {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+func bit(bytes []byte, i int) int {
+	const bitsPerByte = 8
+	return int(bytes[i/bitsPerByte]>>(bitsPerByte-1-i%bitsPerByte)) & 1
+}
@@ -0,0 +1,57 @@
+package netlist_test
+
+import (
+	"net/netip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+)
+
+var _ = Describe("Trie", func() {
+	var t *netlist.Trie
+
+	BeforeEach(func() {
+		t = &netlist.Trie{}
+		for _, p := range []string{"192.0.2.0/24", "198.51.100.7/32", "2001:db8::/32", "::ffff:203.0.113.0/120"} {
+			t.Insert(netip.MustParsePrefix(p))
+		}
+	})
+
+	DescribeTable("should contain", func(addr string) {
+		Expect(t.Contains(netip.MustParseAddr(addr))).To(BeTrue())
+	},
+		Entry("first address of a range", "192.0.2.0"),
+		Entry("last address of a range", "192.0.2.255"),
+		Entry("single address", "198.51.100.7"),
+		Entry("IPv6 address", "2001:db8:1::1"),
+		Entry("IPv4-mapped IPv6 address", "::ffff:192.0.2.1"),
+		Entry("address of an IPv4-mapped IPv6 range", "203.0.113.9"),
+	)
+
+	DescribeTable("should not contain", func(addr string) {
+		Expect(t.Contains(netip.MustParseAddr(addr))).To(BeFalse())
+	},
+		Entry("address next to a range", "192.0.3.0"),
+		Entry("address next to a single address", "198.51.100.8"),
+		Entry("IPv6 address outside the range", "2001:db9::1"),
+		Entry("IPv4 address on the IPv6 side", "::c000:201"),
+	)
+
+	It("should keep a shorter prefix when inserting a longer one", func() {
+		t.Insert(netip.MustParsePrefix("192.0.2.128/25"))
+		Expect(t.Contains(netip.MustParseAddr("192.0.2.1"))).To(BeTrue())
+	})
+
+	It("should cover longer prefixes when inserting a shorter one", func() {
+		t.Insert(netip.MustParsePrefix("198.51.100.0/24"))
+		Expect(t.Contains(netip.MustParseAddr("198.51.100.200"))).To(BeTrue())
+	})
+
+	It("should match everything with a zero-length prefix", func() {
+		t.Insert(netip.MustParsePrefix("0.0.0.0/0"))
+		Expect(t.Contains(netip.MustParseAddr("10.0.0.1"))).To(BeTrue())
+		Expect(t.Contains(netip.MustParseAddr("fe80::1"))).To(BeFalse())
+	})
+})
@@ -0,0 +1,209 @@
+package powerdns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// apexName is the name of the RRSets at the apex of a zone.
+	apexName = "@"
+
+	changeTypeReplace = "REPLACE"
+	changeTypeDelete  = "DELETE"
+
+	errNotAllowed = ": not allowed with this API key"
+)
+
+type apiError struct {
+	code    int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+type patchRequest struct {
+	RRSets []rrSetChange `json:"rrsets"`
+}
+
+type rrSetChange struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	TTL        int          `json:"ttl"`
+	ChangeType string       `json:"changetype"`
+	Records    []recordJSON `json:"records"`
+}
+
+// change is a checked rrSetChange with its name relative to the zone and
+// its unquoted values.
+type change struct {
+	name       string
+	fqdn       string
+	recordType string
+	changeType string
+	ttl        int
+	values     []string
+}
+
+// patchZone replaces and deletes RRSets of a zone. All changes are checked
+// before the first one is applied, but they are not applied atomically.
+func (h *handler) patchZone(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zone, err := h.visibleZone(ctx, r, r.PathValue("zone"))
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	if zone == nil {
+		writeError(w, http.StatusNotFound, errZoneNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	req := &patchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, "Could not parse JSON body")
+		return
+	}
+	changes := make([]*change, 0, len(req.RRSets))
+	for i := range req.RRSets {
+		c, err := h.checkChange(r, zone, &req.RRSets[i])
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		changes = append(changes, c)
+	}
+	if !h.allow(w, r, zone, len(changes)) {
+		return
+	}
+
+	for _, c := range changes {
+		logChange(r, c)
+		if c.changeType == changeTypeDelete {
+			err = h.records.DeleteRRSet(ctx, zone, c.name, c.recordType)
+		} else {
+			err = h.records.SetRecords(ctx, zone, c.name, c.recordType, c.values, c.ttl)
+		}
+		if err != nil {
+			failed(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkChange returns the change of req if the API token of r may apply it
+// to zone.
+func (h *handler) checkChange(r *http.Request, zone *hcloud.Zone, req *rrSetChange) (*change, error) {
+	c := &change{
+		fqdn:       zoneName(req.Name),
+		recordType: strings.ToUpper(req.Type),
+		changeType: strings.ToUpper(req.ChangeType),
+		ttl:        req.TTL,
+	}
+	rrSet := fmt.Sprintf("RRset %s IN %s", req.Name, c.recordType)
+	switch {
+	case c.fqdn == zone.Name:
+		c.name = apexName
+	case strings.HasSuffix(c.fqdn, "."+zone.Name):
+		c.name = strings.TrimSuffix(c.fqdn, "."+zone.Name)
+	default:
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: rrSet + ": Name is out of zone"}
+	}
+	if _, err := hetzner.RRSetTypeFromString(c.recordType); err != nil {
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: rrSet + ": " + err.Error()}
+	}
+	if c.changeType != changeTypeReplace && c.changeType != changeTypeDelete {
+		return nil, &apiError{code: http.StatusUnprocessableEntity, message: rrSet + ": Changetype not understood"}
+	}
+
+	t := middleware.APITokenFromContext(r.Context())
+	if !middleware.APITokenAllows(&h.cfg.Auth, t, c.fqdn, c.recordType, r.RemoteAddr) {
+		logDenied(r, t.Name, c.fqdn, c.recordType)
+		return nil, &apiError{code: http.StatusForbidden, message: rrSet + errNotAllowed}
+	}
+	if c.changeType == changeTypeDelete {
+		return c, h.checkRules(r, c, rrSet)
+	}
+
+	for _, record := range req.Records {
+		if record.Disabled {
+			return nil, &apiError{code: http.StatusUnprocessableEntity, message: rrSet + ": disabled records are not supported"}
+		}
+		value := hetzner.UnquoteIfRequired(record.Content, hcloud.ZoneRRSetType(c.recordType))
+		if err := middleware.ValidateValue(&h.cfg.AddressPolicy, c.fqdn, value, c.recordType); err != nil {
+			return nil, &apiError{code: http.StatusUnprocessableEntity, message: rrSet + ": " + err.Error()}
+		}
+		if !middleware.ClientIPAllowed(h.cfg, nil, c.fqdn, c.recordType, value, r.RemoteAddr) {
+			return nil, &apiError{code: http.StatusForbidden, message: rrSet + errNotAllowed}
+		}
+		c.values = append(c.values, value)
+	}
+	if len(c.values) == 0 {
+		// PowerDNS deletes RRSets replaced by no records.
+		c.changeType = changeTypeDelete
+	}
+	if c.ttl <= 0 {
+		c.ttl = h.cfg.RecordTTL
+	}
+	return c, h.checkRules(r, c, rrSet)
+}
+
+// checkRules checks c against auth.rules for each of its values, or without
+// a value if it deletes the RRSet.
+func (h *handler) checkRules(r *http.Request, c *change, rrSet string) error {
+	values := c.values
+	if c.changeType == changeTypeDelete {
+		values = []string{""}
+	}
+	for _, value := range values {
+		if !middleware.APITokenRulesAllow(h.cfg, r, config.EndpointPowerDNS, c.fqdn, c.recordType, value) {
+			return &apiError{code: http.StatusForbidden, message: rrSet + errNotAllowed}
+		}
+	}
+	return nil
+}
+
+// writeErr answers err, which is an apiError or an error of the Cloud API.
+func writeErr(w http.ResponseWriter, err error) {
+	var e *apiError
+	if errors.As(err, &e) {
+		writeError(w, e.code, e.message)
+		return
+	}
+	failed(w, err)
+}
+
+func logChange(r *http.Request, c *change) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	action := sanitize.LogValue(strings.ToLower(c.changeType))
+	typ := sanitize.LogValue(c.recordType)
+	name := sanitize.LogValue(c.fqdn)
+	val := sanitize.LogValue(strings.Join(c.values, ", "))
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request of API key '%s' to %s '%s' data of '%s' with values '%s'", t, action, typ, name, val)
+}
+
+func logDenied(r *http.Request, token, fqdn, recordType string) {
+	t := sanitize.LogValue(token)
+	addr := sanitize.LogValue(r.RemoteAddr)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("API key '%s' of client '%s' is not allowed to change '%s' data of '%s'", t, addr, typ, name)
+}
@@ -0,0 +1,162 @@
+// Package powerdns implements the subset of the PowerDNS authoritative HTTP
+// API used by DNS providers of tools like external-dns and cert-manager on
+// top of the zones and RRSets of the Cloud API. Clients authenticate with
+// API tokens issued by the proxy and only see the zones and RRSets their
+// grants cover.
+package powerdns
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	// HeaderAPIKey is the header carrying the API token.
+	HeaderAPIKey = "X-API-Key"
+
+	serverID           = "localhost"
+	serverURL          = "/api/v1/servers/" + serverID
+	zonesURL           = serverURL + "/zones"
+	maxRequestBodySize = 1 << 20 // 1 MB
+)
+
+// Records reads and changes the zones and RRSets of the Cloud API.
+type Records interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+	RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error)
+	SetRecords(ctx context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int) error
+	DeleteRRSet(ctx context.Context, zone *hcloud.Zone, name, recordType string) error
+}
+
+type handler struct {
+	cfg     *config.Config
+	limits  *middleware.ScopedRateLimits
+	records Records
+}
+
+// New returns the handler of the /api/v1/servers routes. It must run after
+// middleware.NewAPITokenAuth. Changes are limited by the zone and upstream
+// limits of limits.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, records Records) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, limits: limits, records: records}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/servers", listServers)
+	mux.HandleFunc("GET "+serverURL, getServer)
+	mux.HandleFunc("GET "+zonesURL, h.listZones)
+	mux.HandleFunc("GET "+zonesURL+"/{zone}", h.getZone)
+	mux.HandleFunc("PATCH "+zonesURL+"/{zone}", h.patchZone)
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, "Not Found")
+	})
+
+	return func(_ http.Handler) http.Handler {
+		return mux
+	}
+}
+
+// APIKey returns the API token of r.
+func APIKey(r *http.Request) string {
+	return r.Header.Get(HeaderAPIKey)
+}
+
+// Unauthorized answers requests without a valid API token like PowerDNS.
+func Unauthorized(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+type serverJSON struct {
+	Type       string `json:"type"`
+	ID         string `json:"id"`
+	DaemonType string `json:"daemon_type"`
+	Version    string `json:"version"`
+	URL        string `json:"url"`
+	ConfigURL  string `json:"config_url"`
+	ZonesURL   string `json:"zones_url"`
+}
+
+var server = serverJSON{
+	Type:       "Server",
+	ID:         serverID,
+	DaemonType: "authoritative",
+	Version:    "4.9.0",
+	URL:        serverURL,
+	ConfigURL:  serverURL + "/config{/config_setting}",
+	ZonesURL:   zonesURL + "{/zone}",
+}
+
+func listServers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, []serverJSON{server})
+}
+
+func getServer(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, server)
+}
+
+func (h *handler) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+// visibleZone returns the zone with the canonical name id if the API token
+// of r covers it.
+func (h *handler) visibleZone(ctx context.Context, r *http.Request, id string) (*hcloud.Zone, error) {
+	zone, err := h.records.Zone(ctx, zoneName(id))
+	if err != nil || zone == nil {
+		return nil, err
+	}
+	if !h.coversZone(r, zone.Name) {
+		return nil, nil
+	}
+	return zone, nil
+}
+
+func (h *handler) coversZone(r *http.Request, zone string) bool {
+	return middleware.APITokenCoversZone(&h.cfg.Auth, middleware.APITokenFromContext(r.Context()), zone, r.RemoteAddr)
+}
+
+// zoneName returns the name of the zone with the canonical name id, which
+// ends with a dot.
+func zoneName(id string) string {
+	return strings.ToLower(strings.TrimSuffix(id, "."))
+}
+
+// canonical returns name with a trailing dot.
+func canonical(name string) string {
+	return name + "."
+}
+
+// allow reports whether n changes of zone with the API token of r are within
+// the scoped rate limits, and answers 429 Too Many Requests otherwise.
+func (h *handler) allow(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone, n int) bool {
+	if !h.limits.AllowN(w, middleware.APITokenLimitUser(r), zone.Name, n) {
+		writeError(w, http.StatusTooManyRequests, "Too Many Requests")
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func failed(w http.ResponseWriter, err error) {
+	log.Printf("failed to call the Cloud API: %v", err)
+	writeError(w, http.StatusInternalServerError, "Internal Server Error")
+}
@@ -0,0 +1,13 @@
+package powerdns_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPowerDNS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "powerdns test suite")
+}
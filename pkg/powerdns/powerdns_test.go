@@ -0,0 +1,252 @@
+package powerdns_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/powerdns"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+)
+
+const (
+	apiKey  = "team-key"
+	zoneURL = "/api/v1/servers/localhost/zones/example.com."
+)
+
+// fakeRecords keeps the zones and RRSets of the Cloud API in memory.
+type fakeRecords struct {
+	zones  []*hcloud.Zone
+	rrSets map[int64][]*hcloud.ZoneRRSet
+	calls  []string
+}
+
+func (f *fakeRecords) Zones(_ context.Context) ([]*hcloud.Zone, error) {
+	return f.zones, nil
+}
+
+func (f *fakeRecords) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	for _, zone := range f.zones {
+		if strconv.FormatInt(zone.ID, 10) == idOrName || zone.Name == idOrName {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRecords) RRSets(_ context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	return f.rrSets[zone.ID], nil
+}
+
+func (f *fakeRecords) SetRecords(_ context.Context, _ *hcloud.Zone, name, recordType string, values []string, ttl int) error {
+	f.calls = append(f.calls, "set "+name+" "+recordType+" "+strings.Join(values, ",")+" "+strconv.Itoa(ttl))
+	return nil
+}
+
+func (f *fakeRecords) DeleteRRSet(_ context.Context, _ *hcloud.Zone, name, recordType string) error {
+	f.calls = append(f.calls, "delete "+name+" "+recordType)
+	return nil
+}
+
+type rrSetJSON struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	TTL     int    `json:"ttl"`
+	Records []struct {
+		Content string `json:"content"`
+	} `json:"records"`
+}
+
+type zoneJSON struct {
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Kind   string      `json:"kind"`
+	RRSets []rrSetJSON `json:"rrsets"`
+}
+
+var _ = Describe("PowerDNS API", func() {
+	var (
+		records *fakeRecords
+		limits  *middleware.ScopedRateLimits
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		ttl := 300
+		records = &fakeRecords{
+			zones: []*hcloud.Zone{
+				{ID: 1, Name: "example.com", TTL: 3600},
+				{ID: 2, Name: "example.org", TTL: 3600},
+			},
+			rrSets: map[int64][]*hcloud.ZoneRRSet{
+				1: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.4"}}},
+					{Name: "www", Type: hcloud.ZoneRRSetTypeTXT, Records: []hcloud.ZoneRRSetRecord{{Value: `"token"`}}},
+					{Name: "@", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.5"}}},
+				},
+			},
+		}
+
+		cfg := &config.Config{
+			Timeout:   10,
+			RecordTTL: 60,
+			Auth: config.Auth{
+				APITokens: []config.APIToken{{Name: "team", Token: apiKey, Domains: []string{"*.example.com"}}},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewAPITokenAuth(cfg, lockout, powerdns.APIKey, powerdns.Unauthorized)(
+			powerdns.New(cfg, limits, records)(nil),
+		)
+	})
+
+	do := func(method, target string, body any) *httptest.ResponseRecorder {
+		var reader io.Reader = http.NoBody
+		if body != nil {
+			data, err := json.Marshal(body)
+			Expect(err).ToNot(HaveOccurred())
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set(powerdns.HeaderAPIKey, apiKey)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	patch := func(rrSets ...map[string]any) *httptest.ResponseRecorder {
+		return do(http.MethodPatch, zoneURL, map[string]any{"rrsets": rrSets})
+	}
+
+	It("should reject invalid API keys", func() {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/servers/localhost/zones", http.NoBody)
+		req.Header.Set(powerdns.HeaderAPIKey, "invalid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("should list only covered zones", func() {
+		rec := do(http.MethodGet, "/api/v1/servers/localhost/zones", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var zones []zoneJSON
+		Expect(json.Unmarshal(rec.Body.Bytes(), &zones)).To(Succeed())
+		Expect(zones).To(ConsistOf(zoneJSON{ID: "example.com.", Name: "example.com.", Kind: "Native"}))
+	})
+
+	It("should return a zone with the granted RRSets", func() {
+		rec := do(http.MethodGet, zoneURL, nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		zone := zoneJSON{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &zone)).To(Succeed())
+		Expect(zone.RRSets).To(HaveLen(2))
+		Expect(zone.RRSets[0].Name).To(Equal("www.example.com."))
+		Expect(zone.RRSets[0].TTL).To(Equal(300))
+		Expect(zone.RRSets[0].Records[0].Content).To(Equal("1.2.3.4"))
+		Expect(zone.RRSets[1].Type).To(Equal("TXT"))
+		Expect(zone.RRSets[1].TTL).To(Equal(3600))
+		Expect(zone.RRSets[1].Records[0].Content).To(Equal(`"token"`))
+	})
+
+	It("should hide zones that are not covered", func() {
+		Expect(do(http.MethodGet, "/api/v1/servers/localhost/zones/example.org.", nil).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("should replace and delete RRSets", func() {
+		rec := patch(
+			map[string]any{
+				"name": "api.example.com.", "type": "TXT", "ttl": 120, "changetype": "REPLACE",
+				"records": []map[string]any{{"content": `"heritage=external-dns"`, "disabled": false}},
+			},
+			map[string]any{
+				"name": "www.example.com.", "type": "A", "changetype": "REPLACE",
+				"records": []map[string]any{{"content": "1.2.3.6"}, {"content": "1.2.3.7"}},
+			},
+			map[string]any{"name": "old.example.com.", "type": "AAAA", "changetype": "DELETE"},
+		)
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(records.calls).To(Equal([]string{
+			"set api TXT heritage=external-dns 120",
+			"set www A 1.2.3.6,1.2.3.7 60",
+			"delete old AAAA",
+		}))
+	})
+
+	It("should delete RRSets replaced by no records", func() {
+		rec := patch(map[string]any{"name": "www.example.com.", "type": "A", "changetype": "REPLACE", "records": []any{}})
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(records.calls).To(Equal([]string{"delete www A"}))
+	})
+
+	It("should reject patches exceeding the zone limit before applying them", func() {
+		limits.Zone = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		rec := patch(
+			map[string]any{"name": "www.example.com.", "type": "A", "changetype": "DELETE"},
+			map[string]any{"name": "old.example.com.", "type": "AAAA", "changetype": "DELETE"},
+		)
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(records.calls).To(BeEmpty())
+
+		rec = patch(map[string]any{"name": "www.example.com.", "type": "A", "changetype": "DELETE"})
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+	})
+
+	It("should limit changes per API key", func() {
+		limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		Expect(patch(map[string]any{"name": "www.example.com.", "type": "A", "changetype": "DELETE"}).Code).
+			To(Equal(http.StatusNoContent))
+		Expect(patch(map[string]any{"name": "old.example.com.", "type": "AAAA", "changetype": "DELETE"}).Code).
+			To(Equal(http.StatusTooManyRequests))
+		Expect(records.calls).To(Equal([]string{"delete www A"}))
+	})
+
+	DescribeTable("should reject changing", func(rrSet map[string]any, code int) {
+		rec := patch(
+			map[string]any{
+				"name": "ok.example.com.", "type": "A", "changetype": "REPLACE",
+				"records": []map[string]any{{"content": "1.2.3.4"}},
+			},
+			rrSet,
+		)
+		Expect(rec.Code).To(Equal(code))
+		Expect(records.calls).To(BeEmpty())
+	},
+		Entry("RRSets outside the grants", map[string]any{"name": "example.com.", "type": "A", "changetype": "DELETE"},
+			http.StatusForbidden),
+		Entry("RRSets out of zone", map[string]any{"name": "www.example.org.", "type": "A", "changetype": "DELETE"},
+			http.StatusUnprocessableEntity),
+		Entry("unsupported types", map[string]any{"name": "www.example.com.", "type": "MX", "changetype": "DELETE"},
+			http.StatusUnprocessableEntity),
+		Entry("unknown change types", map[string]any{"name": "www.example.com.", "type": "A", "changetype": "EXTEND"},
+			http.StatusUnprocessableEntity),
+		Entry("private addresses", map[string]any{
+			"name": "www.example.com.", "type": "A", "changetype": "REPLACE",
+			"records": []map[string]any{{"content": "10.0.0.1"}},
+		}, http.StatusUnprocessableEntity),
+		Entry("addresses other than the client IP", map[string]any{
+			"name": "home.example.com.", "type": "A", "changetype": "REPLACE",
+			"records": []map[string]any{{"content": "1.2.3.8"}},
+		}, http.StatusForbidden),
+		Entry("disabled records", map[string]any{
+			"name": "www.example.com.", "type": "A", "changetype": "REPLACE",
+			"records": []map[string]any{{"content": "1.2.3.4", "disabled": true}},
+		}, http.StatusUnprocessableEntity),
+	)
+})
@@ -0,0 +1,146 @@
+package powerdns
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const errZoneNotFound = "Could not find domain"
+
+type zoneJSON struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	URL            string   `json:"url"`
+	Kind           string   `json:"kind"`
+	Serial         int      `json:"serial"`
+	NotifiedSerial int      `json:"notified_serial"`
+	EditedSerial   int      `json:"edited_serial"`
+	Masters        []string `json:"masters"`
+	DNSSEC         bool     `json:"dnssec"`
+	Account        string   `json:"account"`
+}
+
+type zoneDetailJSON struct {
+	zoneJSON
+	RRSets []rrSetJSON `json:"rrsets"`
+}
+
+type rrSetJSON struct {
+	Name     string       `json:"name"`
+	Type     string       `json:"type"`
+	TTL      int          `json:"ttl"`
+	Records  []recordJSON `json:"records"`
+	Comments []any        `json:"comments"`
+}
+
+type recordJSON struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+func newZoneJSON(zone *hcloud.Zone) zoneJSON {
+	id := canonical(zone.Name)
+	return zoneJSON{
+		ID:      id,
+		Name:    id,
+		Type:    "Zone",
+		URL:     zonesURL + "/" + id,
+		Kind:    "Native",
+		Masters: []string{},
+	}
+}
+
+func newRRSetJSON(zone *hcloud.Zone, rrSet *hcloud.ZoneRRSet) rrSetJSON {
+	ttl := zone.TTL
+	if rrSet.TTL != nil {
+		ttl = *rrSet.TTL
+	}
+	records := make([]recordJSON, 0, len(rrSet.Records))
+	for _, record := range rrSet.Records {
+		records = append(records, recordJSON{Content: record.Value})
+	}
+	return rrSetJSON{
+		Name:     canonical(recordFQDN(rrSet.Name, zone.Name)),
+		Type:     string(rrSet.Type),
+		TTL:      ttl,
+		Records:  records,
+		Comments: []any{},
+	}
+}
+
+// listZones lists the zones of the API token, optionally filtered by the
+// zone query parameter.
+func (h *handler) listZones(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.records.Zones(ctx)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	name := zoneName(r.URL.Query().Get("zone"))
+	result := make([]zoneJSON, 0, len(zones))
+	for _, zone := range zones {
+		if (name == "" || zone.Name == name) && h.coversZone(r, zone.Name) {
+			result = append(result, newZoneJSON(zone))
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// getZone returns a zone with the RRSets the API token grants, optionally
+// filtered by the rrset_name and rrset_type query parameters.
+func (h *handler) getZone(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zone, err := h.visibleZone(ctx, r, r.PathValue("zone"))
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	if zone == nil {
+		writeError(w, http.StatusNotFound, errZoneNotFound)
+		return
+	}
+
+	detail := zoneDetailJSON{zoneJSON: newZoneJSON(zone), RRSets: []rrSetJSON{}}
+	if r.URL.Query().Get("rrsets") == "false" {
+		writeJSON(w, http.StatusOK, detail)
+		return
+	}
+	rrSets, err := h.records.RRSets(ctx, zone)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	filterName := strings.ToLower(r.URL.Query().Get("rrset_name"))
+	filterType := strings.ToUpper(r.URL.Query().Get("rrset_type"))
+	t := middleware.APITokenFromContext(r.Context())
+	for _, rrSet := range rrSets {
+		set := newRRSetJSON(zone, rrSet)
+		fqdn := recordFQDN(rrSet.Name, zone.Name)
+		switch {
+		case filterName != "" && set.Name != filterName,
+			filterType != "" && set.Type != filterType,
+			!middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, set.Type, r.RemoteAddr):
+			continue
+		}
+		detail.RRSets = append(detail.RRSets, set)
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// recordFQDN returns the FQDN of the RRSet name relative to zone.
+func recordFQDN(name, zone string) string {
+	if name == apexName {
+		return zone
+	}
+	return name + "." + zone
+}
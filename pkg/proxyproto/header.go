@@ -0,0 +1,158 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	v1Prefix = "PROXY "
+	// v1MaxLen is the maximum length of a v1 header including CRLF.
+	v1MaxLen = 107
+
+	v2HeaderLen    = 16
+	v2VersionMask  = 0xf0
+	v2Version      = 0x20
+	v2CommandMask  = 0x0f
+	v2CommandLocal = 0x00
+	v2CommandProxy = 0x01
+	v2FamilyMask   = 0xf0
+	v2FamilyInet   = 0x10
+	v2FamilyInet6  = 0x20
+	v2AddrLenInet  = 12
+	v2AddrLenInet6 = 36
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errNoHeader = errors.New("missing PROXY protocol header")
+
+// readHeader reads a PROXY protocol v1 or v2 header from r and returns the
+// source address it carries. ok is false if the header does not carry an
+// address, as for v1 UNKNOWN or v2 LOCAL connections (e.g. health checks).
+func readHeader(r *bufio.Reader) (src *net.TCPAddr, ok bool, err error) {
+	peek, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", errNoHeader, err)
+	}
+	if string(peek) == v1Prefix {
+		return readV1(r)
+	}
+
+	peek, err = r.Peek(len(v2Signature))
+	if err != nil || !bytes.Equal(peek, v2Signature) {
+		return nil, false, errNoHeader
+	}
+	return readV2(r)
+}
+
+func readV1(r *bufio.Reader) (*net.TCPAddr, bool, error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, false, errors.New("v1 header is not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	const (
+		fieldsProto = 2
+		fieldsFull  = 6
+	)
+	if len(fields) >= fieldsProto && fields[1] == "UNKNOWN" {
+		return nil, false, nil
+	}
+	if len(fields) != fieldsFull {
+		return nil, false, fmt.Errorf("invalid v1 header %q", line)
+	}
+
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid v1 source address: %w", err)
+	}
+	if _, err := netip.ParseAddr(fields[3]); err != nil {
+		return nil, false, fmt.Errorf("invalid v1 destination address: %w", err)
+	}
+	switch fields[1] {
+	case "TCP4":
+		if !addr.Is4() {
+			return nil, false, errors.New("v1 TCP4 header with non-IPv4 source address")
+		}
+	case "TCP6":
+		if !addr.Is6() {
+			return nil, false, errors.New("v1 TCP6 header with non-IPv6 source address")
+		}
+	default:
+		return nil, false, fmt.Errorf("unsupported v1 protocol %q", fields[1])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid v1 source port: %w", err)
+	}
+	if _, err := strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, false, fmt.Errorf("invalid v1 destination port: %w", err)
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), true, nil
+}
+
+func readV2(r *bufio.Reader) (*net.TCPAddr, bool, error) {
+	header := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, false, fmt.Errorf("failed to read v2 header: %w", err)
+	}
+	if header[12]&v2VersionMask != v2Version {
+		return nil, false, fmt.Errorf("unsupported v2 version 0x%x", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, false, fmt.Errorf("failed to read v2 addresses: %w", err)
+	}
+
+	switch header[12] & v2CommandMask {
+	case v2CommandLocal:
+		return nil, false, nil
+	case v2CommandProxy:
+	default:
+		return nil, false, fmt.Errorf("unsupported v2 command 0x%x", header[12]&v2CommandMask)
+	}
+
+	// Only the address family is relevant, the transport protocol is
+	// ignored. TLVs following the addresses are skipped.
+	switch header[13] & v2FamilyMask {
+	case v2FamilyInet:
+		if len(payload) < v2AddrLenInet {
+			return nil, false, errors.New("v2 header too short for IPv4 addresses")
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		port := binary.BigEndian.Uint16(payload[8:10])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), true, nil
+	case v2FamilyInet6:
+		if len(payload) < v2AddrLenInet6 {
+			return nil, false, errors.New("v2 header too short for IPv6 addresses")
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16]))
+		port := binary.BigEndian.Uint16(payload[32:34])
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), true, nil
+	default:
+		// AF_UNSPEC and AF_UNIX carry no usable source address.
+		return nil, false, nil
+	}
+}
@@ -0,0 +1,95 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func v2Header(command, family byte, addrs []byte) string {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, v2Version|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return string(append(header, addrs...))
+}
+
+func v2AddrsInet(src, dst string, srcPort, dstPort uint16) []byte {
+	s := netip.MustParseAddr(src).AsSlice()
+	d := netip.MustParseAddr(dst).AsSlice()
+	b := append(append([]byte{}, s...), d...)
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	return binary.BigEndian.AppendUint16(b, dstPort)
+}
+
+var _ = Describe("readHeader", func() {
+	const payload = "GET / HTTP/1.1\r\n"
+
+	DescribeTable("should read the source address", func(header, expected string) {
+		r := bufio.NewReader(strings.NewReader(header + payload))
+		src, ok, err := readHeader(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		Expect(src.String()).To(Equal(expected))
+
+		rest, err := io.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rest)).To(Equal(payload))
+	},
+		Entry("v1 TCP4", "PROXY TCP4 192.0.2.7 10.0.0.1 56324 8081\r\n", "192.0.2.7:56324"),
+		Entry("v1 TCP6", "PROXY TCP6 2001:db8::7 2001:db8::1 56324 8081\r\n", "[2001:db8::7]:56324"),
+		Entry(
+			"v2 TCP4",
+			v2Header(v2CommandProxy, 0x11, v2AddrsInet("192.0.2.7", "10.0.0.1", 56324, 8081)),
+			"192.0.2.7:56324",
+		),
+		Entry(
+			"v2 TCP6",
+			v2Header(v2CommandProxy, 0x21, v2AddrsInet("2001:db8::7", "2001:db8::1", 56324, 8081)),
+			"[2001:db8::7]:56324",
+		),
+		Entry(
+			"v2 TCP4 with TLVs",
+			v2Header(v2CommandProxy, 0x11, append(v2AddrsInet("192.0.2.7", "10.0.0.1", 56324, 8081), 0x04, 0x00, 0x01, 0xff)),
+			"192.0.2.7:56324",
+		),
+	)
+
+	DescribeTable("should not return an address", func(header string) {
+		r := bufio.NewReader(strings.NewReader(header + payload))
+		src, ok, err := readHeader(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(src).To(BeNil())
+
+		rest, err := io.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rest)).To(Equal(payload))
+	},
+		Entry("v1 UNKNOWN", "PROXY UNKNOWN\r\n"),
+		Entry("v1 UNKNOWN with addresses", "PROXY UNKNOWN 192.0.2.7 10.0.0.1 56324 8081\r\n"),
+		Entry("v2 LOCAL", v2Header(v2CommandLocal, 0x00, nil)),
+		Entry("v2 AF_UNSPEC", v2Header(v2CommandProxy, 0x00, nil)),
+	)
+
+	DescribeTable("should fail", func(header, errMsg string) {
+		_, _, err := readHeader(bufio.NewReader(strings.NewReader(header)))
+		Expect(err).To(MatchError(ContainSubstring(errMsg)))
+	},
+		Entry("without header", payload, "missing PROXY protocol header"),
+		Entry("on a short connection", "PRO", "missing PROXY protocol header"),
+		Entry("on v1 without CRLF", "PROXY TCP4 192.0.2.7 10.0.0.1 56324 8081\n", "not terminated by CRLF"),
+		Entry("on a too long v1 header", "PROXY "+strings.Repeat("A", 120)+"\r\n", "not terminated by CRLF"),
+		Entry("on v1 with missing fields", "PROXY TCP4 192.0.2.7\r\n", "invalid v1 header"),
+		Entry("on v1 with an invalid address", "PROXY TCP4 bogus 10.0.0.1 56324 8081\r\n", "invalid v1 source address"),
+		Entry("on v1 with a mismatching family", "PROXY TCP4 2001:db8::7 10.0.0.1 56324 8081\r\n", "non-IPv4"),
+		Entry("on v1 with an invalid port", "PROXY TCP4 192.0.2.7 10.0.0.1 99999 8081\r\n", "invalid v1 source port"),
+		Entry("on v2 with short addresses", v2Header(v2CommandProxy, 0x11, []byte{1, 2, 3}), "too short"),
+		Entry("on v2 with an unknown command", v2Header(0x0f, 0x11, nil), "unsupported v2 command"),
+		Entry("on a truncated v2 header", v2Header(v2CommandProxy, 0x11, nil)[:14], "failed to read v2 header"),
+	)
+})
@@ -0,0 +1,87 @@
+package proxyproto
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+)
+
+// Listener accepts connections carrying a PROXY protocol v1 or v2 header
+// and reports the source address of the header as their remote address.
+// Only connections from trusted networks are expected to send a header,
+// connections from other peers are passed through unchanged.
+type Listener struct {
+	net.Listener
+	trusted       *netlist.Trie
+	headerTimeout time.Duration
+}
+
+func NewListener(inner net.Listener, trusted []netip.Prefix, headerTimeout time.Duration) *Listener {
+	t := &netlist.Trie{}
+	for _, p := range trusted {
+		t.Insert(p)
+	}
+	return &Listener{Listener: inner, trusted: t, headerTimeout: headerTimeout}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	addrPort, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil || !l.trusted.Contains(addrPort.Addr()) {
+		return c, nil
+	}
+	return &conn{Conn: c, reader: bufio.NewReader(c), headerTimeout: l.headerTimeout}, nil
+}
+
+// conn reads the PROXY protocol header lazily on the first call to Read or
+// RemoteAddr, so that a slow peer cannot block Accept.
+type conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+	once          sync.Once
+	remoteAddr    net.Addr
+	err           error
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *conn) readHeader() {
+	if err := c.SetReadDeadline(time.Now().Add(c.headerTimeout)); err != nil {
+		c.err = err
+		return
+	}
+	src, ok, err := readHeader(c.reader)
+	if err != nil {
+		log.Printf("rejecting connection from %s: %v", c.Conn.RemoteAddr(), err)
+		c.err = err
+		return
+	}
+	if ok {
+		c.remoteAddr = src
+	}
+	c.err = c.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,69 @@
+package proxyproto_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/proxyproto"
+)
+
+var _ = Describe("Listener", func() {
+	const payload = "hello"
+
+	var inner net.Listener
+
+	BeforeEach(func(ctx context.Context) {
+		var err error
+		inner, err = (&net.ListenConfig{}).Listen(ctx, "tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(inner.Close)
+	})
+
+	accept := func(trusted []netip.Prefix, send string) (remoteAddr string, received []byte, err error) {
+		ln := proxyproto.NewListener(inner, trusted, time.Second)
+		go func() {
+			defer GinkgoRecover()
+			c, dialErr := (&net.Dialer{}).DialContext(context.Background(), "tcp", inner.Addr().String())
+			Expect(dialErr).ToNot(HaveOccurred())
+			_, writeErr := c.Write([]byte(send))
+			Expect(writeErr).ToNot(HaveOccurred())
+			Expect(c.Close()).To(Succeed())
+		}()
+
+		c, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		defer c.Close()
+		remoteAddr = c.RemoteAddr().String()
+		received, err = io.ReadAll(c)
+		return remoteAddr, received, err
+	}
+
+	It("uses the source address of the header from trusted peers", func() {
+		remoteAddr, received, err := accept(
+			[]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+			"PROXY TCP4 192.0.2.7 10.0.0.1 56324 8081\r\n"+payload,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remoteAddr).To(Equal("192.0.2.7:56324"))
+		Expect(string(received)).To(Equal(payload))
+	})
+
+	It("rejects connections without header from trusted peers", func() {
+		_, _, err := accept([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, payload)
+		Expect(err).To(MatchError(ContainSubstring("missing PROXY protocol header")))
+	})
+
+	It("passes connections from untrusted peers through unchanged", func() {
+		header := "PROXY TCP4 192.0.2.7 10.0.0.1 56324 8081\r\n"
+		remoteAddr, received, err := accept([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, header+payload)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(remoteAddr).To(HavePrefix("127.0.0.1:"))
+		Expect(string(received)).To(Equal(header + payload))
+	})
+})
@@ -0,0 +1,46 @@
+package ratelimit
+
+import "time"
+
+// Decision is the outcome of a single rate limit or quota check.
+type Decision struct {
+	Allowed bool
+	// Limit is the size of the bucket or quota the decision was made against.
+	Limit int
+	// Remaining is the number of requests left after this one.
+	Remaining int
+	// Reset is the time until the bucket or quota is replenished. For a
+	// rejected request it is the time until a retry can succeed.
+	Reset time.Duration
+}
+
+// Stricter returns the more restrictive of two decisions. A rejection wins
+// over an admission, otherwise the decision with fewer remaining requests
+// (or the longer reset on a tie) is returned. Admissions without a limit,
+// as returned by a nil Policy, never win over one with a limit.
+func Stricter(a, b Decision) Decision {
+	if a.Allowed && b.Allowed {
+		if a.Limit == 0 {
+			return b
+		}
+		if b.Limit == 0 {
+			return a
+		}
+	}
+	if a.Allowed != b.Allowed {
+		if !a.Allowed {
+			return a
+		}
+		return b
+	}
+	if !a.Allowed {
+		if b.Reset > a.Reset {
+			return b
+		}
+		return a
+	}
+	if b.Remaining < a.Remaining || (b.Remaining == a.Remaining && b.Reset > a.Reset) {
+		return b
+	}
+	return a
+}
@@ -1,6 +1,7 @@
 package ratelimit
 
 import (
+	"math"
 	"sync"
 	"time"
 
@@ -44,6 +45,27 @@ func NewLimiter(ratePerSecond float64, burst int, idle time.Duration) *Limiter {
 }
 
 func (l *Limiter) Allow(key string) bool {
+	return l.Check(key).Allowed
+}
+
+// Check takes a token from the bucket of key and reports the resulting
+// state of the bucket.
+func (l *Limiter) Check(key string) Decision {
+	return l.CheckN(key, 1)
+}
+
+// CheckN takes n tokens from the bucket of key if it holds that many and
+// reports the resulting state of the bucket.
+func (l *Limiter) CheckN(key string, n int) Decision {
+	return l.check(key, n, true)
+}
+
+// Peek reports the decision of CheckN without taking any tokens.
+func (l *Limiter) Peek(key string, n int) Decision {
+	return l.check(key, n, false)
+}
+
+func (l *Limiter) check(key string, n int, take bool) Decision {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -57,14 +79,41 @@ func (l *Limiter) Allow(key string) bool {
 		if len(l.buckets) >= l.maxBuckets {
 			l.sweep(now)
 			if len(l.buckets) >= l.maxBuckets {
-				return false
+				return Decision{Limit: l.burst, Reset: limiterSweepInterval}
 			}
 		}
 		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
 		l.buckets[key] = b
 	}
 	b.lastSeen = now
-	return b.limiter.AllowN(now, 1)
+
+	tokens := b.limiter.TokensAt(now)
+	allowed := tokens >= float64(n)
+	if allowed {
+		if take {
+			b.limiter.AllowN(now, n)
+		}
+		tokens -= float64(n)
+	}
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     l.burst,
+		Remaining: max(0, int(math.Floor(tokens))),
+	}
+	if allowed {
+		d.Reset = l.refill(float64(l.burst) - tokens)
+	} else {
+		d.Reset = l.refill(float64(n) - tokens)
+	}
+	return d
+}
+
+// refill returns how long the bucket takes to gain the given amount of tokens.
+func (l *Limiter) refill(tokens float64) time.Duration {
+	if tokens <= 0 || l.limit <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / float64(l.limit) * float64(time.Second))
 }
 
 func (l *Limiter) shouldSweep(now time.Time) bool {
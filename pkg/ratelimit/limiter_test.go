@@ -49,6 +49,21 @@ var _ = Describe("Limiter", func() {
 		Expect(l.Allow(ip)).To(BeFalse())
 	})
 
+	It("reports the bucket state", func() {
+		d := l.Check(ip)
+		Expect(d.Allowed).To(BeTrue())
+		Expect(d.Limit).To(Equal(3))
+		Expect(d.Remaining).To(Equal(2))
+		Expect(d.Reset).To(Equal(time.Second))
+
+		l.Check(ip)
+		l.Check(ip)
+		d = l.Check(ip)
+		Expect(d.Allowed).To(BeFalse())
+		Expect(d.Remaining).To(BeZero())
+		Expect(d.Reset).To(Equal(time.Second))
+	})
+
 	It("isolates keys", func() {
 		for range 3 {
 			Expect(l.Allow(ip)).To(BeTrue())
@@ -0,0 +1,70 @@
+package ratelimit
+
+import "sync"
+
+// Policy combines an optional token bucket with an optional daily quota.
+// A nil Policy allows every request.
+type Policy struct {
+	mu      sync.Mutex
+	limiter *Limiter
+	quota   *Quota
+}
+
+func NewPolicy(limiter *Limiter, quota *Quota) *Policy {
+	if limiter == nil && quota == nil {
+		return nil
+	}
+	return &Policy{limiter: limiter, quota: quota}
+}
+
+// Check is CheckN for a single request.
+func (p *Policy) Check(key string) Decision {
+	return p.CheckN(key, 1)
+}
+
+// CheckN takes n tokens from the bucket of key and counts n requests against
+// the daily quota of key if both allow them, so that a rejection by either
+// charges neither. The stricter of both decisions is returned.
+func (p *Policy) CheckN(key string, n int) Decision {
+	if p == nil {
+		return Decision{Allowed: true}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if d := p.decide(key, n, false); !d.Allowed {
+		return d
+	}
+	return p.decide(key, n, true)
+}
+
+// Peek reports the decision of CheckN without charging the bucket or the
+// quota.
+func (p *Policy) Peek(key string, n int) Decision {
+	if p == nil {
+		return Decision{Allowed: true}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.decide(key, n, false)
+}
+
+func (p *Policy) decide(key string, n int, take bool) Decision {
+	limiterCheck, quotaCheck := p.limiter.Peek, p.quota.Peek
+	if take {
+		limiterCheck, quotaCheck = p.limiter.CheckN, p.quota.CheckN
+	}
+
+	d := Decision{Allowed: true}
+	if p.limiter != nil {
+		d = limiterCheck(key, n)
+		if !d.Allowed {
+			return d
+		}
+	}
+	if p.quota != nil {
+		d = Stricter(d, quotaCheck(key, n))
+	}
+	return d
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// quotaDefaultMaxEntries caps memory use when many unique keys are seen
+// within a day. When full, requests for new keys are rejected until the
+// quota resets.
+const quotaDefaultMaxEntries = 1 << 16
+
+// Quota counts requests per key and rejects them once limit is reached.
+// All counters are reset at midnight UTC.
+type Quota struct {
+	mu         sync.Mutex
+	counts     map[string]int
+	limit      int
+	maxEntries int
+	now        func() time.Time
+	resetAt    time.Time
+}
+
+func NewQuota(limit int) *Quota {
+	return &Quota{
+		counts:     make(map[string]int),
+		limit:      limit,
+		maxEntries: quotaDefaultMaxEntries,
+		now:        time.Now,
+	}
+}
+
+// Check counts a request against the quota of key and reports the
+// resulting state. Rejected requests are not counted.
+func (q *Quota) Check(key string) Decision {
+	return q.CheckN(key, 1)
+}
+
+// CheckN counts n requests against the quota of key if they fit and reports
+// the resulting state. Rejected requests are not counted.
+func (q *Quota) CheckN(key string, n int) Decision {
+	return q.check(key, n, true)
+}
+
+// Peek reports the decision of CheckN without counting the requests.
+func (q *Quota) Peek(key string, n int) Decision {
+	return q.check(key, n, false)
+}
+
+func (q *Quota) check(key string, n int, count bool) Decision {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	if !now.Before(q.resetAt) {
+		clear(q.counts)
+		q.resetAt = now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	}
+
+	d := Decision{Limit: q.limit, Reset: q.resetAt.Sub(now)}
+	c, ok := q.counts[key]
+	if c+n > q.limit || (!ok && len(q.counts) >= q.maxEntries) {
+		return d
+	}
+
+	c += n
+	if count {
+		q.counts[key] = c
+	}
+	d.Allowed = true
+	d.Remaining = q.limit - c
+	return d
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Quota", func() {
+	const user = "user"
+
+	var (
+		now time.Time
+		q   *Quota
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		q = NewQuota(2)
+		q.now = func() time.Time { return now }
+	})
+
+	It("allows up to the limit and reports the remaining requests", func() {
+		d := q.Check(user)
+		Expect(d.Allowed).To(BeTrue())
+		Expect(d.Limit).To(Equal(2))
+		Expect(d.Remaining).To(Equal(1))
+		Expect(d.Reset).To(Equal(12 * time.Hour))
+
+		d = q.Check(user)
+		Expect(d.Allowed).To(BeTrue())
+		Expect(d.Remaining).To(BeZero())
+
+		d = q.Check(user)
+		Expect(d.Allowed).To(BeFalse())
+		Expect(d.Remaining).To(BeZero())
+		Expect(d.Reset).To(Equal(12 * time.Hour))
+	})
+
+	It("isolates keys", func() {
+		Expect(q.Check(user).Allowed).To(BeTrue())
+		Expect(q.Check(user).Allowed).To(BeTrue())
+		Expect(q.Check(user).Allowed).To(BeFalse())
+		Expect(q.Check("other").Allowed).To(BeTrue())
+	})
+
+	It("resets at midnight UTC", func() {
+		Expect(q.Check(user).Allowed).To(BeTrue())
+		Expect(q.Check(user).Allowed).To(BeTrue())
+		Expect(q.Check(user).Allowed).To(BeFalse())
+
+		now = time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+		d := q.Check(user)
+		Expect(d.Allowed).To(BeTrue())
+		Expect(d.Reset).To(Equal(24 * time.Hour))
+	})
+
+	It("rejects new keys when the entry cap is reached", func() {
+		q.maxEntries = 1
+		Expect(q.Check(user).Allowed).To(BeTrue())
+		Expect(q.Check("other").Allowed).To(BeFalse())
+		Expect(q.Check(user).Allowed).To(BeTrue())
+	})
+})
+
+var _ = Describe("Policy", func() {
+	const key = "key"
+
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It("allows everything when nil", func() {
+		p := NewPolicy(nil, nil)
+		Expect(p).To(BeNil())
+		Expect(p.Check(key).Allowed).To(BeTrue())
+	})
+
+	It("does not count requests rejected by the limiter against the quota", func() {
+		l := NewLimiter(1.0, 1, time.Minute)
+		l.now = func() time.Time { return now }
+		q := NewQuota(10)
+		q.now = func() time.Time { return now }
+		p := NewPolicy(l, q)
+
+		Expect(p.Check(key).Allowed).To(BeTrue())
+		Expect(p.Check(key).Allowed).To(BeFalse())
+		Expect(q.counts).To(HaveKeyWithValue(key, 1))
+	})
+
+	It("does not take tokens for requests rejected by the quota", func() {
+		l := NewLimiter(1.0, 5, time.Minute)
+		l.now = func() time.Time { return now }
+		q := NewQuota(1)
+		q.now = func() time.Time { return now }
+		p := NewPolicy(l, q)
+
+		Expect(p.Check(key).Allowed).To(BeTrue())
+		Expect(p.Check(key).Allowed).To(BeFalse())
+		Expect(l.Peek(key, 4).Allowed).To(BeTrue())
+		Expect(l.Peek(key, 5).Allowed).To(BeFalse())
+	})
+
+	It("charges n requests all or none", func() {
+		l := NewLimiter(1.0, 5, time.Minute)
+		l.now = func() time.Time { return now }
+		q := NewQuota(3)
+		q.now = func() time.Time { return now }
+		p := NewPolicy(l, q)
+
+		Expect(p.CheckN(key, 4).Allowed).To(BeFalse())
+		d := p.CheckN(key, 3)
+		Expect(d.Allowed).To(BeTrue())
+		Expect(d.Remaining).To(Equal(0))
+		Expect(l.Peek(key, 2).Allowed).To(BeTrue())
+	})
+
+	It("returns the stricter decision", func() {
+		l := NewLimiter(1.0, 5, time.Minute)
+		l.now = func() time.Time { return now }
+		q := NewQuota(2)
+		q.now = func() time.Time { return now }
+		p := NewPolicy(l, q)
+
+		d := p.Check(key)
+		Expect(d.Allowed).To(BeTrue())
+		Expect(d.Limit).To(Equal(2))
+		Expect(d.Remaining).To(Equal(1))
+
+		Expect(p.Check(key).Allowed).To(BeTrue())
+		d = p.Check(key)
+		Expect(d.Allowed).To(BeFalse())
+		Expect(d.Reset).To(Equal(12 * time.Hour))
+	})
+})
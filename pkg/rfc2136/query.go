@@ -0,0 +1,59 @@
+package rfc2136
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+// SOA timers of the synthesized zones in seconds.
+const (
+	soaRefresh = 86400
+	soaRetry   = 10800
+	soaExpire  = 3600000
+	soaMinTTL  = 3600
+)
+
+// query answers SOA queries so that clients can discover the zone of a
+// name: the SOA record of the zone is returned as answer for the zone and
+// in the authority section for other names. Other queries are refused.
+func (h *Handler) query(req, resp *dns.Msg) int {
+	q := req.Question[0]
+	if q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET {
+		return dns.RcodeRefused
+	}
+	name := fqdn(q.Name)
+	_, zone, err := middleware.SplitFQDN(name)
+	if err != nil {
+		return dns.RcodeRefused
+	}
+
+	resp.Authoritative = true
+	soa := h.soa(zone)
+	if name == zone {
+		resp.Answer = append(resp.Answer, soa)
+	} else {
+		resp.Ns = append(resp.Ns, soa)
+	}
+	return dns.RcodeSuccess
+}
+
+func (h *Handler) soa(zone string) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(zone),
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(h.cfg.RecordTTL), //nolint:gosec // the TTL is a small positive number
+		},
+		Ns:      dns.Fqdn(h.cfg.RFC2136.PrimaryNS),
+		Mbox:    "hostmaster." + dns.Fqdn(zone),
+		Serial:  uint32(time.Now().Unix()), //nolint:gosec // serial numbers wrap around
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  soaMinTTL,
+	}
+}
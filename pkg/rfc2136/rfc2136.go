@@ -0,0 +1,226 @@
+// Package rfc2136 implements a DNS UPDATE (RFC 2136) listener authenticated
+// with TSIG (RFC 8945) that adds and removes records through the Cloud API
+// under the limits of the HTTP endpoints.
+package rfc2136
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/miekg/dns"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/netlist"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// maxSectionRecords limits the records of the prerequisite and update
+	// sections of a message.
+	maxSectionRecords = 64
+	// tsigFudge is the permitted clock skew of signed messages in seconds.
+	tsigFudge = 300
+)
+
+// Records looks up existing records to evaluate prerequisites and adds and
+// removes the records of updates.
+type Records interface {
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+	Values(ctx context.Context, zone, name, recordType string) ([]string, error)
+	NameInUse(ctx context.Context, zone, name string) (bool, error)
+	AddRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string, ttl int) error
+	RemoveRecord(ctx context.Context, zone *hcloud.Zone, name, recordType, value string) error
+}
+
+// Handler answers DNS UPDATE messages and SOA queries.
+type Handler struct {
+	cfg     *config.Config
+	keys    map[string]*config.TSIGKey
+	limits  *middleware.ScopedRateLimits
+	lockout *ratelimit.Lockout
+	deny    *netlist.List
+	exempt  *netlist.List
+	records Records
+}
+
+// New returns a Handler whose updates count towards limits with the name of
+// their key as user. Clients failing TSIG authentication are locked out by
+// lockout like the clients of the HTTP endpoints.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, lockout *ratelimit.Lockout, records Records) *Handler {
+	keys := map[string]*config.TSIGKey{}
+	for i := range cfg.RFC2136.Keys {
+		key := &cfg.RFC2136.Keys[i]
+		keys[dns.CanonicalName(key.Name)] = key
+	}
+	return &Handler{
+		cfg:     cfg,
+		keys:    keys,
+		limits:  limits,
+		lockout: lockout,
+		deny:    netlist.New(cfg.DenyNetworks.Prefixes, cfg.DenyNetworks.Files),
+		exempt:  netlist.New(cfg.ExemptNetworks.Prefixes, cfg.ExemptNetworks.Files),
+		records: records,
+	}
+}
+
+// NewServers returns the UDP and TCP servers of handler listening on
+// cfg.RFC2136.ListenAddr.
+func NewServers(cfg *config.Config, handler dns.Handler) []*dns.Server {
+	secrets := TSIGSecrets(cfg.RFC2136.Keys)
+	servers := make([]*dns.Server, 0, 2) //nolint:mnd // UDP and TCP
+	for _, network := range []string{"udp", "tcp"} {
+		servers = append(servers, &dns.Server{
+			Addr:          cfg.RFC2136.ListenAddr,
+			Net:           network,
+			Handler:       handler,
+			UDPSize:       dns.DefaultMsgSize,
+			TsigSecret:    secrets,
+			MsgAcceptFunc: acceptMsg,
+		})
+	}
+	return servers
+}
+
+// TSIGSecrets returns the secrets of keys by canonical key name.
+func TSIGSecrets(keys []config.TSIGKey) map[string]string {
+	secrets := make(map[string]string, len(keys))
+	for _, key := range keys {
+		secrets[dns.CanonicalName(key.Name)] = key.Secret
+	}
+	return secrets
+}
+
+// acceptMsg accepts queries and updates with a single zone or question and
+// a bounded number of records.
+func acceptMsg(dh dns.Header) dns.MsgAcceptAction {
+	const (
+		flagQR        = 1 << 15
+		opcodeShift   = 11
+		opcodeMask    = 0xF
+		maxAdditional = 2
+	)
+	if dh.Bits&flagQR != 0 {
+		return dns.MsgIgnore
+	}
+	if opcode := int(dh.Bits>>opcodeShift) & opcodeMask; opcode != dns.OpcodeQuery && opcode != dns.OpcodeUpdate {
+		return dns.MsgRejectNotImplemented
+	}
+	if dh.Qdcount != 1 || dh.Ancount > maxSectionRecords || dh.Nscount > maxSectionRecords || dh.Arcount > maxAdditional {
+		return dns.MsgReject
+	}
+	return dns.MsgAccept
+}
+
+func (h *Handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+
+	remoteAddr := remoteIP(w.RemoteAddr())
+	var key *config.TSIGKey
+	exempt, rcode := h.filterClient(remoteAddr)
+	if rcode == dns.RcodeSuccess {
+		key, rcode = h.authenticate(w, req, remoteAddr, exempt)
+	}
+	if rcode == dns.RcodeSuccess {
+		switch req.Opcode {
+		case dns.OpcodeUpdate:
+			rcode = h.update(key, remoteAddr, req)
+		case dns.OpcodeQuery:
+			rcode = h.query(req, resp)
+		default:
+			rcode = dns.RcodeNotImplemented
+		}
+	}
+	resp.Rcode = rcode
+
+	// Clients treat signed NOTAUTH responses as TSIG errors.
+	if key != nil && rcode != dns.RcodeNotAuth {
+		resp.SetTsig(dns.CanonicalName(key.Name), dns.CanonicalName(key.Algorithm), tsigFudge, time.Now().Unix())
+	}
+	if err := w.WriteMsg(resp); err != nil {
+		log.Printf("failed to write DNS response: %v", err)
+	}
+}
+
+// filterClient refuses clients in the denied networks and reports whether
+// the client is in the exempt networks.
+func (h *Handler) filterClient(remoteAddr string) (exempt bool, rcode int) {
+	addr, err := netip.ParseAddr(remoteAddr)
+	if err != nil {
+		log.Printf("failed to parse client address %s: %v", remoteAddr, err)
+		return false, dns.RcodeServerFailure
+	}
+	if h.deny.Contains(addr) {
+		log.Printf("client '%s' is in a denied network", addr)
+		return false, dns.RcodeRefused
+	}
+	return h.exempt.Contains(addr), dns.RcodeSuccess
+}
+
+// authenticate returns the key the request is signed with. Updates must be
+// signed, queries may be unsigned. Unsigned updates and failed TSIG
+// authentications count towards the lockout of clients not exempt from it.
+func (h *Handler) authenticate(w dns.ResponseWriter, req *dns.Msg, remoteAddr string, exempt bool) (*config.TSIGKey, int) {
+	t := req.IsTsig()
+	if t == nil && req.Opcode != dns.OpcodeUpdate {
+		return nil, dns.RcodeSuccess
+	}
+	if !exempt && h.lockout.IsBlocked(remoteAddr) {
+		log.Printf("client '%s' is locked out", remoteAddr)
+		return nil, dns.RcodeRefused
+	}
+	if t == nil {
+		logRefused(w.RemoteAddr(), "unsigned update")
+		h.recordFailure(remoteAddr, exempt)
+		return nil, dns.RcodeRefused
+	}
+
+	key, ok := h.keys[dns.CanonicalName(t.Hdr.Name)]
+	if err := w.TsigStatus(); err != nil || !ok ||
+		dns.CanonicalName(t.Algorithm) != dns.CanonicalName(key.Algorithm) {
+		name := sanitize.LogValue(t.Hdr.Name)
+		//nolint:gosec // value is sanitized above
+		log.Printf("client '%s' failed TSIG authentication with key '%s': %v", remoteAddr, name, err)
+		h.recordFailure(remoteAddr, exempt)
+		return nil, dns.RcodeNotAuth
+	}
+	h.lockout.Reset(remoteAddr)
+	return key, dns.RcodeSuccess
+}
+
+func (h *Handler) recordFailure(remoteAddr string, exempt bool) {
+	if !exempt {
+		h.lockout.RecordFailure(remoteAddr)
+	}
+}
+
+func remoteIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func logRefused(addr net.Addr, reason string) {
+	log.Printf("refused DNS message from '%s': %s", remoteIP(addr), reason)
+}
+
+// fqdn returns name in the form used by the HTTP endpoints, i.e. in lower
+// case without the trailing dot.
+func fqdn(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
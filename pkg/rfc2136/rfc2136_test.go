@@ -0,0 +1,435 @@
+package rfc2136_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/miekg/dns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rfc2136"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+const (
+	zone      = "example.com."
+	keyName   = "certbot."
+	keySecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0"
+	digest    = "LPJNul-wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ"
+)
+
+// fakeBackend keeps the values of RRsets by name and type and records the
+// changes made to them. The change with the index failAt fails.
+type fakeBackend struct {
+	mu     sync.Mutex
+	calls  []string
+	values map[string][]string
+	failAt int
+}
+
+func (f *fakeBackend) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	if idOrName != strings.TrimSuffix(zone, ".") {
+		return nil, nil
+	}
+	return &hcloud.Zone{ID: 1, Name: idOrName}, nil
+}
+
+func (f *fakeBackend) Values(_ context.Context, zone, name, recordType string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.values[name+"."+zone+"/"+recordType], nil
+}
+
+func (f *fakeBackend) AddRecord(_ context.Context, z *hcloud.Zone, name, recordType, value string, ttl int) error {
+	key := name + "." + z.Name + "/" + recordType
+	return f.change(key, fmt.Sprintf("add %s %s %s %d", name, recordType, value, ttl), func(values []string) []string {
+		return append(values, value)
+	})
+}
+
+func (f *fakeBackend) RemoveRecord(_ context.Context, z *hcloud.Zone, name, recordType, value string) error {
+	key := name + "." + z.Name + "/" + recordType
+	return f.change(key, fmt.Sprintf("remove %s %s %s", name, recordType, value), func(values []string) []string {
+		return slices.DeleteFunc(values, func(v string) bool { return v == value })
+	})
+}
+
+func (f *fakeBackend) change(key, call string, apply func([]string) []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.calls) == f.failAt {
+		f.calls = append(f.calls, "failed "+call)
+		return errors.New("failed")
+	}
+	f.calls = append(f.calls, call)
+	f.values[key] = apply(f.values[key])
+	return nil
+}
+
+func (f *fakeBackend) NameInUse(_ context.Context, zone, name string) (bool, error) {
+	for k := range f.values {
+		if strings.HasPrefix(k, name+"."+zone+"/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var _ = Describe("RFC 2136", func() {
+	var (
+		backend *fakeBackend
+		cfg     *config.Config
+		limits  *middleware.ScopedRateLimits
+		lockout *ratelimit.Lockout
+		addr    string
+		servers []*dns.Server
+	)
+
+	BeforeEach(func() {
+		backend = &fakeBackend{values: map[string][]string{}, failAt: -1}
+		expr := `endpoint != "rfc2136" || fqdn != "denied.dyn.example.com"`
+		program, err := rules.Compile(expr, config.RuleVariables)
+		Expect(err).ToNot(HaveOccurred())
+		cfg = &config.Config{
+			Timeout: 10,
+			Auth: config.Auth{
+				Rules: []config.Rule{{Name: "denied-name", Expr: expr, Program: program}},
+				Roles: map[string]*config.Role{
+					"acme": {Domains: []string{"*.acme.example.com"}, RecordTypes: []string{"TXT"}},
+				},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.dyn.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+			RecordTTL: 60,
+			RFC2136: &config.RFC2136{
+				ListenAddr: "127.0.0.1:0",
+				PrimaryNS:  "ns.example.net",
+				Keys: []config.TSIGKey{
+					{
+						Name:      keyName,
+						Algorithm: config.TSIGAlgorithmHMACSHA256,
+						Secret:    keySecret,
+						Domains:   []string{"*.dyn.example.com"},
+						Roles:     []string{"acme"},
+					},
+					{
+						Name:      "sha512",
+						Algorithm: config.TSIGAlgorithmHMACSHA512,
+						Secret:    keySecret,
+						Domains:   []string{"*.example.com", "*.example.net"},
+					},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout = ratelimit.NewLockout(10, time.Hour, time.Hour)
+	})
+
+	JustBeforeEach(func() {
+		servers = rfc2136.NewServers(cfg, rfc2136.New(cfg, limits, lockout, backend))
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		servers[0].PacketConn = pc
+		started := make(chan struct{})
+		servers[0].NotifyStartedFunc = func() { close(started) }
+		go func() { _ = servers[0].ActivateAndServe() }()
+		Eventually(started).Should(BeClosed())
+		addr = pc.LocalAddr().String()
+	})
+
+	AfterEach(func() {
+		Expect(servers[0].Shutdown()).To(Succeed())
+	})
+
+	exchange := func(m *dns.Msg, key, algorithm, secret string) *dns.Msg {
+		c := &dns.Client{Timeout: 5 * time.Second}
+		if key != "" {
+			c.TsigSecret = map[string]string{key: secret}
+			m.SetTsig(key, algorithm, 300, time.Now().Unix())
+		}
+		r, _, err := c.Exchange(m, addr)
+		Expect(err).ToNot(HaveOccurred())
+		return r
+	}
+
+	newUpdate := func(insert, remove []string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate(zone)
+		for _, s := range insert {
+			rr, err := dns.NewRR(s)
+			Expect(err).ToNot(HaveOccurred())
+			m.Insert([]dns.RR{rr})
+		}
+		for _, s := range remove {
+			rr, err := dns.NewRR(s)
+			Expect(err).ToNot(HaveOccurred())
+			m.Remove([]dns.RR{rr})
+		}
+		return m
+	}
+
+	signed := func(m *dns.Msg) *dns.Msg {
+		return exchange(m, keyName, dns.HmacSHA256, keySecret)
+	}
+
+	Context("queries", func() {
+		It("answers SOA queries of zones", func() {
+			m := new(dns.Msg)
+			m.SetQuestion(zone, dns.TypeSOA)
+			r := exchange(m, "", "", "")
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(r.Authoritative).To(BeTrue())
+			Expect(r.Answer).To(HaveLen(1))
+			soa, ok := r.Answer[0].(*dns.SOA)
+			Expect(ok).To(BeTrue())
+			Expect(soa.Hdr.Name).To(Equal(zone))
+			Expect(soa.Ns).To(Equal("ns.example.net."))
+		})
+
+		It("returns the SOA of the zone in the authority section for other names", func() {
+			m := new(dns.Msg)
+			m.SetQuestion("_acme-challenge.a.example.com.", dns.TypeSOA)
+			r := signed(m)
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(r.Answer).To(BeEmpty())
+			Expect(r.Ns).To(HaveLen(1))
+			Expect(r.Ns[0].Header().Name).To(Equal(zone))
+			Expect(r.IsTsig()).ToNot(BeNil())
+		})
+
+		It("refuses other queries", func() {
+			m := new(dns.Msg)
+			m.SetQuestion("a.example.com.", dns.TypeA)
+			Expect(exchange(m, "", "", "").Rcode).To(Equal(dns.RcodeRefused))
+		})
+	})
+
+	Context("updates", func() {
+		It("adds records to their RRsets", func() {
+			backend.values["a.dyn.example.com/A"] = []string{"1.2.3.1"}
+			r := signed(newUpdate([]string{
+				"a.dyn.example.com. 120 IN A 1.2.3.2",
+				"_acme-challenge.x.acme.example.com. 60 IN TXT " + digest,
+			}, nil))
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(r.IsTsig()).ToNot(BeNil())
+			Expect(backend.calls).To(Equal([]string{
+				"add a.dyn A 1.2.3.2 120",
+				"add _acme-challenge.x.acme TXT " + digest + " 60",
+			}))
+			Expect(backend.values).To(HaveKeyWithValue("a.dyn.example.com/A", []string{"1.2.3.1", "1.2.3.2"}))
+		})
+
+		It("deletes records", func() {
+			backend.values["_acme-challenge.x.acme.example.com/TXT"] = []string{digest, "other"}
+			r := signed(newUpdate(nil, []string{"_acme-challenge.x.acme.example.com. 0 IN TXT " + digest}))
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(backend.calls).To(Equal([]string{"remove _acme-challenge.x.acme TXT " + digest}))
+			Expect(backend.values).To(HaveKeyWithValue("_acme-challenge.x.acme.example.com/TXT", []string{"other"}))
+		})
+
+		It("skips adding existing and deleting missing records", func() {
+			backend.values["a.dyn.example.com/A"] = []string{"1.2.3.1"}
+			r := signed(newUpdate(
+				[]string{"a.dyn.example.com. 60 IN A 1.2.3.1"},
+				[]string{"a.dyn.example.com. 0 IN A 1.2.3.2"},
+			))
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(backend.calls).To(BeEmpty())
+		})
+
+		It("supports hmac-sha512 keys", func() {
+			r := exchange(newUpdate([]string{"b.example.com. 60 IN AAAA 2a01:4f8::1"}, nil), "sha512.", dns.HmacSHA512, keySecret)
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(backend.calls).To(HaveLen(1))
+		})
+
+		It("limits updates per key", func() {
+			limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(2))
+			r := signed(newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1", "b.dyn.example.com. 60 IN A 1.2.3.1"}, nil))
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+
+			r = signed(newUpdate([]string{"c.dyn.example.com. 60 IN A 1.2.3.1"}, nil))
+			Expect(r.Rcode).To(Equal(dns.RcodeRefused))
+			Expect(backend.calls).To(HaveLen(2))
+
+			r = exchange(newUpdate([]string{"c.example.com. 60 IN A 1.2.3.1"}, nil), "sha512.", dns.HmacSHA512, keySecret)
+			Expect(r.Rcode).To(Equal(dns.RcodeSuccess))
+		})
+
+		Context("with a lockout after one failure", func() {
+			BeforeEach(func() {
+				lockout = ratelimit.NewLockout(1, time.Hour, time.Hour)
+			})
+
+			failAuthentication := func() {
+				m := newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+				Expect(exchange(m, keyName, dns.HmacSHA256, "d3Jvbmc=").Rcode).To(Equal(dns.RcodeNotAuth))
+			}
+
+			It("locks out clients failing TSIG authentication", func() {
+				failAuthentication()
+				Expect(signed(newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)).Rcode).To(Equal(dns.RcodeRefused))
+				Expect(backend.calls).To(BeEmpty())
+			})
+
+			Context("in exempt networks", func() {
+				BeforeEach(func() {
+					cfg.ExemptNetworks.Prefixes = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+				})
+
+				It("does not lock out clients", func() {
+					failAuthentication()
+					Expect(signed(newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)).Rcode).To(Equal(dns.RcodeSuccess))
+				})
+			})
+		})
+
+		Context("with denied networks", func() {
+			BeforeEach(func() {
+				cfg.DenyNetworks.Prefixes = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+			})
+
+			It("refuses clients in denied networks", func() {
+				Expect(signed(newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)).Rcode).To(Equal(dns.RcodeRefused))
+				Expect(backend.calls).To(BeEmpty())
+			})
+		})
+
+		DescribeTable("should reject", func(m func() *dns.Msg, key, algorithm, secret string, rcode int) {
+			Expect(exchange(m(), key, algorithm, secret).Rcode).To(Equal(rcode))
+			Expect(backend.calls).To(BeEmpty())
+		},
+			Entry("unsigned updates", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, "", "", "", dns.RcodeRefused),
+			Entry("updates with a wrong secret", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA256, "d3Jvbmc=", dns.RcodeNotAuth),
+			Entry("updates with an unknown key", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, "unknown.", dns.HmacSHA256, keySecret, dns.RcodeNotAuth),
+			Entry("updates with another algorithm", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA512, keySecret, dns.RcodeNotAuth),
+			Entry("names not granted", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1", "www.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeRefused),
+			Entry("record types not granted by the role", func() *dns.Msg {
+				return newUpdate([]string{"x.acme.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeRefused),
+			Entry("names outside the zone", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.org. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeNotZone),
+			Entry("names denied by rules", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1", "denied.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeRefused),
+			Entry("private addresses", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 10.0.0.1"}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeRefused),
+			Entry("addresses other than the client IP", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1", "home.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeRefused),
+			Entry("zones that do not exist", func() *dns.Msg {
+				m := newUpdate([]string{"a.example.net. 60 IN A 1.2.3.1"}, nil)
+				m.Question[0].Name = "example.net."
+				return m
+			}, "sha512.", dns.HmacSHA512, keySecret, dns.RcodeNotAuth),
+			Entry("unsupported record types", func() *dns.Msg {
+				return newUpdate([]string{"a.dyn.example.com. 60 IN CNAME example.net."}, nil)
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeNotImplemented),
+			Entry("RRset deletes", func() *dns.Msg {
+				m := newUpdate(nil, nil)
+				m.RemoveRRset([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "a.dyn.example.com.", Rrtype: dns.TypeA}}})
+				return m
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeNotImplemented),
+			Entry("zones that are not registrable domains", func() *dns.Msg {
+				m := newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.1"}, nil)
+				m.Question[0].Name = "dyn.example.com."
+				return m
+			}, keyName, dns.HmacSHA256, keySecret, dns.RcodeNotAuth),
+		)
+
+		It("reverts the applied updates if an update fails", func() {
+			backend.values["a.dyn.example.com/A"] = []string{"1.2.3.1"}
+			backend.failAt = 2
+			r := signed(newUpdate(
+				[]string{"b.dyn.example.com. 120 IN A 1.2.3.2", "c.dyn.example.com. 60 IN A 1.2.3.3"},
+				[]string{"a.dyn.example.com. 0 IN A 1.2.3.1"},
+			))
+			Expect(r.Rcode).To(Equal(dns.RcodeServerFailure))
+			Expect(backend.calls).To(Equal([]string{
+				"add b.dyn A 1.2.3.2 120",
+				"add c.dyn A 1.2.3.3 60",
+				"failed remove a.dyn A 1.2.3.1",
+				"remove c.dyn A 1.2.3.3",
+				"remove b.dyn A 1.2.3.2",
+			}))
+			Expect(backend.values).To(HaveKeyWithValue("a.dyn.example.com/A", []string{"1.2.3.1"}))
+		})
+	})
+
+	Context("prerequisites", func() {
+		BeforeEach(func() {
+			backend.values["a.dyn.example.com/A"] = []string{"1.2.3.1"}
+		})
+
+		rr := func(s string) []dns.RR {
+			r, err := dns.NewRR(s)
+			Expect(err).ToNot(HaveOccurred())
+			return []dns.RR{r}
+		}
+
+		DescribeTable("should evaluate", func(prereq func(m *dns.Msg), rcode int) {
+			m := newUpdate([]string{"a.dyn.example.com. 60 IN A 1.2.3.2"}, nil)
+			prereq(m)
+			Expect(signed(m).Rcode).To(Equal(rcode))
+			if rcode == dns.RcodeSuccess {
+				Expect(backend.calls).To(HaveLen(1))
+			} else {
+				Expect(backend.calls).To(BeEmpty())
+			}
+		},
+			Entry("RRset exists", func(m *dns.Msg) {
+				m.RRsetUsed(rr("a.dyn.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeSuccess),
+			Entry("RRset exists, failing", func(m *dns.Msg) {
+				m.RRsetUsed(rr("b.dyn.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeNXRrset),
+			Entry("RRset does not exist, failing", func(m *dns.Msg) {
+				m.RRsetNotUsed(rr("a.dyn.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeYXRrset),
+			Entry("name is in use", func(m *dns.Msg) {
+				m.NameUsed(rr("a.dyn.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeSuccess),
+			Entry("name is in use, failing", func(m *dns.Msg) {
+				m.NameUsed(rr("b.dyn.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeNameError),
+			Entry("name is not in use, failing", func(m *dns.Msg) {
+				m.NameNotUsed(rr("a.dyn.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeYXDomain),
+			Entry("RRset exists with values", func(m *dns.Msg) {
+				m.Used(rr("a.dyn.example.com. 0 IN A 1.2.3.1"))
+			}, dns.RcodeSuccess),
+			Entry("RRset exists with values, failing", func(m *dns.Msg) {
+				m.Used(rr("a.dyn.example.com. 0 IN A 1.2.3.3"))
+			}, dns.RcodeNXRrset),
+			Entry("names not granted", func(m *dns.Msg) {
+				m.NameUsed(rr("www.example.com. 0 IN A 0.0.0.0"))
+			}, dns.RcodeRefused),
+		)
+	})
+})
@@ -0,0 +1,367 @@
+package rfc2136
+
+import (
+	"context"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/miekg/dns"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/data"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	recordTypeA    = "A"
+	recordTypeAAAA = "AAAA"
+	recordTypeTXT  = "TXT"
+	recordTypeANY  = "ANY"
+
+	// endpointName is the endpoint of DNS updates in rules.
+	endpointName = "rfc2136"
+)
+
+// change is a record added or deleted by an update message.
+type change struct {
+	reqData *data.ReqData
+	delete  bool
+	ttl     int
+}
+
+// rrSet identifies the RRset of a value dependent prerequisite.
+type rrSet struct {
+	name       string
+	recordType string
+}
+
+// update checks the zone, the updates and the prerequisites of req and the
+// limits of key and then applies the updates. Updates are applied one by
+// one, the previous ones are reverted if an update fails.
+func (h *Handler) update(key *config.TSIGKey, remoteAddr string, req *dns.Msg) int {
+	q := req.Question[0]
+	if q.Qtype != dns.TypeSOA || q.Qclass != dns.ClassINET {
+		return dns.RcodeFormatError
+	}
+	zone := fqdn(q.Name)
+	if _, apex, err := middleware.SplitFQDN(zone); err != nil || apex != zone {
+		return dns.RcodeNotAuth
+	}
+
+	changes, rcode := h.parseUpdates(key, remoteAddr, zone, req.Ns)
+	if rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	if rcode := h.checkPrerequisites(key, remoteAddr, zone, req.Answer); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	z, changes, rcode := h.plan(zone, changes)
+	if rcode != dns.RcodeSuccess || len(changes) == 0 {
+		return rcode
+	}
+	if !h.limits.AllowN(nil, key.Name, zone, len(changes)) {
+		return dns.RcodeRefused
+	}
+	return h.apply(z, changes)
+}
+
+// plan returns the zone of the updates and the changes that alter its
+// records. Adding a record the RRset has already and deleting one it does
+// not have are no-ops (RFC 2136, section 3.4.2).
+func (h *Handler) plan(zone string, changes []change) (*hcloud.Zone, []change, int) {
+	ctx, cancel := h.context()
+	defer cancel()
+	z, err := h.records.Zone(ctx, zone)
+	if err != nil {
+		log.Printf("failed to look up zone '%s': %v", zone, err)
+		return nil, nil, dns.RcodeServerFailure
+	}
+	if z == nil {
+		return nil, nil, dns.RcodeNotAuth
+	}
+
+	values := map[rrSet][]string{}
+	planned := make([]change, 0, len(changes))
+	for _, c := range changes {
+		s := rrSet{name: c.reqData.FullName, recordType: c.reqData.Type}
+		existing, ok := values[s]
+		if !ok {
+			if existing, err = h.records.Values(ctx, zone, c.reqData.Name, c.reqData.Type); err != nil {
+				log.Printf("failed to look up records of '%s': %v", s.name, err)
+				return nil, nil, dns.RcodeServerFailure
+			}
+			existing = slices.Clone(existing)
+		}
+		if slices.Contains(existing, c.reqData.Value) == c.delete {
+			planned = append(planned, c)
+			if c.delete {
+				existing = slices.DeleteFunc(existing, func(v string) bool { return v == c.reqData.Value })
+			} else {
+				existing = append(existing, c.reqData.Value)
+			}
+		}
+		values[s] = existing
+	}
+	return z, planned, dns.RcodeSuccess
+}
+
+// apply applies changes to zone one by one. If a change fails, the changes
+// applied before are reverted, records added back get the TTL of the proxy.
+func (h *Handler) apply(zone *hcloud.Zone, changes []change) int {
+	for i, c := range changes {
+		logChange(c)
+		if err := h.applyChange(zone, c); err != nil {
+			name := sanitize.LogValue(c.reqData.FullName)
+			//nolint:gosec // value is sanitized above
+			log.Printf("failed to apply DNS update of '%s': %v", name, err)
+			h.revert(zone, changes[:i])
+			return dns.RcodeServerFailure
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func (h *Handler) revert(zone *hcloud.Zone, applied []change) {
+	for _, c := range slices.Backward(applied) {
+		c.delete, c.ttl = !c.delete, h.cfg.RecordTTL
+		if err := h.applyChange(zone, c); err != nil {
+			name := sanitize.LogValue(c.reqData.FullName)
+			//nolint:gosec // value is sanitized above
+			log.Printf("failed to revert DNS update of '%s': %v", name, err)
+		}
+	}
+}
+
+func (h *Handler) applyChange(zone *hcloud.Zone, c change) error {
+	ctx, cancel := h.context()
+	defer cancel()
+	if c.delete {
+		return h.records.RemoveRecord(ctx, zone, c.reqData.Name, c.reqData.Type, c.reqData.Value)
+	}
+	return h.records.AddRecord(ctx, zone, c.reqData.Name, c.reqData.Type, c.reqData.Value, c.ttl)
+}
+
+func logChange(c change) {
+	name := sanitize.LogValue(c.reqData.FullName)
+	value := sanitize.LogValue(c.reqData.Value)
+	action := "add"
+	if c.delete {
+		action = "remove"
+	}
+	//nolint:gosec // values are sanitized above
+	log.Printf("received DNS update to %s '%s' data '%s' of '%s'", action, c.reqData.Type, value, name)
+}
+
+// parseUpdates converts the update section to changes. Records are added
+// with the class of the zone and deleted with class NONE, deleting RRsets
+// and names with class ANY is not supported.
+func (h *Handler) parseUpdates(key *config.TSIGKey, remoteAddr, zone string, updates []dns.RR) ([]change, int) {
+	changes := make([]change, 0, len(updates))
+	for _, rr := range updates {
+		hdr := rr.Header()
+		name := fqdn(hdr.Name)
+		if !inZone(name, zone) {
+			return nil, dns.RcodeNotZone
+		}
+
+		var del bool
+		switch hdr.Class {
+		case dns.ClassINET:
+		case dns.ClassNONE:
+			if hdr.Ttl != 0 {
+				return nil, dns.RcodeFormatError
+			}
+			del = true
+		case dns.ClassANY:
+			logRefusedName(remoteAddr, "deleting RRsets and names is not supported", name)
+			return nil, dns.RcodeNotImplemented
+		default:
+			return nil, dns.RcodeFormatError
+		}
+
+		recordType, value, rcode := recordValue(rr)
+		if rcode != dns.RcodeSuccess {
+			return nil, rcode
+		}
+		if !middleware.CheckGrant(&h.cfg.Auth, key.Domains, key.Roles, name, recordType, remoteAddr) {
+			logRefusedName(remoteAddr, "key '"+key.Name+"' is not allowed to update "+recordType+" data", name)
+			return nil, dns.RcodeRefused
+		}
+		if !del {
+			if err := middleware.ValidateValue(&h.cfg.AddressPolicy, name, value, recordType); err != nil {
+				logRefusedName(remoteAddr, err.Error(), name)
+				return nil, dns.RcodeRefused
+			}
+			if !middleware.ClientIPAllowed(h.cfg, nil, name, recordType, value, remoteAddr) {
+				return nil, dns.RcodeRefused
+			}
+		}
+
+		reqData := &data.ReqData{
+			FullName: name,
+			Name:     relativeName(name, zone),
+			Zone:     zone,
+			Value:    value,
+			Type:     recordType,
+			Username: key.Name,
+		}
+		if !middleware.CheckRules(h.cfg, reqData, endpointName, remoteAddr, key.Name) {
+			return nil, dns.RcodeRefused
+		}
+		changes = append(changes, change{reqData: reqData, delete: del, ttl: h.ttl(hdr.Ttl)})
+	}
+	return changes, dns.RcodeSuccess
+}
+
+// checkPrerequisites evaluates the prerequisite section as described in
+// RFC 2136, section 3.2.
+func (h *Handler) checkPrerequisites(key *config.TSIGKey, remoteAddr, zone string, prereqs []dns.RR) int {
+	required := map[rrSet][]string{}
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		name := fqdn(hdr.Name)
+		if hdr.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !inZone(name, zone) {
+			return dns.RcodeNotZone
+		}
+		recordType := dns.TypeToString[hdr.Rrtype]
+		if !middleware.CheckGrant(&h.cfg.Auth, key.Domains, key.Roles, name, recordType, remoteAddr) {
+			logRefusedName(remoteAddr, "key '"+key.Name+"' is not allowed to read "+recordType+" data", name)
+			return dns.RcodeRefused
+		}
+
+		if hdr.Class == dns.ClassINET {
+			t, value, rcode := recordValue(rr)
+			if rcode != dns.RcodeSuccess {
+				return rcode
+			}
+			s := rrSet{name: name, recordType: t}
+			required[s] = append(required[s], value)
+			continue
+		}
+		if rcode := h.checkExistence(rr, zone, name, recordType); rcode != dns.RcodeSuccess {
+			return rcode
+		}
+	}
+
+	for s, values := range required {
+		ctx, cancel := h.context()
+		existing, err := h.records.Values(ctx, zone, relativeName(s.name, zone), s.recordType)
+		cancel()
+		if err != nil {
+			log.Printf("failed to look up records of '%s': %v", s.name, err)
+			return dns.RcodeServerFailure
+		}
+		if !sameValues(existing, values) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// checkExistence evaluates a prerequisite on whether a name or an RRset is
+// in use (class ANY) or not in use (class NONE).
+func (h *Handler) checkExistence(rr dns.RR, zone, name, recordType string) int {
+	hdr := rr.Header()
+	if hdr.Rdlength != 0 || (hdr.Class != dns.ClassANY && hdr.Class != dns.ClassNONE) {
+		return dns.RcodeFormatError
+	}
+	if !slices.Contains([]string{recordTypeA, recordTypeAAAA, recordTypeTXT, recordTypeANY}, recordType) {
+		return dns.RcodeNotImplemented
+	}
+
+	ctx, cancel := h.context()
+	defer cancel()
+	var (
+		exists bool
+		err    error
+	)
+	if recordType == recordTypeANY {
+		exists, err = h.records.NameInUse(ctx, zone, relativeName(name, zone))
+	} else {
+		var values []string
+		values, err = h.records.Values(ctx, zone, relativeName(name, zone), recordType)
+		exists = len(values) > 0
+	}
+	if err != nil {
+		log.Printf("failed to look up records of '%s': %v", name, err)
+		return dns.RcodeServerFailure
+	}
+
+	return existenceRcode(hdr.Class == dns.ClassANY, recordType == recordTypeANY, exists)
+}
+
+// existenceRcode returns the response code of a prerequisite on whether a
+// name or an RRset exists.
+func existenceRcode(mustExist, name, exists bool) int {
+	switch {
+	case mustExist == exists:
+		return dns.RcodeSuccess
+	case mustExist && name:
+		return dns.RcodeNameError
+	case mustExist:
+		return dns.RcodeNXRrset
+	case name:
+		return dns.RcodeYXDomain
+	}
+	return dns.RcodeYXRrset
+}
+
+// recordValue returns the type and value of rr, which must be an A, AAAA or
+// TXT record with data.
+func recordValue(rr dns.RR) (recordType, value string, rcode int) {
+	if rr.Header().Rdlength == 0 {
+		return "", "", dns.RcodeFormatError
+	}
+	switch r := rr.(type) {
+	case *dns.A:
+		return recordTypeA, r.A.String(), dns.RcodeSuccess
+	case *dns.AAAA:
+		return recordTypeAAAA, r.AAAA.String(), dns.RcodeSuccess
+	case *dns.TXT:
+		return recordTypeTXT, strings.Join(r.Txt, ""), dns.RcodeSuccess
+	}
+	return "", "", dns.RcodeNotImplemented
+}
+
+// ttl returns the TTL of added records, which is the TTL of the proxy if
+// the update has none.
+func (h *Handler) ttl(ttl uint32) int {
+	if ttl == 0 {
+		return h.cfg.RecordTTL
+	}
+	return int(ttl)
+}
+
+func (h *Handler) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+func inZone(name, zone string) bool {
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+func relativeName(name, zone string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, zone), ".")
+}
+
+func sameValues(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+func logRefusedName(remoteAddr, reason, name string) {
+	n := sanitize.LogValue(name)
+	r := sanitize.LogValue(reason)
+	//nolint:gosec // values are sanitized above
+	log.Printf("refused DNS update of '%s' from '%s': %s", n, remoteAddr, r)
+}
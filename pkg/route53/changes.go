@@ -0,0 +1,444 @@
+package route53
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// apexName is the name of the RRSets at the apex of a zone.
+	apexName = "@"
+
+	actionCreate = "CREATE"
+	actionUpsert = "UPSERT"
+	actionDelete = "DELETE"
+
+	statusPending = "PENDING"
+	statusInSync  = "INSYNC"
+
+	// changeIDPrefix starts change IDs, which list the IDs of the actions of
+	// the change in base 36 separated by changeIDSeparator.
+	changeIDPrefix    = "C"
+	changeIDSeparator = "-"
+	changeIDBase      = 36
+
+	errNoSuchChange = "Could not find resource with ID: "
+)
+
+type changeRequest struct {
+	ChangeBatch struct {
+		Comment string
+		Changes []changeXML `xml:"Changes>Change"`
+	}
+}
+
+type changeXML struct {
+	Action            string
+	ResourceRecordSet rrSetXML
+}
+
+type changeInfo struct {
+	ID          string `xml:"Id"`
+	Status      string
+	SubmittedAt string
+	Comment     string `xml:",omitempty"`
+}
+
+type changeRRSetsResponse struct {
+	XMLName    xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsResponse"`
+	ChangeInfo changeInfo
+}
+
+type getChangeResponse struct {
+	XMLName    xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ GetChangeResponse"`
+	ChangeInfo changeInfo
+}
+
+// change is a checked change of a batch with its name relative to the zone
+// and its unquoted values.
+type change struct {
+	action     string
+	name       string
+	fqdn       string
+	recordType string
+	ttl        int
+	values     []string
+}
+
+// rrSetState is the state of an RRSet while the changes of a batch are
+// applied to it.
+type rrSetState struct {
+	name       string
+	fqdn       string
+	recordType string
+	ttl        int
+	values     []string
+}
+
+// changeRRSets applies a change batch. All changes are checked against the
+// current RRSets of the zone before the first one is applied, changes of
+// the same RRSet are merged into one. CREATE and UPSERT set the records of
+// an RRSet like updates, DELETE removes the given records like cleanups.
+// The batch is not applied atomically.
+func (h *handler) changeRRSets(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	req := &changeRequest{}
+	if err := xml.NewDecoder(r.Body).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "Could not parse XML body")
+		return
+	}
+	if len(req.ChangeBatch.Changes) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "The change batch must contain at least one change")
+		return
+	}
+
+	states, err := h.rrSetStates(ctx, zone)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	var order []*rrSetState
+	for i := range req.ChangeBatch.Changes {
+		c, err := h.checkChange(r, zone, &req.ChangeBatch.Changes[i])
+		if err == nil {
+			var state *rrSetState
+			state, err = applyChange(states, c)
+			if err == nil && !slices.Contains(order, state) {
+				order = append(order, state)
+			}
+		}
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+	}
+	if !h.allow(w, r, zone, len(order)) {
+		return
+	}
+
+	ids, err := h.applyStates(ctx, r, zone, order)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	status := statusPending
+	if len(ids) == 0 {
+		status = statusInSync
+	}
+	writeXML(w, http.StatusOK, &changeRRSetsResponse{ChangeInfo: changeInfo{
+		ID:          changePrefix + changeID(ids),
+		Status:      status,
+		SubmittedAt: time.Now().UTC().Format(time.RFC3339),
+		Comment:     req.ChangeBatch.Comment,
+	}})
+}
+
+// allow reports whether n RRSet changes of zone with the API token of r are
+// within the scoped rate limits, and answers like throttled Route 53
+// requests otherwise.
+func (h *handler) allow(w http.ResponseWriter, r *http.Request, zone *hcloud.Zone, n int) bool {
+	if !h.limits.AllowN(w, middleware.APITokenLimitUser(r), zone.Name, n) {
+		writeError(w, http.StatusBadRequest, codeThrottling, "Rate exceeded")
+		return false
+	}
+	return true
+}
+
+// rrSetStates returns the current state of the RRSets of zone by name and
+// type.
+func (h *handler) rrSetStates(ctx context.Context, zone *hcloud.Zone) (map[string]*rrSetState, error) {
+	rrSets, err := h.records.RRSets(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]*rrSetState, len(rrSets))
+	for _, rrSet := range rrSets {
+		ttl := zone.TTL
+		if rrSet.TTL != nil {
+			ttl = *rrSet.TTL
+		}
+		values := make([]string, 0, len(rrSet.Records))
+		for _, record := range rrSet.Records {
+			values = append(values, hetzner.UnquoteIfRequired(record.Value, rrSet.Type))
+		}
+		states[stateKey(rrSet.Name, string(rrSet.Type))] = &rrSetState{
+			name:       rrSet.Name,
+			fqdn:       recordFQDN(rrSet.Name, zone.Name),
+			recordType: string(rrSet.Type),
+			ttl:        ttl,
+			values:     values,
+		}
+	}
+	return states, nil
+}
+
+// checkChange returns the change of req if the API token of r may apply it
+// to zone.
+func (h *handler) checkChange(r *http.Request, zone *hcloud.Zone, req *changeXML) (*change, error) {
+	set := &req.ResourceRecordSet
+	c := &change{
+		action:     strings.ToUpper(req.Action),
+		fqdn:       normalizeName(set.Name),
+		recordType: strings.ToUpper(set.Type),
+		ttl:        h.cfg.RecordTTL,
+	}
+	switch {
+	case c.fqdn == zone.Name:
+		c.name = apexName
+	case strings.HasSuffix(c.fqdn, "."+zone.Name):
+		c.name = strings.TrimSuffix(c.fqdn, "."+zone.Name)
+	default:
+		return nil, invalidChange("RRSet with DNS name %s is not permitted in zone %s.", set.Name, zone.Name)
+	}
+	if !slices.Contains([]string{actionCreate, actionUpsert, actionDelete}, c.action) {
+		return nil, &apiError{code: http.StatusBadRequest, errCode: codeInvalidInput, message: "Invalid action: " + req.Action}
+	}
+	if _, err := hetzner.RRSetTypeFromString(c.recordType); err != nil {
+		return nil, invalidChange("RRSet of type %s is not supported: %s", c.recordType, err)
+	}
+	if set.SetIdentifier != "" || set.AliasTarget != nil {
+		return nil, invalidChange("Alias and routing policy record sets are not supported")
+	}
+
+	t := middleware.APITokenFromContext(r.Context())
+	if !middleware.APITokenAllows(&h.cfg.Auth, t, c.fqdn, c.recordType, r.RemoteAddr) {
+		logDenied(r, t.Name, c.fqdn, c.recordType)
+		return nil, accessDenied(c, set.Name)
+	}
+
+	if set.TTL != nil && *set.TTL > 0 {
+		c.ttl = *set.TTL
+	}
+	if err := h.checkValues(r, c, set); err != nil {
+		return nil, err
+	}
+	return c, h.checkRules(r, c, set.Name)
+}
+
+// checkRules checks the values of c against auth.rules.
+func (h *handler) checkRules(r *http.Request, c *change, name string) error {
+	for _, value := range c.values {
+		if !middleware.APITokenRulesAllow(h.cfg, r, config.EndpointRoute53, c.fqdn, c.recordType, value) {
+			return accessDenied(c, name)
+		}
+	}
+	return nil
+}
+
+// accessDenied returns the error of changes of the RRSet name the API token
+// may not make.
+func accessDenied(c *change, name string) *apiError {
+	return &apiError{
+		code:    http.StatusForbidden,
+		errCode: codeAccessDenied,
+		message: "Not authorized to change " + c.recordType + " records of " + name,
+	}
+}
+
+// checkValues sets the unquoted values of the records of set on c and
+// validates the values of records to create, which auth.matchClientIP must
+// allow for the client of r.
+func (h *handler) checkValues(r *http.Request, c *change, set *rrSetXML) error {
+	for _, record := range set.ResourceRecords {
+		value := hetzner.UnquoteIfRequired(record.Value, hcloud.ZoneRRSetType(c.recordType))
+		if c.action != actionDelete {
+			if err := middleware.ValidateValue(&h.cfg.AddressPolicy, c.fqdn, value, c.recordType); err != nil {
+				return invalidChange("Invalid Resource Record: %s", err)
+			}
+			if !middleware.ClientIPAllowed(h.cfg, nil, c.fqdn, c.recordType, value, r.RemoteAddr) {
+				return accessDenied(c, set.Name)
+			}
+		}
+		c.values = append(c.values, value)
+	}
+	if len(c.values) == 0 && c.action != actionDelete {
+		return invalidChange("ResourceRecords of %s must not be empty", set.Name)
+	}
+	return nil
+}
+
+// applyChange applies c to the states of the RRSets and returns the state
+// it changed. CREATE requires the RRSet not to exist, DELETE requires it to
+// exist with all values to remove.
+func applyChange(states map[string]*rrSetState, c *change) (*rrSetState, error) {
+	key := stateKey(c.name, c.recordType)
+	state := states[key]
+	exists := state != nil && len(state.values) > 0
+	rrSet := fmt.Sprintf("[name='%s', type='%s']", escapeName(c.fqdn), c.recordType)
+
+	switch c.action {
+	case actionCreate:
+		if exists {
+			return nil, invalidChange("Tried to create resource record set %s but it already exists", rrSet)
+		}
+	case actionDelete:
+		if !exists {
+			return nil, invalidChange("Tried to delete resource record set %s but it was not found", rrSet)
+		}
+		for _, v := range c.values {
+			if !slices.Contains(state.values, v) {
+				return nil, invalidChange("Tried to delete resource record set %s but the values provided do not match the current values", rrSet)
+			}
+		}
+		var remaining []string
+		if len(c.values) > 0 {
+			remaining = slices.DeleteFunc(slices.Clone(state.values), func(v string) bool {
+				return slices.Contains(c.values, v)
+			})
+		}
+		state.values = remaining
+		return state, nil
+	}
+
+	if state == nil {
+		state = &rrSetState{name: c.name, fqdn: c.fqdn, recordType: c.recordType}
+		states[key] = state
+	}
+	state.values, state.ttl = c.values, c.ttl
+	return state, nil
+}
+
+// applyStates applies the changed states to the RRSets of zone and returns
+// the IDs of the started actions.
+func (h *handler) applyStates(ctx context.Context, r *http.Request, zone *hcloud.Zone, states []*rrSetState) ([]int64, error) {
+	ids := make([]int64, 0, len(states))
+	for _, state := range states {
+		logChange(r, state)
+		var (
+			action *hcloud.Action
+			err    error
+		)
+		if len(state.values) == 0 {
+			action, err = h.records.StartDeleteRRSet(ctx, zone, state.name, state.recordType)
+		} else {
+			action, err = h.records.StartSetRecords(ctx, zone, state.name, state.recordType, state.values, state.ttl)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if action != nil {
+			ids = append(ids, action.ID)
+		}
+	}
+	return ids, nil
+}
+
+// getChange reports a change as in sync once all of its actions succeeded.
+func (h *handler) getChange(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	ids, err := parseChangeID(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, codeNoSuchChange, errNoSuchChange+id)
+		return
+	}
+
+	ctx, cancel := h.context(r)
+	defer cancel()
+	info := changeInfo{ID: changePrefix + id, Status: statusInSync}
+	submittedAt := time.Now()
+	for _, actionID := range ids {
+		action, err := h.records.Action(ctx, actionID)
+		if err != nil {
+			failed(w, err)
+			return
+		}
+		if action == nil {
+			writeError(w, http.StatusNotFound, codeNoSuchChange, errNoSuchChange+id)
+			return
+		}
+		switch action.Status {
+		case hcloud.ActionStatusError:
+			log.Printf("action %d of change failed: %s", action.ID, action.ErrorMessage)
+			writeError(w, http.StatusInternalServerError, codeInternal, "The change failed: "+action.ErrorMessage)
+			return
+		case hcloud.ActionStatusRunning:
+			info.Status = statusPending
+		case hcloud.ActionStatusSuccess:
+		}
+		if !action.Started.IsZero() && action.Started.Before(submittedAt) {
+			submittedAt = action.Started
+		}
+	}
+	info.SubmittedAt = submittedAt.UTC().Format(time.RFC3339)
+	writeXML(w, http.StatusOK, &getChangeResponse{ChangeInfo: info})
+}
+
+// changeID returns the ID of the change with the actions with ids.
+func changeID(ids []int64) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strings.ToUpper(strconv.FormatInt(id, changeIDBase)))
+	}
+	return changeIDPrefix + strings.Join(parts, changeIDSeparator)
+}
+
+// parseChangeID returns the IDs of the actions of the change with id.
+func parseChangeID(id string) ([]int64, error) {
+	list, ok := strings.CutPrefix(id, changeIDPrefix)
+	if !ok {
+		return nil, errors.New("invalid change ID")
+	}
+	if list == "" {
+		return nil, nil
+	}
+	var ids []int64
+	for part := range strings.SplitSeq(list, changeIDSeparator) {
+		actionID, err := strconv.ParseInt(part, changeIDBase, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, actionID)
+	}
+	return ids, nil
+}
+
+func stateKey(name, recordType string) string {
+	return name + "/" + recordType
+}
+
+func invalidChange(format string, a ...any) error {
+	return &apiError{code: http.StatusBadRequest, errCode: codeInvalidChangeBatch, message: fmt.Sprintf(format, a...)}
+}
+
+// writeErr answers err, which is an apiError or an error of the Cloud API.
+func writeErr(w http.ResponseWriter, err error) {
+	var e *apiError
+	if errors.As(err, &e) {
+		writeError(w, e.code, e.errCode, e.message)
+		return
+	}
+	failed(w, err)
+}
+
+func logChange(r *http.Request, state *rrSetState) {
+	t := sanitize.LogValue(middleware.APITokenFromContext(r.Context()).Name)
+	typ := sanitize.LogValue(state.recordType)
+	name := sanitize.LogValue(state.fqdn)
+	val := sanitize.LogValue(strings.Join(state.values, ", "))
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request of API token '%s' to set '%s' data of '%s' to values '%s'", t, typ, name, val)
+}
+
+func logDenied(r *http.Request, token, fqdn, recordType string) {
+	t := sanitize.LogValue(token)
+	addr := sanitize.LogValue(r.RemoteAddr)
+	typ := sanitize.LogValue(recordType)
+	name := sanitize.LogValue(fqdn)
+	//nolint:gosec // values are sanitized above
+	log.Printf("API token '%s' of client '%s' is not allowed to change '%s' data of '%s'", t, addr, typ, name)
+}
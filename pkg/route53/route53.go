@@ -0,0 +1,214 @@
+// Package route53 implements the hosted zone, record set and change routes
+// of the Amazon Route 53 REST API used by DNS clients like external-dns,
+// lego or cert-manager on top of the zones and RRSets of the Cloud API.
+// Clients sign requests with AWS Signature Version 4 using the access key
+// ID and the token of an API token issued by the proxy as credentials.
+package route53
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sigv4"
+)
+
+const (
+	// PathPrefix is the prefix of all routes, the API version of Route 53.
+	PathPrefix = "/2013-04-01"
+
+	// Service is the service name of the credential scope of signatures.
+	Service = "route53"
+
+	// Namespace is the XML namespace of all request and response bodies.
+	Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+	// SignatureWindow is the maximum age of signatures.
+	SignatureWindow = 15 * time.Minute
+
+	hostedZonePath     = PathPrefix + "/hostedzone/{zone}"
+	rrSetPath          = hostedZonePath + "/rrset"
+	hostedZonePrefix   = "/hostedzone/"
+	changePrefix       = "/change/"
+	maxRequestBodySize = 64 << 10 // 64 KB
+)
+
+// Error codes of the Route 53 API.
+const (
+	codeAccessDenied       = "AccessDenied"
+	codeSignature          = "SignatureDoesNotMatch"
+	codeNoSuchHostedZone   = "NoSuchHostedZone"
+	codeNoSuchChange       = "NoSuchChange"
+	codeInvalidInput       = "InvalidInput"
+	codeInvalidChangeBatch = "InvalidChangeBatch"
+	codeThrottling         = "Throttling"
+	codeInternal           = "InternalFailure"
+	codeUnknownOperation   = "UnknownOperationException"
+)
+
+// Records reads and changes the zones and RRSets of the Cloud API.
+type Records interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+	RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error)
+	StartSetRecords(ctx context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int) (*hcloud.Action, error)
+	StartDeleteRRSet(ctx context.Context, zone *hcloud.Zone, name, recordType string) (*hcloud.Action, error)
+	Action(ctx context.Context, id int64) (*hcloud.Action, error)
+}
+
+type handler struct {
+	cfg     *config.Config
+	limits  *middleware.ScopedRateLimits
+	records Records
+}
+
+// New returns the handler of the routes below PathPrefix. It must run after
+// middleware.NewAPITokenAuth with the token function of APIToken. Changes
+// are limited by the zone and upstream limits of limits.
+func New(cfg *config.Config, limits *middleware.ScopedRateLimits, records Records) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, limits: limits, records: records}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+PathPrefix+"/hostedzone", h.listHostedZones)
+	mux.HandleFunc("GET "+PathPrefix+"/hostedzonesbyname", h.listHostedZonesByName)
+	mux.HandleFunc("GET "+hostedZonePath, h.withZone(h.getHostedZone))
+	mux.HandleFunc("GET "+rrSetPath, h.withZone(h.listRRSets))
+	mux.HandleFunc("POST "+rrSetPath, h.withZone(h.changeRRSets))
+	mux.HandleFunc("POST "+rrSetPath+"/", h.withZone(h.changeRRSets))
+	mux.HandleFunc("GET "+PathPrefix+"/change/{id}", h.getChange)
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusNotFound, codeUnknownOperation, "The requested operation is not supported")
+	})
+
+	return func(_ http.Handler) http.Handler {
+		return mux
+	}
+}
+
+// APIToken returns the token function of middleware.NewAPITokenAuth for
+// requests signed with AWS Signature Version 4. It returns the token of the
+// API token with the access key ID of the signature if the signature is
+// valid, and an empty token otherwise.
+func APIToken(cfg *config.Config) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+		if err != nil || len(body) > maxRequestBodySize {
+			return ""
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var token string
+		_, err = sigv4.Verify(r, body, Service, time.Now(), SignatureWindow, func(accessKeyID string) (string, bool) {
+			for i := range cfg.Auth.APITokens {
+				if t := &cfg.Auth.APITokens[i]; t.AccessKeyID != "" && t.AccessKeyID == accessKeyID {
+					token = t.Token
+					return token, true
+				}
+			}
+			return "", false
+		})
+		if err != nil {
+			return ""
+		}
+		return token
+	}
+}
+
+// Unauthorized answers requests without a valid signature like Route 53.
+func Unauthorized(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusForbidden, codeSignature,
+		"The request signature we calculated does not match the signature you provided")
+}
+
+func (h *handler) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+func (h *handler) coversZone(r *http.Request, zone string) bool {
+	return middleware.APITokenCoversZone(&h.cfg.Auth, middleware.APITokenFromContext(r.Context()), zone, r.RemoteAddr)
+}
+
+// withZone looks up the hosted zone of the path and answers 404 if the API
+// token does not cover it.
+func (h *handler) withZone(
+	next func(context.Context, http.ResponseWriter, *http.Request, *hcloud.Zone),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := h.context(r)
+		defer cancel()
+
+		id := r.PathValue("zone")
+		var zone *hcloud.Zone
+		if _, err := strconv.ParseInt(id, 10, 64); err == nil {
+			z, err := h.records.Zone(ctx, id)
+			if err != nil {
+				failed(w, err)
+				return
+			}
+			if z != nil && h.coversZone(r, z.Name) {
+				zone = z
+			}
+		}
+		if zone == nil {
+			writeError(w, http.StatusNotFound, codeNoSuchHostedZone, "No hosted zone found with ID: "+id)
+			return
+		}
+		next(ctx, w, r, zone)
+	}
+}
+
+type apiError struct {
+	code    int
+	errCode string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+type errorDetail struct {
+	Type    string
+	Code    string
+	Message string
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ErrorResponse"`
+	Error   errorDetail
+}
+
+func writeError(w http.ResponseWriter, code int, errCode, message string) {
+	errType := "Sender"
+	if code >= http.StatusInternalServerError {
+		errType = "Receiver"
+	}
+	writeXML(w, code, &errorResponse{Error: errorDetail{Type: errType, Code: errCode, Message: message}})
+}
+
+func writeXML(w http.ResponseWriter, code int, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		log.Printf("failed to encode response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(code)
+	if _, err := io.WriteString(w, xml.Header+string(data)); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func failed(w http.ResponseWriter, err error) {
+	log.Printf("failed to call the Cloud API: %v", err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "An internal error occurred")
+}
@@ -0,0 +1,13 @@
+package route53_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRoute53(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "route53 test suite")
+}
@@ -0,0 +1,335 @@
+package route53_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/ratelimit"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/route53"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sigv4"
+)
+
+const (
+	accessKeyID = "AKIDTEAM"
+	token       = "team-secret"
+	rrSetURL    = "/2013-04-01/hostedzone/1/rrset"
+)
+
+// fakeRecords keeps the zones, RRSets and actions of the Cloud API in
+// memory. Changes are recorded but not applied.
+type fakeRecords struct {
+	zones   []*hcloud.Zone
+	rrSets  map[int64][]*hcloud.ZoneRRSet
+	actions map[int64]*hcloud.Action
+	calls   []string
+}
+
+func (f *fakeRecords) Zones(_ context.Context) ([]*hcloud.Zone, error) {
+	return f.zones, nil
+}
+
+func (f *fakeRecords) Zone(_ context.Context, idOrName string) (*hcloud.Zone, error) {
+	for _, zone := range f.zones {
+		if strconv.FormatInt(zone.ID, 10) == idOrName || zone.Name == idOrName {
+			return zone, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeRecords) RRSets(_ context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	return f.rrSets[zone.ID], nil
+}
+
+func (f *fakeRecords) StartSetRecords(
+	_ context.Context, _ *hcloud.Zone, name, recordType string, values []string, ttl int,
+) (*hcloud.Action, error) {
+	f.calls = append(f.calls, "set "+name+" "+recordType+" "+strings.Join(values, ",")+" "+strconv.Itoa(ttl))
+	return f.startAction(), nil
+}
+
+func (f *fakeRecords) StartDeleteRRSet(_ context.Context, _ *hcloud.Zone, name, recordType string) (*hcloud.Action, error) {
+	f.calls = append(f.calls, "delete "+name+" "+recordType)
+	return f.startAction(), nil
+}
+
+func (f *fakeRecords) Action(_ context.Context, id int64) (*hcloud.Action, error) {
+	return f.actions[id], nil
+}
+
+func (f *fakeRecords) startAction() *hcloud.Action {
+	action := &hcloud.Action{ID: int64(len(f.actions) + 1), Status: hcloud.ActionStatusRunning, Started: time.Now()}
+	f.actions[action.ID] = action
+	return action
+}
+
+type errorResponse struct {
+	Error struct {
+		Code string
+	}
+}
+
+type rrSet struct {
+	Name   string
+	Type   string
+	TTL    int
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type listRRSetsResponse struct {
+	ResourceRecordSets []rrSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated        bool
+	NextRecordName     string
+	NextRecordType     string
+}
+
+type hostedZone struct {
+	ID   string `xml:"Id"`
+	Name string
+}
+
+type listHostedZonesResponse struct {
+	HostedZones []hostedZone `xml:"HostedZones>HostedZone"`
+}
+
+type changeInfoResponse struct {
+	ChangeInfo struct {
+		ID     string `xml:"Id"`
+		Status string
+	}
+}
+
+type change struct {
+	action, name, recordType string
+	values                   []string
+}
+
+func changeBatch(changes ...change) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` +
+		`<ChangeResourceRecordSetsRequest xmlns="https://route53.amazonaws.com/doc/2013-04-01/"><ChangeBatch><Changes>`)
+	for _, c := range changes {
+		b.WriteString("<Change><Action>" + c.action + "</Action><ResourceRecordSet><Name>" + c.name + "</Name><Type>" +
+			c.recordType + "</Type><TTL>120</TTL><ResourceRecords>")
+		for _, v := range c.values {
+			b.WriteString("<ResourceRecord><Value>" + v + "</Value></ResourceRecord>")
+		}
+		b.WriteString("</ResourceRecords></ResourceRecordSet></Change>")
+	}
+	b.WriteString("</Changes></ChangeBatch></ChangeResourceRecordSetsRequest>")
+	return b.String()
+}
+
+var _ = Describe("Route53 API", func() {
+	var (
+		records *fakeRecords
+		limits  *middleware.ScopedRateLimits
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		ttl := 300
+		records = &fakeRecords{
+			zones: []*hcloud.Zone{
+				{ID: 1, Name: "example.com", TTL: 3600},
+				{ID: 2, Name: "example.org", TTL: 3600},
+			},
+			rrSets: map[int64][]*hcloud.ZoneRRSet{
+				1: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.4"}}},
+					{Name: "_acme-challenge.www", Type: hcloud.ZoneRRSetTypeTXT, Records: []hcloud.ZoneRRSetRecord{{Value: `"token"`}}},
+					{Name: "@", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.5"}}},
+				},
+			},
+			actions: map[int64]*hcloud.Action{},
+		}
+
+		cfg := &config.Config{
+			Timeout:   10,
+			RecordTTL: 60,
+			Auth: config.Auth{
+				APITokens: []config.APIToken{
+					{Name: "team", Token: token, AccessKeyID: accessKeyID, Domains: []string{"*.example.com"}},
+				},
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		limits = &middleware.ScopedRateLimits{}
+		lockout := ratelimit.NewLockout(10, time.Hour, time.Hour)
+		handler = middleware.NewAPITokenAuth(cfg, lockout, route53.APIToken(cfg), route53.Unauthorized)(
+			route53.New(cfg, limits, records)(nil),
+		)
+	})
+
+	do := func(method, target, body string, v any) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		sigv4.Sign(req, []byte(body), accessKeyID, token, "us-east-1", route53.Service, time.Now())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if v != nil {
+			Expect(xml.Unmarshal(rec.Body.Bytes(), v)).To(Succeed())
+		}
+		return rec
+	}
+
+	expectError := func(rec *httptest.ResponseRecorder, code int, errCode string) {
+		Expect(rec.Code).To(Equal(code))
+		res := &errorResponse{}
+		Expect(xml.Unmarshal(rec.Body.Bytes(), res)).To(Succeed())
+		Expect(res.Error.Code).To(Equal(errCode))
+	}
+
+	It("should reject requests with invalid signatures", func() {
+		req := httptest.NewRequest(http.MethodGet, "/2013-04-01/hostedzone", http.NoBody)
+		sigv4.Sign(req, nil, accessKeyID, "wrong", "us-east-1", route53.Service, time.Now())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		expectError(rec, http.StatusForbidden, "SignatureDoesNotMatch")
+	})
+
+	It("should reject signed requests with changed bodies", func() {
+		body := changeBatch(change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.7"}})
+		req := httptest.NewRequest(http.MethodPost, rrSetURL, bytes.NewReader([]byte(body)))
+		sigv4.Sign(req, []byte(strings.ReplaceAll(body, "1.2.3.7", "1.2.3.8")), accessKeyID, token, "us-east-1",
+			route53.Service, time.Now())
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		expectError(rec, http.StatusForbidden, "SignatureDoesNotMatch")
+		Expect(records.calls).To(BeEmpty())
+	})
+
+	It("should list only covered hosted zones", func() {
+		res := &listHostedZonesResponse{}
+		Expect(do(http.MethodGet, "/2013-04-01/hostedzone", "", res).Code).To(Equal(http.StatusOK))
+		Expect(res.HostedZones).To(Equal([]hostedZone{{ID: "/hostedzone/1", Name: "example.com."}}))
+
+		res = &listHostedZonesResponse{}
+		Expect(do(http.MethodGet, "/2013-04-01/hostedzonesbyname?dnsname=example.com", "", res).Code).To(Equal(http.StatusOK))
+		Expect(res.HostedZones).To(HaveLen(1))
+
+		expectError(do(http.MethodGet, "/2013-04-01/hostedzone/2", "", nil), http.StatusNotFound, "NoSuchHostedZone")
+	})
+
+	It("should list granted record sets in pages", func() {
+		res := &listRRSetsResponse{}
+		Expect(do(http.MethodGet, rrSetURL, "", res).Code).To(Equal(http.StatusOK))
+		Expect(res.ResourceRecordSets).To(Equal([]rrSet{
+			{Name: "www.example.com.", Type: "A", TTL: 300, Values: []string{"1.2.3.4"}},
+			{Name: "_acme-challenge.www.example.com.", Type: "TXT", TTL: 3600, Values: []string{`"token"`}},
+		}))
+
+		res = &listRRSetsResponse{}
+		do(http.MethodGet, rrSetURL+"?maxitems=1", "", res)
+		Expect(res.ResourceRecordSets).To(HaveLen(1))
+		Expect(res.IsTruncated).To(BeTrue())
+		Expect(res.NextRecordName).To(Equal("_acme-challenge.www.example.com."))
+
+		res = &listRRSetsResponse{}
+		do(http.MethodGet, rrSetURL+"?name=_acme-challenge.www.example.com.&type=TXT", "", res)
+		Expect(res.ResourceRecordSets).To(HaveLen(1))
+		Expect(res.ResourceRecordSets[0].Type).To(Equal("TXT"))
+	})
+
+	It("should upsert record sets and report the change in sync once its actions succeeded", func() {
+		res := &changeInfoResponse{}
+		rec := do(http.MethodPost, rrSetURL+"/", changeBatch(change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.7", "1.2.3.8"}}), res)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(records.calls).To(Equal([]string{"set www A 1.2.3.7,1.2.3.8 120"}))
+		Expect(res.ChangeInfo.Status).To(Equal("PENDING"))
+		Expect(res.ChangeInfo.ID).To(HavePrefix("/change/C"))
+
+		changeURL := "/2013-04-01" + res.ChangeInfo.ID
+		res = &changeInfoResponse{}
+		Expect(do(http.MethodGet, changeURL, "", res).Code).To(Equal(http.StatusOK))
+		Expect(res.ChangeInfo.Status).To(Equal("PENDING"))
+
+		records.actions[1].Status = hcloud.ActionStatusSuccess
+		res = &changeInfoResponse{}
+		do(http.MethodGet, changeURL, "", res)
+		Expect(res.ChangeInfo.Status).To(Equal("INSYNC"))
+	})
+
+	It("should report failed changes", func() {
+		res := &changeInfoResponse{}
+		do(http.MethodPost, rrSetURL, changeBatch(change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.7"}}), res)
+		records.actions[1].Status = hcloud.ActionStatusError
+		expectError(do(http.MethodGet, "/2013-04-01"+res.ChangeInfo.ID, "", nil), http.StatusInternalServerError, "InternalFailure")
+		expectError(do(http.MethodGet, "/2013-04-01/change/CX9", "", nil), http.StatusNotFound, "NoSuchChange")
+	})
+
+	It("should merge changes of the same record set", func() {
+		rec := do(http.MethodPost, rrSetURL, changeBatch(
+			change{"DELETE", "www.example.com.", "A", []string{"1.2.3.4"}},
+			change{"CREATE", "www.example.com.", "A", []string{"1.2.3.9"}},
+			change{"DELETE", "_acme-challenge.www.example.com.", "TXT", []string{`"token"`}},
+			change{"CREATE", `\052.example.com.`, "TXT", []string{`"wildcard"`}},
+		), nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(records.calls).To(Equal([]string{
+			"set www A 1.2.3.9 120",
+			"delete _acme-challenge.www TXT",
+			"set * TXT wildcard 120",
+		}))
+	})
+
+	It("should throttle change batches exceeding the zone limit", func() {
+		limits.Zone = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		expectError(do(http.MethodPost, rrSetURL, changeBatch(
+			change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.7"}},
+			change{"UPSERT", "api.example.com.", "A", []string{"1.2.3.8"}},
+		), nil), http.StatusBadRequest, "Throttling")
+		Expect(records.calls).To(BeEmpty())
+
+		rec := do(http.MethodPost, rrSetURL, changeBatch(change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.7"}}), nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+	})
+
+	It("should throttle change batches exceeding the limit of the access key", func() {
+		limits.User = ratelimit.NewPolicy(nil, ratelimit.NewQuota(1))
+		rec := do(http.MethodPost, rrSetURL, changeBatch(change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.7"}}), nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		expectError(do(http.MethodPost, rrSetURL, changeBatch(
+			change{"UPSERT", "www.example.com.", "A", []string{"1.2.3.8"}},
+		), nil), http.StatusBadRequest, "Throttling")
+		Expect(records.calls).To(HaveLen(1))
+	})
+
+	DescribeTable("should reject change batches with", func(c change, code int, errCode string) {
+		expectError(do(http.MethodPost, rrSetURL, changeBatch(c), nil), code, errCode)
+		Expect(records.calls).To(BeEmpty())
+	},
+		Entry("existing record sets to create", change{"CREATE", "www.example.com.", "A", []string{"1.2.3.7"}},
+			http.StatusBadRequest, "InvalidChangeBatch"),
+		Entry("missing record sets to delete", change{"DELETE", "api.example.com.", "A", []string{"1.2.3.7"}},
+			http.StatusBadRequest, "InvalidChangeBatch"),
+		Entry("values to delete not matching", change{"DELETE", "www.example.com.", "A", []string{"1.2.3.7"}},
+			http.StatusBadRequest, "InvalidChangeBatch"),
+		Entry("names outside the grants", change{"UPSERT", "example.com.", "A", []string{"1.2.3.7"}},
+			http.StatusForbidden, "AccessDenied"),
+		Entry("names outside the zone", change{"UPSERT", "www.example.org.", "A", []string{"1.2.3.7"}},
+			http.StatusBadRequest, "InvalidChangeBatch"),
+		Entry("unsupported types", change{"UPSERT", "www.example.com.", "MX", []string{"10 mx.example.com."}},
+			http.StatusBadRequest, "InvalidChangeBatch"),
+		Entry("private addresses", change{"UPSERT", "www.example.com.", "A", []string{"10.0.0.1"}},
+			http.StatusBadRequest, "InvalidChangeBatch"),
+		Entry("addresses other than the client IP", change{"UPSERT", "home.example.com.", "A", []string{"1.2.3.8"}},
+			http.StatusForbidden, "AccessDenied"),
+		Entry("unknown actions", change{"REPLACE", "www.example.com.", "A", []string{"1.2.3.7"}},
+			http.StatusBadRequest, "InvalidInput"),
+	)
+})
@@ -0,0 +1,297 @@
+package route53
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+const (
+	defaultMaxZones   = 100
+	defaultMaxRRSets  = 300
+	wildcardLabel     = "*"
+	escapedWildcard   = `\052`
+	paramMaxItems     = "maxitems"
+	errInvalidMaxItem = "Invalid value for maxitems"
+)
+
+type hostedZoneConfig struct {
+	PrivateZone bool
+}
+
+type hostedZoneXML struct {
+	ID              string `xml:"Id"`
+	Name            string
+	CallerReference string
+	Config          hostedZoneConfig
+}
+
+func newHostedZoneXML(zone *hcloud.Zone) hostedZoneXML {
+	id := strconv.FormatInt(zone.ID, 10)
+	return hostedZoneXML{ID: hostedZonePrefix + id, Name: zone.Name + ".", CallerReference: id}
+}
+
+type listHostedZonesResponse struct {
+	XMLName     xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ListHostedZonesResponse"`
+	HostedZones []hostedZoneXML `xml:"HostedZones>HostedZone"`
+	Marker      string          `xml:",omitempty"`
+	IsTruncated bool
+	NextMarker  string `xml:",omitempty"`
+	MaxItems    int
+}
+
+type listHostedZonesByNameResponse struct {
+	XMLName     xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ListHostedZonesByNameResponse"`
+	HostedZones []hostedZoneXML `xml:"HostedZones>HostedZone"`
+	DNSName     string          `xml:",omitempty"`
+	IsTruncated bool
+	NextDNSName string `xml:",omitempty"`
+	MaxItems    int
+}
+
+type delegationSet struct {
+	NameServers []string `xml:"NameServers>NameServer"`
+}
+
+type getHostedZoneResponse struct {
+	XMLName       xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ GetHostedZoneResponse"`
+	HostedZone    hostedZoneXML
+	DelegationSet delegationSet
+}
+
+type resourceRecord struct {
+	Value string
+}
+
+type rrSetXML struct {
+	Name            string
+	Type            string
+	SetIdentifier   string           `xml:",omitempty"`
+	TTL             *int             `xml:",omitempty"`
+	ResourceRecords []resourceRecord `xml:"ResourceRecords>ResourceRecord"`
+	AliasTarget     *struct{}        `xml:",omitempty"`
+}
+
+type listRRSetsResponse struct {
+	XMLName            xml.Name   `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ListResourceRecordSetsResponse"`
+	ResourceRecordSets []rrSetXML `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated        bool
+	NextRecordName     string `xml:",omitempty"`
+	NextRecordType     string `xml:",omitempty"`
+	MaxItems           int
+}
+
+// visibleZones returns the zones covered by the API token of r in the
+// order of Route 53, by their labels from right to left.
+func (h *handler) visibleZones(ctx context.Context, r *http.Request) ([]*hcloud.Zone, error) {
+	zones, err := h.records.Zones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]*hcloud.Zone, 0, len(zones))
+	for _, zone := range zones {
+		if h.coversZone(r, zone.Name) {
+			visible = append(visible, zone)
+		}
+	}
+	slices.SortFunc(visible, func(a, b *hcloud.Zone) int {
+		return cmp.Compare(sortKey(a.Name), sortKey(b.Name))
+	})
+	return visible, nil
+}
+
+// listHostedZones lists the zones of the API token, in pages starting at
+// the zone ID of the marker query parameter.
+func (h *handler) listHostedZones(w http.ResponseWriter, r *http.Request) {
+	maxItems, ok := queryMaxItems(w, r, defaultMaxZones)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.visibleZones(ctx, r)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	marker := r.URL.Query().Get("marker")
+	start := 0
+	if marker != "" {
+		start = slices.IndexFunc(zones, func(zone *hcloud.Zone) bool {
+			return strconv.FormatInt(zone.ID, 10) == strings.TrimPrefix(marker, hostedZonePrefix)
+		})
+		if start < 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidInput, "Invalid value for marker")
+			return
+		}
+	}
+
+	res := &listHostedZonesResponse{Marker: marker, MaxItems: maxItems, HostedZones: []hostedZoneXML{}}
+	for i, zone := range zones[start:] {
+		if i == maxItems {
+			res.IsTruncated = true
+			res.NextMarker = strconv.FormatInt(zone.ID, 10)
+			break
+		}
+		res.HostedZones = append(res.HostedZones, newHostedZoneXML(zone))
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+// listHostedZonesByName lists the zones of the API token starting at the
+// zone named like the dnsname query parameter.
+func (h *handler) listHostedZonesByName(w http.ResponseWriter, r *http.Request) {
+	maxItems, ok := queryMaxItems(w, r, defaultMaxZones)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.visibleZones(ctx, r)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	dnsName := r.URL.Query().Get("dnsname")
+	startKey := sortKey(normalizeName(dnsName))
+	res := &listHostedZonesByNameResponse{DNSName: dnsName, MaxItems: maxItems, HostedZones: []hostedZoneXML{}}
+	for _, zone := range zones {
+		if dnsName != "" && sortKey(zone.Name) < startKey {
+			continue
+		}
+		if len(res.HostedZones) == maxItems {
+			res.IsTruncated = true
+			res.NextDNSName = zone.Name + "."
+			break
+		}
+		res.HostedZones = append(res.HostedZones, newHostedZoneXML(zone))
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+func (h *handler) getHostedZone(_ context.Context, w http.ResponseWriter, _ *http.Request, zone *hcloud.Zone) {
+	nameServers := make([]string, 0, len(zone.AuthoritativeNameservers.Assigned))
+	nameServers = append(nameServers, zone.AuthoritativeNameservers.Assigned...)
+	writeXML(w, http.StatusOK, &getHostedZoneResponse{
+		HostedZone:    newHostedZoneXML(zone),
+		DelegationSet: delegationSet{NameServers: nameServers},
+	})
+}
+
+// listRRSets lists the RRSets of zone the API token of r may change, in
+// pages starting at the RRSet named like the name and type query parameters.
+func (h *handler) listRRSets(ctx context.Context, w http.ResponseWriter, r *http.Request, zone *hcloud.Zone) {
+	maxItems, ok := queryMaxItems(w, r, defaultMaxRRSets)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	startName, startType := normalizeName(q.Get("name")), strings.ToUpper(q.Get("type"))
+	if startType != "" && startName == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, "The type parameter requires the name parameter")
+		return
+	}
+
+	rrSets, err := h.records.RRSets(ctx, zone)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+	t := middleware.APITokenFromContext(r.Context())
+	sets := make([]rrSetXML, 0, len(rrSets))
+	for _, rrSet := range rrSets {
+		fqdn := recordFQDN(rrSet.Name, zone.Name)
+		if middleware.APITokenAllows(&h.cfg.Auth, t, fqdn, string(rrSet.Type), r.RemoteAddr) {
+			sets = append(sets, newRRSetXML(zone, rrSet, fqdn))
+		}
+	}
+	slices.SortFunc(sets, compareRRSets)
+
+	start := rrSetXML{Name: escapeName(startName), Type: startType}
+	res := &listRRSetsResponse{MaxItems: maxItems, ResourceRecordSets: []rrSetXML{}}
+	for _, set := range sets {
+		if startName != "" && compareRRSets(set, start) < 0 {
+			continue
+		}
+		if len(res.ResourceRecordSets) == maxItems {
+			res.IsTruncated = true
+			res.NextRecordName, res.NextRecordType = set.Name, set.Type
+			break
+		}
+		res.ResourceRecordSets = append(res.ResourceRecordSets, set)
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+func newRRSetXML(zone *hcloud.Zone, rrSet *hcloud.ZoneRRSet, fqdn string) rrSetXML {
+	ttl := zone.TTL
+	if rrSet.TTL != nil {
+		ttl = *rrSet.TTL
+	}
+	records := make([]resourceRecord, 0, len(rrSet.Records))
+	for _, record := range rrSet.Records {
+		records = append(records, resourceRecord{Value: record.Value})
+	}
+	return rrSetXML{Name: escapeName(fqdn), Type: string(rrSet.Type), TTL: &ttl, ResourceRecords: records}
+}
+
+func compareRRSets(a, b rrSetXML) int {
+	return cmp.Or(cmp.Compare(sortKey(normalizeName(a.Name)), sortKey(normalizeName(b.Name))), cmp.Compare(a.Type, b.Type))
+}
+
+func queryMaxItems(w http.ResponseWriter, r *http.Request, def int) (int, bool) {
+	v := r.URL.Query().Get(paramMaxItems)
+	if v == "" {
+		return def, true
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 1 {
+		writeError(w, http.StatusBadRequest, codeInvalidInput, errInvalidMaxItem)
+		return 0, false
+	}
+	return min(i, def), true
+}
+
+// normalizeName returns name in lower case without the trailing dot and
+// with an escaped wildcard label unescaped.
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if rest, ok := strings.CutPrefix(name, escapedWildcard); ok {
+		return wildcardLabel + rest
+	}
+	return name
+}
+
+// escapeName returns fqdn like Route 53 names, with the trailing dot and an
+// escaped wildcard label.
+func escapeName(fqdn string) string {
+	if rest, ok := strings.CutPrefix(fqdn, wildcardLabel); ok {
+		fqdn = escapedWildcard + rest
+	}
+	return fqdn + "."
+}
+
+// sortKey returns name with its labels in reverse order, which sorts names
+// like Route 53.
+func sortKey(name string) string {
+	labels := strings.Split(name, ".")
+	slices.Reverse(labels)
+	return strings.Join(labels, ".")
+}
+
+func recordFQDN(name, zone string) string {
+	if name == apexName {
+		return zone
+	}
+	return name + "." + zone
+}
@@ -0,0 +1,204 @@
+package rules
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type node interface {
+	typ() Type
+	eval(vars Vars) (any, error)
+}
+
+type literal struct {
+	val any
+	t   Type
+}
+
+func (n *literal) typ() Type { return n.t }
+
+func (n *literal) eval(Vars) (any, error) { return n.val, nil }
+
+type variable struct {
+	name string
+	t    Type
+}
+
+func (n *variable) typ() Type { return n.t }
+
+func (n *variable) eval(vars Vars) (any, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("variable %q is not set", n.name)
+	}
+	var typeOK bool
+	switch n.t {
+	case String:
+		_, typeOK = v.(string)
+	case Int:
+		_, typeOK = v.(int)
+	case Bool:
+		_, typeOK = v.(bool)
+	case StringList:
+		_, typeOK = v.([]string)
+	case IntList:
+		_, typeOK = v.([]int)
+	}
+	if !typeOK {
+		return nil, fmt.Errorf("variable %q is not of type %s", n.name, n.t)
+	}
+	return v, nil
+}
+
+type list struct {
+	elems []node
+	t     Type
+}
+
+func newList(elems []node) (node, error) {
+	if len(elems) == 0 {
+		return nil, errors.New("empty lists are not supported")
+	}
+	t := elems[0].typ()
+	for _, e := range elems[1:] {
+		if e.typ() != t {
+			return nil, fmt.Errorf("list elements must be of the same type, got %s and %s", t, e.typ())
+		}
+	}
+	switch t {
+	case String:
+		return &list{elems: elems, t: StringList}, nil
+	case Int:
+		return &list{elems: elems, t: IntList}, nil
+	}
+	return nil, fmt.Errorf("lists of %s are not supported", t)
+}
+
+func (n *list) typ() Type { return n.t }
+
+func (n *list) eval(vars Vars) (any, error) {
+	var (
+		strs []string
+		ints []int
+	)
+	for _, e := range n.elems {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			strs = append(strs, v)
+		case int:
+			ints = append(ints, v)
+		}
+	}
+	if n.t == StringList {
+		return strs, nil
+	}
+	return ints, nil
+}
+
+type not struct {
+	x node
+}
+
+func (n *not) typ() Type { return Bool }
+
+func (n *not) eval(vars Vars) (any, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+type logical struct {
+	op   string
+	l, r node
+}
+
+func (n *logical) typ() Type { return Bool }
+
+func (n *logical) eval(vars Vars) (any, error) {
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit like && and || in Go.
+	if l.(bool) == (n.op == "||") {
+		return l, nil
+	}
+	return n.r.eval(vars)
+}
+
+type add struct {
+	l, r node
+}
+
+func (n *add) typ() Type { return n.l.typ() }
+
+func (n *add) eval(vars Vars) (any, error) {
+	l, r, err := evalPair(vars, n.l, n.r)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := l.(string); ok {
+		return s + r.(string), nil
+	}
+	return l.(int) + r.(int), nil
+}
+
+type relation struct {
+	op   string
+	l, r node
+}
+
+func (n *relation) typ() Type { return Bool }
+
+func (n *relation) eval(vars Vars) (any, error) {
+	l, r, err := evalPair(vars, n.l, n.r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "in":
+		if s, ok := l.(string); ok {
+			return slices.Contains(r.([]string), s), nil
+		}
+		return slices.Contains(r.([]int), l.(int)), nil
+	}
+	c := compare(l, r)
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+func compare(l, r any) int {
+	if s, ok := l.(string); ok {
+		return cmp.Compare(s, r.(string))
+	}
+	return cmp.Compare(l.(int), r.(int))
+}
+
+func evalPair(vars Vars, l, r node) (lv, rv any, err error) {
+	if lv, err = l.eval(vars); err != nil {
+		return nil, nil, err
+	}
+	if rv, err = r.eval(vars); err != nil {
+		return nil, nil, err
+	}
+	return lv, rv, nil
+}
@@ -0,0 +1,124 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// call is a method call on a string. Arguments of matches and inCIDR must
+// be literals, so that they are validated and prepared on compilation.
+type call struct {
+	recv   node
+	args   []node
+	result Type
+	fn     func(recv string, args []any) any
+}
+
+type method struct {
+	args   []Type
+	result Type
+	// literal methods take a single literal argument, which is prepared by
+	// build on compilation.
+	literal bool
+	build   func(arg string) (func(recv string, args []any) any, error)
+	fn      func(recv string, args []any) any
+}
+
+var methods = map[string]method{
+	"startsWith": {args: []Type{String}, result: Bool, fn: func(recv string, args []any) any {
+		return strings.HasPrefix(recv, args[0].(string))
+	}},
+	"endsWith": {args: []Type{String}, result: Bool, fn: func(recv string, args []any) any {
+		return strings.HasSuffix(recv, args[0].(string))
+	}},
+	"contains": {args: []Type{String}, result: Bool, fn: func(recv string, args []any) any {
+		return strings.Contains(recv, args[0].(string))
+	}},
+	"lower": {result: String, fn: func(recv string, _ []any) any {
+		return strings.ToLower(recv)
+	}},
+	"upper": {result: String, fn: func(recv string, _ []any) any {
+		return strings.ToUpper(recv)
+	}},
+	"size": {result: Int, fn: func(recv string, _ []any) any {
+		return len(recv)
+	}},
+	"matches": {args: []Type{String}, result: Bool, literal: true, build: buildMatches},
+	"inCIDR":  {args: []Type{String}, result: Bool, literal: true, build: buildInCIDR},
+}
+
+func buildMatches(arg string) (func(string, []any) any, error) {
+	re, err := regexp.Compile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	return func(recv string, _ []any) any {
+		return re.MatchString(recv)
+	}, nil
+}
+
+func buildInCIDR(arg string) (func(string, []any) any, error) {
+	prefix, err := netip.ParsePrefix(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR range: %w", err)
+	}
+	prefix = prefix.Masked()
+	return func(recv string, _ []any) any {
+		addr, err := netip.ParseAddr(recv)
+		return err == nil && prefix.Contains(addr.Unmap())
+	}, nil
+}
+
+func newCall(recv node, name string, args []node) (node, error) {
+	m, ok := methods[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %q", name)
+	}
+	if recv.typ() != String {
+		return nil, fmt.Errorf("method %s requires a string receiver, got %s", name, recv.typ())
+	}
+	if len(args) != len(m.args) {
+		return nil, fmt.Errorf("method %s takes %d arguments, got %d", name, len(m.args), len(args))
+	}
+	for i, arg := range args {
+		if arg.typ() != m.args[i] {
+			return nil, fmt.Errorf("argument %d of method %s must be of type %s, got %s", i+1, name, m.args[i], arg.typ())
+		}
+	}
+
+	fn := m.fn
+	if m.literal {
+		lit, ok := args[0].(*literal)
+		if !ok {
+			return nil, fmt.Errorf("argument of method %s must be a literal", name)
+		}
+		var err error
+		if fn, err = m.build(lit.val.(string)); err != nil {
+			return nil, err
+		}
+	}
+	return &call{recv: recv, args: args, result: m.result, fn: fn}, nil
+}
+
+func (n *call) typ() Type { return n.result }
+
+func (n *call) eval(vars Vars) (any, error) {
+	recv, err := n.recv.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := recv.(string)
+	if !ok {
+		return nil, errors.New("method receiver is not a string")
+	}
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		if args[i], err = arg.eval(vars); err != nil {
+			return nil, err
+		}
+	}
+	return n.fn(s, args), nil
+}
@@ -0,0 +1,113 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenInt
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// pos is the 1-based column of the token in the expression.
+	pos int
+}
+
+// punctuation is ordered so that longer operators are matched first.
+var punctuation = []string{"&&", "||", "==", "!=", "<=", ">=", "(", ")", "[", "]", ",", ".", "!", "<", ">", "+"}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isLetter(c):
+			start := i
+			for i < len(expr) && (isLetter(expr[i]) || isDigit(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expr[start:i], pos: start + 1})
+		case isDigit(c):
+			start := i
+			for i < len(expr) && isDigit(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenInt, text: expr[start:i], pos: start + 1})
+		case c == '"' || c == '\'':
+			s, n, err := lexString(expr[i:])
+			if err != nil {
+				return nil, &Error{Pos: i + 1, Msg: err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: s, pos: i + 1})
+			i += n
+		default:
+			p := matchPunct(expr[i:])
+			if p == "" {
+				return nil, &Error{Pos: i + 1, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			tokens = append(tokens, token{kind: tokenPunct, text: p, pos: i + 1})
+			i += len(p)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expr) + 1}), nil
+}
+
+// lexString returns the unquoted string literal at the start of s and its
+// length including the quotes.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, errors.New("unterminated string")
+			}
+			switch s[i] {
+			case '\\', '"', '\'':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, fmt.Errorf("invalid escape sequence \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+func matchPunct(s string) string {
+	for _, p := range punctuation {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
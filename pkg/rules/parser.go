@@ -0,0 +1,295 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+	decls  map[string]Type
+}
+
+func (p *parser) parse() (node, error) {
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorf(t, "unexpected %s", describe(t))
+	}
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokenPunct && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) error {
+	if !p.accept(punct) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, got %s", punct, describe(t))
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return &Error{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogical("&&", p.parseRelation)
+}
+
+func (p *parser) parseLogical(op string, operand func() (node, error)) (node, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !p.accept(op) {
+			return l, nil
+		}
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != Bool || r.typ() != Bool {
+			return nil, p.errorf(t, "%s requires bool operands, got %s and %s", op, l.typ(), r.typ())
+		}
+		l = &logical{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseRelation() (node, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	var op string
+	switch {
+	case t.kind == tokenPunct && (t.text == "==" || t.text == "!=" || t.text == "<" ||
+		t.text == "<=" || t.text == ">" || t.text == ">="):
+		op = t.text
+	case t.kind == tokenIdent && t.text == "in":
+		op = "in"
+	default:
+		return l, nil
+	}
+	p.next()
+	r, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRelation(op, l.typ(), r.typ()); err != nil {
+		return nil, p.errorf(t, "%v", err)
+	}
+	return &relation{op: op, l: l, r: r}, nil
+}
+
+func checkRelation(op string, l, r Type) error {
+	switch op {
+	case "==", "!=":
+		if l != r {
+			return fmt.Errorf("cannot compare %s and %s", l, r)
+		}
+		if l == StringList || l == IntList {
+			return fmt.Errorf("%s does not support %s operands", op, l)
+		}
+	case "in":
+		if (r != StringList || l != String) && (r != IntList || l != Int) {
+			return fmt.Errorf("in requires a list of %s, got %s", l, r)
+		}
+	default:
+		if l != r || (l != Int && l != String) {
+			return fmt.Errorf("%s requires int or string operands of the same type, got %s and %s", op, l, r)
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseAdd() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if !p.accept("+") {
+			return l, nil
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if l.typ() != r.typ() || (l.typ() != Int && l.typ() != String) {
+			return nil, p.errorf(t, "+ requires int or string operands of the same type, got %s and %s", l.typ(), r.typ())
+		}
+		l = &add{l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if !p.accept("!") {
+		return p.parseMember()
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf(t, "expression is nested too deeply")
+	}
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if x.typ() != Bool {
+		return nil, p.errorf(t, "! requires a bool operand, got %s", x.typ())
+	}
+	return &not{x: x}, nil
+}
+
+func (p *parser) parseMember() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(".") {
+		t := p.next()
+		if t.kind != tokenIdent {
+			return nil, p.errorf(t, "expected method name, got %s", describe(t))
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		args, err := p.parseList(")")
+		if err != nil {
+			return nil, err
+		}
+		if x, err = newCall(x, t.text, args); err != nil {
+			return nil, p.errorf(t, "%v", err)
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literal{val: t.text, t: String}, nil
+	case tokenInt:
+		i, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "invalid int %s", t.text)
+		}
+		return &literal{val: i, t: Int}, nil
+	case tokenIdent:
+		return p.parseIdent(t)
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			return p.parseNested(t, func() (node, error) {
+				x, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				return x, p.expect(")")
+			})
+		case "[":
+			return p.parseNested(t, func() (node, error) {
+				elems, err := p.parseList("]")
+				if err != nil {
+					return nil, err
+				}
+				return newList(elems)
+			})
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", describe(t))
+}
+
+func (p *parser) parseIdent(t token) (node, error) {
+	switch t.text {
+	case "true":
+		return &literal{val: true, t: Bool}, nil
+	case "false":
+		return &literal{val: false, t: Bool}, nil
+	}
+	typ, ok := p.decls[t.text]
+	if !ok {
+		return nil, p.errorf(t, "unknown variable %q", t.text)
+	}
+	return &variable{name: t.text, t: typ}, nil
+}
+
+func (p *parser) parseNested(t token, parse func() (node, error)) (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.errorf(t, "expression is nested too deeply")
+	}
+	x, err := parse()
+	if err != nil {
+		if _, ok := err.(*Error); !ok {
+			return nil, p.errorf(t, "%v", err)
+		}
+		return nil, err
+	}
+	return x, nil
+}
+
+// parseList parses comma separated expressions up to the closing punct.
+func (p *parser) parseList(closing string) ([]node, error) {
+	var elems []node
+	if p.accept(closing) {
+		return elems, nil
+	}
+	for {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, x)
+		if p.accept(closing) {
+			return elems, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
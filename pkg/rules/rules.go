@@ -0,0 +1,107 @@
+// Package rules implements a small, side-effect free expression language
+// for authorization rules, modeled after a subset of CEL. Expressions are
+// parsed and type checked on compilation and evaluated against variables
+// declared at compile time.
+//
+// The engine is not built on cel-go on purpose: rules only compare the
+// strings and ints of a single request, which does not justify pulling
+// cel-go, ANTLR and the protobuf runtime into the binary, and the small
+// grammar bounds the length and nesting of expressions, so that compiling
+// untrusted configuration and evaluating rules on every request stays
+// cheap. The parser and the evaluator are covered by fuzz tests.
+package rules
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Type is the type of a value or expression.
+type Type int
+
+const (
+	String Type = iota + 1
+	Int
+	Bool
+	StringList
+	IntList
+)
+
+func (t Type) String() string {
+	switch t {
+	case String:
+		return "string"
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	case StringList:
+		return "list(string)"
+	case IntList:
+		return "list(int)"
+	}
+	return "unknown"
+}
+
+const (
+	maxExprLen = 4096
+	maxDepth   = 64
+)
+
+// Error is a compilation error at a column of the expression.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos, e.Msg)
+}
+
+// Vars are the values of the declared variables, strings or ints.
+type Vars map[string]any
+
+// Program is a compiled expression.
+type Program struct {
+	expr string
+	root node
+}
+
+// Compile parses and type checks expr, which must be of type bool and may
+// only use the declared variables.
+func Compile(expr string, decls map[string]Type) (*Program, error) {
+	if len(expr) > maxExprLen {
+		return nil, fmt.Errorf("expression exceeds %d characters", maxExprLen)
+	}
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, decls: decls}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if root.typ() != Bool {
+		return nil, &Error{Pos: 1, Msg: fmt.Sprintf("expression must be of type bool, got %s", root.typ())}
+	}
+	return &Program{expr: expr, root: root}, nil
+}
+
+func (p *Program) String() string {
+	return p.expr
+}
+
+// Eval evaluates the program with vars. An error is returned if a variable
+// is missing or of the wrong type.
+func (p *Program) Eval(vars Vars) (bool, error) {
+	v, err := p.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, errors.New("expression did not evaluate to bool")
+	}
+	return b, nil
+}
@@ -0,0 +1,81 @@
+package rules_test
+
+import (
+	"testing"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+var fuzzDecls = map[string]rules.Type{
+	"fqdn":  rules.String,
+	"value": rules.String,
+	"hour":  rules.Int,
+	"tags":  rules.StringList,
+	"ports": rules.IntList,
+}
+
+var fuzzSeeds = []string{
+	`value.inCIDR("192.0.2.0/24")`,
+	`fqdn.startsWith("a" + ".") && !fqdn.endsWith(".example.org")`,
+	`fqdn.matches("^[a-z]+\\.home\\.") || fqdn.lower().upper().size() > 3`,
+	`"home" in tags || hour in ports || value in ["a", 'b']`,
+	`hour + 1 >= 8 && hour < 18 && "a" <= fqdn`,
+	`((((true))))`,
+	`!!false != (fqdn == "x\"y")`,
+	`fqdn.contains(`,
+	`hour + "a" == 1`,
+	`[] == tags`,
+	`value.inCIDR(value)`,
+}
+
+// FuzzCompile checks that compilation does not panic and that compiled
+// programs evaluate without errors when all declared variables are set, as
+// type checking promises.
+func FuzzCompile(f *testing.F) {
+	for _, expr := range fuzzSeeds {
+		f.Add(expr)
+	}
+	vars := rules.Vars{
+		"fqdn":  "www.home.example.com",
+		"value": "192.0.2.1",
+		"hour":  12,
+		"tags":  []string{"home"},
+		"ports": []int{80},
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		p, err := rules.Compile(expr, fuzzDecls)
+		if err != nil {
+			if p != nil {
+				t.Fatalf("Compile(%q) returned a program and error %v", expr, err)
+			}
+			return
+		}
+		if p.String() != expr {
+			t.Fatalf("Compile(%q).String() = %q", expr, p.String())
+		}
+		if _, err := p.Eval(vars); err != nil {
+			t.Fatalf("Eval of %q failed: %v", expr, err)
+		}
+	})
+}
+
+// FuzzEval checks that programs evaluate any values of the declared types
+// and fail on missing variables instead of panicking.
+func FuzzEval(f *testing.F) {
+	for _, expr := range fuzzSeeds {
+		f.Add(expr, "www.example.com", "2001:db8::1", 7, "home", true)
+	}
+	f.Fuzz(func(t *testing.T, expr, fqdn, value string, hour int, tag string, complete bool) {
+		p, err := rules.Compile(expr, fuzzDecls)
+		if err != nil {
+			return
+		}
+		vars := rules.Vars{"fqdn": fqdn, "value": value, "hour": hour, "tags": []string{tag}, "ports": []int{hour}}
+		if !complete {
+			delete(vars, "fqdn")
+		}
+		if _, err := p.Eval(vars); complete && err != nil {
+			t.Fatalf("Eval of %q failed: %v", expr, err)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package rules_test
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/rules"
+)
+
+var _ = Describe("Program", func() {
+	decls := map[string]rules.Type{
+		"fqdn":     rules.String,
+		"type":     rules.String,
+		"value":    rules.String,
+		"username": rules.String,
+		"hour":     rules.Int,
+		"tags":     rules.StringList,
+		"zone":     rules.String,
+	}
+	vars := rules.Vars{
+		"fqdn":     "alice.home.example.com",
+		"type":     "A",
+		"value":    "192.0.2.10",
+		"username": "alice",
+		"hour":     14,
+		"tags":     []string{"home"},
+	}
+
+	DescribeTable("should evaluate", func(expr string, expected bool) {
+		p, err := rules.Compile(expr, decls)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.Eval(vars)).To(Equal(expected))
+	},
+		Entry("equality", `type == "A"`, true),
+		Entry("inequality", `type != "A"`, false),
+		Entry("single quoted strings", `type == 'A'`, true),
+		Entry("escapes", `"a\"b" == 'a"b'`, true),
+		Entry("inCIDR", `value.inCIDR("192.0.2.0/24")`, true),
+		Entry("inCIDR outside", `value.inCIDR("198.51.100.0/24")`, false),
+		Entry("inCIDR non-ip", `fqdn.inCIDR("192.0.2.0/24")`, false),
+		Entry("implication", `type != "A" || value.inCIDR("192.0.2.0/24")`, true),
+		Entry("startsWith with concatenation", `fqdn.startsWith(username + ".")`, true),
+		Entry("endsWith", `fqdn.endsWith(".example.org")`, false),
+		Entry("contains", `fqdn.contains("home")`, true),
+		Entry("matches", `fqdn.matches("^[a-z]+\\.home\\.")`, true),
+		Entry("lower and upper", `type.lower() == "a" && type.lower().upper() == type`, true),
+		Entry("size", `username.size() == 5`, true),
+		Entry("string in list", `type in ["A", "AAAA"]`, true),
+		Entry("int in list", `hour in [1, 2]`, false),
+		Entry("string in variable list", `"home" in tags`, true),
+		Entry("int comparison", `hour >= 8 && hour < 18`, true),
+		Entry("int addition", `hour + 10 > 23`, true),
+		Entry("string comparison", `"a" < "b"`, true),
+		Entry("negation", `!(type == "TXT")`, true),
+		Entry("double negation", `!!true`, true),
+		Entry("precedence", `false && false || true`, true),
+		Entry("short-circuit with an unset variable", `true || zone == ""`, true),
+	)
+
+	It("should fail to evaluate with a missing variable", func() {
+		p, err := rules.Compile(`fqdn == ""`, decls)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = p.Eval(rules.Vars{})
+		Expect(err).To(MatchError(`variable "fqdn" is not set`))
+	})
+
+	DescribeTable("should fail to compile", func(expr, expectedErr string) {
+		_, err := rules.Compile(expr, decls)
+		Expect(err).To(MatchError(expectedErr))
+	},
+		Entry("non-bool expression", `type`, "column 1: expression must be of type bool, got string"),
+		Entry("unknown variable", `typo == "A"`, `column 1: unknown variable "typo"`),
+		Entry("unknown method", `fqdn.trim() == ""`, `column 6: unknown method "trim"`),
+		Entry("type mismatch", `hour == "14"`, "column 6: cannot compare int and string"),
+		Entry("list comparison", `tags == ["home"]`, "column 6: == does not support list(string) operands"),
+		Entry("logical operand", `type && true`, "column 6: && requires bool operands, got string and bool"),
+		Entry("in without list", `type in "A"`, "column 6: in requires a list of string, got string"),
+		Entry("mixed list", `type in ["A", 1]`, "column 9: list elements must be of the same type, got string and int"),
+		Entry("empty list", `type in []`, "column 9: empty lists are not supported"),
+		Entry("wrong argument count", `fqdn.startsWith()`, "column 6: method startsWith takes 1 arguments, got 0"),
+		Entry("non-literal regexp", `fqdn.matches(username)`, "column 6: argument of method matches must be a literal"),
+		Entry("invalid regexp", `fqdn.matches("(")`,
+			"column 6: invalid regular expression: error parsing regexp: missing closing ): `(`"),
+		Entry("invalid CIDR", `value.inCIDR("192.0.2.0/33")`,
+			`column 7: invalid CIDR range: netip.ParsePrefix("192.0.2.0/33"): prefix length out of range`),
+		Entry("method on int", `hour.size() == 1`, "column 6: method size requires a string receiver, got int"),
+		Entry("unterminated string", `type == "A`, "column 9: unterminated string"),
+		Entry("invalid escape", `type == "\d"`, `column 9: invalid escape sequence \d`),
+		Entry("unexpected character", `type == "A" ; true`, `column 13: unexpected character ';'`),
+		Entry("missing parenthesis", `(type == "A"`, `column 13: expected ")", got end of expression`),
+		Entry("trailing tokens", `type == "A" "B"`, `column 13: unexpected string "B"`),
+		Entry("missing operand", `type ==`, "column 8: unexpected end of expression"),
+	)
+
+	It("should reject deeply nested expressions", func() {
+		_, err := rules.Compile(strings.Repeat("(", 100)+"true"+strings.Repeat(")", 100), decls)
+		Expect(err).To(MatchError(ContainSubstring("expression is nested too deeply")))
+	})
+
+	It("should reject long expressions", func() {
+		_, err := rules.Compile(strings.Repeat(" ", 5000)+"true", decls)
+		Expect(err).To(MatchError("expression exceeds 4096 characters"))
+	})
+})
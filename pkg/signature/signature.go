@@ -0,0 +1,152 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of signed requests.
+const (
+	HeaderKey       = "X-Signature-Key"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+const (
+	nonceMinLen = 16
+	nonceMaxLen = 64
+	// nonceCacheMaxEntries caps memory use of the nonce cache. Nonces are
+	// only cached for requests with a valid signature.
+	nonceCacheMaxEntries = 1 << 16
+)
+
+var (
+	ErrMissingHeaders   = errors.New("missing signature headers")
+	ErrInvalidTimestamp = errors.New("invalid or expired timestamp")
+	ErrInvalidNonce     = errors.New("nonce must be 16 to 64 characters of [A-Za-z0-9_-]")
+	ErrUnknownKey       = errors.New("unknown key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrReplayed         = errors.New("nonce was already used")
+	ErrNonceCacheFull   = errors.New("nonce cache is full")
+)
+
+// Signed reports whether r carries a signature.
+func Signed(r *http.Request) bool {
+	return r.Header.Get(HeaderSignature) != ""
+}
+
+// StringToSign returns the string signed for a request: the method, path,
+// query with keys sorted, timestamp and nonce, separated by newlines.
+func StringToSign(method, path string, query url.Values, timestamp, nonce string) string {
+	return strings.Join([]string{method, path, query.Encode(), timestamp, nonce}, "\n")
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of stringToSign with secret.
+func Sign(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier verifies signed requests. Requests are only accepted if their
+// timestamp is within the window around the current time and their nonce
+// was not used by the same key before.
+type Verifier struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewVerifier(window time.Duration) *Verifier {
+	return &Verifier{
+		window: window,
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Verify verifies the signature of r with the secret of its key as returned
+// by secret, and returns the key and its secret.
+func (v *Verifier) Verify(r *http.Request, secret func(key string) (string, bool)) (key, keySecret string, err error) {
+	key = r.Header.Get(HeaderKey)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature := r.Header.Get(HeaderSignature)
+	if key == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", "", ErrMissingHeaders
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", "", ErrInvalidTimestamp
+	}
+	now := v.now()
+	signedAt := time.Unix(ts, 0)
+	if signedAt.Before(now.Add(-v.window)) || signedAt.After(now.Add(v.window)) {
+		return "", "", ErrInvalidTimestamp
+	}
+	if !validNonce(nonce) {
+		return "", "", ErrInvalidNonce
+	}
+
+	keySecret, ok := secret(key)
+	if !ok {
+		return "", "", ErrUnknownKey
+	}
+	expected := Sign(keySecret, StringToSign(r.Method, r.URL.Path, r.URL.Query(), timestamp, nonce))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return "", "", ErrInvalidSignature
+	}
+
+	if err := v.useNonce(key+"\x00"+nonce, signedAt.Add(v.window+time.Second)); err != nil {
+		return "", "", err
+	}
+	return key, keySecret, nil
+}
+
+func validNonce(nonce string) bool {
+	if len(nonce) < nonceMinLen || len(nonce) > nonceMaxLen {
+		return false
+	}
+	for _, c := range nonce {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// useNonce records nonce until expires, after which the timestamp of a
+// replayed request is outside the window.
+func (v *Verifier) useNonce(nonce string, expires time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.now()
+	if e, ok := v.nonces[nonce]; ok && now.Before(e) {
+		return ErrReplayed
+	}
+	if len(v.nonces) >= nonceCacheMaxEntries {
+		for n, e := range v.nonces {
+			if !now.Before(e) {
+				delete(v.nonces, n)
+			}
+		}
+		if len(v.nonces) >= nonceCacheMaxEntries {
+			return ErrNonceCacheFull
+		}
+	}
+	v.nonces[nonce] = expires
+	return nil
+}
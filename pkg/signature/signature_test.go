@@ -0,0 +1,103 @@
+package signature
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verifier", func() {
+	const (
+		key    = "device"
+		secret = "secret"
+		nonce  = "0123456789abcdef"
+		target = "/plain/update?ip=192.0.2.1&hostname=a.example.com"
+	)
+
+	var (
+		verifier *Verifier
+		now      time.Time
+	)
+
+	secrets := func(k string) (string, bool) {
+		return secret, k == key
+	}
+
+	newRequest := func(ts time.Time, nonce string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		query := url.Values{"hostname": {"a.example.com"}, "ip": {"192.0.2.1"}}
+		r.Header.Set(HeaderKey, key)
+		r.Header.Set(HeaderTimestamp, timestamp)
+		r.Header.Set(HeaderNonce, nonce)
+		r.Header.Set(HeaderSignature, Sign(secret, StringToSign(http.MethodGet, "/plain/update", query, timestamp, nonce)))
+		return r
+	}
+
+	BeforeEach(func() {
+		now = time.Unix(1_700_000_000, 0)
+		verifier = NewVerifier(5 * time.Minute)
+		verifier.now = func() time.Time { return now }
+	})
+
+	It("should sign method, path, sorted query, timestamp and nonce", func() {
+		query := url.Values{"ip": {"192.0.2.1"}, "hostname": {"a.example.com"}}
+		Expect(StringToSign(http.MethodGet, "/plain/update", query, "1700000000", nonce)).To(Equal(
+			"GET\n/plain/update\nhostname=a.example.com&ip=192.0.2.1\n1700000000\n" + nonce,
+		))
+		Expect(Sign(secret, "GET\n/plain/update\n\n1700000000\n"+nonce)).To(HaveLen(64))
+	})
+
+	It("should accept a valid signature", func() {
+		k, s, err := verifier.Verify(newRequest(now, nonce), secrets)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(k).To(Equal(key))
+		Expect(s).To(Equal(secret))
+	})
+
+	It("should reject a replayed nonce", func() {
+		_, _, err := verifier.Verify(newRequest(now, nonce), secrets)
+		Expect(err).ToNot(HaveOccurred())
+		now = now.Add(time.Minute)
+		_, _, err = verifier.Verify(newRequest(now.Add(-time.Minute), nonce), secrets)
+		Expect(err).To(MatchError(ErrReplayed))
+	})
+
+	It("should reject a replay after the window", func() {
+		signedAt := now
+		_, _, err := verifier.Verify(newRequest(signedAt, nonce), secrets)
+		Expect(err).ToNot(HaveOccurred())
+		for _, d := range []time.Duration{5 * time.Minute, time.Second, time.Hour} {
+			now = now.Add(d)
+			_, _, err = verifier.Verify(newRequest(signedAt, nonce), secrets)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+
+	DescribeTable("should reject", func(modify func(r *http.Request), expected error) {
+		r := newRequest(now, nonce)
+		modify(r)
+		_, _, err := verifier.Verify(r, secrets)
+		Expect(err).To(MatchError(expected))
+	},
+		Entry("without signature headers", func(r *http.Request) { r.Header.Del(HeaderNonce) }, ErrMissingHeaders),
+		Entry("with an invalid timestamp", func(r *http.Request) { r.Header.Set(HeaderTimestamp, "abc") }, ErrInvalidTimestamp),
+		Entry("with an old timestamp", func(r *http.Request) {
+			*r = *newRequest(time.Unix(1_700_000_000, 0).Add(-6*time.Minute), nonce)
+		}, ErrInvalidTimestamp),
+		Entry("with a future timestamp", func(r *http.Request) {
+			*r = *newRequest(time.Unix(1_700_000_000, 0).Add(6*time.Minute), nonce)
+		}, ErrInvalidTimestamp),
+		Entry("with a short nonce", func(r *http.Request) { *r = *newRequest(time.Unix(1_700_000_000, 0), "short") }, ErrInvalidNonce),
+		Entry("with an unknown key", func(r *http.Request) { r.Header.Set(HeaderKey, "other") }, ErrUnknownKey),
+		Entry("with a modified query", func(r *http.Request) { r.URL.RawQuery = "hostname=b.example.com&ip=192.0.2.1" },
+			ErrInvalidSignature),
+		Entry("with a modified method", func(r *http.Request) { r.Method = http.MethodPost }, ErrInvalidSignature),
+		Entry("with a modified nonce", func(r *http.Request) { r.Header.Set(HeaderNonce, "fedcba9876543210") }, ErrInvalidSignature),
+	)
+})
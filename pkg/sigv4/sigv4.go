@@ -0,0 +1,247 @@
+// Package sigv4 signs and verifies requests with AWS Signature Version 4 as
+// used by the AWS SDKs, with the signature in the Authorization header.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// Algorithm is the signing algorithm of Signature Version 4.
+	Algorithm = "AWS4-HMAC-SHA256"
+
+	// HeaderDate is the header carrying the time of signing.
+	HeaderDate = "X-Amz-Date"
+	// HeaderContentSHA256 optionally carries the hash of the body.
+	HeaderContentSHA256 = "X-Amz-Content-Sha256"
+
+	// TimeFormat is the format of HeaderDate.
+	TimeFormat = "20060102T150405Z"
+
+	headerAuthorization = "Authorization"
+
+	dateFormat   = "20060102"
+	terminator   = "aws4_request"
+	scopeParts   = 5
+	headerHost   = "host"
+	headerXDate  = "x-amz-date"
+	keyPrefix    = "AWS4"
+	credentialID = "Credential="
+	signedID     = "SignedHeaders="
+	signatureID  = "Signature="
+)
+
+var (
+	ErrMissingAuthorization = errors.New("missing authorization")
+	ErrInvalidAuthorization = errors.New("invalid authorization header")
+	ErrInvalidDate          = errors.New("invalid or expired signing date")
+	ErrInvalidScope         = errors.New("invalid credential scope")
+	ErrUnknownKey           = errors.New("unknown access key")
+	ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// authorization is the parsed Authorization header of a signed request.
+type authorization struct {
+	accessKeyID   string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+func (a *authorization) scope() string {
+	return strings.Join([]string{a.date, a.region, a.service, terminator}, "/")
+}
+
+// Verify verifies the signature of r with body for service with the secret
+// of its access key as returned by secret, and returns the access key ID.
+// The signing time must be within window of now.
+func Verify(
+	r *http.Request, body []byte, service string, now time.Time, window time.Duration, secret func(accessKeyID string) (string, bool),
+) (string, error) {
+	header := r.Header.Get(headerAuthorization)
+	if header == "" {
+		return "", ErrMissingAuthorization
+	}
+	auth, err := parseAuthorization(header)
+	if err != nil {
+		return "", err
+	}
+
+	signedAt, err := time.Parse(TimeFormat, r.Header.Get(HeaderDate))
+	if err != nil || signedAt.Sub(now).Abs() > window {
+		return "", ErrInvalidDate
+	}
+	if auth.date != signedAt.Format(dateFormat) || auth.service != service {
+		return "", ErrInvalidScope
+	}
+	payloadHash := hashHex(body)
+	if h := r.Header.Get(HeaderContentSHA256); h != "" && h != payloadHash {
+		return "", ErrInvalidSignature
+	}
+
+	key, ok := secret(auth.accessKeyID)
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	expected := signature(key, auth, r.Header.Get(HeaderDate), canonicalRequest(r, auth.signedHeaders, payloadHash))
+	if !hmac.Equal([]byte(expected), []byte(auth.signature)) {
+		return "", ErrInvalidSignature
+	}
+	return auth.accessKeyID, nil
+}
+
+// Sign signs r with body for service in region with the access key
+// accessKeyID and its secret at now. The host and HeaderDate headers are
+// signed.
+func Sign(r *http.Request, body []byte, accessKeyID, secret, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(TimeFormat)
+	r.Header.Set(HeaderDate, amzDate)
+	auth := &authorization{
+		accessKeyID:   accessKeyID,
+		date:          now.UTC().Format(dateFormat),
+		region:        region,
+		service:       service,
+		signedHeaders: []string{headerHost, headerXDate},
+	}
+	sig := signature(secret, auth, amzDate, canonicalRequest(r, auth.signedHeaders, hashHex(body)))
+	r.Header.Set(headerAuthorization, Algorithm+" "+strings.Join([]string{
+		credentialID + accessKeyID + "/" + auth.scope(),
+		signedID + strings.Join(auth.signedHeaders, ";"),
+		signatureID + sig,
+	}, ", "))
+}
+
+// parseAuthorization parses an Authorization header like
+// "AWS4-HMAC-SHA256 Credential=AKID/20150830/us-east-1/iam/aws4_request,
+// SignedHeaders=host;x-amz-date, Signature=<hex>".
+func parseAuthorization(header string) (*authorization, error) {
+	params, ok := strings.CutPrefix(header, Algorithm+" ")
+	if !ok {
+		return nil, ErrInvalidAuthorization
+	}
+	auth := &authorization{}
+	var credential, signedHeaders string
+	for param := range strings.SplitSeq(params, ",") {
+		param = strings.TrimSpace(param)
+		switch {
+		case strings.HasPrefix(param, credentialID):
+			credential = strings.TrimPrefix(param, credentialID)
+		case strings.HasPrefix(param, signedID):
+			signedHeaders = strings.TrimPrefix(param, signedID)
+		case strings.HasPrefix(param, signatureID):
+			auth.signature = strings.TrimPrefix(param, signatureID)
+		}
+	}
+
+	scope := strings.Split(credential, "/")
+	if len(scope) != scopeParts || scope[4] != terminator || auth.signature == "" {
+		return nil, ErrInvalidAuthorization
+	}
+	auth.accessKeyID, auth.date, auth.region, auth.service = scope[0], scope[1], scope[2], scope[3]
+	auth.signedHeaders = strings.Split(signedHeaders, ";")
+	if !slices.Contains(auth.signedHeaders, headerHost) || !slices.Contains(auth.signedHeaders, headerXDate) {
+		return nil, ErrInvalidAuthorization
+	}
+	return auth, nil
+}
+
+// canonicalRequest returns the canonical request of r with the headers
+// signedHeaders and the hex encoded hash of its body payloadHash.
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		values := r.Header.Values(name)
+		if name == headerHost {
+			values = []string{r.Host}
+		}
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers.WriteString(name + ":" + strings.Join(trimmed, ",") + "\n")
+	}
+
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		r.Method,
+		escape(path, true),
+		canonicalQuery(r),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(r *http.Request) string {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var params []string
+	for _, key := range keys {
+		values := slices.Clone(query[key])
+		slices.Sort(values)
+		for _, value := range values {
+			params = append(params, escape(key, false)+"="+escape(value, false))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// escape percent-encodes all bytes of s except unreserved characters and,
+// if keepSlash is set, slashes.
+func escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		if isUnreserved(c) || (keepSlash && c == '/') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+// signature returns the hex encoded signature of the canonical request
+// signed at amzDate with the key derived from secret for the scope of auth.
+func signature(secret string, auth *authorization, amzDate, canonical string) string {
+	stringToSign := strings.Join([]string{Algorithm, amzDate, auth.scope(), hashHex([]byte(canonical))}, "\n")
+	key := hmacSHA256([]byte(keyPrefix+secret), auth.date)
+	for _, part := range []string{auth.region, auth.service, terminator} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
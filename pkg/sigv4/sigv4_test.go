@@ -0,0 +1,109 @@
+package sigv4_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sigv4"
+)
+
+const (
+	accessKeyID = "AKIDEXAMPLE"
+	secret      = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	amzDate     = "20150830T123600Z"
+)
+
+var _ = Describe("SigV4", func() {
+	var now time.Time
+
+	BeforeEach(func() {
+		var err error
+		now, err = time.Parse(sigv4.TimeFormat, amzDate)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	secrets := func(id string) (string, bool) {
+		return secret, id == accessKeyID
+	}
+
+	verify := func(r *http.Request, body, service string) (string, error) {
+		return sigv4.Verify(r, []byte(body), service, now, 15*time.Minute, secrets)
+	}
+
+	It("should verify the get-vanilla example of the AWS test suite", func() {
+		r := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", http.NoBody)
+		r.Header.Set(sigv4.HeaderDate, amzDate)
+		r.Header.Set("Authorization", sigv4.Algorithm+" Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+			"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31")
+		Expect(verify(r, "", "service")).To(Equal(accessKeyID))
+	})
+
+	It("should verify the IAM example of the AWS documentation", func() {
+		r := httptest.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", http.NoBody)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+		r.Header.Set(sigv4.HeaderDate, amzDate)
+		r.Header.Set("Authorization", sigv4.Algorithm+" Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+			"SignedHeaders=content-type;host;x-amz-date, "+
+			"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7")
+		Expect(verify(r, "", "iam")).To(Equal(accessKeyID))
+	})
+
+	Context("signed requests", func() {
+		const body = "<Request/>"
+
+		var r *http.Request
+
+		BeforeEach(func() {
+			target := "https://proxy.example.com/2013-04-01/hostedzone/1/rrset?name=a%20b&type=TXT"
+			r = httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+			sigv4.Sign(r, []byte(body), accessKeyID, secret, "us-east-1", "route53", now)
+		})
+
+		It("should verify requests signed with Sign", func() {
+			Expect(verify(r, body, "route53")).To(Equal(accessKeyID))
+		})
+
+		DescribeTable("should reject", func(modify func(r *http.Request) string, expected error) {
+			b := modify(r)
+			_, err := verify(r, b, "route53")
+			Expect(err).To(MatchError(expected))
+		},
+			Entry("changed bodies", func(_ *http.Request) string {
+				return "<Other/>"
+			}, sigv4.ErrInvalidSignature),
+			Entry("changed paths", func(r *http.Request) string {
+				r.URL.Path = "/2013-04-01/hostedzone/2/rrset"
+				return body
+			}, sigv4.ErrInvalidSignature),
+			Entry("changed hosts", func(r *http.Request) string {
+				r.Host = "other.example.com"
+				return body
+			}, sigv4.ErrInvalidSignature),
+			Entry("expired dates", func(r *http.Request) string {
+				r.Header.Set(sigv4.HeaderDate, now.Add(-time.Hour).Format(sigv4.TimeFormat))
+				return body
+			}, sigv4.ErrInvalidDate),
+			Entry("other services", func(r *http.Request) string {
+				r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "/route53/", "/iam/", 1))
+				return body
+			}, sigv4.ErrInvalidScope),
+			Entry("unknown keys", func(r *http.Request) string {
+				r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), accessKeyID, "OTHER", 1))
+				return body
+			}, sigv4.ErrUnknownKey),
+			Entry("unsigned dates", func(r *http.Request) string {
+				r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), ";x-amz-date", "", 1))
+				return body
+			}, sigv4.ErrInvalidAuthorization),
+			Entry("missing authorization", func(r *http.Request) string {
+				r.Header.Del("Authorization")
+				return body
+			}, sigv4.ErrMissingAuthorization),
+		)
+	})
+})
@@ -0,0 +1,20 @@
+package tlscert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// LoadCertPool returns a pool of the PEM encoded certificates in path.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
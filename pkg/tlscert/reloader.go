@@ -0,0 +1,71 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+// Reloader serves a certificate loaded from a certificate and a key file.
+// The files are reloaded when they change, so renewed certificates are used
+// without a restart. If a reload fails, e.g. because only one of the files
+// was replaced yet, the previous certificate is kept and the reload is
+// retried.
+type Reloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	watcher  *filewatch.Watcher
+	interval time.Duration
+	now      func() time.Time
+	cert     atomic.Pointer[tls.Certificate]
+	retryAt  atomic.Int64
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		watcher:  filewatch.New(filewatch.DefaultInterval, certFile, keyFile),
+		interval: filewatch.DefaultInterval,
+		now:      time.Now,
+	}
+	r.cert.Store(&cert)
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if r.watcher.Changed() || r.retryDue() {
+		r.reload()
+	}
+	return r.cert.Load(), nil
+}
+
+func (r *Reloader) retryDue() bool {
+	retryAt := r.retryAt.Load()
+	return retryAt != 0 && r.now().UnixNano() >= retryAt
+}
+
+func (r *Reloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		log.Printf("failed to reload TLS certificate, keeping previous certificate: %v", err)
+		r.retryAt.Store(r.now().Add(r.interval).UnixNano())
+		return
+	}
+	r.cert.Store(&cert)
+	r.retryAt.Store(0)
+	log.Printf("Reloaded TLS certificate from %s", r.certFile)
+}
@@ -0,0 +1,132 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/filewatch"
+)
+
+func generateKeyPair(commonName string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{commonName},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+var _ = Describe("Reloader", func() {
+	var certFile, keyFile string
+
+	writeFile := func(path string, data []byte) {
+		Expect(os.WriteFile(path, data, 0o600)).To(Succeed())
+	}
+
+	writeKeyPair := func(commonName string) {
+		certPEM, keyPEM := generateKeyPair(commonName)
+		writeFile(certFile, certPEM)
+		writeFile(keyFile, keyPEM)
+	}
+
+	commonName := func(r *Reloader) string {
+		cert, err := r.GetCertificate(nil)
+		Expect(err).ToNot(HaveOccurred())
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		return leaf.Subject.CommonName
+	}
+
+	newReloader := func() *Reloader {
+		r, err := NewReloader(certFile, keyFile)
+		Expect(err).ToNot(HaveOccurred())
+		r.watcher = filewatch.New(0, certFile, keyFile)
+		r.interval = 0
+		return r
+	}
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		certFile = filepath.Join(dir, "tls.crt")
+		keyFile = filepath.Join(dir, "tls.key")
+		writeKeyPair("first.example.com")
+	})
+
+	It("should fail on invalid files", func() {
+		writeFile(keyFile, []byte("invalid"))
+		_, err := NewReloader(certFile, keyFile)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reload the certificate on change", func() {
+		r := newReloader()
+		Expect(commonName(r)).To(Equal("first.example.com"))
+
+		writeKeyPair("second.example.com")
+		Expect(commonName(r)).To(Equal("second.example.com"))
+	})
+
+	It("should keep the previous certificate until both files are replaced", func() {
+		r := newReloader()
+		certPEM, keyPEM := generateKeyPair("second.example.com")
+
+		writeFile(certFile, certPEM)
+		Expect(commonName(r)).To(Equal("first.example.com"))
+
+		writeFile(keyFile, keyPEM)
+		Expect(commonName(r)).To(Equal("second.example.com"))
+	})
+
+	It("should retry a failed reload", func() {
+		r := newReloader()
+		certPEM, keyPEM := generateKeyPair("second.example.com")
+
+		writeFile(certFile, certPEM)
+		Expect(commonName(r)).To(Equal("first.example.com"))
+
+		// Replace the key without the watcher noticing the change.
+		writeFile(keyFile, keyPEM)
+		r.watcher = filewatch.New(0, certFile, keyFile)
+		Expect(commonName(r)).To(Equal("second.example.com"))
+	})
+})
+
+var _ = Describe("LoadCertPool", func() {
+	It("should load certificates", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		certPEM, _ := generateKeyPair("ca.example.com")
+		Expect(os.WriteFile(path, certPEM, 0o600)).To(Succeed())
+
+		pool, err := LoadCertPool(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool.Equal(x509.NewCertPool())).To(BeFalse())
+	})
+
+	It("should fail without certificates", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+		Expect(os.WriteFile(path, []byte("invalid"), 0o600)).To(Succeed())
+
+		_, err := LoadCertPool(path)
+		Expect(err).To(MatchError(ContainSubstring("no certificates found")))
+	})
+})
@@ -0,0 +1,272 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/miekg/dns"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/hetzner"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// apexName is the name of the RRSets at the apex of a zone.
+	apexName = "@"
+
+	recordTypeCNAME = "CNAME"
+)
+
+// supportedTypes are the record types of the endpoints of the webhook.
+var supportedTypes = []string{"A", "AAAA", "TXT", recordTypeCNAME}
+
+// endpoint is an endpoint of external-dns, which is an RRSet with all its
+// values as targets. Labels and provider specific properties are passed
+// through unchanged.
+type endpoint struct {
+	DNSName          string            `json:"dnsName"`
+	Targets          []string          `json:"targets"`
+	RecordType       string            `json:"recordType"`
+	SetIdentifier    string            `json:"setIdentifier,omitempty"`
+	RecordTTL        int64             `json:"recordTTL,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ProviderSpecific json.RawMessage   `json:"providerSpecific,omitempty"`
+}
+
+// changes are the changes of a plan of external-dns. UpdateOld holds the
+// current state of the endpoints of UpdateNew.
+type changes struct {
+	Create    []*endpoint `json:"create"`
+	UpdateOld []*endpoint `json:"updateOld"`
+	UpdateNew []*endpoint `json:"updateNew"`
+	Delete    []*endpoint `json:"delete"`
+}
+
+// change is a checked change of an RRSet with its name relative to its
+// zone. Deletions have no values.
+type change struct {
+	zone       *hcloud.Zone
+	name       string
+	fqdn       string
+	recordType string
+	values     []string
+	ttl        int
+}
+
+// getRecords returns the RRSets of the domain filter with a supported type
+// as endpoints.
+func (h *handler) getRecords(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.records.Zones(ctx)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	endpoints := []*endpoint{}
+	for _, zone := range zones {
+		if !h.overlaps(zone.Name) {
+			continue
+		}
+		rrSets, err := h.records.RRSets(ctx, zone)
+		if err != nil {
+			failed(w, err)
+			return
+		}
+		for _, rrSet := range rrSets {
+			fqdn := recordFQDN(rrSet.Name, zone.Name)
+			if slices.Contains(supportedTypes, string(rrSet.Type)) && h.manages(fqdn) {
+				endpoints = append(endpoints, newEndpoint(zone, rrSet, fqdn))
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, endpoints)
+}
+
+func newEndpoint(zone *hcloud.Zone, rrSet *hcloud.ZoneRRSet, fqdn string) *endpoint {
+	ttl := zone.TTL
+	if rrSet.TTL != nil {
+		ttl = *rrSet.TTL
+	}
+	targets := make([]string, 0, len(rrSet.Records))
+	for _, record := range rrSet.Records {
+		value := hetzner.UnquoteIfRequired(record.Value, rrSet.Type)
+		if rrSet.Type == hcloud.ZoneRRSetTypeCNAME {
+			value = strings.TrimSuffix(value, ".")
+		}
+		targets = append(targets, value)
+	}
+	return &endpoint{DNSName: fqdn, Targets: targets, RecordType: string(rrSet.Type), RecordTTL: int64(ttl)}
+}
+
+// adjustEndpoints drops the desired endpoints the webhook cannot manage, so
+// external-dns does not plan changes of them.
+func (h *handler) adjustEndpoints(w http.ResponseWriter, r *http.Request) {
+	var endpoints []*endpoint
+	if !decode(w, r, &endpoints) {
+		return
+	}
+	adjusted := make([]*endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		fqdn := normalizeName(ep.DNSName)
+		if ep.SetIdentifier != "" || !slices.Contains(supportedTypes, ep.RecordType) || !h.manages(fqdn) {
+			continue
+		}
+		if ep.RecordType == recordTypeCNAME {
+			for i := range ep.Targets {
+				ep.Targets[i] = strings.TrimSuffix(ep.Targets[i], ".")
+			}
+		}
+		adjusted = append(adjusted, ep)
+	}
+	writeJSON(w, http.StatusOK, adjusted)
+}
+
+// applyChanges deletes the RRSets of the deleted endpoints and sets the
+// records of created and updated ones. All changes are checked before the
+// first one is applied, but they are not applied atomically.
+func (h *handler) applyChanges(w http.ResponseWriter, r *http.Request) {
+	req := &changes{}
+	if !decode(w, r, req) {
+		return
+	}
+	ctx, cancel := h.context(r)
+	defer cancel()
+	zones, err := h.records.Zones(ctx)
+	if err != nil {
+		failed(w, err)
+		return
+	}
+
+	var checked []*change
+	for _, ep := range slices.Concat(req.Delete, req.Create, req.UpdateNew) {
+		c, err := h.checkEndpoint(r, zones, ep, !slices.Contains(req.Delete, ep))
+		if err != nil {
+			log.Printf("rejected changes of external-dns: %s", sanitize.LogValue(err.Error()))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		checked = append(checked, c)
+	}
+
+	for _, c := range checked {
+		logChange(c)
+		if c.values == nil {
+			err = h.records.DeleteRRSet(ctx, c.zone, c.name, c.recordType)
+		} else {
+			err = h.records.SetRecords(ctx, c.zone, c.name, c.recordType, c.values, c.ttl)
+		}
+		if err != nil {
+			failed(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkEndpoint returns the change of ep, which sets its targets if set is
+// true and deletes its RRSet otherwise.
+func (h *handler) checkEndpoint(r *http.Request, zones []*hcloud.Zone, ep *endpoint, set bool) (*change, error) {
+	c := &change{fqdn: normalizeName(ep.DNSName), recordType: ep.RecordType, ttl: int(ep.RecordTTL)}
+	switch {
+	case ep.SetIdentifier != "":
+		return nil, fmt.Errorf("%s: set identifiers are not supported", c.fqdn)
+	case !slices.Contains(supportedTypes, c.recordType):
+		return nil, fmt.Errorf("%s: unsupported record type %s", c.fqdn, c.recordType)
+	case !h.manages(c.fqdn):
+		return nil, fmt.Errorf("%s: not in the domain filter", c.fqdn)
+	}
+	c.zone, c.name = findZone(zones, c.fqdn)
+	if c.zone == nil {
+		return nil, fmt.Errorf("%s: no zone found", c.fqdn)
+	}
+	if !set {
+		return c, nil
+	}
+
+	if len(ep.Targets) == 0 || (c.recordType == recordTypeCNAME && len(ep.Targets) > 1) {
+		return nil, fmt.Errorf("%s: invalid number of targets", c.fqdn)
+	}
+	for _, target := range ep.Targets {
+		value, err := h.checkTarget(r, c, target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.fqdn, err)
+		}
+		c.values = append(c.values, value)
+	}
+	if c.ttl <= 0 {
+		c.ttl = h.cfg.RecordTTL
+	}
+	return c, nil
+}
+
+// checkTarget returns the value of the record of c with target, which
+// auth.matchClientIP must allow for the client of r.
+func (h *handler) checkTarget(r *http.Request, c *change, target string) (string, error) {
+	switch c.recordType {
+	case recordTypeCNAME:
+		value := dns.Fqdn(strings.ToLower(target))
+		if _, ok := dns.IsDomainName(value); !ok {
+			return "", fmt.Errorf("invalid CNAME target %s", target)
+		}
+		return value, nil
+	case string(hcloud.ZoneRRSetTypeTXT):
+		return hetzner.UnquoteIfRequired(target, hcloud.ZoneRRSetTypeTXT), nil
+	default:
+		if err := middleware.ValidateValue(&h.cfg.AddressPolicy, c.fqdn, target, c.recordType); err != nil {
+			return "", err
+		}
+		if !middleware.ClientIPAllowed(h.cfg, nil, c.fqdn, c.recordType, target, r.RemoteAddr) {
+			return "", fmt.Errorf("%s does not match the client IP", target)
+		}
+		return target, nil
+	}
+}
+
+// findZone returns the zone of fqdn with the longest name and the name of
+// fqdn relative to it.
+func findZone(zones []*hcloud.Zone, fqdn string) (*hcloud.Zone, string) {
+	var found *hcloud.Zone
+	for _, zone := range zones {
+		if matches(fqdn, zone.Name) && (found == nil || len(zone.Name) > len(found.Name)) {
+			found = zone
+		}
+	}
+	if found == nil {
+		return nil, ""
+	}
+	if fqdn == found.Name {
+		return found, apexName
+	}
+	return found, strings.TrimSuffix(fqdn, "."+found.Name)
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func recordFQDN(name, zone string) string {
+	if name == apexName {
+		return zone
+	}
+	return name + "." + zone
+}
+
+func logChange(c *change) {
+	action := "set"
+	if c.values == nil {
+		action = "delete"
+	}
+	typ := sanitize.LogValue(c.recordType)
+	name := sanitize.LogValue(c.fqdn)
+	val := sanitize.LogValue(strings.Join(c.values, ", "))
+	//nolint:gosec // values are sanitized above
+	log.Printf("received request of external-dns to %s '%s' data of '%s' with values '%s'", action, typ, name, val)
+}
@@ -0,0 +1,152 @@
+// Package webhook implements the webhook provider protocol of external-dns
+// on top of the zones and RRSets of the Cloud API, so external-dns manages
+// the records of the configured domain filter without holding the Cloud API
+// token.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/sanitize"
+)
+
+const (
+	// MediaType is the media type of all request and response bodies,
+	// including the version of the protocol.
+	MediaType = "application/external.dns.webhook+json;version=1"
+
+	mediaTypeName      = "application/external.dns.webhook+json"
+	headerContentType  = "Content-Type"
+	maxRequestBodySize = 1 << 20 // 1 MB
+)
+
+// Records reads and changes the zones and RRSets of the Cloud API,
+// including CNAME RRSets.
+type Records interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	RRSets(ctx context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error)
+	SetRecords(ctx context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int) error
+	DeleteRRSet(ctx context.Context, zone *hcloud.Zone, name, recordType string) error
+}
+
+type handler struct {
+	cfg     *config.Config
+	webhook *config.Webhook
+	records Records
+}
+
+// domainFilter is the domain filter of external-dns, which the negotiation
+// returns.
+type domainFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// New returns the handler of the webhook listener. Clients outside the
+// allowed networks of cfg.Webhook are rejected.
+func New(cfg *config.Config, records Records) func(http.Handler) http.Handler {
+	h := &handler{cfg: cfg, webhook: cfg.Webhook, records: records}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", h.negotiate)
+	mux.HandleFunc("GET /records", h.getRecords)
+	mux.HandleFunc("POST /records", h.applyChanges)
+	mux.HandleFunc("POST /adjustendpoints", h.adjustEndpoints)
+
+	return func(_ http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !h.allowsClient(r) {
+				addr := sanitize.LogValue(r.RemoteAddr)
+				//nolint:gosec // value is sanitized above
+				log.Printf("client '%s' is not allowed to use the webhook", addr)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (h *handler) allowsClient(r *http.Request) bool {
+	if len(h.webhook.Prefixes) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(h.webhook.Prefixes, func(p netip.Prefix) bool {
+		return p.Contains(addr.Unmap())
+	})
+}
+
+// negotiate answers the negotiation of external-dns with the domain filter.
+func (h *handler) negotiate(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, &domainFilter{Include: h.webhook.DomainFilter, Exclude: h.webhook.ExcludeDomains})
+}
+
+func (h *handler) context(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(h.cfg.Timeout)*time.Second)
+}
+
+// manages reports whether fqdn is in the domain filter.
+func (h *handler) manages(fqdn string) bool {
+	return matchesAny(fqdn, h.webhook.DomainFilter) && !matchesAny(fqdn, h.webhook.ExcludeDomains)
+}
+
+// overlaps reports whether zone holds records in the domain filter.
+func (h *handler) overlaps(zone string) bool {
+	return slices.ContainsFunc(h.webhook.DomainFilter, func(domain string) bool {
+		return matches(zone, domain) || matches(domain, zone)
+	})
+}
+
+func matchesAny(fqdn string, domains []string) bool {
+	return slices.ContainsFunc(domains, func(domain string) bool {
+		return matches(fqdn, domain)
+	})
+}
+
+// matches reports whether fqdn is domain or one of its subdomains.
+func matches(fqdn, domain string) bool {
+	return fqdn == domain || strings.HasSuffix(fqdn, "."+domain)
+}
+
+// decode decodes the body of r into v if it has the media type of the
+// protocol.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get(headerContentType)); err != nil || mediaType != mediaTypeName {
+		http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set(headerContentType, MediaType)
+	w.Header().Set("Vary", headerContentType)
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
+
+func failed(w http.ResponseWriter, err error) {
+	log.Printf("failed to call the Cloud API: %v", err)
+	http.Error(w, "failed to call the Cloud API", http.StatusInternalServerError)
+}
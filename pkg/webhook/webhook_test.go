@@ -0,0 +1,205 @@
+package webhook_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/webhook"
+)
+
+// fakeRecords keeps the zones and RRSets of the Cloud API in memory.
+type fakeRecords struct {
+	zones  []*hcloud.Zone
+	rrSets map[int64][]*hcloud.ZoneRRSet
+	calls  []string
+}
+
+func (f *fakeRecords) Zones(_ context.Context) ([]*hcloud.Zone, error) {
+	return f.zones, nil
+}
+
+func (f *fakeRecords) RRSets(_ context.Context, zone *hcloud.Zone) ([]*hcloud.ZoneRRSet, error) {
+	return f.rrSets[zone.ID], nil
+}
+
+func (f *fakeRecords) SetRecords(_ context.Context, zone *hcloud.Zone, name, recordType string, values []string, ttl int) error {
+	f.calls = append(f.calls, "set "+zone.Name+" "+name+" "+recordType+" "+strings.Join(values, ",")+" "+strconv.Itoa(ttl))
+	return nil
+}
+
+func (f *fakeRecords) DeleteRRSet(_ context.Context, zone *hcloud.Zone, name, recordType string) error {
+	f.calls = append(f.calls, "delete "+zone.Name+" "+name+" "+recordType)
+	return nil
+}
+
+type endpointJSON struct {
+	DNSName    string   `json:"dnsName"`
+	Targets    []string `json:"targets"`
+	RecordType string   `json:"recordType"`
+	RecordTTL  int64    `json:"recordTTL,omitempty"`
+}
+
+var _ = Describe("Webhook", func() {
+	var (
+		records *fakeRecords
+		cfg     *config.Config
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		ttl := 300
+		records = &fakeRecords{
+			zones: []*hcloud.Zone{
+				{ID: 1, Name: "example.com", TTL: 3600},
+				{ID: 2, Name: "k8s.example.com", TTL: 3600},
+				{ID: 3, Name: "example.org", TTL: 3600},
+			},
+			rrSets: map[int64][]*hcloud.ZoneRRSet{
+				1: {
+					{Name: "www", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.4"}}},
+				},
+				2: {
+					{Name: "app", Type: hcloud.ZoneRRSetTypeA, TTL: &ttl, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.5"}}},
+					{Name: "app", Type: hcloud.ZoneRRSetTypeTXT, Records: []hcloud.ZoneRRSetRecord{{Value: `"heritage=external-dns"`}}},
+					{Name: "web", Type: hcloud.ZoneRRSetTypeCNAME, Records: []hcloud.ZoneRRSetRecord{{Value: "app.k8s.example.com."}}},
+					{Name: "@", Type: hcloud.ZoneRRSetTypeMX, Records: []hcloud.ZoneRRSetRecord{{Value: "10 mail.example.com."}}},
+					{Name: "internal", Type: hcloud.ZoneRRSetTypeA, Records: []hcloud.ZoneRRSetRecord{{Value: "1.2.3.6"}}},
+				},
+			},
+		}
+		cfg = &config.Config{
+			Timeout:   10,
+			RecordTTL: 60,
+			Webhook: &config.Webhook{
+				ListenAddr:     ":8888",
+				DomainFilter:   []string{"k8s.example.com"},
+				ExcludeDomains: []string{"internal.k8s.example.com"},
+			},
+			Auth: config.Auth{
+				MatchClientIP: []config.ClientIPMatch{
+					{Domains: []string{"home.k8s.example.com"}, Mode: config.ClientIPMatchExact},
+				},
+			},
+		}
+		handler = nil
+	})
+
+	do := func(method, target string, body any) *httptest.ResponseRecorder {
+		if handler == nil {
+			handler = webhook.New(cfg, records)(nil)
+		}
+		var reader io.Reader = http.NoBody
+		if body != nil {
+			data, err := json.Marshal(body)
+			Expect(err).ToNot(HaveOccurred())
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.RemoteAddr = "1.2.3.100"
+		req.Header.Set("Content-Type", webhook.MediaType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	It("should negotiate the domain filter", func() {
+		rec := do(http.MethodGet, "/", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal(webhook.MediaType))
+		Expect(rec.Body.String()).To(MatchJSON(`{"include":["k8s.example.com"],"exclude":["internal.k8s.example.com"]}`))
+	})
+
+	It("should list the records of the domain filter", func() {
+		rec := do(http.MethodGet, "/records", nil)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var endpoints []endpointJSON
+		Expect(json.Unmarshal(rec.Body.Bytes(), &endpoints)).To(Succeed())
+		Expect(endpoints).To(ConsistOf(
+			endpointJSON{DNSName: "app.k8s.example.com", Targets: []string{"1.2.3.5"}, RecordType: "A", RecordTTL: 300},
+			endpointJSON{DNSName: "app.k8s.example.com", Targets: []string{"heritage=external-dns"}, RecordType: "TXT", RecordTTL: 3600},
+			endpointJSON{DNSName: "web.k8s.example.com", Targets: []string{"app.k8s.example.com"}, RecordType: "CNAME", RecordTTL: 3600},
+		))
+	})
+
+	It("should drop endpoints it cannot manage", func() {
+		rec := do(http.MethodPost, "/adjustendpoints", []endpointJSON{
+			{DNSName: "new.k8s.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"},
+			{DNSName: "www.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"},
+			{DNSName: "k8s.example.com", Targets: []string{"10 mail.example.com"}, RecordType: "MX"},
+			{DNSName: "internal.k8s.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"},
+		})
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		var endpoints []endpointJSON
+		Expect(json.Unmarshal(rec.Body.Bytes(), &endpoints)).To(Succeed())
+		Expect(endpoints).To(ConsistOf(
+			endpointJSON{DNSName: "new.k8s.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"},
+		))
+	})
+
+	It("should apply changes", func() {
+		rec := do(http.MethodPost, "/records", map[string][]endpointJSON{
+			"create": {
+				{DNSName: "new.k8s.example.com", Targets: []string{"1.2.3.7", "1.2.3.8"}, RecordType: "A", RecordTTL: 120},
+				{DNSName: "alias.k8s.example.com.", Targets: []string{"app.k8s.example.com"}, RecordType: "CNAME"},
+			},
+			"updateOld": {{DNSName: "app.k8s.example.com", Targets: []string{"1.2.3.5"}, RecordType: "A"}},
+			"updateNew": {{DNSName: "app.k8s.example.com", Targets: []string{"1.2.3.9"}, RecordType: "A"}},
+			"delete":    {{DNSName: "web.k8s.example.com", Targets: []string{"app.k8s.example.com"}, RecordType: "CNAME"}},
+		})
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+		Expect(records.calls).To(Equal([]string{
+			"delete k8s.example.com web CNAME",
+			"set k8s.example.com new A 1.2.3.7,1.2.3.8 120",
+			"set k8s.example.com alias CNAME app.k8s.example.com. 60",
+			"set k8s.example.com app A 1.2.3.9 60",
+		}))
+	})
+
+	DescribeTable("should reject changes", func(ep endpointJSON) {
+		rec := do(http.MethodPost, "/records", map[string][]endpointJSON{
+			"create": {{DNSName: "ok.k8s.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"}, ep},
+		})
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		Expect(records.calls).To(BeEmpty())
+	},
+		Entry("outside the domain filter", endpointJSON{DNSName: "www.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"}),
+		Entry("in an excluded domain", endpointJSON{DNSName: "internal.k8s.example.com", Targets: []string{"1.2.3.7"}, RecordType: "A"}),
+		Entry("with an unsupported type", endpointJSON{DNSName: "k8s.example.com", Targets: []string{"10 mx"}, RecordType: "MX"}),
+		Entry("with a private address", endpointJSON{DNSName: "new.k8s.example.com", Targets: []string{"10.0.0.1"}, RecordType: "A"}),
+		Entry("with an address other than the client IP", endpointJSON{
+			DNSName: "home.k8s.example.com", Targets: []string{"1.2.3.8"}, RecordType: "A",
+		}),
+		Entry("without targets", endpointJSON{DNSName: "new.k8s.example.com", RecordType: "A"}),
+		Entry("with several CNAME targets", endpointJSON{
+			DNSName: "new.k8s.example.com", Targets: []string{"a.example.com", "b.example.com"}, RecordType: "CNAME",
+		}),
+	)
+
+	It("should reject other media types", func() {
+		handler = webhook.New(cfg, records)(nil)
+		req := httptest.NewRequest(http.MethodPost, "/adjustendpoints", strings.NewReader("[]"))
+		req.RemoteAddr = "1.2.3.100"
+		req.Header.Set("Content-Type", "text/plain")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusUnsupportedMediaType))
+	})
+
+	It("should reject clients outside the allowed networks", func() {
+		cfg.Webhook.Prefixes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+		Expect(do(http.MethodGet, "/", nil).Code).To(Equal(http.StatusForbidden))
+	})
+})
@@ -0,0 +1,115 @@
+// Package zoneapi holds what the emulated DNS provider APIs share: looking
+// up the zones an API token covers, paginating lists and writing JSON
+// responses.
+package zoneapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/config"
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/middleware"
+)
+
+// Zones looks up the zones of the Cloud API.
+type Zones interface {
+	Zones(ctx context.Context) ([]*hcloud.Zone, error)
+	Zone(ctx context.Context, idOrName string) (*hcloud.Zone, error)
+}
+
+// CoversZone reports whether the API token of r covers zone. r must have
+// passed middleware.NewAPITokenAuth.
+func CoversZone(auth *config.Auth, r *http.Request, zone string) bool {
+	return middleware.APITokenCoversZone(auth, middleware.APITokenFromContext(r.Context()), zone, r.RemoteAddr)
+}
+
+// VisibleZone returns the zone with the numeric id if the API token of r
+// covers it, and nil if it does not or id is not numeric.
+func VisibleZone(ctx context.Context, auth *config.Auth, zones Zones, r *http.Request, id string) (*hcloud.Zone, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, nil
+	}
+	zone, err := zones.Zone(ctx, id)
+	if err != nil || zone == nil {
+		return nil, err
+	}
+	if !CoversZone(auth, r, zone.Name) {
+		return nil, nil
+	}
+	return zone, nil
+}
+
+// VisibleZones returns the zones the API token of r covers.
+func VisibleZones(ctx context.Context, auth *config.Auth, zones Zones, r *http.Request) ([]*hcloud.Zone, error) {
+	all, err := zones.Zones(ctx)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]*hcloud.Zone, 0, len(all))
+	for _, zone := range all {
+		if CoversZone(auth, r, zone.Name) {
+			visible = append(visible, zone)
+		}
+	}
+	return visible, nil
+}
+
+// Page describes a page of a list.
+type Page struct {
+	// Number is the requested page, starting at 1.
+	Number int
+	// PerPage is the maximum number of entries of a page.
+	PerPage int
+	// Count is the number of entries of the page.
+	Count int
+	// Total is the number of entries of the list.
+	Total int
+	// Pages is the number of pages of the list.
+	Pages int
+}
+
+// Paginate returns the page of items requested by the page and per_page
+// query parameters of r. per_page defaults to defaultPerPage and is capped
+// at maxPerPage. Pages past the end are empty.
+func Paginate[T any](r *http.Request, items []T, defaultPerPage, maxPerPage int) ([]T, Page) {
+	number := queryInt(r, "page", 1)
+	perPage := min(queryInt(r, "per_page", defaultPerPage), maxPerPage)
+
+	// Check the page before multiplying to not overflow on large numbers.
+	start := len(items)
+	if number-1 <= len(items)/perPage {
+		start = min((number-1)*perPage, len(items))
+	}
+	end := min(start+perPage, len(items))
+	return items[start:end], Page{
+		Number:  number,
+		PerPage: perPage,
+		Count:   end - start,
+		Total:   len(items),
+		Pages:   (len(items) + perPage - 1) / perPage,
+	}
+}
+
+// queryInt returns the positive integer of the query parameter key of r, or
+// def if it has none.
+func queryInt(r *http.Request, key string, def int) int {
+	i, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || i < 1 {
+		return def
+	}
+	return i
+}
+
+// WriteJSON writes v as JSON response with the status code.
+func WriteJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
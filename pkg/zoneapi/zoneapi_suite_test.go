@@ -0,0 +1,13 @@
+package zoneapi_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestZoneAPI(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "zoneapi test suite")
+}
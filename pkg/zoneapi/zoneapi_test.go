@@ -0,0 +1,35 @@
+package zoneapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/0xfelix/hetzner-dnsapi-proxy/pkg/zoneapi"
+)
+
+var _ = Describe("Paginate", func() {
+	items := []int{1, 2, 3, 4, 5}
+
+	DescribeTable("should return the requested page", func(query string, expected []int, page zoneapi.Page) {
+		r := httptest.NewRequest(http.MethodGet, "/"+query, http.NoBody)
+		result, p := zoneapi.Paginate(r, items, 2, 3)
+		Expect(result).To(Equal(expected))
+		Expect(p).To(Equal(page))
+	},
+		Entry("first page by default", "", []int{1, 2},
+			zoneapi.Page{Number: 1, PerPage: 2, Count: 2, Total: 5, Pages: 3}),
+		Entry("last page", "?page=3", []int{5},
+			zoneapi.Page{Number: 3, PerPage: 2, Count: 1, Total: 5, Pages: 3}),
+		Entry("capped page size", "?page=2&per_page=100", []int{4, 5},
+			zoneapi.Page{Number: 2, PerPage: 3, Count: 2, Total: 5, Pages: 2}),
+		Entry("invalid parameters", "?page=-1&per_page=x", []int{1, 2},
+			zoneapi.Page{Number: 1, PerPage: 2, Count: 2, Total: 5, Pages: 3}),
+		Entry("page past the end", "?page=4", []int{},
+			zoneapi.Page{Number: 4, PerPage: 2, Count: 0, Total: 5, Pages: 3}),
+		Entry("page overflowing the offset", "?page=100000000000000000", []int{},
+			zoneapi.Page{Number: 100000000000000000, PerPage: 2, Count: 0, Total: 5, Pages: 3}),
+	)
+})
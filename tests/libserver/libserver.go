@@ -42,7 +42,7 @@ func New(url string, ttl int) (server *httptest.Server, token, username, passwor
 		Lockout:   config.Lockout{MaxAttempts: 1000, DurationSeconds: 3600, WindowSeconds: 900},
 	}
 
-	return httptest.NewServer(app.New(cfg)), token, username, password
+	return httptest.NewServer(app.New(cfg, app.NewLimits(cfg))), token, username, password
 }
 
 func NewNoAllowedDomains(url string) *httptest.Server {
@@ -55,7 +55,7 @@ func NewNoAllowedDomains(url string) *httptest.Server {
 		RateLimit: config.RateLimit{RPS: 1000, Burst: 1000, IdleSeconds: 600},
 		Lockout:   config.Lockout{MaxAttempts: 1000, DurationSeconds: 3600, WindowSeconds: 900},
 	}
-	return httptest.NewServer(app.New(cfg))
+	return httptest.NewServer(app.New(cfg, app.NewLimits(cfg)))
 }
 
 func randString(n int) string {